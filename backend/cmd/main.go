@@ -4,12 +4,18 @@ import (
 	"backend/cmd/server"
 	"backend/internal/api"
 	"backend/internal/infrastructure/db"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
 	persistence "backend/internal/infrastructure/persistence/service_repository"
 	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/realtime"
 	"backend/internal/infrastructure/redis"
+	analyticsusecase "backend/internal/usecase/analytics_usecase"
 	messagequeue "backend/internal/usecase/message_queue"
+	notificationusecase "backend/internal/usecase/notification_usecase"
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
 	casbinusage "backend/pkg/casbin"
 	"backend/pkg/config"
+	"backend/pkg/constants"
 	dbinit "backend/pkg/db_init"
 	"context"
 
@@ -19,9 +25,9 @@ import (
 )
 
 var (
-	enforcer       *casbin.Enforcer
-	rabbitMQClient *rabbitmq.RabbitMQClient
-	redisClient    *redis.RedisClient
+	enforcer       *casbin.SyncedEnforcer
+	rabbitMQClient rabbitmq.RabbitMQConnection
+	redisClient    redis.RedisConnection
 )
 
 func init() {
@@ -35,20 +41,20 @@ func init() {
 
 	if err := dbinit.InitDatabase(); err != nil {
 		logrus.Fatal("Failed to init database", err)
-	} else {
-		if err := dbinit.SeedDataBase(context.Background(), db.DatabaseClient.GetDB()); err != nil {
-			logrus.Error(err)
-		}
+	}
+	if err := dbinit.MigrateDataBase(db.DatabaseClient.GetDB()); err != nil {
+		logrus.Fatal("Failed to migrate database: ", err)
+	}
+	if err := dbinit.SeedDataBase(context.Background(), db.DatabaseClient.GetDB()); err != nil {
+		logrus.Error(err)
 	}
 
 	rc, err := dbinit.InitRedis()
 	if err != nil {
-		logrus.Warnf("Redis connection not available, skipping token caching. Error: %v", err)
-		redisClient = nil
-	} else {
-		redisClient = rc
-		logrus.Info("Redis connected successfully")
+		logrus.Warnf("Redis connection not available, running in degraded mode. Error: %v", err)
+		rc = dbinit.NewMemoryRedis()
 	}
+	redisClient = rc
 
 	client, err := dbinit.InitRabbitMQ()
 	if err != nil {
@@ -56,7 +62,7 @@ func init() {
 	}
 	rabbitMQClient = client
 
-	e, err := casbinusage.InitCasbin()
+	e, err := casbinusage.InitCasbin(context.Background(), db.DatabaseClient.GetDB(), redisClient)
 	if err != nil {
 		logrus.Fatal("Failed to init casbin", err)
 	}
@@ -68,11 +74,27 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	msgUsecase := messagequeue.NewRabbitMQUsecase(rabbitMQClient, persistence.NewBookingQueueRepository(db.DatabaseClient.GetDB()), *redisClient)
-	engine := server.NewEngine()
+	bookingQueueRepo := persistence.NewBookingQueueRepository(db.DatabaseClient.GetDB())
+	deadLetterRepo := messagingrepository.NewDeadLetterRepository(db.DatabaseClient.GetDB())
+	outboxRepo := messagingrepository.NewOutboxRepository(db.DatabaseClient.GetDB())
+	eventBus := rabbitmq.NewEventBus(rabbitMQClient)
+	msgUsecase := messagequeue.NewRabbitMQUsecase(rabbitMQClient, eventBus, bookingQueueRepo, deadLetterRepo, outboxRepo)
+	notificationUsecase := notificationusecase.NewNotificationUsecase(eventBus, notificationusecase.NewLogNotifier())
+	analyticsUsecase := analyticsusecase.NewAnalyticsUsecase(eventBus, redisClient)
+	queueFeedUsecase := queuefeedusecase.NewQueueFeedUsecase(eventBus, bookingQueueRepo, redisClient)
+	queueHub := realtime.NewHub(redisClient, constants.CHANNEL_REDIS, queueFeedUsecase, dbinit.AllowedOrigins())
+	engine, err := server.NewEngine(dbinit.TrustedProxies(), dbinit.AllowedOrigins())
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
 	apiRoutes := engine.Group("/api")
-	api.SetupRoutes(apiRoutes, enforcer, msgUsecase, redisClient)
+	api.SetupRoutes(apiRoutes, enforcer, msgUsecase, analyticsUsecase, queueFeedUsecase, queueHub, redisClient)
+	go func() {
+		if err := queueHub.Run(ctx); err != nil {
+			logrus.Error("Failed to start realtime hub:", err)
+		}
+	}()
 	go func() {
 		err := msgUsecase.StartBookingConsumer(ctx)
 		if err != nil {
@@ -80,8 +102,46 @@ func main() {
 			cancel() // Cancel context on error
 		}
 	}()
+	go func() {
+		if err := queueFeedUsecase.StartQueueFeedConsumer(ctx); err != nil {
+			logrus.Error("Failed to start queue feed consumer:", err)
+		}
+	}()
+	go func() {
+		if err := queueFeedUsecase.StartQueueReconciler(ctx, dbinit.QueueReconcileInterval()); err != nil {
+			logrus.Error("Failed to start queue reconciler:", err)
+		}
+	}()
+	go func() {
+		if err := notificationUsecase.StartNotificationConsumer(ctx); err != nil {
+			logrus.Error("Failed to start notification consumer:", err)
+		}
+	}()
+	go func() {
+		if err := analyticsUsecase.StartAnalyticsConsumer(ctx); err != nil {
+			logrus.Error("Failed to start analytics consumer:", err)
+		}
+	}()
+	go func() {
+		if err := msgUsecase.StartDeadLetterConsumer(ctx); err != nil {
+			logrus.Error("Failed to start dead-letter consumer:", err)
+		}
+	}()
+	go func() {
+		if err := msgUsecase.StartOutboxRelay(ctx); err != nil {
+			logrus.Error("Failed to start outbox relay:", err)
+		}
+	}()
 
 	server := server.New(config.AppConfig.Main.Port, engine)
+	server.OnStop(queueHub.Close)
+	server.OnShutdown(func(shutdownCtx context.Context) error {
+		// finish in-flight messages before the relay and consumers are cancelled
+		drainCtx, drainCancel := context.WithTimeout(shutdownCtx, dbinit.RabbitMQDrainTimeout())
+		defer drainCancel()
+		defer cancel()
+		return rabbitMQClient.Drain(drainCtx)
+	})
 	if err := server.Run(); err != nil {
 		logrus.Info("Can not connect to service")
 	}
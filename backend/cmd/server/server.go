@@ -4,6 +4,7 @@ import (
 	"backend/internal/api/middleware"
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -20,8 +21,12 @@ const (
 	version     = "0.0.0.1"
 )
 
+// ShutdownHook runs after the HTTP server stopped accepting requests
+type ShutdownHook func(ctx context.Context) error
+
 type Server struct {
-	httpServer *http.Server
+	httpServer    *http.Server
+	shutdownHooks []ShutdownHook
 }
 
 func New(port string, router *gin.Engine) *Server {
@@ -33,22 +38,40 @@ func New(port string, router *gin.Engine) *Server {
 	}
 }
 
+// OnShutdown registers hooks run in order on SIGINT/SIGTERM, e.g. draining consumers
+func (s *Server) OnShutdown(hook ShutdownHook) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// OnStop registers fn to run as soon as shutdown starts, before in-flight requests are
+// waited for, e.g. closing long-lived event streams
+func (s *Server) OnStop(fn func()) {
+	s.httpServer.RegisterOnShutdown(fn)
+}
+
 func serviceHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "Service: %s\nVersion: %s", serviceName, version)
 	}
 }
 
-func NewEngine() *gin.Engine {
+// NewEngine builds the router. X-Forwarded-For is only read from trustedProxies, the
+// client IP of the rate limits and the login lockouts cannot be spoofed through it.
+// Without trusted proxies the client IP is the peer address. Browsers may call the API
+// from the pages of allowedOrigins
+func NewEngine(trustedProxies, allowedOrigins []string) (*gin.Engine, error) {
 	engine := gin.Default()
+	if err := engine.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
 
 	// Add global CORS middleware only
-	engine.Use(middleware.AllowCORS())
+	engine.Use(middleware.AllowCORS(allowedOrigins))
 	// Remove global AuthMiddleware - will be applied selectively in routes
 
 	engine.GET("/service", serviceHandler())
 
-	return engine
+	return engine, nil
 }
 
 func (s *Server) Run() error {
@@ -71,6 +94,12 @@ func (s *Server) Run() error {
 	}
 	defer cancel()
 
+	for _, hook := range s.shutdownHooks {
+		if err := hook(ctx); err != nil {
+			logrus.Errorf("Shutdown hook failed: %v", err)
+		}
+	}
+
 	logrus.Infof("Service %s has been shutdown", serviceName)
 	return nil
 }
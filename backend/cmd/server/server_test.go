@@ -0,0 +1,107 @@
+package server
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
+	"backend/internal/infrastructure/ratelimit"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLimitedEngine(t *testing.T, trustedProxies []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine, err := NewEngine(trustedProxies, nil)
+	require.NoError(t, err)
+	rules := []ratelimit.Rule{{Key: ratelimit.KeyByIP, Limit: 2, Window: time.Minute}}
+	engine.POST("/login", middleware.RateLimitMiddleware(ratelimit.NewLimiter(redis.NewMemoryStore()), "login", rules), func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+	return engine
+}
+
+func login(engine *gin.Engine, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewEngine_SpoofedForwardedForKeepsTheBucket(t *testing.T) {
+	engine := newLimitedEngine(t, nil)
+
+	for i, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+		w := login(engine, "203.0.113.9:4711", spoofed)
+		require.Equal(t, http.StatusOK, w.Code, i)
+		assert.Equal(t, "203.0.113.9", w.Body.String())
+	}
+	w := login(engine, "203.0.113.9:4711", "198.51.100.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestNewEngine_TrustedProxyForwardsTheClient(t *testing.T) {
+	engine := newLimitedEngine(t, []string{"10.0.0.0/8"})
+
+	// behind the proxy every client has its own bucket
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		w := login(engine, "10.1.2.3:4711", client)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, client, w.Body.String())
+	}
+
+	_, err := NewEngine([]string{"not-an-ip"}, nil)
+	assert.Error(t, err)
+}
+
+type discardedHistory struct {
+	loginhistoryrepository.LoginHistoryRepository
+}
+
+func (discardedHistory) Record(ctx context.Context, e *account.LoginEvent) error { return nil }
+
+func TestNewEngine_SpoofedForwardedForDoesNotEscapeTheIPLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine, err := NewEngine(nil, nil)
+	require.NoError(t, err)
+	guard := loginguardusecase.NewLoginGuardUsecase(discardedHistory{}, redis.NewMemoryStore(), loginguardusecase.Config{
+		Window:       time.Minute,
+		DelayAfter:   100,
+		MaxDelay:     time.Minute,
+		LockAfter:    100,
+		LockDuration: time.Minute,
+		IPLockAfter:  2,
+	})
+	// every login fails, each on another account
+	n := 0
+	engine.POST("/login", func(c *gin.Context) {
+		n++
+		attempt := &dto.LoginAttempt{Role: "doctor", Identifier: fmt.Sprint(n), Client: middleware.GetClientInfo(c)}
+		if err := guard.Check(c, attempt); err != nil {
+			middleware.LoginFailed(c, err)
+			return
+		}
+		guard.Failed(c, attempt, "", loginguardusecase.ReasonUnknownAccount)
+		c.String(http.StatusUnauthorized, attempt.Client.IP)
+	})
+
+	for _, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+		w := login(engine, "203.0.113.9:4711", spoofed)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "203.0.113.9", w.Body.String())
+	}
+	w := login(engine, "203.0.113.9:4711", "198.51.100.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
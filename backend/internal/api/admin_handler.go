@@ -1,10 +1,15 @@
 package api
 
 import (
+	"backend/internal/api/middleware"
 	"backend/internal/domain/dto"
+	staff "backend/internal/domain/staff/admin"
+	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
 	"backend/internal/usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
+	"backend/pkg/common/pagination"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -26,12 +31,174 @@ func (h *AdminHandler) LoginAdmin(ctx *gin.Context) {
 		return
 	}
 
+	loginRequest.Client = middleware.GetClientInfo(ctx)
 	resp, err := h.adminUsecase.AdminLogin(ctx, &loginRequest)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+		middleware.LoginFailed(ctx, err)
 		logrus.Error(err)
 		return
 	}
-	ctx.SetCookie("clinic_token", resp.Token, 3600*24, "/", "", false, true)
+	middleware.SetSessionCookies(ctx, resp)
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, resp, "Login Successfully"))
 }
+
+// adminError maps the usecase errors to their status
+func adminError(ctx *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, usecase.ErrWeakPassword):
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+	case errors.Is(err, usecase.ErrWrongPassword):
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+	case errors.Is(err, usecase.ErrOwnAccount):
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, err.Error()))
+	case errors.Is(err, staffrepository.ErrAdminNotFound):
+		ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+	case errors.Is(err, staffrepository.ErrAdminExists), errors.Is(err, staffrepository.ErrLastSuperAdmin):
+		ctx.JSON(http.StatusConflict, errorsresponse.NewCustomErrResponse(http.StatusConflict, err.Error()))
+	default:
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, msg))
+	}
+}
+
+func (h *AdminHandler) GetProfile(ctx *gin.Context) {
+	adminId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	admin, err := h.adminUsecase.GetAdmin(ctx, adminId)
+	if err != nil {
+		adminError(ctx, err, "Failed to fetch profile")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, admin, "Data fetch"))
+}
+
+// ChangePassword changes the password of the caller, they have to sign in again
+func (h *AdminHandler) ChangePassword(ctx *gin.Context) {
+	var req dto.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	adminId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	if err := h.adminUsecase.ChangePassword(ctx, adminId, &req); err != nil {
+		adminError(ctx, err, "Failed to change password")
+		return
+	}
+	middleware.ClearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Password changed, sign in again"))
+}
+
+func (h *AdminHandler) GetAdmins(ctx *gin.Context) {
+	var paginationReq pagination.Pagination
+	if err := ctx.ShouldBindQuery(&paginationReq); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid page"))
+		return
+	}
+	admins, err := h.adminUsecase.GetAdmins(ctx, &paginationReq)
+	if err != nil {
+		adminError(ctx, err, "Failed to fetch admins")
+		return
+	}
+	fullResp := dto.PaginationResponse[staff.Admin]{
+		Data:       admins,
+		Pagination: &paginationReq,
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &fullResp, "Data fetch"))
+}
+
+func (h *AdminHandler) GetAdminById(ctx *gin.Context) {
+	admin, err := h.adminUsecase.GetAdmin(ctx, ctx.Param("id"))
+	if err != nil {
+		adminError(ctx, err, "Failed to fetch admin")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, admin, "Data fetch"))
+}
+
+func (h *AdminHandler) CreateAdmin(ctx *gin.Context) {
+	var req dto.CreateAdminRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	admin, err := h.adminUsecase.CreateAdmin(ctx, &req)
+	if err != nil {
+		adminError(ctx, err, "Failed to create admin")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, admin, "Admin created"))
+}
+
+func (h *AdminHandler) UpdateAdmin(ctx *gin.Context) {
+	var req dto.UpdateAdminRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	actorId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	admin, err := h.adminUsecase.UpdateAdmin(ctx, actorId, ctx.Param("id"), &req)
+	if err != nil {
+		adminError(ctx, err, "Failed to update admin")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, admin, "Admin updated"))
+}
+
+func (h *AdminHandler) DisableAdmin(ctx *gin.Context) {
+	h.setDisabled(ctx, true, "Admin disabled")
+}
+
+func (h *AdminHandler) EnableAdmin(ctx *gin.Context) {
+	h.setDisabled(ctx, false, "Admin enabled")
+}
+
+func (h *AdminHandler) setDisabled(ctx *gin.Context, disabled bool, msg string) {
+	actorId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	if err := h.adminUsecase.SetDisabled(ctx, actorId, ctx.Param("id"), disabled); err != nil {
+		adminError(ctx, err, "Failed to update admin")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, msg))
+}
+
+func (h *AdminHandler) DeleteAdmin(ctx *gin.Context) {
+	actorId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	if err := h.adminUsecase.DeleteAdmin(ctx, actorId, ctx.Param("id")); err != nil {
+		adminError(ctx, err, "Failed to delete admin")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Admin deleted"))
+}
+
+// SetAdminPassword sets the password of another admin, their sessions are signed out
+func (h *AdminHandler) SetAdminPassword(ctx *gin.Context) {
+	var req dto.AdminPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	if err := h.adminUsecase.SetPassword(ctx, ctx.Param("id"), &req); err != nil {
+		adminError(ctx, err, "Failed to set password")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Password set"))
+}
@@ -0,0 +1,34 @@
+package analyticshandler
+
+import (
+	analyticsusecase "backend/internal/usecase/analytics_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type AnalyticsHandler struct {
+	analyticsUsecase analyticsusecase.AnalyticsUsecase
+}
+
+func NewAnalyticsHandler(analyticsUsecase analyticsusecase.AnalyticsUsecase) *AnalyticsHandler {
+	return &AnalyticsHandler{analyticsUsecase: analyticsUsecase}
+}
+
+// GetEventCounts returns the event counters of ?date=YYYY-MM-DD, today by default
+func (h *AnalyticsHandler) GetEventCounts(ctx *gin.Context) {
+	date := ctx.DefaultQuery("date", time.Now().UTC().Format("2006-01-02"))
+
+	counts, err := h.analyticsUsecase.GetEventCounts(ctx, date)
+	if err != nil {
+		logrus.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, counts, "Data fetched"))
+}
@@ -0,0 +1,151 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	recoveryusecase "backend/internal/usecase/recovery_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	ssousecase "backend/internal/usecase/sso_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type AuthHandler struct {
+	sessions  sessionusecase.SessionUsecase
+	recovery  recoveryusecase.RecoveryUsecase
+	twoFactor twofactorusecase.TwoFactorUsecase
+	guard     loginguardusecase.LoginGuardUsecase
+	sso       ssousecase.SSOUsecase
+}
+
+func NewAuthHandler(sessions sessionusecase.SessionUsecase, recovery recoveryusecase.RecoveryUsecase, twoFactor twofactorusecase.TwoFactorUsecase, guard loginguardusecase.LoginGuardUsecase, sso ssousecase.SSOUsecase) *AuthHandler {
+	return &AuthHandler{sessions: sessions, recovery: recovery, twoFactor: twoFactor, guard: guard, sso: sso}
+}
+
+// refreshToken reads the refresh token from its cookie, or from the body for clients
+// without cookies
+func refreshToken(ctx *gin.Context) string {
+	if token, err := ctx.Cookie(middleware.RefreshTokenCookie); err == nil && token != "" {
+		return token
+	}
+	var req dto.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		return ""
+	}
+	return req.RefreshToken
+}
+
+// Refresh trades the refresh token for a new access token and a new refresh token, the
+// old refresh token stops working
+func (h *AuthHandler) Refresh(ctx *gin.Context) {
+	token := refreshToken(ctx)
+	if token == "" {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Refresh token is required"))
+		return
+	}
+
+	client := middleware.GetClientInfo(ctx)
+	tokens, err := h.sessions.Refresh(ctx, token, &client)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, sessionusecase.ErrInvalidRefreshToken) || errors.Is(err, sessionusecase.ErrRefreshTokenReused) {
+			middleware.ClearSessionCookies(ctx)
+			ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Session expired, please sign in again"))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to refresh session"))
+		return
+	}
+
+	resp := dto.NewLoginResponse(tokens, nil, "Session refreshed")
+	middleware.SetSessionCookies(ctx, resp)
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, resp, "Session refreshed"))
+}
+
+// Logout revokes the session of the access token, or of the refresh token once the
+// access token expired, and clears the cookies either way
+func (h *AuthHandler) Logout(ctx *gin.Context) {
+	var err error
+	if sessionId := h.accessSession(ctx); sessionId != "" {
+		err = h.sessions.Revoke(ctx, sessionId)
+	} else if token := refreshToken(ctx); token != "" {
+		err = h.sessions.RevokeByRefreshToken(ctx, token)
+	}
+	// the headers are written with the response, the cookies must be cleared before
+	middleware.ClearSessionCookies(ctx)
+	if err != nil && !errors.Is(err, sessionusecase.ErrInvalidRefreshToken) {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to sign out"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Signed out"))
+}
+
+func (h *AuthHandler) accessSession(ctx *gin.Context) string {
+	token, err := ctx.Cookie(middleware.AccessTokenCookie)
+	if err != nil || token == "" {
+		return ""
+	}
+	claims, err := middleware.ValidateToken(token)
+	if err != nil {
+		return ""
+	}
+	sessionId, _ := (*claims)["sid"].(string)
+	return sessionId
+}
+
+// GetSessions lists the devices the caller is signed in on
+func (h *AuthHandler) GetSessions(ctx *gin.Context) {
+	userId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	sessions, err := h.sessions.List(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch sessions"))
+		return
+	}
+	current := ctx.GetString("session")
+	for _, s := range sessions {
+		s.Current = s.SessionId == current
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, sessions, "Sessions fetched"))
+}
+
+// GetUserSessions lists the open sessions of any user, for admins
+func (h *AuthHandler) GetUserSessions(ctx *gin.Context) {
+	sessions, err := h.sessions.List(ctx, ctx.Param("id"))
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch sessions"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, sessions, "Sessions fetched"))
+}
+
+// ForceSignOut revokes every session of a compromised account, its access tokens are
+// refused from the next request on
+func (h *AuthHandler) ForceSignOut(ctx *gin.Context) {
+	userId := ctx.Param("id")
+	revoked, err := h.sessions.RevokeAll(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to sign out user"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, gin.H{"user_id": userId, "revoked": revoked}, "User signed out everywhere"))
+}
@@ -0,0 +1,63 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type revokingSessions struct {
+	sessionusecase.SessionUsecase
+	revoked []string
+	err     error
+}
+
+func (s *revokingSessions) RevokeByRefreshToken(ctx context.Context, refreshToken string) error {
+	s.revoked = append(s.revoked, refreshToken)
+	return s.err
+}
+
+func logout(t *testing.T, sessions sessionusecase.SessionUsecase) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/logout", NewAuthHandler(sessions, nil, nil, nil, nil).Logout)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: middleware.RefreshTokenCookie, Value: "refresh-1"})
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+// expiredCookies lists the cookies the response tells the browser to drop, from the
+// headers as they were when the response was written
+func expiredCookies(w *httptest.ResponseRecorder) []string {
+	var names []string
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 && c.Value == "" {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func TestLogout_ClearsSessionCookies(t *testing.T) {
+	sessions := &revokingSessions{}
+	w := logout(t, sessions)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"refresh-1"}, sessions.revoked)
+	assert.ElementsMatch(t, []string{middleware.AccessTokenCookie, middleware.RefreshTokenCookie}, expiredCookies(w))
+
+	// the cookies go even when the session could not be revoked
+	w = logout(t, &revokingSessions{err: errors.New("redis down")})
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.ElementsMatch(t, []string{middleware.AccessTokenCookie, middleware.RefreshTokenCookie}, expiredCookies(w))
+}
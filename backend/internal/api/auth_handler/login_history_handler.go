@@ -0,0 +1,60 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// GetLoginHistory lists the recent logins of the caller, new devices and locations are
+// flagged
+func (h *AuthHandler) GetLoginHistory(ctx *gin.Context) {
+	userId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	events, err := h.guard.History(ctx, userId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch login history"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, events, "Login history fetched"))
+}
+
+// GetUserLoginHistory lists the recent logins of any user, for admins
+func (h *AuthHandler) GetUserLoginHistory(ctx *gin.Context) {
+	events, err := h.guard.History(ctx, ctx.Param("id"))
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch login history"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, events, "Login history fetched"))
+}
+
+// UnlockLogin lifts the lockout of an account or an IP before it expires
+func (h *AuthHandler) UnlockLogin(ctx *gin.Context) {
+	var req dto.UnlockLoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil || (req.Role == "" && req.IP == "") {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Role and identifier, or IP, are required"))
+		return
+	}
+
+	if err := h.guard.Unlock(ctx, &req); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to unlock login"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, req, "Login unlocked"))
+}
@@ -0,0 +1,78 @@
+package authhandler
+
+import (
+	"backend/internal/domain/dto"
+	recoveryusecase "backend/internal/usecase/recovery_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ForgotPassword sends a reset code to the email or phone number, the answer does not
+// tell whether an account uses it
+func (h *AuthHandler) ForgotPassword(ctx *gin.Context) {
+	var req dto.PasswordResetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+
+	if err := h.recovery.RequestReset(ctx, &req); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to send reset code"))
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, response.NewCustomSuccessResponse(http.StatusAccepted, nil, "If the account exists, a reset code was sent"))
+}
+
+// VerifyResetCode checks the code and hands out the token for ResetPassword
+func (h *AuthHandler) VerifyResetCode(ctx *gin.Context) {
+	var req dto.VerifyResetCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+
+	token, err := h.recovery.VerifyCode(ctx, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, recoveryusecase.ErrInvalidCode):
+			ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+		case errors.Is(err, recoveryusecase.ErrTooManyAttempts):
+			ctx.JSON(http.StatusTooManyRequests, errorsresponse.NewCustomErrResponse(http.StatusTooManyRequests, err.Error()))
+		default:
+			logrus.Errorf("Handler layer: %v", err)
+			ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to verify code"))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, token, "Code verified"))
+}
+
+// ResetPassword sets the new password, the sessions open on the account are revoked
+func (h *AuthHandler) ResetPassword(ctx *gin.Context) {
+	var req dto.SetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+
+	if err := h.recovery.ResetPassword(ctx, &req); err != nil {
+		switch {
+		case errors.Is(err, recoveryusecase.ErrWeakPassword), errors.Is(err, recoveryusecase.ErrInvalidResetToken):
+			ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+		default:
+			logrus.Errorf("Handler layer: %v", err)
+			ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to reset password"))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Password reset, please sign in again"))
+}
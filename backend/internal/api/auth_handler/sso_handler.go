@@ -0,0 +1,86 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	ssoidentityrepository "backend/internal/infrastructure/persistence/sso_identity_repository"
+	ssousecase "backend/internal/usecase/sso_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ssoError maps the single sign-on errors to their status
+func ssoError(ctx *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, ssousecase.ErrDisabled):
+		ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+	case errors.Is(err, ssousecase.ErrInvalidState), errors.Is(err, ssousecase.ErrInvalidToken):
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+	case errors.Is(err, ssousecase.ErrNoRole), errors.Is(err, ssousecase.ErrEmailNotVerified),
+		errors.Is(err, ssousecase.ErrNotLinked), errors.Is(err, ssoidentityrepository.ErrIdentityConflict):
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, err.Error()))
+	default:
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, msg))
+	}
+}
+
+// stateHash is what the state cookie holds, the state itself only travels to the provider
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSSO hands out the URL of the identity provider to send the browser to. The state
+// is bound to the browser by a cookie, a callback started elsewhere is refused
+func (h *AuthHandler) StartSSO(ctx *gin.Context) {
+	start, err := h.sso.Start(ctx)
+	if err != nil {
+		ssoError(ctx, err, "Failed to start single sign-on")
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(middleware.SSOStateCookie, stateHash(start.State), int(time.Until(start.ExpiresAt).Seconds()), "/", "", false, true)
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, start, "Single sign-on started"))
+}
+
+// FinishSSO takes the code and state the provider redirected back with and signs in
+// like a password login, with a two-factor challenge when the account needs one. The
+// state has to match the cookie StartSSO set, which is cleared either way
+func (h *AuthHandler) FinishSSO(ctx *gin.Context) {
+	var req dto.SSOCallbackRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Code and state are required"))
+		return
+	}
+	req.Client = middleware.GetClientInfo(ctx)
+
+	cookie, _ := ctx.Cookie(middleware.SSOStateCookie)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(middleware.SSOStateCookie, "", -1, "/", "", false, true)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(stateHash(req.State))) != 1 {
+		logrus.Warn("Single sign-on callback without the state cookie of its start")
+		ssoError(ctx, ssousecase.ErrInvalidState, "Failed to sign in")
+		return
+	}
+
+	resp, err := h.sso.Callback(ctx, &req)
+	if err != nil {
+		ssoError(ctx, err, "Failed to sign in")
+		return
+	}
+
+	middleware.SetSessionCookies(ctx, resp)
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, resp, resp.Message))
+}
@@ -0,0 +1,75 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	ssousecase "backend/internal/usecase/sso_usecase"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSSO struct {
+	ssousecase.SSOUsecase
+	callbacks int
+}
+
+func (s *fakeSSO) Start(ctx context.Context) (*dto.SSOStartResponse, error) {
+	return &dto.SSOStartResponse{AuthorizationURL: "https://idp.example/auth", ExpiresAt: time.Now().Add(5 * time.Minute), State: "state-1"}, nil
+}
+
+func (s *fakeSSO) Callback(ctx context.Context, req *dto.SSOCallbackRequest) (*dto.LoginResponse, error) {
+	s.callbacks++
+	return &dto.LoginResponse{}, nil
+}
+
+func TestFinishSSO_RequiresTheStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sso := &fakeSSO{}
+	engine := gin.New()
+	h := NewAuthHandler(nil, nil, nil, nil, sso)
+	engine.POST("/sso/start", h.StartSSO)
+	engine.POST("/sso/callback", h.FinishSSO)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sso/start", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == middleware.SSOStateCookie {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.NotContains(t, cookie.Value, "state-1")
+
+	callback := func(state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sso/callback", strings.NewReader(`{"code":"code-1","state":"`+state+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// a callback planted in another browser carries no cookie, or the cookie of another start
+	assert.Equal(t, http.StatusUnauthorized, callback("state-1").Code)
+	assert.Equal(t, http.StatusUnauthorized, callback("state-2", cookie).Code)
+	assert.Zero(t, sso.callbacks)
+
+	w = callback("state-1", cookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, sso.callbacks)
+	assert.Contains(t, expiredCookies(w), middleware.SSOStateCookie)
+}
@@ -0,0 +1,179 @@
+package authhandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// twoFactorError answers the errors of the two-factor usecase
+func twoFactorError(ctx *gin.Context, err error, msg string) {
+	var throttled *loginguardusecase.ThrottleError
+	switch {
+	case errors.As(err, &throttled):
+		middleware.LoginFailed(ctx, err)
+	case errors.Is(err, twofactorusecase.ErrInvalidCode), errors.Is(err, twofactorusecase.ErrInvalidChallenge):
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+	case errors.Is(err, twofactorusecase.ErrNotStaff), errors.Is(err, twofactorusecase.ErrRequired):
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, err.Error()))
+	case errors.Is(err, twofactorusecase.ErrAlreadyEnabled), errors.Is(err, twofactorusecase.ErrNotEnabled):
+		ctx.JSON(http.StatusConflict, errorsresponse.NewCustomErrResponse(http.StatusConflict, err.Error()))
+	default:
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, msg))
+	}
+}
+
+// TwoFactorChallenge finishes a staff login with the code of the authenticator app or a
+// recovery code, the session is opened here
+func (h *AuthHandler) TwoFactorChallenge(ctx *gin.Context) {
+	var req dto.TwoFactorChallengeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+
+	resp, err := h.twoFactor.Challenge(ctx, &req)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to verify code")
+		return
+	}
+
+	middleware.SetSessionCookies(ctx, resp)
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, resp, "Login successfully"))
+}
+
+// TwoFactorEnrollChallenge hands out the secret to a login that must enroll first, the
+// first code then goes to TwoFactorChallenge
+func (h *AuthHandler) TwoFactorEnrollChallenge(ctx *gin.Context) {
+	var req dto.TwoFactorEnrollChallengeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+
+	enrollment, err := h.twoFactor.EnrollChallenge(ctx, req.ChallengeToken)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to start enrollment")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, enrollment, "Scan the QR code with your authenticator app"))
+}
+
+func (h *AuthHandler) GetTwoFactorStatus(ctx *gin.Context) {
+	userId, role, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	status, err := h.twoFactor.Status(ctx, userId, role)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to fetch two-factor status")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, status, "Two-factor status fetched"))
+}
+
+// EnrollTwoFactor starts the enrollment of a signed in staff member
+func (h *AuthHandler) EnrollTwoFactor(ctx *gin.Context) {
+	userId, role, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	enrollment, err := h.twoFactor.Enroll(ctx, userId, role)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to start enrollment")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, enrollment, "Scan the QR code with your authenticator app"))
+}
+
+// ActivateTwoFactor turns the second factor on with a first code, the recovery codes
+// are only shown in this answer
+func (h *AuthHandler) ActivateTwoFactor(ctx *gin.Context) {
+	userId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	var req dto.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Code is required"))
+		return
+	}
+
+	codes, err := h.twoFactor.Activate(ctx, userId, req.Code)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, codes, "Two-factor authentication enabled, keep the recovery codes safe"))
+}
+
+func (h *AuthHandler) DisableTwoFactor(ctx *gin.Context) {
+	userId, role, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	var req dto.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Code is required"))
+		return
+	}
+
+	if err := h.twoFactor.Disable(ctx, userId, role, req.Code); err != nil {
+		twoFactorError(ctx, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Two-factor authentication disabled"))
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(ctx *gin.Context) {
+	userId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	var req dto.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Code is required"))
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(ctx, userId, req.Code)
+	if err != nil {
+		twoFactorError(ctx, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, codes, "Recovery codes regenerated"))
+}
+
+// ResetTwoFactor removes the second factor of a staff member who lost their device, for
+// admins
+func (h *AuthHandler) ResetTwoFactor(ctx *gin.Context) {
+	userId := ctx.Param("id")
+	if err := h.twoFactor.Reset(ctx, userId); err != nil {
+		twoFactorError(ctx, err, "Failed to reset two-factor authentication")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, gin.H{"user_id": userId}, "Two-factor authentication reset"))
+}
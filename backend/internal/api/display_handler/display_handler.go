@@ -0,0 +1,115 @@
+package displayhandler
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	displayrepository "backend/internal/infrastructure/persistence/display_repository"
+	"backend/internal/infrastructure/realtime"
+	displayusecase "backend/internal/usecase/display_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DisplayHandler serves the waiting-room boards. The board routes take no login, the
+// token in the path decides which faculty or room the TV shows
+type DisplayHandler struct {
+	displayUsecase displayusecase.DisplayUsecase
+	hub            *realtime.Hub
+}
+
+func NewDisplayHandler(displayUsecase displayusecase.DisplayUsecase, hub *realtime.Hub) *DisplayHandler {
+	return &DisplayHandler{
+		displayUsecase: displayUsecase,
+		hub:            hub,
+	}
+}
+
+// GetBoard returns the board as it is now
+func (h *DisplayHandler) GetBoard(ctx *gin.Context) {
+	board, err := h.displayUsecase.Resolve(ctx, ctx.Param("token"))
+	if err != nil {
+		h.invalidToken(ctx, err)
+		return
+	}
+
+	resp, err := h.displayUsecase.Render(ctx, board)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to load board"))
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, resp, "Data fetched"))
+}
+
+// StreamBoard pushes the board over SSE on every change, with a chime event whenever a
+// ticket is called
+func (h *DisplayHandler) StreamBoard(ctx *gin.Context) {
+	board, err := h.displayUsecase.Resolve(ctx, ctx.Param("token"))
+	if err != nil {
+		h.invalidToken(ctx, err)
+		return
+	}
+
+	scope := realtime.Scope{FacultyId: board.FacultyId, Room: board.Room, Display: true}
+	if err := h.hub.ServeSSE(ctx.Writer, ctx.Request, scope); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+	}
+}
+
+func (h *DisplayHandler) invalidToken(ctx *gin.Context, err error) {
+	if errors.Is(err, displayusecase.ErrInvalidDisplayToken) {
+		ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, "Board not found"))
+		return
+	}
+	logrus.Errorf("Handler layer: %v", err)
+	ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to load board"))
+}
+
+func (h *DisplayHandler) CreateBoard(ctx *gin.Context) {
+	var req dtoqueue.CreateDisplayBoardRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid input"))
+		logrus.Error(err)
+		return
+	}
+
+	resp, err := h.displayUsecase.CreateBoard(ctx, &req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to create board"))
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, resp, "Board created, keep the token, it is not shown again"))
+}
+
+func (h *DisplayHandler) GetBoards(ctx *gin.Context) {
+	boards, err := h.displayUsecase.ListBoards(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch boards"))
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, boards, "Data fetched"))
+}
+
+func (h *DisplayHandler) DeleteBoard(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid id"))
+		return
+	}
+
+	if err := h.displayUsecase.DeleteBoard(ctx, id); err != nil {
+		if errors.Is(err, displayrepository.ErrBoardNotFound) {
+			ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to delete board"))
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, id, "Board deleted"))
+}
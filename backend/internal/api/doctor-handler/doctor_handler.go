@@ -1,28 +1,38 @@
 package doctorhandler
 
 import (
+	"backend/internal/api/middleware"
 	"backend/internal/domain/dto"
 	"backend/internal/domain/dto/dtodoctor"
+	dtoqueue "backend/internal/domain/dto/queue"
+	accessusecase "backend/internal/usecase/access_usecase"
 	doctorusecase "backend/internal/usecase/doctor-usecase"
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
 	serviceusecase "backend/internal/usecase/service_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
 	"backend/pkg/common/pagination"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type DoctorHandler struct {
 	doctorSvc           doctorusecase.DoctorUsecase
 	bookingQueueUsecase serviceusecase.BookingQueueUseCase
+	feed                queuefeedusecase.QueueFeedUsecase
+	access              accessusecase.AccessUsecase
 }
 
-func NewDoctorHandler(doctorSvc doctorusecase.DoctorUsecase, bookingQueueUsecase serviceusecase.BookingQueueUseCase) *DoctorHandler {
+func NewDoctorHandler(doctorSvc doctorusecase.DoctorUsecase, bookingQueueUsecase serviceusecase.BookingQueueUseCase, feed queuefeedusecase.QueueFeedUsecase, access accessusecase.AccessUsecase) *DoctorHandler {
 	return &DoctorHandler{
 		doctorSvc:           doctorSvc,
 		bookingQueueUsecase: bookingQueueUsecase,
+		feed:                feed,
+		access:              access,
 	}
 }
 
@@ -52,14 +62,15 @@ func (h *DoctorHandler) LoginDoctor(ctx *gin.Context) {
 		return
 	}
 
+	req.Client = middleware.GetClientInfo(ctx)
 	resp, err := h.doctorSvc.Login(ctx, &req)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+		middleware.LoginFailed(ctx, err)
 		logrus.Error(err)
 		return
 	}
 
-	ctx.SetCookie("clinic_token", resp.Token, 3600*24, "/", "", false, true)
+	middleware.SetSessionCookies(ctx, resp)
 
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &resp, "Doctor login successfully"))
 }
@@ -152,13 +163,64 @@ func (h *DoctorHandler) CreateDrugReceipt(ctx *gin.Context) {
 		return
 	}
 
-	err := h.doctorSvc.CreateDrugReceipt(ctx, &req)
+	actor, err := middleware.GetActorFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return
+	}
+	if err := h.access.Booking(ctx, actor, req.QueueId, accessusecase.ActionWrite); err != nil {
+		middleware.AbortAccess(ctx, err)
+		return
+	}
+
+	err = h.doctorSvc.CreateDrugReceipt(ctx, &req)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Error has occured"))
 		return
 	}
 
+	if err := h.bookingQueueUsecase.AssignDoctor(ctx, req.QueueId, actor.Id); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+	}
+
 	h.bookingQueueUsecase.UpdateBookingStatus(ctx, req.QueueId, "created drug receipt")
 
 	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, &req, "Successfully placed the drug receipt"))
 }
+
+// CallNext calls the next waiting patient of the doctor's faculty to their room, the
+// waiting-room boards chime
+func (h *DoctorHandler) CallNext(ctx *gin.Context) {
+	var req dtoqueue.CallNextRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid input"))
+		logrus.Error(err)
+		return
+	}
+
+	userId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return
+	}
+	doctorId, err := uuid.Parse(userId)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return
+	}
+
+	// StaffScope resolved the doctor's faculty, the doctor is assigned under the booking
+	// lock before the call goes out
+	called, err := h.feed.CallNext(ctx, middleware.GetFacultyFromContext(ctx), req.Room, &doctorId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, queuefeedusecase.ErrQueueEmpty) {
+			ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to call next patient"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, called, "Patient called"))
+}
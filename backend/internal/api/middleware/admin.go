@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
+	"backend/internal/usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RequireSuperAdmin lets through the active super admins. The role is read from the
+// admin table on each request, a demotion or a disabled account applies at once. It
+// runs after AuthMiddleware
+func RequireSuperAdmin(admins usecase.AdminUsecase) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requireSuperAdmin(c, admins) {
+			c.Next()
+		}
+	}
+}
+
+// RequireSuperAdminForAdmins guards the user routes whose :param may name an admin
+// account, acting on an admin takes a super admin while any admin may act on the others
+func RequireSuperAdminForAdmins(admins usecase.AdminUsecase, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := admins.GetAdmin(c, c.Param(param))
+		if errors.Is(err, staffrepository.ErrAdminNotFound) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Admin lookup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "error during authorization"))
+			c.Abort()
+			return
+		}
+		if requireSuperAdmin(c, admins) {
+			c.Next()
+		}
+	}
+}
+
+// requireSuperAdmin answers and aborts the request unless the actor is an active super admin
+func requireSuperAdmin(c *gin.Context, admins usecase.AdminUsecase) bool {
+	actor, ok := requireActor(c)
+	if !ok {
+		return false
+	}
+	allowed := false
+	if actor.Role == "admin" {
+		var err error
+		if allowed, err = admins.IsSuperAdmin(c, actor.Id); err != nil {
+			logrus.Errorf("Super admin check failed: %v", err)
+			c.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "error during authorization"))
+			c.Abort()
+			return false
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, "Only a super admin can do this"))
+		c.Abort()
+		return false
+	}
+	return true
+}
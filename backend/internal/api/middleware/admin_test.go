@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	staff "backend/internal/domain/staff/admin"
+	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
+	"backend/internal/usecase"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type adminAccounts struct {
+	usecase.AdminUsecase
+	admins map[string]*staff.Admin
+}
+
+func (a *adminAccounts) GetAdmin(ctx context.Context, adminId string) (*staff.Admin, error) {
+	if admin, ok := a.admins[adminId]; ok {
+		return admin, nil
+	}
+	return nil, staffrepository.ErrAdminNotFound
+}
+
+func (a *adminAccounts) IsSuperAdmin(ctx context.Context, adminId string) (bool, error) {
+	admin, ok := a.admins[adminId]
+	return ok && admin.IsSuperAdmin() && !admin.Disabled, nil
+}
+
+func TestRequireSuperAdminForAdmins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admins := &adminAccounts{admins: map[string]*staff.Admin{
+		"root":  {Role: staff.RoleSuperAdmin},
+		"clerk": {Role: staff.RoleAdmin},
+	}}
+
+	r := gin.New()
+	r.POST("/users/:id/sign-out", func(c *gin.Context) {
+		c.Set("claims", &jwt.MapClaims{"sub": c.GetHeader("X-Actor"), "role": "admin"})
+	}, RequireSuperAdminForAdmins(admins, "id"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		actor, target string
+		want          int
+	}{
+		{"clerk", "patient-1", http.StatusOK},
+		{"clerk", "root", http.StatusForbidden},
+		{"clerk", "clerk", http.StatusForbidden},
+		{"root", "clerk", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+tc.target+"/sign-out", nil)
+		req.Header.Set("X-Actor", tc.actor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.want, w.Code, "%s signs out %s", tc.actor, tc.target)
+	}
+}
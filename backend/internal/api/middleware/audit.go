@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"backend/internal/domain/audit"
+	accessusecase "backend/internal/usecase/access_usecase"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditRecorder appends to the audit log
+type AuditRecorder interface {
+	Record(ctx context.Context, e *audit.Event) error
+}
+
+// auditTimeout bounds the write of an audit event, it runs after the client may have
+// gone away
+const auditTimeout = 5 * time.Second
+
+// Audit records the request in the audit log once it was handled. key names the param,
+// or else the query value, holding the id of the resource. Patient resources carry the
+// patient id, and a patient with no key is reading their own data. Requests refused by
+// the access checks are left to the access auditor
+func Audit(recorder AuditRecorder, resource string, action accessusecase.Action, key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.GetBool(accessDeniedKey) {
+			return
+		}
+		actor, err := GetActorFromContext(c)
+		if err != nil {
+			return
+		}
+		resourceId := ""
+		if key != "" {
+			resourceId = c.Param(key)
+			if resourceId == "" {
+				resourceId = c.Query(key)
+			}
+		}
+
+		status := c.Writer.Status()
+		e := &audit.Event{
+			ActorId:    actor.Id,
+			Role:       actor.Role,
+			Action:     string(action),
+			Resource:   resource,
+			ResourceId: resourceId,
+			Outcome:    audit.OutcomeSuccess,
+			IP:         actor.IP,
+			UserAgent:  actor.UserAgent,
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Status:     status,
+		}
+		switch {
+		case status == http.StatusForbidden:
+			e.Outcome = audit.OutcomeDenied
+		case status >= http.StatusBadRequest:
+			e.Outcome = audit.OutcomeFailed
+		}
+
+		patientId := ""
+		switch {
+		case resource == "patient" && resourceId != "":
+			patientId = resourceId
+		case actor.Role == "patient":
+			patientId = actor.Id
+		}
+		if id, err := uuid.Parse(patientId); err == nil {
+			e.PatientId = &id
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditTimeout)
+		defer cancel()
+		if err := recorder.Record(ctx, e); err != nil {
+			logrus.Errorf("Failed to audit %s %s by %s: %v", e.Method, e.Path, e.ActorId, err)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package middleware
 
 import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -46,10 +54,43 @@ func ValidateToken(tokenString string) (*jwt.MapClaims, error) {
 	return nil, jwt.ErrTokenNotValidYet
 }
 
-// AuthMiddleware validates JWT tokens in Authorization header
-func AuthMiddleware() gin.HandlerFunc {
+const (
+	AccessTokenCookie  = "clinic_token"
+	RefreshTokenCookie = "clinic_refresh"
+	// DeviceCookie keeps the device id across logins, a new login on the device replaces
+	// its previous session
+	DeviceCookie = "clinic_device"
+	// DeviceHeader lets clients without cookies name their device
+	DeviceHeader = "X-Device-Id"
+	// APIKeyHeader carries the API key of a service account
+	APIKeyHeader = "X-API-Key"
+	// SSOStateCookie holds the hash of the single sign-on state the browser started with
+	SSOStateCookie = "clinic_sso_state"
+)
+
+// apiKeyKey holds the id of the API key a service account authenticated with
+const apiKeyKey = "api_key"
+
+// SessionChecker tells whether the session of an access token is still open
+type SessionChecker interface {
+	Check(ctx context.Context, sessionId, userId string) error
+}
+
+// KeyAuthenticator resolves the API key of a service account
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, rawKey, ip string) (*account.APIKey, error)
+}
+
+// AuthMiddleware validates JWT tokens in Authorization header and refuses tokens of
+// revoked sessions. Requests with an API key are authenticated by keys instead
+func AuthMiddleware(sessions SessionChecker, keys KeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader, err := c.Cookie("clinic_token")
+		if rawKey := c.GetHeader(APIKeyHeader); rawKey != "" {
+			authenticateKey(c, keys, rawKey)
+			return
+		}
+
+		authHeader, err := c.Cookie(AccessTokenCookie)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cookie"})
 			c.Abort()
@@ -79,6 +120,17 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// tokens issued before sessions existed have no sid and are refused as well
+		sessionId, _ := (*claims)["sid"].(string)
+		userId, _ := (*claims)["sub"].(string)
+		if err := sessions.Check(c, sessionId, userId); err != nil {
+			logrus.Warnf("Session check failed for %s: %v", userId, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			c.Abort()
+			return
+		}
+
+		c.Set("session", sessionId)
 		c.Set("patient", (*claims)["sub"])
 		c.Set("doctor", (*claims)["sub"])
 		c.Set("nurse", (*claims)["sub"])
@@ -89,6 +141,32 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authenticateKey lets a service account through as its Casbin role, within the scopes
+// of its key
+func authenticateKey(c *gin.Context, keys KeyAuthenticator, rawKey string) {
+	key, err := keys.Authenticate(c, rawKey, c.ClientIP())
+	if err != nil {
+		logrus.Warnf("API key authentication failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+		c.Abort()
+		return
+	}
+	if !key.Allows(c.Request.Method) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key scope does not allow this request"})
+		c.Abort()
+		return
+	}
+
+	claims := &jwt.MapClaims{
+		"sub":  key.ServiceAccountId.String(),
+		"role": key.ServiceAccount.Role,
+		"kid":  key.Id.String(),
+	}
+	c.Set(apiKeyKey, key.Id.String())
+	c.Set("claims", claims)
+	c.Next()
+}
+
 func GetUserInfoFromContext(ctx *gin.Context) (string, string, error) {
 	claims, exists := ctx.Get("claims")
 	if !exists {
@@ -147,11 +225,15 @@ func GetPatientIdFromToken(ctx *gin.Context) (string, string, error) {
 	return userId, role, nil
 }
 
-func AllowCORS() gin.HandlerFunc {
+// AllowCORS lets the pages of allowedOrigins call the API with their cookies
+func AllowCORS(allowedOrigins []string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+		ctx.Writer.Header().Add("Vary", "Origin")
+		if origin := ctx.GetHeader("Origin"); slices.Contains(allowedOrigins, origin) {
+			ctx.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		}
 		ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, clinic_token, Authorization, Cookie")
+		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, clinic_token, Authorization, Cookie, X-Device-Id, X-API-Key")
 		ctx.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
 		ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Set-Cookie")
 
@@ -162,3 +244,46 @@ func AllowCORS() gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// SetSessionCookies stores the tokens of a login or refresh, each cookie lives as long
+// as its token. A login waiting for its second factor has no tokens yet
+func SetSessionCookies(ctx *gin.Context, resp *dto.LoginResponse) {
+	if resp.Token == "" {
+		return
+	}
+	ctx.SetCookie(AccessTokenCookie, resp.Token, int(time.Until(resp.ExpiresAt).Seconds()), "/", "", false, true)
+	ctx.SetCookie(RefreshTokenCookie, resp.RefreshToken, int(time.Until(resp.RefreshExpiresAt).Seconds()), "/", "", false, true)
+	ctx.SetCookie(DeviceCookie, resp.DeviceId, 3600*24*365, "/", "", false, true)
+}
+
+// LoginFailed answers a refused login, throttled attempts get 429 and Retry-After
+func LoginFailed(ctx *gin.Context, err error) {
+	var throttled *loginguardusecase.ThrottleError
+	if errors.As(err, &throttled) {
+		ctx.Header("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, errorsresponse.NewCustomErrResponse(http.StatusTooManyRequests, err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+}
+
+func ClearSessionCookies(ctx *gin.Context) {
+	ctx.SetCookie(AccessTokenCookie, "", -1, "/", "", false, true)
+	ctx.SetCookie(RefreshTokenCookie, "", -1, "/", "", false, true)
+}
+
+// GetClientInfo identifies the requesting device
+func GetClientInfo(ctx *gin.Context) dto.ClientInfo {
+	deviceId := ctx.GetHeader(DeviceHeader)
+	if deviceId == "" {
+		deviceId, _ = ctx.Cookie(DeviceCookie)
+	}
+	if len(deviceId) > 64 {
+		deviceId = deviceId[:64]
+	}
+	return dto.ClientInfo{
+		DeviceId:  deviceId,
+		UserAgent: ctx.Request.UserAgent(),
+		IP:        ctx.ClientIP(),
+	}
+}
@@ -1,17 +1,18 @@
-
 package middleware
 
 import (
 	"net/http"
 
-
-
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
 )
 
-func CasbinMiddleware(e *casbin.Enforcer) gin.HandlerFunc {
+// CasbinMiddleware authorizes the request against the policy, by the role of the token
+// and then by the user id, which the g rules may grant extra roles. It runs after
+// AuthMiddleware
+func CasbinMiddleware(e *casbin.SyncedEnforcer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		claims, exists := c.Get("claims")
 		if !exists {
@@ -33,16 +34,22 @@ func CasbinMiddleware(e *casbin.Enforcer) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		userId, _ := (*mapClaims)["sub"].(string)
 
 		// casbin enforce
 		ok, err := e.Enforce(role, c.Request.URL.Path, c.Request.Method)
+		if err == nil && !ok && userId != "" {
+			ok, err = e.Enforce(userId, c.Request.URL.Path, c.Request.Method)
+		}
 		if err != nil {
+			logrus.Errorf("Casbin enforce failed: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error during authorization"})
 			c.Abort()
 			return
 		}
 
 		if !ok {
+			logrus.Warnf("Access denied for %s (%s) on %s %s", userId, role, c.Request.Method, c.Request.URL.Path)
 			c.JSON(http.StatusForbidden, gin.H{"error": "you are not authorized"})
 			c.Abort()
 			return
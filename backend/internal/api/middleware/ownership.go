@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"backend/internal/domain/dto"
+	accessusecase "backend/internal/usecase/access_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// facultyKey holds the faculty StaffScope resolved for the request
+const facultyKey = "faculty"
+
+// GetActorFromContext builds the caller of the request from the token claims, it runs
+// after AuthMiddleware
+func GetActorFromContext(ctx *gin.Context) (*dto.Actor, error) {
+	userId, role, err := GetUserInfoFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.Actor{
+		Id:        userId,
+		Role:      role,
+		FacultyId: GetFacultyFromContext(ctx),
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}, nil
+}
+
+// GetFacultyFromContext returns the faculty of the doctor or nurse calling, zero outside
+// StaffScope
+func GetFacultyFromContext(ctx *gin.Context) byte {
+	facultyId, _ := ctx.Get(facultyKey)
+	f, _ := facultyId.(byte)
+	return f
+}
+
+// StaffScope resolves the faculty of the doctor or nurse calling, their access is
+// scoped to it. Staff without a faculty are refused, and so are service accounts whose
+// role inherits a staff role, they belong to no faculty
+func StaffScope(access accessusecase.AccessUsecase) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(apiKeyKey); ok {
+			c.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, "Service accounts cannot use the faculty scoped staff routes"))
+			c.Abort()
+			return
+		}
+		actor, ok := requireActor(c)
+		if !ok {
+			return
+		}
+		facultyId, err := access.Faculty(c, actor)
+		if err != nil {
+			AbortAccess(c, err)
+			return
+		}
+		c.Set(facultyKey, facultyId)
+		c.Next()
+	}
+}
+
+// RequirePatientAccess checks the patient id of the path parameter against the caller
+func RequirePatientAccess(access accessusecase.AccessUsecase, param string, action accessusecase.Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := requireActor(c)
+		if !ok {
+			return
+		}
+		if err := access.Patient(c, actor, c.Param(param), action); err != nil {
+			AbortAccess(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireBookingAccess checks the booking id of the path parameter, or else of the
+// query parameter, named key against the caller
+func RequireBookingAccess(access accessusecase.AccessUsecase, key string, action accessusecase.Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := requireActor(c)
+		if !ok {
+			return
+		}
+		id := c.Param(key)
+		if id == "" {
+			id = c.Query(key)
+		}
+		queueId, err := strconv.Atoi(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid Queue ID"))
+			c.Abort()
+			return
+		}
+		if err := access.Booking(c, actor, queueId, action); err != nil {
+			AbortAccess(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireStaffAccess lets doctors and nurses change their own record only
+func RequireStaffAccess(access accessusecase.AccessUsecase, param string, action accessusecase.Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := requireActor(c)
+		if !ok {
+			return
+		}
+		if err := access.Staff(c, actor, c.Param(param), action); err != nil {
+			AbortAccess(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+func requireActor(c *gin.Context) (*dto.Actor, bool) {
+	actor, err := GetActorFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		c.Abort()
+		return nil, false
+	}
+	return actor, true
+}
+
+// accessDeniedKey marks requests whose denial the access auditor already recorded
+const accessDeniedKey = "access_denied"
+
+// AbortAccess answers a failed access check
+func AbortAccess(c *gin.Context, err error) {
+	c.Set(accessDeniedKey, true)
+	switch {
+	case errors.Is(err, accessusecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, "You are not allowed to access this resource"))
+	case errors.Is(err, accessusecase.ErrNoFaculty):
+		c.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, err.Error()))
+	default:
+		logrus.Errorf("Access check failed: %v", err)
+		c.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "error during authorization"))
+	}
+	c.Abort()
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaffScope_RefusesServiceAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// a service account whose role inherits doctor passed Casbin
+	r.GET("/api/doctor/queues", func(c *gin.Context) {
+		c.Set(apiKeyKey, "key-1")
+		c.Set("claims", &jwt.MapClaims{"sub": "service-1", "role": "lab"})
+	}, StaffScope(nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/doctor/queues", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Service accounts")
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"backend/internal/infrastructure/ratelimit"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimitMiddleware applies the rules of a route group, every rule is counted and the
+// most restrictive one is reported in the RateLimit headers. A rule without a subject,
+// e.g. by phone on a request that has none, is skipped. When Redis fails the request
+// goes through, locking everyone out of login is worse than a short unthrottled window
+func RateLimitMiddleware(limiter *ratelimit.Limiter, group string, rules []ratelimit.Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reported *ratelimit.Result
+		for _, rule := range rules {
+			subject := rateLimitSubject(c, rule.Key)
+			if subject == "" {
+				continue
+			}
+
+			res, err := limiter.Allow(c, group, rule, subject)
+			if err != nil {
+				logrus.Warnf("Rate limit %s skipped: %v", group, err)
+				continue
+			}
+			if reported == nil || moreRestrictive(res, *reported) {
+				reported = &res
+			}
+		}
+		if reported == nil {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.FormatInt(reported.Rule.Limit, 10))
+		c.Header("RateLimit-Remaining", strconv.FormatInt(reported.Remaining, 10))
+		c.Header("RateLimit-Reset", seconds(reported.Reset))
+		c.Header("RateLimit-Policy", reported.Rule.Policy())
+
+		if !reported.Allowed {
+			logrus.Warnf("Rate limit %s exceeded on %s by %s", group, reported.Rule.Key, c.ClientIP())
+			c.Header("Retry-After", seconds(reported.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, errorsresponse.NewCustomErrResponse(http.StatusTooManyRequests, "Too many requests, please try again later"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func moreRestrictive(a, b ratelimit.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+func rateLimitSubject(c *gin.Context, key ratelimit.KeyBy) string {
+	switch key {
+	case ratelimit.KeyByIP:
+		return c.ClientIP()
+	case ratelimit.KeyByUser:
+		// the group must run after AuthMiddleware for this one
+		userId, _, err := GetUserInfoFromContext(c)
+		if err != nil {
+			return ""
+		}
+		return userId
+	case ratelimit.KeyByPhone:
+		phone := strings.TrimSpace(phoneNumberFromRequest(c))
+		if phone == "" {
+			return ""
+		}
+		// phone numbers are not written to Redis in clear
+		sum := sha256.Sum256([]byte(phone))
+		return hex.EncodeToString(sum[:8])
+	}
+	return ""
+}
+
+// phoneNumberFromRequest reads phone_number from a JSON or form body and leaves the body
+// in place for the handler
+func phoneNumberFromRequest(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	if strings.HasPrefix(c.ContentType(), gin.MIMEJSON) {
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+		var req struct {
+			PhoneNumber string `json:"phone_number"`
+		}
+		_ = json.Unmarshal(body, &req)
+		return req.PhoneNumber
+	}
+	return c.PostForm("phone_number")
+}
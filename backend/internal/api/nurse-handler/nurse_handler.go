@@ -1,38 +1,43 @@
 package nursehandler
 
 import (
+	"backend/internal/api/middleware"
 	"backend/internal/domain/dto"
 	dtonurse "backend/internal/domain/dto/dto_nurse"
 	dtoqueue "backend/internal/domain/dto/queue"
-	"backend/internal/infrastructure/redis"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/realtime"
+	accessusecase "backend/internal/usecase/access_usecase"
 	nurseusecase "backend/internal/usecase/nurse-usecase"
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
 	serviceusecase "backend/internal/usecase/service_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
 	"backend/pkg/common/pagination"
-	"backend/pkg/constants"
-	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type NurseHandler struct {
 	nurseSvc  nurseusecase.NurseUsecase
 	mqUsecase serviceusecase.BookingQueueUseCase
-	redis     redis.RedisClient
+	hub       *realtime.Hub
+	feed      queuefeedusecase.QueueFeedUsecase
+	access    accessusecase.AccessUsecase
 }
 
-func NewNurseHandler(nurseSvc nurseusecase.NurseUsecase, mqUsecase serviceusecase.BookingQueueUseCase, redis redis.RedisClient) *NurseHandler {
+func NewNurseHandler(nurseSvc nurseusecase.NurseUsecase, mqUsecase serviceusecase.BookingQueueUseCase, hub *realtime.Hub, feed queuefeedusecase.QueueFeedUsecase, access accessusecase.AccessUsecase) *NurseHandler {
 	return &NurseHandler{
 		nurseSvc:  nurseSvc,
 		mqUsecase: mqUsecase,
-		redis:     redis,
+		hub:       hub,
+		feed:      feed,
+		access:    access,
 	}
 }
 
@@ -62,14 +67,15 @@ func (h *NurseHandler) LoginNurse(ctx *gin.Context) {
 		return
 	}
 
+	req.Client = middleware.GetClientInfo(ctx)
 	resp, err := h.nurseSvc.Login(ctx, &req)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+		middleware.LoginFailed(ctx, err)
 		logrus.Error(err)
 		return
 	}
 
-	ctx.SetCookie("clinic_token", resp.Token, 3600*24, "/", "", false, true)
+	middleware.SetSessionCookies(ctx, resp)
 
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &resp, "Nurse login successfully"))
 }
@@ -155,195 +161,129 @@ func (h *NurseHandler) GetNurseProfile(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &nurse, "Nurse profile fetched"))
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
-type ClientWS struct {
-	conn *websocket.Conn
-	send chan []byte
-}
-
-func (cws *ClientWS) writeMsg() {
-	for msg := range cws.send {
-		if err := cws.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			logrus.Errorf("write error: %s", err)
-			return
-		}
+// GetAllBookingQueues streams the live queue of the caller's faculty over a websocket,
+// updates are pushed by the hub
+func (h *NurseHandler) GetAllBookingQueues(ctx *gin.Context) {
+	scope := realtime.Scope{FacultyId: middleware.GetFacultyFromContext(ctx)}
+	if err := h.hub.ServeWS(ctx.Writer, ctx.Request, scope); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		return
 	}
+	logrus.Info("Connection is closing ...")
 }
 
-func (h *NurseHandler) GetAllBookingQueues(ctx *gin.Context) {
+// ReorderQueues moves the given bookings to the front of the live queue, clients get a queue_reordered event
+func (h *NurseHandler) ReorderQueues(ctx *gin.Context) {
+	var req dtoqueue.ReorderQueueRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid input"))
+		logrus.Error(err)
+		return
+	}
 
-	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	actor, err := middleware.GetActorFromContext(ctx)
 	if err != nil {
-		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
 		return
 	}
-
-	defer logrus.Info("Connection is closing ...")
-	defer conn.Close()
-
-	client := &ClientWS{
-		conn: conn,
-		send: make(chan []byte, 256),
+	for _, queueId := range req.QueueIds {
+		if err := h.access.Booking(ctx, actor, queueId, accessusecase.ActionWrite); err != nil {
+			middleware.AbortAccess(ctx, err)
+			return
+		}
 	}
 
-	go client.writeMsg()
-
-	_, _, err = conn.ReadMessage()
+	err = h.feed.Reorder(ctx, req.QueueIds)
 	if err != nil {
-		log.Println(err)
+		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, queuefeedusecase.ErrUnknownQueue) {
+			ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to reorder queue"))
 		return
 	}
 
-	var paginationReq pagination.Pagination
-	err = ctx.ShouldBindQuery(&paginationReq)
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &req, "Queue reordered"))
+}
+
+// CallQueue sends the booking's patient to a room, their stream gets a queue_called event
+// and the next patient of the faculty is told they're next
+func (h *NurseHandler) CallQueue(ctx *gin.Context) {
+	queueId, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid id"))
 		return
 	}
 
-	var bqResponse []*dtoqueue.BookingQueueResponse
-	mapBookingQueue, err := h.redis.HGetAll(ctx, "queue")
-	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+	var req dtoqueue.CallQueueRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid input"))
+		logrus.Error(err)
 		return
 	}
 
-	if mapBookingQueue == nil {
-		resp, err := h.mqUsecase.GetBookingQueues(ctx, &paginationReq)
-		if err != nil {
-			conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-			return
-		}
-
-		var id int
-		for _, q := range resp {
-			id = q.QueueId
-		}
-
-		respMarshaled, err := json.Marshal(&resp)
+	// a doctor calling a booking treats it, which opens the patient's record to them. The
+	// assignment is made under the booking lock before the call goes out
+	var doctorId *uuid.UUID
+	if userId, role, err := middleware.GetUserInfoFromContext(ctx); err == nil && role == "doctor" {
+		id, err := uuid.Parse(userId)
 		if err != nil {
-			conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-			return
-		}
-
-		_, err = h.redis.HSet(ctx, "queue", id, respMarshaled)
-		if err != nil {
-			conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+			ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
 			return
 		}
+		doctorId = &id
 	}
 
-	for _, v := range mapBookingQueue {
-		var bq dtoqueue.BookingQueueResponse
-		err := json.Unmarshal([]byte(v), &bq)
-		if err != nil {
-			conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+	err = h.feed.Call(ctx, queueId, req.Room, doctorId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, queuefeedusecase.ErrUnknownQueue) {
+			ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
 			return
 		}
-		bqResponse = append(bqResponse, &bq)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to call patient"))
+		return
 	}
 
-	// fullResp := dto.PaginationResponse{
-	// 	Data:       bqResponse,
-	// 	Pagination: &paginationReq,
-	// }
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &req, "Patient called"))
+}
 
-	initalData := map[string]interface{}{
-		"type": "queue_list",
-		"data": bqResponse,
+// CallNext calls the next waiting patient of the nurse's faculty to a room, the
+// waiting-room boards chime
+func (h *NurseHandler) CallNext(ctx *gin.Context) {
+	var req dtoqueue.CallNextRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid input"))
+		logrus.Error(err)
+		return
 	}
 
-	bqresp, err := json.Marshal(initalData)
+	actor, err := middleware.GetActorFromContext(ctx)
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return
+	}
+	if req.FacultyId == 0 {
+		req.FacultyId = actor.FacultyId
+	}
+	if err := h.access.FacultyScope(ctx, actor, req.FacultyId); err != nil {
+		middleware.AbortAccess(ctx, err)
 		return
 	}
 
-	client.send <- bqresp
-
-	go func() {
-		sub := h.redis.SubChannel(ctx, constants.CHANNEL_REDIS)
-		ch := sub.Channel()
-		for message := range ch {
-			// When Redis trigger received, fetch fresh data and send to WebSocket
-			if message.Payload == "updated" {
-				var bqResponse []*dtoqueue.BookingQueueResponse
-				mapBookingQueue, err := h.redis.HGetAll(ctx, "queue")
-				if err != nil {
-					conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-					return
-				}
-
-				if mapBookingQueue == nil {
-					resp, err := h.mqUsecase.GetBookingQueues(ctx, &paginationReq)
-					if err != nil {
-						conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-						return
-					}
-
-					var id int
-					for _, q := range resp {
-						id = q.QueueId
-					}
-
-					respMarshaled, err := json.Marshal(&resp)
-					if err != nil {
-						conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-						return
-					}
-
-					_, err = h.redis.HSet(ctx, "queue", id, respMarshaled)
-					if err != nil {
-						conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-						return
-					}
-				}
-
-				for _, v := range mapBookingQueue {
-					var bq dtoqueue.BookingQueueResponse
-					err := json.Unmarshal([]byte(v), &bq)
-					if err != nil {
-						conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-						return
-					}
-					bqResponse = append(bqResponse, &bq)
-				}
-
-				// fullResp := dto.PaginationResponse{
-				// 	Data:       bqResponse,
-				// 	Pagination: &paginationReq,
-				// }
-
-				initalData := map[string]interface{}{
-					"type": "queue_list",
-					"data": bqResponse,
-				}
-
-				bqresp, err := json.Marshal(initalData)
-				if err != nil {
-					conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-					return
-				}
-
-				client.send <- bqresp
-			} else {
-				// Handle other message types
-				conn.WriteMessage(websocket.TextMessage, []byte(message.Payload))
-			}
-		}
-	}()
-
-	for {
-		select {
-		case <-ctx.Done():
+	called, err := h.feed.CallNext(ctx, req.FacultyId, req.Room, nil)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, queuefeedusecase.ErrQueueEmpty) {
+			ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
 			return
-		default:
-			time.Sleep(4 * time.Hour)
 		}
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to call next patient"))
+		return
 	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, called, "Patient called"))
 }
 
 func (h *NurseHandler) MarkCompleteQueue(ctx *gin.Context) {
@@ -353,13 +293,7 @@ func (h *NurseHandler) MarkCompleteQueue(ctx *gin.Context) {
 		return
 	}
 
-	err := h.redis.HDel(ctx, "queue", queueIdStr)
-	if err != nil {
-		logrus.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Error has occur when mark complete this patient"))
-		return
-	}
-
+	// the cached entry is dropped by the booking cache consumer once the status change is relayed
 	queueId, err := strconv.Atoi(queueIdStr)
 	if err != nil {
 		logrus.Errorf("Handler layer: %v", err)
@@ -370,6 +304,10 @@ func (h *NurseHandler) MarkCompleteQueue(ctx *gin.Context) {
 	err = h.mqUsecase.UpdateBookingStatus(ctx, queueId, "completed")
 	if err != nil {
 		logrus.Errorf("Handler layer: %v", err)
+		if errors.Is(err, persistence.ErrStaleFence) {
+			ctx.JSON(http.StatusConflict, errorsresponse.NewCustomErrResponse(http.StatusConflict, "Booking was changed meanwhile, please retry"))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Error has occur when updating complete status"))
 		return
 	}
@@ -87,6 +87,34 @@ func (h *PatientHandler) GetPatients(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &fullResp, "Data fetched"))
 }
 
+// GetStaffPatients lists the patients the calling doctor or nurse has a booking with
+func (h *PatientHandler) GetStaffPatients(ctx *gin.Context) {
+	var paginationReq pagination.Pagination
+	if err := ctx.ShouldBindQuery(&paginationReq); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid query parameters"))
+		return
+	}
+
+	actor, err := middleware.GetActorFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return
+	}
+
+	resp, err := h.patientSvc.GetPatientsOfStaff(ctx, &paginationReq, actor.Id, actor.FacultyId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch patient's data"))
+		return
+	}
+
+	fullResp := dto.PaginationResponse[dtopatient.PatientResponse]{
+		Data:       resp,
+		Pagination: &paginationReq,
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &fullResp, "Data fetched"))
+}
+
 func (h *PatientHandler) GetPatientById(ctx *gin.Context) {
 	id := ctx.Param("id")
 
@@ -189,14 +217,15 @@ func (h *PatientHandler) LoginPatient(ctx *gin.Context) {
 		return
 	}
 
+	req.Client = middleware.GetClientInfo(ctx)
 	loginResp, err := h.patientSvc.LoginPatient(ctx, &req)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, err.Error()))
+		middleware.LoginFailed(ctx, err)
 		logrus.Error(err)
 		return
 	}
 
-	ctx.SetCookie("clinic_token", loginResp.Token, 3600*24, "/", "", false, true)
+	middleware.SetSessionCookies(ctx, loginResp)
 
 	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, loginResp, "Login successfully"))
 }
@@ -1,7 +1,6 @@
 package paymenthandler
 
 import (
-	messagequeue "backend/internal/usecase/message_queue"
 	paymentusecase "backend/internal/usecase/payment_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"io"
@@ -13,13 +12,11 @@ import (
 )
 
 type PaymentHandler struct {
-	rbmqUsecase    messagequeue.RabbitMQUsecase
 	paymentUsecase paymentusecase.PaymentMethods
 }
 
-func NewPaymentHandler(rbmqUsecase messagequeue.RabbitMQUsecase, paymentUsecase paymentusecase.PaymentMethods) PaymentHandler {
-	return PaymentHandler{rbmqUsecase: rbmqUsecase,
-		paymentUsecase: paymentUsecase}
+func NewPaymentHandler(paymentUsecase paymentusecase.PaymentMethods) PaymentHandler {
+	return PaymentHandler{paymentUsecase: paymentUsecase}
 }
 
 var webhookSecret = "your-webhook-secret-key"
@@ -49,12 +46,12 @@ func (h *PaymentHandler) HandleWebHook(ctx *gin.Context) {
 		bookingQueuePublish, err := h.paymentUsecase.WebhookCheckAndSolving(event)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to process payment"))
-			return 
+			return
 		}
 
-		err = h.rbmqUsecase.PublishBooking(ctx, bookingQueuePublish)
+		err = h.paymentUsecase.ConfirmPayment(ctx, bookingQueuePublish)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to publish booking queue"))
+			ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to confirm payment"))
 			return
 		}
 	}
@@ -0,0 +1,109 @@
+package policyhandler
+
+import (
+	"backend/internal/domain/dto"
+	policyusecase "backend/internal/usecase/policy_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type PolicyHandler struct {
+	policies policyusecase.PolicyUsecase
+}
+
+func NewPolicyHandler(policies policyusecase.PolicyUsecase) *PolicyHandler {
+	return &PolicyHandler{policies: policies}
+}
+
+// policyError maps the usecase errors to their status
+func policyError(ctx *gin.Context, err error, msg string) {
+	logrus.Errorf("Handler layer: %v", err)
+	switch {
+	case errors.Is(err, policyusecase.ErrInvalidPolicy):
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+	case errors.Is(err, policyusecase.ErrProtectedPolicy):
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, err.Error()))
+	case errors.Is(err, policyusecase.ErrPolicyNotFound):
+		ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+	case errors.Is(err, policyusecase.ErrPolicyExists):
+		ctx.JSON(http.StatusConflict, errorsresponse.NewCustomErrResponse(http.StatusConflict, err.Error()))
+	default:
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, msg))
+	}
+}
+
+func (h *PolicyHandler) GetPolicies(ctx *gin.Context) {
+	policies, err := h.policies.List(ctx)
+	if err != nil {
+		policyError(ctx, err, "Failed to fetch policies")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, policies, "Policies fetched"))
+}
+
+func (h *PolicyHandler) AddPolicy(ctx *gin.Context) {
+	var req dto.PolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	if err := h.policies.AddPolicy(ctx, &req); err != nil {
+		policyError(ctx, err, "Failed to add policy")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, req, "Policy added"))
+}
+
+func (h *PolicyHandler) RemovePolicy(ctx *gin.Context) {
+	var req dto.PolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	if err := h.policies.RemovePolicy(ctx, &req); err != nil {
+		policyError(ctx, err, "Failed to remove policy")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, req, "Policy removed"))
+}
+
+func (h *PolicyHandler) AddRole(ctx *gin.Context) {
+	var req dto.RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	if err := h.policies.AddRole(ctx, &req); err != nil {
+		policyError(ctx, err, "Failed to add role")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, req, "Role added"))
+}
+
+func (h *PolicyHandler) RemoveRole(ctx *gin.Context) {
+	var req dto.RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	if err := h.policies.RemoveRole(ctx, &req); err != nil {
+		policyError(ctx, err, "Failed to remove role")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, req, "Role removed"))
+}
+
+// ReloadPolicies rereads the policy on this instance, for rules edited in the database
+// by hand
+func (h *PolicyHandler) ReloadPolicies(ctx *gin.Context) {
+	if err := h.policies.Reload(ctx); err != nil {
+		policyError(ctx, err, "Failed to reload policies")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Policies reloaded"))
+}
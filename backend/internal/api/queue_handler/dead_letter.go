@@ -0,0 +1,82 @@
+package queuehandler
+
+import (
+	"backend/internal/domain/dto"
+	dtoqueue "backend/internal/domain/dto/queue"
+	messagequeue "backend/internal/usecase/message_queue"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"backend/pkg/common/pagination"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type DeadLetterHandler struct {
+	rbmqUsecase messagequeue.RabbitMQUsecase
+}
+
+func NewDeadLetterHandler(rbmqUsecase messagequeue.RabbitMQUsecase) *DeadLetterHandler {
+	return &DeadLetterHandler{rbmqUsecase: rbmqUsecase}
+}
+
+func (h *DeadLetterHandler) GetDeadLetters(ctx *gin.Context) {
+	var paginationReq pagination.Pagination
+	if err := ctx.ShouldBindQuery(&paginationReq); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid query parameters"))
+		return
+	}
+
+	var filter dtoqueue.DeadLetterFilter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid query parameters"))
+		return
+	}
+
+	resp, err := h.rbmqUsecase.GetDeadLetters(ctx, &paginationReq, filter.Status)
+	if err != nil {
+		logrus.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch dead letters"))
+		return
+	}
+
+	fullResp := dto.PaginationResponse[dtoqueue.DeadLetterResponse]{
+		Data:       resp,
+		Pagination: &paginationReq,
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &fullResp, "Data fetched"))
+}
+
+func (h *DeadLetterHandler) RequeueDeadLetter(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid id"))
+		return
+	}
+
+	if err := h.rbmqUsecase.RequeueDeadLetter(ctx, id); err != nil {
+		logrus.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, id, "Dead letter requeued"))
+}
+
+func (h *DeadLetterHandler) DiscardDeadLetter(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid id"))
+		return
+	}
+
+	if err := h.rbmqUsecase.DiscardDeadLetter(ctx, id); err != nil {
+		logrus.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, id, "Dead letter discarded"))
+}
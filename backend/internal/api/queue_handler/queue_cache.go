@@ -0,0 +1,47 @@
+package queuehandler
+
+import (
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// QueueCacheHandler lets admins check the Redis live queue against Postgres
+type QueueCacheHandler struct {
+	feed queuefeedusecase.QueueFeedUsecase
+}
+
+func NewQueueCacheHandler(feed queuefeedusecase.QueueFeedUsecase) *QueueCacheHandler {
+	return &QueueCacheHandler{feed: feed}
+}
+
+// GetQueueDrift reports the differences without fixing them
+func (h *QueueCacheHandler) GetQueueDrift(ctx *gin.Context) {
+	drift, err := h.feed.Drift(ctx)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to compare queue cache"))
+		return
+	}
+
+	message := "Queue cache is in sync"
+	if !drift.InSync() {
+		message = "Queue cache has drifted"
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, drift, message))
+}
+
+// ReconcileQueue rebuilds the cache now instead of waiting for the next round
+func (h *QueueCacheHandler) ReconcileQueue(ctx *gin.Context) {
+	drift, err := h.feed.Reconcile(ctx)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to reconcile queue cache"))
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, drift, "Queue cache reconciled"))
+}
@@ -0,0 +1,83 @@
+package realtimehandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/infrastructure/realtime"
+	doctorusecase "backend/internal/usecase/doctor-usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RealtimeHandler serves the live queue as Server-Sent Events, authenticated by the
+// clinic_token cookie like the rest of the API
+type RealtimeHandler struct {
+	hub       *realtime.Hub
+	doctorSvc doctorusecase.DoctorUsecase
+}
+
+func NewRealtimeHandler(hub *realtime.Hub, doctorSvc doctorusecase.DoctorUsecase) *RealtimeHandler {
+	return &RealtimeHandler{
+		hub:       hub,
+		doctorSvc: doctorSvc,
+	}
+}
+
+// NurseQueueStream streams the live queue of the nurse's faculty
+func (h *RealtimeHandler) NurseQueueStream(ctx *gin.Context) {
+	if _, ok := h.authorize(ctx, "nurse"); !ok {
+		return
+	}
+	h.serve(ctx, realtime.Scope{FacultyId: middleware.GetFacultyFromContext(ctx)})
+}
+
+// DoctorQueueStream streams the worklist of the doctor's faculty
+func (h *RealtimeHandler) DoctorQueueStream(ctx *gin.Context) {
+	doctorId, ok := h.authorize(ctx, "doctor")
+	if !ok {
+		return
+	}
+
+	d, err := h.doctorSvc.GetDoctorById(ctx, doctorId)
+	if err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, "Failed to fetch doctor profile"))
+		return
+	}
+	if d.Faculty.FacultyId == 0 {
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, "Doctor is not assigned to a faculty"))
+		return
+	}
+
+	h.serve(ctx, realtime.Scope{FacultyId: d.Faculty.FacultyId})
+}
+
+// PatientBookingStream streams the bookings of the patient in the token, never anyone else's
+func (h *RealtimeHandler) PatientBookingStream(ctx *gin.Context) {
+	patientId, ok := h.authorize(ctx, "patient")
+	if !ok {
+		return
+	}
+	h.serve(ctx, realtime.Scope{PatientId: patientId})
+}
+
+func (h *RealtimeHandler) authorize(ctx *gin.Context, role string) (string, bool) {
+	userId, tokenRole, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Invalid token"))
+		return "", false
+	}
+	if tokenRole != role {
+		ctx.JSON(http.StatusForbidden, errorsresponse.NewCustomErrResponse(http.StatusForbidden, "Permission denied"))
+		return "", false
+	}
+	return userId, true
+}
+
+func (h *RealtimeHandler) serve(ctx *gin.Context, scope realtime.Scope) {
+	if err := h.hub.ServeSSE(ctx.Writer, ctx.Request, scope); err != nil {
+		logrus.Errorf("Handler layer: %v", err)
+	}
+}
@@ -1,95 +1,229 @@
 package api
 
 import (
+	analyticshandler "backend/internal/api/analytics_handler"
+	audithandler "backend/internal/api/audit_handler"
+	authhandler "backend/internal/api/auth_handler"
+	displayhandler "backend/internal/api/display_handler"
 	doctorhandler "backend/internal/api/doctor-handler"
 	"backend/internal/api/middleware"
 	nurseHandler "backend/internal/api/nurse-handler"
 	patientHandler "backend/internal/api/patient-handler"
 	paymenthandler "backend/internal/api/payment_handler"
+	policyhandler "backend/internal/api/policy_handler"
+	queuehandler "backend/internal/api/queue_handler"
+	realtimehandler "backend/internal/api/realtime_handler"
+	serviceaccounthandler "backend/internal/api/service_account_handler"
 	servicehandler "backend/internal/api/service_handler"
+	"backend/internal/infrastructure/cache"
 	"backend/internal/infrastructure/db"
+	"backend/internal/infrastructure/ratelimit"
+	"backend/internal/infrastructure/realtime"
 	"backend/internal/usecase"
+	accessusecase "backend/internal/usecase/access_usecase"
 	cloudinaryutils "backend/pkg/common/utils/cloudinary_utils"
+	dbinit "backend/pkg/db_init"
+	"context"
 
+	accountrepository "backend/internal/infrastructure/persistence/account_repository"
+	auditrepository "backend/internal/infrastructure/persistence/audit_repository"
+	displayrepository "backend/internal/infrastructure/persistence/display_repository"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
 	patientrepository "backend/internal/infrastructure/persistence/patient_repository"
+	paymentrepository "backend/internal/infrastructure/persistence/payment_repository"
+	serviceaccountrepository "backend/internal/infrastructure/persistence/service_account_repository"
 	persistence "backend/internal/infrastructure/persistence/service_repository"
+	ssoidentityrepository "backend/internal/infrastructure/persistence/sso_identity_repository"
 	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
 	doctorrepository "backend/internal/infrastructure/persistence/staff_repository/doctor_repository"
 	nurserepository "backend/internal/infrastructure/persistence/staff_repository/nurse_repository"
+	twofactorrepository "backend/internal/infrastructure/persistence/two_factor_repository"
 	"backend/internal/infrastructure/redis"
+	analyticsusecase "backend/internal/usecase/analytics_usecase"
+	auditusecase "backend/internal/usecase/audit_usecase"
+	displayusecase "backend/internal/usecase/display_usecase"
 	doctorusecase "backend/internal/usecase/doctor-usecase"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
 	messagequeue "backend/internal/usecase/message_queue"
 	nurseUsecase "backend/internal/usecase/nurse-usecase"
 	patientUsecase "backend/internal/usecase/patient-usecase"
 	paymentusecase "backend/internal/usecase/payment_usecase"
+	policyusecase "backend/internal/usecase/policy_usecase"
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
+	recoveryusecase "backend/internal/usecase/recovery_usecase"
+	serviceaccountusecase "backend/internal/usecase/service_account_usecase"
 	serviceusecase "backend/internal/usecase/service_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	ssousecase "backend/internal/usecase/sso_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // localhost:9000/api
-func SetupRoutes(r *gin.RouterGroup, e *casbin.Enforcer, rbmqUsecase messagequeue.RabbitMQUsecase, rc *redis.RedisClient) {
+func SetupRoutes(r *gin.RouterGroup, e *casbin.SyncedEnforcer, rbmqUsecase messagequeue.RabbitMQUsecase, analyticsUsecase analyticsusecase.AnalyticsUsecase, queueFeed queuefeedusecase.QueueFeedUsecase, queueHub *realtime.Hub, rc redis.RedisConnection) {
 	drugRecepitRepository := doctorrepository.NewDrugReceiptRepository(db.DatabaseClient.GetDB())
 	messageQueueRepo := persistence.NewBookingQueueRepository(db.DatabaseClient.GetDB())
-	messageQueueUsecase := serviceusecase.NewBookingQueueUsecase(messageQueueRepo)
+	messageQueueUsecase := serviceusecase.NewBookingQueueUsecase(messageQueueRepo, rc)
+
+	// sessions, every login opens one and AuthMiddleware checks it on each request
+	sessionUsecase := sessionusecase.NewSessionUsecase(rc, dbinit.AccessTokenTTL(), dbinit.RefreshTokenTTL())
+
+	// service accounts of the machine integrations, their API keys are accepted in place
+	// of a session
+	serviceAccountUsecase := serviceaccountusecase.NewServiceAccountUsecase(serviceaccountrepository.NewServiceAccountRepository(db.DatabaseClient.GetDB()), dbinit.ServiceAccountConfig())
+	serviceAccountHandler := serviceaccounthandler.NewServiceAccountHandler(serviceAccountUsecase)
+	authMiddleware := middleware.AuthMiddleware(sessionUsecase, serviceAccountUsecase)
+
+	// failed login throttling and login history
+	loginGuard := loginguardusecase.NewLoginGuardUsecase(loginhistoryrepository.NewLoginHistoryRepository(db.DatabaseClient.GetDB()), rc, dbinit.LoginGuardConfig())
+
+	// staff second factor, the staff logins go through it to open their session
+	twoFactorRepo := twofactorrepository.NewTwoFactorRepository(db.DatabaseClient.GetDB())
+	twoFactorUsecase := twofactorusecase.NewTwoFactorUsecase(twoFactorRepo, sessionUsecase, loginGuard, rc, dbinit.TwoFactorIssuer(), dbinit.TwoFactorRequiredRoles())
+	casbinMiddleware := middleware.CasbinMiddleware(e)
+
+	// casbin policy, stored in the database and reloaded on every instance on change
+	policyUsecase := policyusecase.NewPolicyUsecase(e)
+	policyHandler := policyhandler.NewPolicyHandler(policyUsecase)
 
-	// nurse & message_queue
 	nurseRepo := nurserepository.NewNurseRepo(db.DatabaseClient.GetDB())
-	nurseService := nurseUsecase.NewNurseService(nurseRepo)
-	nurseHandler := nurseHandler.NewNurseHandler(nurseService, messageQueueUsecase, *rc)
+	doctorRepo := doctorrepository.NewDoctorRepo(db.DatabaseClient.GetDB())
+
+	// audit trail of clinical data, hash chained and append-only
+	auditUsecase := auditusecase.NewAuditUsecase(auditrepository.NewAuditRepository(db.DatabaseClient.GetDB()))
+	auditHandler := audithandler.NewAuditHandler(auditUsecase)
+	audit := func(resource string, action accessusecase.Action, key string) gin.HandlerFunc {
+		return middleware.Audit(auditUsecase, resource, action, key)
+	}
+	read, write := accessusecase.ActionRead, accessusecase.ActionWrite
+
+	// record ownership on top of the casbin route permissions, denials go to the audit log
+	accessUsecase := accessusecase.NewAccessUsecase(messageQueueRepo, doctorRepo, nurseRepo, auditUsecase.AccessAuditor())
+	staffScope := middleware.StaffScope(accessUsecase)
+
+	// nurse & message_queue
+	nurseService := nurseUsecase.NewNurseService(nurseRepo, twoFactorUsecase, loginGuard)
+	nurseHandler := nurseHandler.NewNurseHandler(nurseService, messageQueueUsecase, queueHub, queueFeed, accessUsecase)
 
 	// doctor
-	doctorRepo := doctorrepository.NewDoctorRepo(db.DatabaseClient.GetDB())
-	doctorService := doctorusecase.NewDoctorUsecase(doctorRepo, drugRecepitRepository)
-	doctorHandler := doctorhandler.NewDoctorHandler(doctorService, messageQueueUsecase)
+	doctorService := doctorusecase.NewDoctorUsecase(doctorRepo, drugRecepitRepository, twoFactorUsecase, loginGuard)
+	doctorHandler := doctorhandler.NewDoctorHandler(doctorService, messageQueueUsecase, queueFeed, accessUsecase)
+
+	// live queue over SSE
+	realtimeHandler := realtimehandler.NewRealtimeHandler(queueHub, doctorService)
+
+	// waiting-room boards
+	displayRepo := displayrepository.NewDisplayBoardRepository(db.DatabaseClient.GetDB())
+	displayUsecase := displayusecase.NewDisplayUsecase(displayRepo, queueFeed)
+	displayHandler := displayhandler.NewDisplayHandler(displayUsecase, queueHub)
+
+	// the catalog is read through Redis, service writes invalidate it
+	catalogCache := cache.NewCatalogCache(rc, dbinit.CatalogCacheTTL())
 
 	// categories
-	categoryRepo := persistence.NewServiceCategoryRepository(db.DatabaseClient.GetDB())
+	categoryRepo := persistence.NewCachedServiceCategoryRepository(persistence.NewServiceCategoryRepository(db.DatabaseClient.GetDB()), catalogCache)
 	categoryUsecase := serviceusecase.NewServiceCategoryUsecase(categoryRepo)
-	categoryHandler := servicehandler.NewServiceCategoryHandler(categoryUsecase)
+	categoryHandler := servicehandler.NewServiceCategoryHandler(categoryUsecase, catalogCache)
 
 	// sub-categories
-	subcategoryRepo := persistence.NewServiceSubCategoryRepository(db.DatabaseClient.GetDB())
+	subcategoryRepo := persistence.NewCachedServiceSubCategoryRepository(persistence.NewServiceSubCategoryRepository(db.DatabaseClient.GetDB()), catalogCache)
 	subcategoryUsecase := serviceusecase.NewServiceSubCategoryUsecase(subcategoryRepo)
-	subcategoryHandler := servicehandler.NewServiceSubCategoryHandler(subcategoryUsecase)
+	subcategoryHandler := servicehandler.NewServiceSubCategoryHandler(subcategoryUsecase, catalogCache)
 
 	// service
-	serviceRepo := persistence.NewServiceRepository(db.DatabaseClient.GetDB())
+	serviceRepo := persistence.NewCachedServiceRepository(persistence.NewServiceRepository(db.DatabaseClient.GetDB()), catalogCache)
 	serviceUsecase := serviceusecase.NewServicesUsecase(serviceRepo)
-	serviceHandler := servicehandler.NewServiceHandler(serviceUsecase)
+	serviceHandler := servicehandler.NewServiceHandler(serviceUsecase, catalogCache)
 
 	// payment
-	paymentUsecase := paymentusecase.NewPaymentMethods()
-	paymentHandler := paymenthandler.NewPaymentHandler(rbmqUsecase, paymentUsecase)
+	paymentRepo := paymentrepository.NewPaymentRepository(db.DatabaseClient.GetDB())
+	paymentUsecase := paymentusecase.NewPaymentMethods(paymentRepo)
+	paymentHandler := paymenthandler.NewPaymentHandler(paymentUsecase)
+
+	// dead letters
+	deadLetterHandler := queuehandler.NewDeadLetterHandler(rbmqUsecase)
+	queueCacheHandler := queuehandler.NewQueueCacheHandler(queueFeed)
+
+	// analytics
+	analyticsHandler := analyticshandler.NewAnalyticsHandler(analyticsUsecase)
 
 	avatarUploader := cloudinaryutils.NewAvatarUploader()
 	// patient
 	patientRepo := patientrepository.NewPatientRepo(db.DatabaseClient.GetDB())
-	patientService := patientUsecase.NewPatientService(patientRepo, *rc, avatarUploader)
+	patientService := patientUsecase.NewPatientService(patientRepo, sessionUsecase, avatarUploader, loginGuard)
 	patientHandler := patientHandler.NewPatientHandler(patientService, serviceUsecase, messageQueueUsecase, paymentUsecase)
 
+	// password recovery with one-time codes, a reset signs the account out everywhere
+	accountRepo := accountrepository.NewAccountRepository(db.DatabaseClient.GetDB())
+	recoveryUsecase := recoveryusecase.NewRecoveryUsecase(accountRepo, sessionUsecase, rc, dbinit.RecoverySender(), dbinit.RecoveryCodeTTL(), dbinit.RecoveryMaxAttempts())
+	// single sign-on of the staff, linked to the doctor and nurse records by email
+	ssoUsecase := ssousecase.NewSSOUsecase(accountRepo, ssoidentityrepository.NewSSOIdentityRepository(db.DatabaseClient.GetDB()), twoFactorUsecase, loginGuard, rc, dbinit.SSOConfig())
+	authHandler := authhandler.NewAuthHandler(sessionUsecase, recoveryUsecase, twoFactorUsecase, loginGuard, ssoUsecase)
+
 	adminRepository := staffrepository.NewAdminRepository(db.DatabaseClient.GetDB())
-	adminUsecase := usecase.NewAdminUsecase(adminRepository)
+	adminUsecase := usecase.NewAdminUsecase(adminRepository, twoFactorUsecase, loginGuard, sessionUsecase)
 	adminHandler := NewAdminHandler(adminUsecase)
+	// the first admin comes from admin.bootstrap in the config
+	bootstrapUser, bootstrapPassword := dbinit.AdminBootstrap()
+	if err := adminUsecase.Bootstrap(context.Background(), bootstrapUser, bootstrapPassword); err != nil {
+		logrus.Errorf("Failed to bootstrap the admin account: %v", err)
+	}
 
-	r.POST("/login/patient", patientHandler.LoginPatient)
-	r.POST("/login/nurse", nurseHandler.LoginNurse)
-	r.POST("/login/doctor", doctorHandler.LoginDoctor)
-	r.POST("/login/admin", adminHandler.LoginAdmin)
-	r.POST("/register", patientHandler.CreatePatient)
+	// rate limits per group, rate_limit.groups in the config
+	limiter := ratelimit.NewLimiter(rc)
+	rateLimit := func(group string) gin.HandlerFunc {
+		if !dbinit.RateLimitEnabled() {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return middleware.RateLimitMiddleware(limiter, group, dbinit.RateLimitRules(group))
+	}
+	loginLimit := rateLimit("login")
+
+	r.POST("/login/patient", loginLimit, patientHandler.LoginPatient)
+	r.POST("/login/nurse", loginLimit, nurseHandler.LoginNurse)
+	r.POST("/login/doctor", loginLimit, doctorHandler.LoginDoctor)
+	r.POST("/login/admin", loginLimit, adminHandler.LoginAdmin)
+	r.POST("/register", rateLimit("register"), patientHandler.CreatePatient)
+
+	r.POST("/logout", authHandler.Logout)
+	authGroup := r.Group("/auth")
+	{
+		authGroup.POST("/refresh", loginLimit, authHandler.Refresh)
+		authGroup.GET("/sessions", authMiddleware, authHandler.GetSessions)
+		authGroup.GET("/login-history", authMiddleware, authHandler.GetLoginHistory)
+
+		recoveryLimit := rateLimit("recovery")
+		authGroup.POST("/password/forgot", recoveryLimit, authHandler.ForgotPassword)
+		authGroup.POST("/password/verify", recoveryLimit, authHandler.VerifyResetCode)
+		authGroup.POST("/password/reset", recoveryLimit, authHandler.ResetPassword)
+
+		authGroup.POST("/sso/start", loginLimit, authHandler.StartSSO)
+		authGroup.POST("/sso/callback", loginLimit, authHandler.FinishSSO)
+
+		authGroup.POST("/2fa/challenge", loginLimit, authHandler.TwoFactorChallenge)
+		authGroup.POST("/2fa/challenge/enroll", loginLimit, authHandler.TwoFactorEnrollChallenge)
+		authGroup.GET("/2fa", authMiddleware, authHandler.GetTwoFactorStatus)
+		authGroup.POST("/2fa/enroll", authMiddleware, authHandler.EnrollTwoFactor)
+		authGroup.POST("/2fa/activate", authMiddleware, authHandler.ActivateTwoFactor)
+		authGroup.POST("/2fa/disable", authMiddleware, authHandler.DisableTwoFactor)
+		authGroup.POST("/2fa/recovery-codes", authMiddleware, authHandler.RegenerateRecoveryCodes)
+	}
 
 	adminGroup := r.Group("/admin")
+	adminGroup.Use(authMiddleware, casbinMiddleware)
 	{
-		adminGroup.POST("/create-patient", patientHandler.CreatePatient)
+		adminGroup.POST("/create-patient", audit("patient", write, ""), patientHandler.CreatePatient)
 		adminGroup.POST("/create-service", serviceHandler.CreateService)
 		adminGroup.POST("/create-nurse", nurseHandler.CreateNurse)
 		adminGroup.POST("/create-doctor", doctorHandler.CreateDoctor)
 
-		adminGroup.PUT("/patient/:id", patientHandler.UpdatePatient)
-		adminGroup.DELETE("/patient/:id", patientHandler.DeletePatient)
+		adminGroup.PUT("/patient/:id", audit("patient", write, "id"), patientHandler.UpdatePatient)
+		adminGroup.DELETE("/patient/:id", audit("patient", write, "id"), patientHandler.DeletePatient)
 
-		adminGroup.GET("/patients", patientHandler.GetPatients)
+		adminGroup.GET("/patients", audit("patient", read, ""), patientHandler.GetPatients)
 		adminGroup.GET("/nurses", nurseHandler.GetNurses)
 		adminGroup.GET("/nurse/:id", nurseHandler.GetNurseById)
 		adminGroup.DELETE("/nurse/:id", nurseHandler.DeleteNurse)
@@ -97,6 +231,68 @@ func SetupRoutes(r *gin.RouterGroup, e *casbin.Enforcer, rbmqUsecase messagequeu
 		adminGroup.GET("/doctors", doctorHandler.GetDoctors)
 		adminGroup.GET("/doctor/:id", doctorHandler.GetDoctorById)
 		adminGroup.DELETE("/doctor/:id", doctorHandler.DeleteDoctor)
+
+		adminGroup.GET("/dead-letters", deadLetterHandler.GetDeadLetters)
+		adminGroup.POST("/dead-letters/:id/requeue", deadLetterHandler.RequeueDeadLetter)
+		adminGroup.DELETE("/dead-letters/:id", deadLetterHandler.DiscardDeadLetter)
+
+		adminGroup.GET("/queues/drift", queueCacheHandler.GetQueueDrift)
+		adminGroup.POST("/queues/reconcile", queueCacheHandler.ReconcileQueue)
+
+		adminGroup.GET("/analytics/events", analyticsHandler.GetEventCounts)
+
+		adminGroup.GET("/users/:id/sessions", authHandler.GetUserSessions)
+		// signing out or resetting an admin takes a super admin
+		adminTarget := middleware.RequireSuperAdminForAdmins(adminUsecase, "id")
+		adminGroup.POST("/users/:id/sign-out", adminTarget, authHandler.ForceSignOut)
+		adminGroup.DELETE("/users/:id/2fa", adminTarget, authHandler.ResetTwoFactor)
+		adminGroup.GET("/users/:id/login-history", authHandler.GetUserLoginHistory)
+		adminGroup.DELETE("/login-locks", authHandler.UnlockLogin)
+
+		adminGroup.POST("/display-boards", displayHandler.CreateBoard)
+		adminGroup.GET("/display-boards", displayHandler.GetBoards)
+		adminGroup.DELETE("/display-boards/:id", displayHandler.DeleteBoard)
+
+		adminGroup.GET("/audit", auditHandler.SearchAuditLog)
+		adminGroup.GET("/audit/export", audit("audit_log", read, ""), auditHandler.ExportAuditLog)
+		adminGroup.GET("/audit/verify", auditHandler.VerifyAuditLog)
+
+		adminGroup.GET("/service-accounts", serviceAccountHandler.GetServiceAccounts)
+		adminGroup.POST("/service-accounts", serviceAccountHandler.CreateServiceAccount)
+		adminGroup.GET("/service-accounts/:id", serviceAccountHandler.GetServiceAccount)
+		adminGroup.PUT("/service-accounts/:id", serviceAccountHandler.UpdateServiceAccount)
+		adminGroup.DELETE("/service-accounts/:id", serviceAccountHandler.DeleteServiceAccount)
+		adminGroup.POST("/service-accounts/:id/keys", serviceAccountHandler.CreateAPIKey)
+		adminGroup.POST("/service-accounts/:id/keys/:keyId/rotate", serviceAccountHandler.RotateAPIKey)
+		adminGroup.DELETE("/service-accounts/:id/keys/:keyId", serviceAccountHandler.RevokeAPIKey)
+
+		adminGroup.GET("/me", adminHandler.GetProfile)
+		adminGroup.PUT("/me/password", adminHandler.ChangePassword)
+
+		// admin accounts and the access policy, super admins only
+		superAdmin := middleware.RequireSuperAdmin(adminUsecase)
+		adminGroup.GET("/policies", superAdmin, policyHandler.GetPolicies)
+		adminGroup.POST("/policies", superAdmin, policyHandler.AddPolicy)
+		adminGroup.DELETE("/policies", superAdmin, policyHandler.RemovePolicy)
+		adminGroup.POST("/policies/reload", superAdmin, policyHandler.ReloadPolicies)
+		adminGroup.POST("/roles", superAdmin, policyHandler.AddRole)
+		adminGroup.DELETE("/roles", superAdmin, policyHandler.RemoveRole)
+
+		adminGroup.GET("/admins", superAdmin, adminHandler.GetAdmins)
+		adminGroup.POST("/admins", superAdmin, adminHandler.CreateAdmin)
+		adminGroup.GET("/admins/:id", superAdmin, adminHandler.GetAdminById)
+		adminGroup.PUT("/admins/:id", superAdmin, adminHandler.UpdateAdmin)
+		adminGroup.DELETE("/admins/:id", superAdmin, adminHandler.DeleteAdmin)
+		adminGroup.POST("/admins/:id/disable", superAdmin, adminHandler.DisableAdmin)
+		adminGroup.POST("/admins/:id/enable", superAdmin, adminHandler.EnableAdmin)
+		adminGroup.PUT("/admins/:id/password", superAdmin, adminHandler.SetAdminPassword)
+	}
+
+	// localhost:9000/api/display, no login, the board token scopes the feed
+	displayGroup := r.Group("/display")
+	{
+		displayGroup.GET("/:token", displayHandler.GetBoard)
+		displayGroup.GET("/:token/stream", displayHandler.StreamBoard)
 	}
 
 	paymentGroup := r.Group("/payment")
@@ -106,15 +302,16 @@ func SetupRoutes(r *gin.RouterGroup, e *casbin.Enforcer, rbmqUsecase messagequeu
 
 	// localhost:9000/api/patient
 	patientGroup := r.Group("/patient")
-	patientGroup.Use(middleware.AuthMiddleware())
+	patientGroup.Use(authMiddleware, casbinMiddleware)
 	{
 
-		patientGroup.GET("/profile", patientHandler.GetProfile)
-		patientGroup.PUT("/:id", patientHandler.UpdatePatient)
+		patientGroup.GET("/profile", audit("patient", read, ""), patientHandler.GetProfile)
+		patientGroup.PUT("/:id", middleware.RequirePatientAccess(accessUsecase, "id", accessusecase.ActionWrite), audit("patient", write, "id"), patientHandler.UpdatePatient)
 
-		patientGroup.GET("/history_booking", patientHandler.GetBookingQueuesByPatientId)
-		patientGroup.GET("/detail_booking", patientHandler.GetDetailBookingByQueueId)
-		patientGroup.POST("/register-service/:serviceId", patientHandler.PatientRegisterService)
+		patientGroup.GET("/history_booking", audit("booking", read, ""), patientHandler.GetBookingQueuesByPatientId)
+		patientGroup.GET("/detail_booking", middleware.RequireBookingAccess(accessUsecase, "queueId", accessusecase.ActionRead), audit("booking", read, "queueId"), patientHandler.GetDetailBookingByQueueId)
+		patientGroup.POST("/register-service/:serviceId", rateLimit("checkout"), patientHandler.PatientRegisterService)
+		patientGroup.GET("/bookings/stream", realtimeHandler.PatientBookingStream)
 
 		patientGroup.GET("/service/categories", categoryHandler.GetAllCategories)
 		patientGroup.GET("/service/category", categoryHandler.GetCategoryById)
@@ -125,23 +322,30 @@ func SetupRoutes(r *gin.RouterGroup, e *casbin.Enforcer, rbmqUsecase messagequeu
 
 	// localhost:9000/api/nurse
 	nurseGroup := r.Group("/nurse")
-	nurseGroup.Use(middleware.AuthMiddleware())
+	nurseGroup.Use(authMiddleware, casbinMiddleware, staffScope)
 	{
 		nurseGroup.GET("/profile", nurseHandler.GetNurseProfile)
-		nurseGroup.PUT("/:id", nurseHandler.UpdateNurse)
-		nurseGroup.GET("/queues", nurseHandler.GetAllBookingQueues)
-		nurseGroup.DELETE("/mark_complete", nurseHandler.MarkCompleteQueue)
+		nurseGroup.PUT("/:id", middleware.RequireStaffAccess(accessUsecase, "id", accessusecase.ActionWrite), nurseHandler.UpdateNurse)
+		nurseGroup.GET("/queues", audit("booking", read, ""), nurseHandler.GetAllBookingQueues)
+		nurseGroup.GET("/queues/stream", realtimeHandler.NurseQueueStream)
+		nurseGroup.PUT("/queues/order", nurseHandler.ReorderQueues)
+		nurseGroup.POST("/queues/:id/call", middleware.RequireBookingAccess(accessUsecase, "id", accessusecase.ActionWrite), nurseHandler.CallQueue)
+		nurseGroup.POST("/queues/call-next", nurseHandler.CallNext)
+		nurseGroup.DELETE("/mark_complete", middleware.RequireBookingAccess(accessUsecase, "queueId", accessusecase.ActionWrite), audit("booking", write, "queueId"), nurseHandler.MarkCompleteQueue)
 	}
 
 	// localhost:9000/api/doctor
 	doctorGroup := r.Group("/doctor")
-	doctorGroup.Use(middleware.AuthMiddleware())
+	doctorGroup.Use(authMiddleware, casbinMiddleware, staffScope)
 	{
-		doctorGroup.GET("/patients", patientHandler.GetPatients)
+		doctorGroup.GET("/patients", audit("patient", read, ""), patientHandler.GetStaffPatients)
 		doctorGroup.GET("/profile", doctorHandler.GetDoctorProfile)
-		doctorGroup.PUT("/:id", doctorHandler.UpdateDoctor)
-		doctorGroup.GET("/patient/:id", patientHandler.GetPatientById)
-		doctorGroup.GET("/queues", nurseHandler.GetAllBookingQueues)
-		doctorGroup.POST("/create-receipt", doctorHandler.CreateDrugReceipt)
+		doctorGroup.PUT("/:id", middleware.RequireStaffAccess(accessUsecase, "id", accessusecase.ActionWrite), doctorHandler.UpdateDoctor)
+		doctorGroup.GET("/patient/:id", middleware.RequirePatientAccess(accessUsecase, "id", accessusecase.ActionRead), audit("patient", read, "id"), patientHandler.GetPatientById)
+		doctorGroup.GET("/queues", audit("booking", read, ""), nurseHandler.GetAllBookingQueues)
+		doctorGroup.GET("/queues/stream", realtimeHandler.DoctorQueueStream)
+		doctorGroup.POST("/queues/:id/call", middleware.RequireBookingAccess(accessUsecase, "id", accessusecase.ActionWrite), nurseHandler.CallQueue)
+		doctorGroup.POST("/queues/call-next", doctorHandler.CallNext)
+		doctorGroup.POST("/create-receipt", audit("drug_receipt", write, ""), doctorHandler.CreateDrugReceipt)
 	}
 }
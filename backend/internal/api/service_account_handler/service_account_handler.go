@@ -0,0 +1,148 @@
+package serviceaccounthandler
+
+import (
+	"backend/internal/api/middleware"
+	"backend/internal/domain/dto"
+	serviceaccountrepository "backend/internal/infrastructure/persistence/service_account_repository"
+	serviceaccountusecase "backend/internal/usecase/service_account_usecase"
+	errorsresponse "backend/pkg/app_response/errors_response"
+	"backend/pkg/app_response/response"
+	"backend/pkg/common/pagination"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type ServiceAccountHandler struct {
+	accounts serviceaccountusecase.ServiceAccountUsecase
+}
+
+func NewServiceAccountHandler(accounts serviceaccountusecase.ServiceAccountUsecase) *ServiceAccountHandler {
+	return &ServiceAccountHandler{accounts: accounts}
+}
+
+// serviceAccountError maps the usecase errors to their status
+func serviceAccountError(ctx *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, serviceaccountusecase.ErrInvalidRole), errors.Is(err, serviceaccountusecase.ErrInvalidExpiry):
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, err.Error()))
+	case errors.Is(err, serviceaccountrepository.ErrServiceAccountNotFound), errors.Is(err, serviceaccountrepository.ErrKeyNotFound):
+		ctx.JSON(http.StatusNotFound, errorsresponse.NewCustomErrResponse(http.StatusNotFound, err.Error()))
+	case errors.Is(err, serviceaccountrepository.ErrServiceAccountExists):
+		ctx.JSON(http.StatusConflict, errorsresponse.NewCustomErrResponse(http.StatusConflict, err.Error()))
+	default:
+		logrus.Errorf("Handler layer: %v", err)
+		ctx.JSON(http.StatusInternalServerError, errorsresponse.NewCustomErrResponse(http.StatusInternalServerError, msg))
+	}
+}
+
+func (h *ServiceAccountHandler) GetServiceAccounts(ctx *gin.Context) {
+	var page pagination.Pagination
+	if err := ctx.ShouldBindQuery(&page); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "invalid page"))
+		return
+	}
+	accounts, err := h.accounts.ListAccounts(ctx, &page)
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to fetch service accounts")
+		return
+	}
+	fullResp := dto.PaginationResponse[dto.ServiceAccountResponse]{
+		Data:       accounts,
+		Pagination: &page,
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &fullResp, "Data fetch"))
+}
+
+// GetServiceAccount returns the account with its keys, the keys themselves are never
+// shown again
+func (h *ServiceAccountHandler) GetServiceAccount(ctx *gin.Context) {
+	sa, err := h.accounts.GetAccount(ctx, ctx.Param("id"))
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to fetch service account")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, sa, "Data fetch"))
+}
+
+func (h *ServiceAccountHandler) CreateServiceAccount(ctx *gin.Context) {
+	var req dto.CreateServiceAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	actorId, _, err := middleware.GetUserInfoFromContext(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, errorsresponse.NewCustomErrResponse(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	sa, err := h.accounts.CreateAccount(ctx, actorId, &req)
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to create service account")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, sa, "Service account created"))
+}
+
+func (h *ServiceAccountHandler) UpdateServiceAccount(ctx *gin.Context) {
+	var req dto.UpdateServiceAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	sa, err := h.accounts.UpdateAccount(ctx, ctx.Param("id"), &req)
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to update service account")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, sa, "Service account updated"))
+}
+
+func (h *ServiceAccountHandler) DeleteServiceAccount(ctx *gin.Context) {
+	if err := h.accounts.DeleteAccount(ctx, ctx.Param("id")); err != nil {
+		serviceAccountError(ctx, err, "Failed to delete service account")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "Service account deleted"))
+}
+
+// CreateAPIKey issues a key, the response is the only time it is shown
+func (h *ServiceAccountHandler) CreateAPIKey(ctx *gin.Context) {
+	var req dto.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	key, err := h.accounts.CreateKey(ctx, ctx.Param("id"), &req)
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to create api key")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, key, "API key created, store it now, it is not shown again"))
+}
+
+func (h *ServiceAccountHandler) RotateAPIKey(ctx *gin.Context) {
+	var req dto.RotateAPIKeyRequest
+	// the body is optional, without it the new key gets the default lifetime
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, errorsresponse.NewCustomErrResponse(http.StatusBadRequest, "Invalid request"))
+		return
+	}
+	key, err := h.accounts.RotateKey(ctx, ctx.Param("id"), ctx.Param("keyId"), &req)
+	if err != nil {
+		serviceAccountError(ctx, err, "Failed to rotate api key")
+		return
+	}
+	ctx.JSON(http.StatusCreated, response.NewCustomSuccessResponse(http.StatusCreated, key, "API key rotated, store it now, it is not shown again"))
+}
+
+func (h *ServiceAccountHandler) RevokeAPIKey(ctx *gin.Context) {
+	if err := h.accounts.RevokeKey(ctx, ctx.Param("id"), ctx.Param("keyId")); err != nil {
+		serviceAccountError(ctx, err, "Failed to revoke api key")
+		return
+	}
+	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, nil, "API key revoked"))
+}
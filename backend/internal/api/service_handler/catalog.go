@@ -0,0 +1,19 @@
+package servicehandler
+
+import (
+	"backend/internal/infrastructure/cache"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// catalogModified is the Last-Modified of catalog reads, zero when the cache is unavailable
+func catalogModified(ctx context.Context, c *cache.CatalogCache) time.Time {
+	v, err := c.Version(ctx)
+	if err != nil {
+		logrus.Warnf("Handler layer: %v", err)
+		return time.Time{}
+	}
+	return v.ModifiedAt
+}
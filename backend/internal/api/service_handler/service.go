@@ -2,6 +2,7 @@ package servicehandler
 
 import (
 	"backend/internal/domain/dto/dtoservice"
+	"backend/internal/infrastructure/cache"
 	serviceusecase "backend/internal/usecase/service_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
@@ -14,11 +15,13 @@ import (
 
 type ServiceHandler struct {
 	serviceUsecase serviceusecase.ServicesUsecase
+	catalog        *cache.CatalogCache
 }
 
-func NewServiceHandler(serviceUsecase serviceusecase.ServicesUsecase) ServiceHandler {
+func NewServiceHandler(serviceUsecase serviceusecase.ServicesUsecase, catalog *cache.CatalogCache) ServiceHandler {
 	return ServiceHandler{
 		serviceUsecase: serviceUsecase,
+		catalog:        catalog,
 	}
 }
 
@@ -37,7 +40,8 @@ func (h *ServiceHandler) GetServiceBySubcategoryId(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &resp, "All service has fetched"))
+	body := response.NewCustomSuccessResponse(http.StatusOK, &resp, "All service has fetched")
+	response.NewConditionalResponse(ctx, http.StatusOK, &body, catalogModified(ctx, h.catalog))
 }
 
 func (h *ServiceHandler) CreateService(ctx *gin.Context) {
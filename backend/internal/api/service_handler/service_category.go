@@ -1,6 +1,7 @@
 package servicehandler
 
 import (
+	"backend/internal/infrastructure/cache"
 	serviceusecase "backend/internal/usecase/service_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
@@ -12,10 +13,11 @@ import (
 
 type ServiceCategoryHandler struct {
 	serviceCategoryUsecase serviceusecase.ServiceCategoryUsecase
+	catalog                *cache.CatalogCache
 }
 
-func NewServiceCategoryHandler(serviceCategoryUsecase serviceusecase.ServiceCategoryUsecase) *ServiceCategoryHandler {
-	return &ServiceCategoryHandler{serviceCategoryUsecase: serviceCategoryUsecase}
+func NewServiceCategoryHandler(serviceCategoryUsecase serviceusecase.ServiceCategoryUsecase, catalog *cache.CatalogCache) *ServiceCategoryHandler {
+	return &ServiceCategoryHandler{serviceCategoryUsecase: serviceCategoryUsecase, catalog: catalog}
 }
 
 func (h *ServiceCategoryHandler) GetAllCategories(ctx *gin.Context) {
@@ -26,7 +28,8 @@ func (h *ServiceCategoryHandler) GetAllCategories(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &serviceCategories, "all service-categories has fetched"))
+	resp := response.NewCustomSuccessResponse(http.StatusOK, &serviceCategories, "all service-categories has fetched")
+	response.NewConditionalResponse(ctx, http.StatusOK, &resp, catalogModified(ctx, h.catalog))
 }
 
 func (h *ServiceCategoryHandler) GetCategoryById(ctx *gin.Context) {
@@ -43,5 +46,6 @@ func (h *ServiceCategoryHandler) GetCategoryById(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &serviceCategory, "category has fetched"))
+	resp := response.NewCustomSuccessResponse(http.StatusOK, &serviceCategory, "category has fetched")
+	response.NewConditionalResponse(ctx, http.StatusOK, &resp, catalogModified(ctx, h.catalog))
 }
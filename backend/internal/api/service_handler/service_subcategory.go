@@ -1,6 +1,7 @@
 package servicehandler
 
 import (
+	"backend/internal/infrastructure/cache"
 	serviceusecase "backend/internal/usecase/service_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
@@ -12,10 +13,11 @@ import (
 
 type ServiceSubcategoryHandler struct {
 	serviceSubcategoryUsecase serviceusecase.ServiceSubCategoryUsecase
+	catalog                   *cache.CatalogCache
 }
 
-func NewServiceSubCategoryHandler(serviceSubcategoryUsecase serviceusecase.ServiceSubCategoryUsecase) ServiceSubcategoryHandler {
-	return ServiceSubcategoryHandler{serviceSubcategoryUsecase: serviceSubcategoryUsecase}
+func NewServiceSubCategoryHandler(serviceSubcategoryUsecase serviceusecase.ServiceSubCategoryUsecase, catalog *cache.CatalogCache) ServiceSubcategoryHandler {
+	return ServiceSubcategoryHandler{serviceSubcategoryUsecase: serviceSubcategoryUsecase, catalog: catalog}
 }
 
 func (h *ServiceSubcategoryHandler) GetAllSubCategoriesByCategoryId(ctx *gin.Context) {
@@ -32,7 +34,8 @@ func (h *ServiceSubcategoryHandler) GetAllSubCategoriesByCategoryId(ctx *gin.Con
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &serviceSubcategories, "All sub-categories has fetched"))
+	resp := response.NewCustomSuccessResponse(http.StatusOK, &serviceSubcategories, "All sub-categories has fetched")
+	response.NewConditionalResponse(ctx, http.StatusOK, &resp, catalogModified(ctx, h.catalog))
 }
 
 func (h *ServiceSubcategoryHandler) GetSubcategoryById(ctx *gin.Context) {
@@ -48,5 +51,6 @@ func (h *ServiceSubcategoryHandler) GetSubcategoryById(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response.NewCustomSuccessResponse(http.StatusOK, &serviceSubcategory, "category has fetched"))
+	resp := response.NewCustomSuccessResponse(http.StatusOK, &serviceSubcategory, "category has fetched")
+	response.NewConditionalResponse(ctx, http.StatusOK, &resp, catalogModified(ctx, h.catalog))
 }
@@ -0,0 +1,21 @@
+package account
+
+import "github.com/google/uuid"
+
+// ExternalPassword replaces the password of the accounts linked to the identity
+// provider. It is not a bcrypt hash, no password matches it
+const ExternalPassword = "!sso"
+
+// Account is the login of a patient, doctor or nurse, whichever table it lives in
+type Account struct {
+	Id          uuid.UUID `bun:"id"`
+	Role        string    `bun:"-"`
+	Email       string    `bun:"email"`
+	PhoneNumber string    `bun:"phone_number"`
+	Password    string    `bun:"password"`
+}
+
+// ExternalLogin tells whether the account signs in through the identity provider only
+func (a *Account) ExternalLogin() bool {
+	return a.Password == ExternalPassword
+}
@@ -0,0 +1,28 @@
+package account
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// LoginEvent is one password login, successful or not. UserId is empty when the
+// identifier matched no account, Network is the /24 (IPv4) or /48 (IPv6) of the IP and
+// stands in for the location
+type LoginEvent struct {
+	bun.BaseModel `bun:"table:login_event"`
+	Id            int64      `bun:"id,pk,autoincrement"`
+	UserId        *uuid.UUID `bun:"user_id,type:uuid,nullzero"`
+	Role          string     `bun:"role,notnull"`
+	Identifier    string     `bun:"identifier,notnull"`
+	Success       bool       `bun:"success,notnull"`
+	Reason        string     `bun:"reason,notnull,default:''"`
+	IP            string     `bun:"ip,notnull,default:''"`
+	Network       string     `bun:"network,notnull,default:''"`
+	UserAgent     string     `bun:"user_agent,notnull,default:''"`
+	DeviceId      string     `bun:"device_id,notnull,default:''"`
+	NewDevice     bool       `bun:"new_device,notnull,default:false"`
+	NewLocation   bool       `bun:"new_location,notnull,default:false"`
+	CreatedAt     time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
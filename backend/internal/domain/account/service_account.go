@@ -0,0 +1,64 @@
+package account
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// API key scopes, read covers the GET requests and write every other method
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// ServiceAccount is a machine integration, it calls the API with its keys and is
+// authorized as Role in the Casbin policy
+type ServiceAccount struct {
+	bun.BaseModel `bun:"table:service_account"`
+	Id            uuid.UUID `bun:"id,pk,type:uuid"`
+	Name          string    `bun:"name,notnull,unique"`
+	Description   string    `bun:"description,notnull,default:''"`
+	Role          string    `bun:"role,notnull"`
+	Disabled      bool      `bun:"disabled,notnull,default:false"`
+	CreatedBy     string    `bun:"created_by,notnull,default:''"`
+	CreatedAt     time.Time `bun:"created_at,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time `bun:"updated_at,notnull,default:current_timestamp"`
+}
+
+// APIKey is a key of a service account. Only the SHA-256 of the secret is stored,
+// Prefix is the public part of the key it is looked up by
+type APIKey struct {
+	bun.BaseModel    `bun:"table:api_key"`
+	Id               uuid.UUID       `bun:"id,pk,type:uuid"`
+	ServiceAccountId uuid.UUID       `bun:"service_account_id,type:uuid,notnull"`
+	ServiceAccount   *ServiceAccount `bun:"rel:belongs-to,join:service_account_id=id"`
+	Prefix           string          `bun:"prefix,notnull,unique"`
+	Hash             string          `bun:"hash,notnull"`
+	Scopes           []string        `bun:"scopes,type:jsonb,notnull,default:'[]'"`
+	ExpiresAt        time.Time       `bun:"expires_at,notnull"`
+	RevokedAt        *time.Time      `bun:"revoked_at,nullzero"`
+	// RotatedFrom is the key this one replaced
+	RotatedFrom *uuid.UUID `bun:"rotated_from,type:uuid,nullzero"`
+	LastUsedAt  *time.Time `bun:"last_used_at,nullzero"`
+	LastUsedIP  string     `bun:"last_used_ip,notnull,default:''"`
+	UsageCount  int64      `bun:"usage_count,notnull,default:0"`
+	CreatedAt   time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// Active tells whether the key can still be used at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
+
+// Allows tells whether the scopes of the key cover a request of method
+func (k *APIKey) Allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return slices.Contains(k.Scopes, ScopeRead)
+	}
+	return slices.Contains(k.Scopes, ScopeWrite)
+}
@@ -0,0 +1,21 @@
+package account
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// SSOIdentity links a user of the identity provider, by issuer and subject, to the
+// doctor or nurse record it signs in as. The email is the one it was linked by
+type SSOIdentity struct {
+	bun.BaseModel `bun:"table:sso_identity"`
+	Issuer        string     `bun:"issuer,pk"`
+	Subject       string     `bun:"subject,pk"`
+	Role          string     `bun:"role,notnull"`
+	UserId        uuid.UUID  `bun:"user_id,type:uuid,notnull"`
+	Email         string     `bun:"email,notnull"`
+	LastLoginAt   *time.Time `bun:"last_login_at,nullzero"`
+	CreatedAt     time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,22 @@
+package account
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// TwoFactor is the TOTP second factor of a staff account. Secret is sealed with the
+// server key and RecoveryCodes holds the HMACs of the unused codes
+type TwoFactor struct {
+	bun.BaseModel `bun:"table:two_factor"`
+	UserId        uuid.UUID  `bun:"user_id,pk,type:uuid"`
+	Role          string     `bun:"role,notnull"`
+	Secret        string     `bun:"secret,notnull"`
+	Enabled       bool       `bun:"enabled,notnull,default:false"`
+	RecoveryCodes []string   `bun:"recovery_codes,type:jsonb,notnull,default:'[]'"`
+	EnabledAt     *time.Time `bun:"enabled_at,nullzero"`
+	CreatedAt     time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time  `bun:"updated_at,notnull,default:current_timestamp"`
+}
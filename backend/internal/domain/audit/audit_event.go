@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Outcome values
+const (
+	OutcomeSuccess = "success"
+	// OutcomeDenied is an access the authorization checks refused
+	OutcomeDenied = "denied"
+	// OutcomeFailed is an allowed request that did not succeed
+	OutcomeFailed = "failed"
+)
+
+// Event is one access to clinical data. Events are only ever appended, each one carries
+// the hash of the previous one so a changed or removed row breaks the chain
+type Event struct {
+	bun.BaseModel `bun:"table:audit_event"`
+	Id            int64      `json:"id" bun:"id,pk,autoincrement"`
+	ActorId       string     `json:"actor_id" bun:"actor_id,notnull"`
+	Role          string     `json:"role" bun:"role,notnull"`
+	Action        string     `json:"action" bun:"action,notnull"`
+	Resource      string     `json:"resource" bun:"resource,notnull"`
+	ResourceId    string     `json:"resource_id,omitempty" bun:"resource_id,notnull,default:''"`
+	PatientId     *uuid.UUID `json:"patient_id,omitempty" bun:"patient_id,type:uuid,nullzero"`
+	Outcome       string     `json:"outcome" bun:"outcome,notnull"`
+	Reason        string     `json:"reason,omitempty" bun:"reason,notnull,default:''"`
+	IP            string     `json:"ip" bun:"ip,notnull,default:''"`
+	UserAgent     string     `json:"user_agent" bun:"user_agent,notnull,default:''"`
+	Method        string     `json:"method,omitempty" bun:"method,notnull,default:''"`
+	Path          string     `json:"path,omitempty" bun:"path,notnull,default:''"`
+	Status        int        `json:"status,omitempty" bun:"status,notnull,default:0"`
+	CreatedAt     time.Time  `json:"created_at" bun:"created_at,notnull"`
+	PrevHash      string     `json:"prev_hash" bun:"prev_hash,notnull"`
+	Hash          string     `json:"hash" bun:"hash,notnull,unique"`
+}
+
+// ComputeHash hashes the previous hash with every field but Id and Hash. CreatedAt is
+// taken at microseconds, the precision Postgres keeps
+func (e *Event) ComputeHash() string {
+	patientId := ""
+	if e.PatientId != nil {
+		patientId = e.PatientId.String()
+	}
+	fields := []string{
+		e.PrevHash,
+		e.ActorId,
+		e.Role,
+		e.Action,
+		e.Resource,
+		e.ResourceId,
+		patientId,
+		e.Outcome,
+		e.Reason,
+		e.IP,
+		e.UserAgent,
+		e.Method,
+		e.Path,
+		strconv.Itoa(e.Status),
+		e.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+	}
+	// the unit separator cannot appear in the fields, so they cannot be shifted around
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,33 @@
+package authz
+
+import "github.com/uptrace/bun"
+
+// CasbinRule is one line of the Casbin policy, ptype p for a permission and g for a
+// role inheritance. Unused values are stored empty
+type CasbinRule struct {
+	bun.BaseModel `bun:"table:casbin_rule"`
+	Id            int64  `bun:"id,pk,autoincrement"`
+	Ptype         string `bun:"ptype,notnull"`
+	V0            string `bun:"v0,notnull,default:''"`
+	V1            string `bun:"v1,notnull,default:''"`
+	V2            string `bun:"v2,notnull,default:''"`
+	V3            string `bun:"v3,notnull,default:''"`
+	V4            string `bun:"v4,notnull,default:''"`
+	V5            string `bun:"v5,notnull,default:''"`
+}
+
+// NewCasbinRule builds the row of a rule, values past the sixth are dropped
+func NewCasbinRule(ptype string, rule []string) *CasbinRule {
+	v := make([]string, 6)
+	copy(v, rule)
+	return &CasbinRule{Ptype: ptype, V0: v[0], V1: v[1], V2: v[2], V3: v[3], V4: v[4], V5: v[5]}
+}
+
+// Rule returns the values of the row without the trailing empty ones
+func (r *CasbinRule) Rule() []string {
+	v := []string{r.V0, r.V1, r.V2, r.V3, r.V4, r.V5}
+	for len(v) > 0 && v[len(v)-1] == "" {
+		v = v[:len(v)-1]
+	}
+	return v
+}
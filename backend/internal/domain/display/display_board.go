@@ -0,0 +1,19 @@
+package display
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// DisplayBoard is a waiting-room TV. It shows the live queue of one faculty, or of a
+// single room of it, to whoever holds its token, so only the token's hash is stored
+type DisplayBoard struct {
+	bun.BaseModel `bun:"table:display_board"`
+	BoardId       int       `json:"board_id" bun:"board_id,pk,autoincrement"`
+	Name          string    `json:"name" bun:"name,notnull"`
+	FacultyId     byte      `json:"faculty_id" bun:"faculty_id,notnull"`
+	Room          string    `json:"room,omitempty" bun:"room,nullzero"`
+	TokenHash     string    `json:"-" bun:"token_hash,notnull,unique"`
+	CreatedAt     time.Time `json:"created_at" bun:"created_at,default:current_timestamp"`
+}
@@ -0,0 +1,11 @@
+package dto
+
+// Actor is the caller of a request as the authorization checks see it
+type Actor struct {
+	Id   string
+	Role string
+	// FacultyId scopes doctors and nurses, zero for the other roles
+	FacultyId byte
+	IP        string
+	UserAgent string
+}
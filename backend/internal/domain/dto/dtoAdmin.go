@@ -0,0 +1,24 @@
+package dto
+
+type CreateAdminRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=64"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"omitempty,oneof=admin super_admin"`
+}
+
+// UpdateAdminRequest changes the fields that are set
+type UpdateAdminRequest struct {
+	Username string `json:"username" binding:"omitempty,min=3,max=64"`
+	Role     string `json:"role" binding:"omitempty,oneof=admin super_admin"`
+}
+
+// ChangePasswordRequest is the admin changing their own password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// AdminPasswordRequest is a super admin setting the password of another admin
+type AdminPasswordRequest struct {
+	NewPassword string `json:"new_password" binding:"required"`
+}
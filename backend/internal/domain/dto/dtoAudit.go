@@ -0,0 +1,24 @@
+package dto
+
+import "time"
+
+// AuditFilter narrows the audit log, empty fields match everything
+type AuditFilter struct {
+	ActorId   string     `form:"actor_id"`
+	PatientId string     `form:"patient_id" binding:"omitempty,uuid"`
+	Role      string     `form:"role"`
+	Action    string     `form:"action" binding:"omitempty,oneof=read write"`
+	Resource  string     `form:"resource"`
+	Outcome   string     `form:"outcome" binding:"omitempty,oneof=success denied failed"`
+	From      *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// AuditVerifyResponse tells whether the hash chain is intact, BrokenAt is the first
+// event that does not match
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
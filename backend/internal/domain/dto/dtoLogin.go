@@ -1,17 +1,50 @@
 package dto
 
+import "time"
+
 type LoginRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	Password    string `json:"password"`
+
+	Client ClientInfo `json:"-"`
 }
 
 type LoginAdminRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	Client ClientInfo `json:"-"`
 }
 
 type LoginResponse struct {
-	Token   string      `json:"token"`
-	User    interface{} `json:"user"`
-	Message string      `json:"message"`
+	Token string `json:"token"`
+	// RefreshToken is also set as a cookie, it is in the body for clients without cookies
+	RefreshToken     string      `json:"refresh_token,omitempty"`
+	ExpiresAt        time.Time   `json:"expires_at,omitempty"`
+	RefreshExpiresAt time.Time   `json:"refresh_expires_at,omitempty"`
+	DeviceId         string      `json:"device_id,omitempty"`
+	User             interface{} `json:"user,omitempty"`
+	Message          string      `json:"message"`
+
+	// TwoFactorRequired means the password was right and no session is open yet, the
+	// code goes to the challenge endpoint with ChallengeToken
+	TwoFactorRequired bool `json:"two_factor_required,omitempty"`
+	// TwoFactorSetupRequired asks the account to enroll before its first session
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"`
+	ChallengeToken         string `json:"challenge_token,omitempty"`
+	// RecoveryCodes are shown once, after an enrollment finished at login
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// NewLoginResponse answers a login with the tokens of the session it opened
+func NewLoginResponse(tokens *SessionTokens, user interface{}, msg string) *LoginResponse {
+	return &LoginResponse{
+		Token:            tokens.AccessToken,
+		RefreshToken:     tokens.RefreshToken,
+		ExpiresAt:        tokens.AccessExpiresAt,
+		RefreshExpiresAt: tokens.RefreshExpiresAt,
+		DeviceId:         tokens.DeviceId,
+		User:             user,
+		Message:          msg,
+	}
 }
@@ -0,0 +1,29 @@
+package dto
+
+import "time"
+
+// LoginAttempt is a password login as the login guard sees it, Identifier is the phone
+// number or username that was typed in
+type LoginAttempt struct {
+	Role       string
+	Identifier string
+	Client     ClientInfo
+}
+
+type LoginEventResponse struct {
+	Success     bool      `json:"success"`
+	Reason      string    `json:"reason,omitempty"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	DeviceId    string    `json:"device_id,omitempty"`
+	NewDevice   bool      `json:"new_device"`
+	NewLocation bool      `json:"new_location"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UnlockLoginRequest lifts the lockout of an account, of an IP, or both
+type UnlockLoginRequest struct {
+	Role       string `json:"role" binding:"omitempty,oneof=patient doctor nurse admin"`
+	Identifier string `json:"identifier" binding:"required_with=Role"`
+	IP         string `json:"ip" binding:"omitempty,ip"`
+}
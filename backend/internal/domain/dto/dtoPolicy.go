@@ -0,0 +1,20 @@
+package dto
+
+// PolicyRequest grants or revokes an action on a path, obj accepts Casbin keyMatch
+// patterns such as /api/nurse/*
+type PolicyRequest struct {
+	Subject string `json:"sub" binding:"required"`
+	Object  string `json:"obj" binding:"required"`
+	Action  string `json:"act" binding:"required"`
+}
+
+// RoleRequest makes user, a role name or a user id, inherit the permissions of role
+type RoleRequest struct {
+	User string `json:"user" binding:"required"`
+	Role string `json:"role" binding:"required"`
+}
+
+type PolicyResponse struct {
+	Policies []*PolicyRequest `json:"policies"`
+	Roles    []*RoleRequest   `json:"roles"`
+}
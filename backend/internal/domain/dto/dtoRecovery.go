@@ -0,0 +1,27 @@
+package dto
+
+import "time"
+
+// PasswordResetRequest asks for a reset code, contact is the email or phone number of
+// the account
+type PasswordResetRequest struct {
+	Role    string `json:"role" binding:"required,oneof=patient doctor nurse"`
+	Contact string `json:"contact" binding:"required,max=254"`
+}
+
+type VerifyResetCodeRequest struct {
+	Role    string `json:"role" binding:"required,oneof=patient doctor nurse"`
+	Contact string `json:"contact" binding:"required,max=254"`
+	Code    string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// ResetTokenResponse is handed out once the code is verified, it allows one password change
+type ResetTokenResponse struct {
+	ResetToken string    `json:"reset_token"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type SetPasswordRequest struct {
+	ResetToken  string `json:"reset_token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
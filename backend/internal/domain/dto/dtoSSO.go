@@ -0,0 +1,27 @@
+package dto
+
+import "time"
+
+// SSOStartResponse sends the browser to the identity provider, it comes back to the
+// redirect URL with the code and the state for SSOCallbackRequest
+type SSOStartResponse struct {
+	AuthorizationURL string    `json:"authorization_url"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	// State ties the callback to the browser that started, it is kept in a cookie
+	State string `json:"-"`
+}
+
+type SSOCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+
+	Client ClientInfo `json:"-"`
+}
+
+// SSOUser is the user of a single sign-on login response
+type SSOUser struct {
+	Id    string `json:"id"`
+	Role  string `json:"role"`
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
@@ -0,0 +1,66 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateServiceAccountRequest creates a machine integration, Role is the Casbin subject
+// its requests are authorized as
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name" binding:"required,min=3,max=64"`
+	Description string `json:"description" binding:"max=255"`
+	Role        string `json:"role" binding:"required,max=64"`
+}
+
+// UpdateServiceAccountRequest changes the fields that are set
+type UpdateServiceAccountRequest struct {
+	Description *string `json:"description" binding:"omitempty,max=255"`
+	Role        string  `json:"role" binding:"omitempty,max=64"`
+	Disabled    *bool   `json:"disabled"`
+}
+
+// CreateAPIKeyRequest issues a key, without ExpiresAt it expires after api_keys.default_ttl
+type CreateAPIKeyRequest struct {
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RotateAPIKeyRequest replaces a key by one with the same scopes, the old key keeps
+// working for api_keys.rotation_grace
+type RotateAPIKeyRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ServiceAccountResponse struct {
+	Id          uuid.UUID         `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Role        string            `json:"role"`
+	Disabled    bool              `json:"disabled"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Keys        []*APIKeyResponse `json:"keys,omitempty"`
+}
+
+type APIKeyResponse struct {
+	Id          uuid.UUID  `json:"id"`
+	Prefix      string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	Active      bool       `json:"active"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	RotatedFrom *uuid.UUID `json:"rotated_from,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  string     `json:"last_used_ip,omitempty"`
+	UsageCount  int64      `json:"usage_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// NewAPIKeyResponse carries the key itself, it is only shown once
+type NewAPIKeyResponse struct {
+	Key string `json:"key"`
+	*APIKeyResponse
+}
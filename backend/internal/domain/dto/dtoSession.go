@@ -0,0 +1,37 @@
+package dto
+
+import "time"
+
+// ClientInfo identifies the device a session belongs to, the handlers fill it from the
+// request
+type ClientInfo struct {
+	DeviceId  string
+	UserAgent string
+	IP        string
+}
+
+// SessionTokens are handed out when a session is opened or refreshed
+type SessionTokens struct {
+	SessionId        string    `json:"session_id"`
+	DeviceId         string    `json:"device_id"`
+	AccessToken      string    `json:"token"`
+	AccessExpiresAt  time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionResponse struct {
+	SessionId  string    `json:"session_id"`
+	UserId     string    `json:"user_id"`
+	Role       string    `json:"role"`
+	DeviceId   string    `json:"device_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current,omitempty"`
+}
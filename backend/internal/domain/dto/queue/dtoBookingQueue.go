@@ -18,8 +18,9 @@ type BookingQueuePublish struct {
 	ServiceCode string  `json:"service_code,omitempty"`
 	Cost        float64 `json:"cost,omitempty"`
 
-	PaymentStatus string `json:"payment_status"`
-	BookingStatus string `json:"booking_status"`
+	PaymentStatus    string `json:"payment_status"`
+	BookingStatus    string `json:"booking_status"`
+	PaymentSessionId string `json:"payment_session_id,omitempty"`
 
 	AppointmentDate time.Time `json:"appointment"`
 	CreatedAt       time.Time `json:"created_at"`
@@ -35,6 +36,7 @@ type BookingQueueResponse struct {
 	ServiceName string  `json:"service_name,omitempty"`
 	ServiceCode string  `json:"service_code,omitempty"`
 	Cost        float64 `json:"cost,omitempty"`
+	FacultyId   byte    `json:"faculty_id,omitempty"`
 
 	PaymentStatus string `json:"payment_status"`
 	BookingStatus string `json:"booking_status"`
@@ -45,6 +47,11 @@ type BookingQueueResponse struct {
 	DrugName          string `json:"drug_name"`
 	UsageInstructions string `json:"usage_instructions"`
 	Notes             string `json:"notes"`
+
+	// live queue only
+	Room     string     `json:"room,omitempty"`
+	CalledAt *time.Time `json:"called_at,omitempty"`
+	Position int        `json:"position,omitempty"`
 }
 
 func ConvertToResponse(bq *patient.BookingQueue) *BookingQueueResponse {
@@ -61,6 +68,7 @@ func ConvertToResponse(bq *patient.BookingQueue) *BookingQueueResponse {
 		ServiceName:        bq.ServiceName,
 		ServiceCode:        bq.ServiceCode,
 		Cost:               bq.ServiceCost,
+		FacultyId:          bq.FacultyId,
 		PaymentStatus:      string(bq.PaymentStatus),
 		BookingStatus:      string(bq.BookingStatus),
 		AppointmentDate:    bq.AppointmentDate,
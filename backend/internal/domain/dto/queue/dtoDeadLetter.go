@@ -0,0 +1,49 @@
+package dtoqueue
+
+import (
+	"backend/internal/domain/messaging"
+	"time"
+)
+
+type DeadLetterResponse struct {
+	DeadLetterId int        `json:"dead_letter_id"`
+	SourceQueue  string     `json:"source_queue"`
+	MessageId    string     `json:"message_id,omitempty"`
+	MessageType  string     `json:"message_type,omitempty"`
+	Body         string     `json:"body"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"last_error"`
+	Status       string     `json:"status"`
+	DeadAt       time.Time  `json:"dead_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+type DeadLetterFilter struct {
+	Status string `form:"status"`
+}
+
+func ConvertToDeadLetterResponse(dl *messaging.DeadLetter) *DeadLetterResponse {
+	if dl == nil {
+		return nil
+	}
+	return &DeadLetterResponse{
+		DeadLetterId: dl.DeadLetterId,
+		SourceQueue:  dl.SourceQueue,
+		MessageId:    dl.MessageId,
+		MessageType:  dl.MessageType,
+		Body:         dl.Body,
+		Attempts:     dl.Attempts,
+		LastError:    dl.LastError,
+		Status:       string(dl.Status),
+		DeadAt:       dl.DeadAt,
+		ResolvedAt:   dl.ResolvedAt,
+	}
+}
+
+func ConvertToDeadLetterList(dl []*messaging.DeadLetter) []*DeadLetterResponse {
+	resp := make([]*DeadLetterResponse, len(dl))
+	for i, v := range dl {
+		resp[i] = ConvertToDeadLetterResponse(v)
+	}
+	return resp
+}
@@ -0,0 +1,152 @@
+package dtoqueue
+
+import (
+	"backend/internal/domain/display"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DisplayNextTickets is how many waiting tickets a display board lists
+const DisplayNextTickets = 5
+
+// message types of the display stream
+const (
+	DisplayBoardUpdated = "display_board"
+	DisplayChime        = "chime"
+)
+
+type CreateDisplayBoardRequest struct {
+	Name      string `json:"name" binding:"required,max=100"`
+	FacultyId byte   `json:"faculty_id" binding:"required,min=1"`
+	Room      string `json:"room" binding:"max=32"`
+}
+
+// DisplayBoardResponse carries the token only when the board is created, it cannot be read back
+type DisplayBoardResponse struct {
+	*display.DisplayBoard
+	Token string `json:"token,omitempty"`
+}
+
+type CallNextRequest struct {
+	// ignored for doctors, who call from their own faculty
+	FacultyId byte   `json:"faculty_id"`
+	Room      string `json:"room" binding:"required,max=32"`
+}
+
+// DisplayTicket is a booking as the waiting room sees it, without anything identifying
+// beyond a masked name
+type DisplayTicket struct {
+	Ticket      string     `json:"ticket"`
+	PatientName string     `json:"patient_name"`
+	Room        string     `json:"room,omitempty"`
+	CalledAt    *time.Time `json:"called_at,omitempty"`
+}
+
+type DisplayBoard struct {
+	Seq       int64  `json:"seq"`
+	FacultyId byte   `json:"faculty_id"`
+	Room      string `json:"room,omitempty"`
+	// the last ticket called to each room, most recent first
+	Serving []*DisplayTicket `json:"serving"`
+	// the front of the waiting line
+	Next  []*DisplayTicket `json:"next"`
+	Rooms []string         `json:"rooms"`
+}
+
+// DisplayChimeEvent tells a board to play its chime and announce the ticket
+type DisplayChimeEvent struct {
+	Seq    int64          `json:"seq"`
+	Type   string         `json:"type"`
+	Ticket *DisplayTicket `json:"ticket"`
+}
+
+// Ticket is the number shown for a booking
+func Ticket(queueId int) string {
+	return fmt.Sprintf("%04d", queueId)
+}
+
+func NewDisplayTicket(q *BookingQueueResponse) *DisplayTicket {
+	return &DisplayTicket{
+		Ticket:      Ticket(q.QueueId),
+		PatientName: MaskName(q.PatientName),
+		Room:        q.Room,
+		CalledAt:    q.CalledAt,
+	}
+}
+
+// NewDisplayBoard renders the board of a faculty, or of one of its rooms, from the live
+// queue. A room board shows the room's current ticket and the faculty's waiting line
+func NewDisplayBoard(snap *QueueSnapshot, facultyId byte, room string) *DisplayBoard {
+	board := &DisplayBoard{
+		Seq:       snap.Seq,
+		FacultyId: facultyId,
+		Room:      room,
+		Serving:   []*DisplayTicket{},
+		Next:      []*DisplayTicket{},
+		Rooms:     []string{},
+	}
+
+	serving := map[string]*BookingQueueResponse{}
+	var waiting []*BookingQueueResponse
+	for _, q := range snap.Queues {
+		if q.FacultyId != facultyId {
+			continue
+		}
+		if q.Room == "" {
+			if q.Position > 0 {
+				waiting = append(waiting, q)
+			}
+			continue
+		}
+		if current, ok := serving[q.Room]; !ok || calledAfter(q, current) {
+			serving[q.Room] = q
+		}
+	}
+
+	for r, q := range serving {
+		board.Rooms = append(board.Rooms, r)
+		if room == "" || r == room {
+			board.Serving = append(board.Serving, NewDisplayTicket(q))
+		}
+	}
+	sort.Strings(board.Rooms)
+	sort.Slice(board.Serving, func(i, j int) bool {
+		return calledAt(board.Serving[i].CalledAt).After(calledAt(board.Serving[j].CalledAt))
+	})
+
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].Position < waiting[j].Position })
+	for i, q := range waiting {
+		if i == DisplayNextTickets {
+			break
+		}
+		board.Next = append(board.Next, NewDisplayTicket(q))
+	}
+	return board
+}
+
+func calledAfter(a, b *BookingQueueResponse) bool {
+	return calledAt(a.CalledAt).After(calledAt(b.CalledAt))
+}
+
+func calledAt(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// MaskName keeps the first third of every word of a name, "Nguyen Van A" becomes
+// "Ng*** V** A"
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		n := utf8.RuneCountInString(w)
+		keep := (n + 2) / 3
+		hidden := min(n-keep, 3)
+		words[i] = string([]rune(w)[:keep]) + strings.Repeat("*", hidden)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,40 @@
+package dtoqueue
+
+import "time"
+
+// QueueDrift is how far the Redis live queue is from booking_queue. Queue ids are listed
+// by what is wrong with them
+type QueueDrift struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// active bookings in Postgres and entries in the cache
+	Database int `json:"database"`
+	Cache    int `json:"cache"`
+
+	// active in Postgres but not cached
+	Missing []int `json:"missing"`
+	// cached but completed or deleted in Postgres
+	Stale []int `json:"stale"`
+	// cached with different values than Postgres
+	Mismatched []*QueueDriftField `json:"mismatched"`
+	// cached but without a place in the display order
+	Unordered []int `json:"unordered"`
+	// in the display order but not cached
+	Orphaned []int `json:"orphaned"`
+
+	// whether the differences were fixed
+	Repaired bool `json:"repaired"`
+	// bookings left for the next round, they were being changed while repairing
+	Deferred []int `json:"deferred,omitempty"`
+}
+
+type QueueDriftField struct {
+	QueueId  int    `json:"queue_id"`
+	Field    string `json:"field"`
+	Cache    string `json:"cache"`
+	Database string `json:"database"`
+}
+
+// InSync reports whether the cache matches Postgres
+func (d *QueueDrift) InSync() bool {
+	return len(d.Missing)+len(d.Stale)+len(d.Mismatched)+len(d.Unordered)+len(d.Orphaned) == 0
+}
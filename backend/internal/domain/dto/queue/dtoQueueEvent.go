@@ -0,0 +1,60 @@
+package dtoqueue
+
+import "time"
+
+// live queue change events, the type is also the websocket message type
+const (
+	QueueAdded         = "queue_added"
+	QueueStatusChanged = "queue_status_changed"
+	QueueRemoved       = "queue_removed"
+	QueueReordered     = "queue_reordered"
+	QueueCalled        = "queue_called"
+
+	// only sent to the patient of the booking
+	QueuePositionChanged = "queue_position"
+	QueueNext            = "queue_next"
+)
+
+// QueueEvent is one change of the live queue. Seq increases by one per event across
+// every backend instance, a client that saw seq N only needs the events after N
+type QueueEvent struct {
+	Seq       int64                 `json:"seq"`
+	Type      string                `json:"type"`
+	QueueId   int                   `json:"queue_id,omitempty"`
+	PatientId string                `json:"patient_id,omitempty"`
+	FacultyId byte                  `json:"faculty_id,omitempty"`
+	Queue     *BookingQueueResponse `json:"queue,omitempty"`
+	From      string                `json:"from,omitempty"`
+	To        string                `json:"to,omitempty"`
+	Order     []int                 `json:"order,omitempty"`
+	Room      string                `json:"room,omitempty"`
+	// Position is the waiting position of the event's booking, set for its patient only
+	Position int `json:"position,omitempty"`
+	// Positions lists the bookings whose waiting position changed with this event
+	Positions  []*QueuePosition `json:"positions,omitempty"`
+	OccurredAt time.Time        `json:"occurred_at"`
+}
+
+// QueuePosition is how many bookings of the same faculty are called before this one,
+// 1 means next. Bookings already called have no position
+type QueuePosition struct {
+	QueueId   int    `json:"queue_id"`
+	PatientId string `json:"patient_id"`
+	FacultyId byte   `json:"faculty_id,omitempty"`
+	Position  int    `json:"position"`
+}
+
+// QueueSnapshot is the whole live queue in display order. Seq is the last event it
+// already reflects
+type QueueSnapshot struct {
+	Seq    int64                   `json:"seq"`
+	Queues []*BookingQueueResponse `json:"data"`
+}
+
+type CallQueueRequest struct {
+	Room string `json:"room" binding:"required,max=32"`
+}
+
+type ReorderQueueRequest struct {
+	QueueIds []int `json:"queue_ids" binding:"required,min=1"`
+}
@@ -10,6 +10,8 @@ type ServiceSubCategories struct {
 	Name                 string `json:"name" bun:"name"`
 
 	ServiceCategoryId uint16             `json:"service_category_id" bun:"service_category_id"`
+	// FacultyId is the faculty whose doctors see bookings of this subcategory
+	FacultyId byte `json:"faculty_id,omitempty" bun:"faculty_id,nullzero"`
 	ServiceCategory *ServiceCategories `bun:"rel:belongs-to,join:service_category_id=service_category_id"`
 
 	Services []*Services `bun:"rel:has-many,join:service_subcategory_id=service_subcategory_id"`
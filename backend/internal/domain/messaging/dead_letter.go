@@ -0,0 +1,30 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type DeadLetterStatus string
+
+const (
+	DeadLetterStatusDead      DeadLetterStatus = "dead"
+	DeadLetterStatusRequeued  DeadLetterStatus = "requeued"
+	DeadLetterStatusDiscarded DeadLetterStatus = "discarded"
+)
+
+// DeadLetter is a message that exhausted its retries, archived from the dead-letter queue
+type DeadLetter struct {
+	bun.BaseModel `bun:"table:dead_letter"`
+	DeadLetterId  int              `json:"dead_letter_id" bun:"dead_letter_id,pk,autoincrement"`
+	SourceQueue   string           `json:"source_queue" bun:"source_queue"`
+	MessageId     string           `json:"message_id" bun:"message_id"`
+	MessageType   string           `json:"message_type" bun:"message_type"`
+	Body          string           `json:"body" bun:"body,type:text"`
+	Attempts      int              `json:"attempts" bun:"attempts"`
+	LastError     string           `json:"last_error" bun:"last_error"`
+	Status        DeadLetterStatus `json:"status" bun:"status,default:'dead'"`
+	DeadAt        time.Time        `json:"dead_at" bun:"dead_at,default:current_timestamp"`
+	ResolvedAt    *time.Time       `json:"resolved_at" bun:"resolved_at,nullzero"`
+}
@@ -0,0 +1,50 @@
+package messaging
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	OutboxStatusSent    OutboxStatus = "sent"
+)
+
+// OutboxEvent is written in the same transaction as the domain change it describes
+// and published to RabbitMQ afterwards by the outbox relay
+type OutboxEvent struct {
+	bun.BaseModel `bun:"table:outbox"`
+	OutboxId      int64        `json:"outbox_id" bun:"outbox_id,pk,autoincrement"`
+	AggregateType string       `json:"aggregate_type" bun:"aggregate_type"`
+	AggregateId   string       `json:"aggregate_id" bun:"aggregate_id"`
+	EventType     string       `json:"event_type" bun:"event_type"`
+	Exchange      string       `json:"exchange" bun:"exchange"`
+	RoutingKey    string       `json:"routing_key" bun:"routing_key"`
+	Payload       string       `json:"payload" bun:"payload,type:text"`
+	Status        OutboxStatus `json:"status" bun:"status,default:'pending'"`
+	Attempts      int          `json:"attempts" bun:"attempts"`
+	LastError     string       `json:"last_error" bun:"last_error"`
+	CreatedAt     time.Time    `json:"created_at" bun:"created_at,default:current_timestamp"`
+	SentAt        *time.Time   `json:"sent_at" bun:"sent_at,nullzero"`
+}
+
+func NewOutboxEvent(aggregateType, aggregateId, eventType, exchange, routingKey string, payload interface{}) (*OutboxEvent, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxEvent{
+		AggregateType: aggregateType,
+		AggregateId:   aggregateId,
+		EventType:     eventType,
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Payload:       string(body),
+		Status:        OutboxStatusPending,
+		CreatedAt:     time.Now(),
+	}, nil
+}
@@ -34,10 +34,19 @@ type BookingQueue struct {
 	ServiceName string  `json:"service_name" bun:"service_name"`
 	ServiceCode string  `json:"service_code" bun:"service_code"`
 	ServiceCost float64 `json:"service_cost" bun:"service_cost"`
+	FacultyId   byte    `json:"faculty_id,omitempty" bun:"faculty_id,nullzero"`
+	// DoctorId is the doctor who called or treated the booking, it gives them access to
+	// the patient's record
+	DoctorId *uuid.UUID `json:"doctor_id,omitempty" bun:"doctor_id,type:uuid,nullzero"`
 
 	PaymentStatus PaymentStatus `json:"payment_status" bun:"payment_status,default:'waiting for payment'"`
 	BookingStatus BookingStatus `json:"booking_status" bun:"booking_status,default:'in progress'"`
 
+	PaymentSessionId string `json:"payment_session_id,omitempty" bun:"payment_session_id,nullzero,unique"`
+
+	// LockFence is the fencing token of the last locked write, see persistence.BookingLockName
+	LockFence int64 `json:"-" bun:"lock_fence,nullzero"`
+
 	AppointmentDate time.Time `json:"appointment" bun:"appointment,default:current_timestamp"`
 	CreatedAt       time.Time `json:"created_at" bun:"created_at,default:current_timestamp"`
 
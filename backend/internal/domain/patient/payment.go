@@ -0,0 +1,20 @@
+package patient
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Payment records a confirmed checkout session, the session id makes webhook
+// deliveries idempotent
+type Payment struct {
+	bun.BaseModel `bun:"table:payment"`
+	SessionId     string        `json:"session_id" bun:"session_id,pk"`
+	PatientId     uuid.UUID     `json:"patient_id" bun:"patient_id,type:uuid"`
+	ServiceId     string        `json:"service_id" bun:"service_id"`
+	Amount        float64       `json:"amount" bun:"amount"`
+	Status        PaymentStatus `json:"status" bun:"status"`
+	PaidAt        time.Time     `json:"paid_at" bun:"paid_at,default:current_timestamp"`
+}
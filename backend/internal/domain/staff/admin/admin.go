@@ -1,13 +1,32 @@
 package staff
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
+// admin roles, a super admin also manages the other admin accounts
+const (
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+)
+
 type Admin struct {
 	bun.BaseModel `bun:"table:admin"`
-	AdminId       uuid.UUID `json:"admin_id" bun:"admin_id,pk,type:uuid"`
-	Username      string    `json:"username" bun:"username"`
-	Password      string    `json:"password" bun:"password"`
+	AdminId       uuid.UUID  `json:"admin_id" bun:"admin_id,pk,type:uuid"`
+	Username      string     `json:"username" bun:"username"`
+	Password      string     `json:"-" bun:"password"`
+	Role          string     `json:"role" bun:"role,notnull,default:'admin'"`
+	Disabled      bool       `json:"disabled" bun:"disabled,notnull,default:false"`
+	DisabledAt    *time.Time `json:"disabled_at,omitempty" bun:"disabled_at"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty" bun:"last_login_at"`
+	LastLoginIP   string     `json:"last_login_ip,omitempty" bun:"last_login_ip"`
+	CreatedAt     time.Time  `json:"created_at" bun:"created_at,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time  `json:"updated_at" bun:"updated_at,notnull,default:current_timestamp"`
+}
+
+func (a *Admin) IsSuperAdmin() bool {
+	return a.Role == RoleSuperAdmin
 }
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// catalogVersionKey holds the catalog version and when it changed. Entries are keyed by
+// version, so bumping it invalidates all of them at once and the old ones expire on
+// their own
+const catalogVersionKey = "catalog:version"
+
+// CatalogVersion identifies the current state of the service catalog
+type CatalogVersion struct {
+	Number     int64
+	ModifiedAt time.Time
+}
+
+// CatalogCache is a read-through cache for the service catalog, which patients read on
+// every page view and admins rarely change
+type CatalogCache struct {
+	redis redis.RedisConnection
+	ttl   time.Duration
+}
+
+func NewCatalogCache(rc redis.RedisConnection, ttl time.Duration) *CatalogCache {
+	return &CatalogCache{
+		redis: rc,
+		ttl:   ttl,
+	}
+}
+
+// Version returns the current version, starting one when the cache is empty
+func (c *CatalogCache) Version(ctx context.Context) (CatalogVersion, error) {
+	fields, err := c.redis.HGetAll(ctx, catalogVersionKey)
+	if err != nil {
+		return CatalogVersion{}, err
+	}
+	if fields["version"] == "" {
+		return c.bump(ctx)
+	}
+
+	number, err := strconv.ParseInt(fields["version"], 10, 64)
+	if err != nil {
+		return CatalogVersion{}, fmt.Errorf("invalid catalog version %q", fields["version"])
+	}
+	modified, err := strconv.ParseInt(fields["modified_at"], 10, 64)
+	if err != nil {
+		return CatalogVersion{}, fmt.Errorf("invalid catalog modification time %q", fields["modified_at"])
+	}
+	return CatalogVersion{Number: number, ModifiedAt: time.Unix(modified, 0).UTC()}, nil
+}
+
+// Invalidate is called after every catalog write
+func (c *CatalogCache) Invalidate(ctx context.Context) error {
+	v, err := c.bump(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to invalidate catalog cache: %v", err)
+		return err
+	}
+	logrus.Infof("Catalog cache invalidated, now at version %d", v.Number)
+	return nil
+}
+
+func (c *CatalogCache) bump(ctx context.Context) (CatalogVersion, error) {
+	number, err := c.redis.HIncrBy(ctx, catalogVersionKey, "version", 1)
+	if err != nil {
+		return CatalogVersion{}, err
+	}
+	// HTTP dates have second precision
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := c.redis.HSet(ctx, catalogVersionKey, "modified_at", now.Unix()); err != nil {
+		return CatalogVersion{}, err
+	}
+	return CatalogVersion{Number: number, ModifiedAt: now}, nil
+}
+
+// ReadThrough returns the cached value of name at the current version, or loads and
+// caches it. The cache never fails a read, when Redis misbehaves load is used directly
+func ReadThrough[T any](ctx context.Context, c *CatalogCache, name string, load func(ctx context.Context) (T, error)) (T, error) {
+	v, err := c.Version(ctx)
+	if err != nil {
+		logrus.Warnf("Catalog cache unavailable: %v", err)
+		return load(ctx)
+	}
+	key := fmt.Sprintf("catalog:v%d:%s", v.Number, name)
+
+	var value T
+	cached, err := c.redis.Get(ctx, key)
+	if err == nil {
+		if err := json.Unmarshal([]byte(cached), &value); err == nil {
+			return value, nil
+		}
+		logrus.Warnf("Discarding unreadable catalog entry %s", key)
+	} else if !errors.Is(err, redis.ErrNotFound) {
+		logrus.Warnf("Catalog cache unavailable: %v", err)
+		return load(ctx)
+	}
+
+	value, err = load(ctx)
+	if err != nil {
+		return value, err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		if err := c.redis.Set(ctx, key, data, c.ttl); err != nil {
+			logrus.Warnf("Failed to cache %s: %v", key, err)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogCache_ReadThroughUntilInvalidated(t *testing.T) {
+	c := NewCatalogCache(redis.NewMemoryStore(), time.Minute)
+	ctx := context.Background()
+
+	loads := 0
+	load := func(ctx context.Context) ([]string, error) {
+		loads++
+		return []string{"Khám bệnh", "Xét nghiệm"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := ReadThrough(ctx, c, "categories", load)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Khám bệnh", "Xét nghiệm"}, got)
+	}
+	assert.Equal(t, 1, loads)
+
+	before, err := c.Version(ctx)
+	require.NoError(t, err)
+	require.NoError(t, c.Invalidate(ctx))
+	after, err := c.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before.Number+1, after.Number)
+	assert.False(t, after.ModifiedAt.Before(before.ModifiedAt))
+
+	_, err = ReadThrough(ctx, c, "categories", load)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loads)
+
+	// failed loads are not cached
+	failing := func(ctx context.Context) ([]string, error) {
+		loads++
+		return nil, errors.New("db down")
+	}
+	for i := 0; i < 2; i++ {
+		_, err = ReadThrough(ctx, c, "services", failing)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 4, loads)
+}
@@ -0,0 +1,124 @@
+package accountrepository
+
+import (
+	"backend/internal/domain/account"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+var (
+	ErrUnknownRole     = errors.New("unknown role")
+	ErrAccountNotFound = errors.New("account not found")
+)
+
+// accountTable is where the accounts of a role are stored
+type accountTable struct {
+	table    string
+	idColumn string
+}
+
+var accountTables = map[string]accountTable{
+	"patient": {table: "patient", idColumn: "patient_id"},
+	"doctor":  {table: "doctor", idColumn: "doctor_id"},
+	"nurse":   {table: "nurse", idColumn: "nurse_id"},
+}
+
+// AccountRepository reads and updates the credentials of patients, doctors and nurses
+// alike
+type AccountRepository interface {
+	// FindByContact looks the account of role up by email or phone number
+	FindByContact(ctx context.Context, role, contact string) (*account.Account, error)
+	FindByEmail(ctx context.Context, role, email string) (*account.Account, error)
+	FindById(ctx context.Context, role string, id uuid.UUID) (*account.Account, error)
+	UpdatePassword(ctx context.Context, role string, id uuid.UUID, hashedPassword []byte) error
+}
+
+type accountRepository struct {
+	db *bun.DB
+}
+
+func NewAccountRepository(db *bun.DB) AccountRepository {
+	return &accountRepository{db: db}
+}
+
+func (r *accountRepository) FindByContact(ctx context.Context, role, contact string) (*account.Account, error) {
+	contact = strings.TrimSpace(contact)
+	if contact == "" {
+		return nil, ErrAccountNotFound
+	}
+	return r.find(ctx, role, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("lower(email) = lower(?) OR phone_number = ?", contact, contact)
+	})
+}
+
+func (r *accountRepository) FindByEmail(ctx context.Context, role, email string) (*account.Account, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, ErrAccountNotFound
+	}
+	return r.find(ctx, role, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("lower(email) = lower(?)", email)
+	})
+}
+
+func (r *accountRepository) FindById(ctx context.Context, role string, id uuid.UUID) (*account.Account, error) {
+	t, ok := accountTables[role]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+	return r.find(ctx, role, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("? = ?", bun.Ident(t.idColumn), id)
+	})
+}
+
+func (r *accountRepository) find(ctx context.Context, role string, where func(*bun.SelectQuery) *bun.SelectQuery) (*account.Account, error) {
+	t, ok := accountTables[role]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+
+	acc := &account.Account{}
+	q := r.db.NewSelect().
+		TableExpr("?", bun.Ident(t.table)).
+		ColumnExpr("? AS id", bun.Ident(t.idColumn)).
+		ColumnExpr("email, phone_number, password")
+	err := where(q).Limit(1).Scan(ctx, acc)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	acc.Role = role
+	return acc, nil
+}
+
+func (r *accountRepository) UpdatePassword(ctx context.Context, role string, id uuid.UUID, hashedPassword []byte) error {
+	t, ok := accountTables[role]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+
+	res, err := r.db.NewUpdate().
+		TableExpr("?", bun.Ident(t.table)).
+		Set("password = ?", string(hashedPassword)).
+		Set("updated_at = current_timestamp").
+		Where("? = ?", bun.Ident(t.idColumn), id).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
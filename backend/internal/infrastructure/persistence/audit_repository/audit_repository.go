@@ -0,0 +1,187 @@
+package auditrepository
+
+import (
+	"backend/internal/domain/audit"
+	"backend/internal/domain/dto"
+	"backend/pkg/common/pagination"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+// appendLock serializes the appends across instances, each needs the hash of the last
+// event
+const appendLock = 0x61756469
+
+const batchSize = 500
+
+type AuditRepository interface {
+	// Append chains the event to the last one and stores it
+	Append(ctx context.Context, e *audit.Event) error
+	Search(ctx context.Context, filter *dto.AuditFilter, pagination *pagination.Pagination) ([]*audit.Event, error)
+	// Each walks the matching events in insertion order, for exports and verification
+	Each(ctx context.Context, filter *dto.AuditFilter, fn func(*audit.Event) error) error
+}
+
+type auditRepository struct {
+	db *bun.DB
+}
+
+func NewAuditRepository(db *bun.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+// Migrate creates the audit table
+func Migrate(db *bun.DB) error {
+	return (&auditRepository{db: db}).migrate()
+}
+
+func (r *auditRepository) Append(ctx context.Context, e *audit.Event) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", appendLock); err != nil {
+			return err
+		}
+
+		var prev string
+		err := tx.NewSelect().
+			Model((*audit.Event)(nil)).
+			Column("hash").
+			Order("id DESC").
+			Limit(1).
+			Scan(ctx, &prev)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		e.PrevHash = prev
+		e.Hash = e.ComputeHash()
+		_, err = tx.NewInsert().Model(e).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func where(q *bun.SelectQuery, f *dto.AuditFilter) *bun.SelectQuery {
+	if f == nil {
+		return q
+	}
+	if f.ActorId != "" {
+		q = q.Where("actor_id = ?", f.ActorId)
+	}
+	if f.PatientId != "" {
+		q = q.Where("patient_id = ?", f.PatientId)
+	}
+	if f.Role != "" {
+		q = q.Where("role = ?", f.Role)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.Resource != "" {
+		q = q.Where("resource = ?", f.Resource)
+	}
+	if f.Outcome != "" {
+		q = q.Where("outcome = ?", f.Outcome)
+	}
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+	return q
+}
+
+func (r *auditRepository) Search(ctx context.Context, filter *dto.AuditFilter, pagination *pagination.Pagination) ([]*audit.Event, error) {
+	var events []*audit.Event
+	total, err := where(r.db.NewSelect().Model(&events), filter).Count(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	pagination.Total = int64(total)
+
+	err = where(r.db.NewSelect().Model(&events), filter).
+		Order("id DESC").
+		Limit(pagination.GetLimit()).
+		Offset(pagination.GetOffSet()).
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *auditRepository) Each(ctx context.Context, filter *dto.AuditFilter, fn func(*audit.Event) error) error {
+	var after int64
+	for {
+		var events []*audit.Event
+		err := where(r.db.NewSelect().Model(&events), filter).
+			Where("id > ?", after).
+			Order("id ASC").
+			Limit(batchSize).
+			Scan(ctx)
+		if err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return err
+		}
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(events) < batchSize {
+			return nil
+		}
+		after = events[len(events)-1].Id
+	}
+}
+
+func (r *auditRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model((*audit.Event)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate audit_event table: ", err)
+		return err
+	}
+
+	for name, columns := range map[string][]string{
+		"audit_event_patient_idx": {"patient_id", "created_at"},
+		"audit_event_actor_idx":   {"actor_id", "created_at"},
+	} {
+		_, err = r.db.NewCreateIndex().Model((*audit.Event)(nil)).Index(name).Column(columns...).IfNotExists().Exec(ctx)
+		if err != nil {
+			logrus.Error("Failed to index audit_event table: ", err)
+			return err
+		}
+	}
+
+	// the table is append-only, even for the application's own database user
+	_, err = r.db.ExecContext(ctx, `
+CREATE OR REPLACE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_event_append_only ON audit_event;
+CREATE TRIGGER audit_event_append_only BEFORE UPDATE OR DELETE ON audit_event
+	FOR EACH ROW EXECUTE FUNCTION audit_event_append_only();
+
+DROP TRIGGER IF EXISTS audit_event_no_truncate ON audit_event;
+CREATE TRIGGER audit_event_no_truncate BEFORE TRUNCATE ON audit_event
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_event_append_only();`)
+	if err != nil {
+		logrus.Error("Failed to protect audit_event table: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package displayrepository
+
+import (
+	"backend/internal/domain/display"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+var ErrBoardNotFound = errors.New("display board not found")
+
+type DisplayBoardRepository interface {
+	Create(ctx context.Context, board *display.DisplayBoard) error
+	GetByTokenHash(ctx context.Context, tokenHash string) (*display.DisplayBoard, error)
+	List(ctx context.Context) ([]*display.DisplayBoard, error)
+	Delete(ctx context.Context, id int) error
+}
+
+type displayBoardRepository struct {
+	db *bun.DB
+}
+
+func NewDisplayBoardRepository(db *bun.DB) DisplayBoardRepository {
+	return &displayBoardRepository{db: db}
+}
+
+// Migrate creates the display board table
+func Migrate(db *bun.DB) error {
+	return (&displayBoardRepository{db: db}).migrate()
+}
+
+func (r *displayBoardRepository) Create(ctx context.Context, board *display.DisplayBoard) error {
+	_, err := r.db.NewInsert().Model(board).Returning("*").Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *displayBoardRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*display.DisplayBoard, error) {
+	board := &display.DisplayBoard{}
+	err := r.db.NewSelect().Model(board).Where("token_hash = ?", tokenHash).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBoardNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return board, nil
+}
+
+func (r *displayBoardRepository) List(ctx context.Context) ([]*display.DisplayBoard, error) {
+	var boards []*display.DisplayBoard
+	err := r.db.NewSelect().Model(&boards).Order("faculty_id ASC", "board_id ASC").Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return boards, nil
+}
+
+// Delete revokes the board's token, a TV already streaming is refused when it reconnects
+func (r *displayBoardRepository) Delete(ctx context.Context, id int) error {
+	res, err := r.db.NewDelete().Model((*display.DisplayBoard)(nil)).Where("board_id = ?", id).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBoardNotFound
+	}
+	return nil
+}
+
+func (r *displayBoardRepository) migrate() error {
+	_, err := r.db.NewCreateTable().Model(&display.DisplayBoard{}).IfNotExists().Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Failed to migrate display_board table: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package loginhistoryrepository
+
+import (
+	"backend/internal/domain/account"
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+// KnownLogin tells what earlier successful logins of a user looked like
+type KnownLogin struct {
+	// Seen is false on the first successful login
+	Seen    bool `bun:"seen"`
+	Device  bool `bun:"device_seen"`
+	Network bool `bun:"network_seen"`
+}
+
+type LoginHistoryRepository interface {
+	Record(ctx context.Context, e *account.LoginEvent) error
+	// ListByUser returns the latest events of the user, newest first
+	ListByUser(ctx context.Context, userId uuid.UUID, limit int) ([]*account.LoginEvent, error)
+	// Known checks the device and network against the earlier successful logins
+	Known(ctx context.Context, userId uuid.UUID, deviceId, network string) (*KnownLogin, error)
+}
+
+type loginHistoryRepository struct {
+	db *bun.DB
+}
+
+func NewLoginHistoryRepository(db *bun.DB) LoginHistoryRepository {
+	return &loginHistoryRepository{db: db}
+}
+
+// Migrate creates the login history table
+func Migrate(db *bun.DB) error {
+	return (&loginHistoryRepository{db: db}).migrate()
+}
+
+func (r *loginHistoryRepository) Record(ctx context.Context, e *account.LoginEvent) error {
+	_, err := r.db.NewInsert().Model(e).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *loginHistoryRepository) ListByUser(ctx context.Context, userId uuid.UUID, limit int) ([]*account.LoginEvent, error) {
+	var events []*account.LoginEvent
+	err := r.db.NewSelect().
+		Model(&events).
+		Where("user_id = ?", userId).
+		Order("created_at DESC", "id DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *loginHistoryRepository) Known(ctx context.Context, userId uuid.UUID, deviceId, network string) (*KnownLogin, error) {
+	known := &KnownLogin{}
+	err := r.db.NewSelect().
+		Model((*account.LoginEvent)(nil)).
+		ColumnExpr("count(*) > 0 AS seen").
+		ColumnExpr("coalesce(bool_or(device_id = ?), false) AS device_seen", deviceId).
+		ColumnExpr("coalesce(bool_or(network = ?), false) AS network_seen", network).
+		Where("user_id = ?", userId).
+		Where("success").
+		Scan(ctx, known)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return known, nil
+}
+
+func (r *loginHistoryRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model((*account.LoginEvent)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate login_event table: ", err)
+		return err
+	}
+	_, err = r.db.NewCreateIndex().
+		Model((*account.LoginEvent)(nil)).
+		Index("login_event_user_idx").
+		Column("user_id", "created_at").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to index login_event table: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package messagingrepository
+
+import (
+	"backend/internal/domain/messaging"
+	"backend/pkg/common/pagination"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+type DeadLetterRepository interface {
+	Create(ctx context.Context, dl *messaging.DeadLetter) error
+	GetById(ctx context.Context, id int) (*messaging.DeadLetter, error)
+	List(ctx context.Context, pagination *pagination.Pagination, status string) ([]*messaging.DeadLetter, error)
+	UpdateStatus(ctx context.Context, id int, status messaging.DeadLetterStatus) error
+}
+
+type deadLetterRepository struct {
+	db *bun.DB
+}
+
+func NewDeadLetterRepository(db *bun.DB) DeadLetterRepository {
+	return &deadLetterRepository{db: db}
+}
+
+// MigrateDeadLetter creates the dead letter table
+func MigrateDeadLetter(db *bun.DB) error {
+	return (&deadLetterRepository{db: db}).migrate()
+}
+
+func (r *deadLetterRepository) Create(ctx context.Context, dl *messaging.DeadLetter) error {
+	_, err := r.db.NewInsert().Model(dl).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *deadLetterRepository) GetById(ctx context.Context, id int) (*messaging.DeadLetter, error) {
+	dl := &messaging.DeadLetter{}
+	err := r.db.NewSelect().Model(dl).Where("dead_letter_id = ?", id).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("dead letter not found")
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (r *deadLetterRepository) List(ctx context.Context, pagination *pagination.Pagination, status string) ([]*messaging.DeadLetter, error) {
+	var dl []*messaging.DeadLetter
+	offset := pagination.GetOffSet()
+	limit := pagination.GetLimit()
+
+	query := r.db.NewSelect().Model(&dl)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	total, err := query.Count(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	pagination.Total = int64(total)
+
+	err = query.Order("dead_letter_id DESC").Limit(limit).Offset(offset).Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (r *deadLetterRepository) UpdateStatus(ctx context.Context, id int, status messaging.DeadLetterStatus) error {
+	_, err := r.db.NewUpdate().
+		Model((*messaging.DeadLetter)(nil)).
+		Set("status = ?", status).
+		Set("resolved_at = ?", time.Now()).
+		Where("dead_letter_id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *deadLetterRepository) migrate() error {
+	_, err := r.db.NewCreateTable().Model(&messaging.DeadLetter{}).IfNotExists().Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Failed to migrate dead_letter table: %v", err)
+		return err
+	}
+
+	_, err = r.db.ExecContext(context.Background(),
+		`ALTER TABLE IF EXISTS dead_letter ADD COLUMN IF NOT EXISTS message_type VARCHAR`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate dead_letter table: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package messagingrepository
+
+import (
+	"backend/internal/domain/messaging"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+type OutboxRepository interface {
+	// RelayPending hands pending events to publish in insertion order and marks the
+	// published ones as sent. It stops at the first failure so ordering is preserved.
+	RelayPending(ctx context.Context, limit int, publish func(ev *messaging.OutboxEvent) error) (int, error)
+}
+
+type outboxRepository struct {
+	db *bun.DB
+}
+
+func NewOutboxRepository(db *bun.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+// MigrateOutbox creates the outbox table
+func MigrateOutbox(db *bun.DB) error {
+	return (&outboxRepository{db: db}).migrate()
+}
+
+// InsertOutboxEvents stores events with the given connection, pass the transaction
+// of the domain change so both are committed together
+func InsertOutboxEvents(ctx context.Context, db bun.IDB, events ...*messaging.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	_, err := db.NewInsert().Model(&events).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to insert outbox events: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *outboxRepository) RelayPending(ctx context.Context, limit int, publish func(ev *messaging.OutboxEvent) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED lets several relays (one per instance) share the table
+	var events []*messaging.OutboxEvent
+	err = tx.NewSelect().
+		Model(&events).
+		Where("status = ?", messaging.OutboxStatusPending).
+		Order("outbox_id ASC").
+		Limit(limit).
+		For("UPDATE SKIP LOCKED").
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return 0, err
+	}
+
+	sent := 0
+	for _, ev := range events {
+		if pubErr := publish(ev); pubErr != nil {
+			_, err = tx.NewUpdate().
+				Model((*messaging.OutboxEvent)(nil)).
+				Set("attempts = attempts + 1").
+				Set("last_error = ?", pubErr.Error()).
+				Where("outbox_id = ?", ev.OutboxId).
+				Exec(ctx)
+			if err != nil {
+				logrus.Errorf("Repository layer: %v", err)
+				return sent, err
+			}
+			break
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*messaging.OutboxEvent)(nil)).
+			Set("status = ?", messaging.OutboxStatusSent).
+			Set("attempts = attempts + 1").
+			Set("sent_at = ?", time.Now()).
+			Where("outbox_id = ?", ev.OutboxId).
+			Exec(ctx)
+		if err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return sent, err
+		}
+		sent++
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.Errorf("Failed to commit transaction: %v", err)
+		return 0, err
+	}
+	return sent, nil
+}
+
+func (r *outboxRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model(&messaging.OutboxEvent{}).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to migrate outbox table: %v", err)
+		return err
+	}
+
+	_, err = r.db.NewCreateIndex().
+		Model((*messaging.OutboxEvent)(nil)).
+		Index("outbox_pending_idx").
+		Column("status", "outbox_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to create outbox index: %v", err)
+		return err
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package patientrepository
 
 import (
 	dtopatient "backend/internal/domain/dto/dto_patient"
+	"backend/internal/domain/messaging"
 	"backend/internal/domain/patient"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
 	"backend/pkg/common/pagination"
 	"context"
 	"database/sql"
@@ -15,9 +17,13 @@ import (
 )
 
 type PatientRepository interface {
-	CreatePatient(ctx context.Context, m *patient.Patient) error
+	// CreatePatient stores events in the same transaction as the patient
+	CreatePatient(ctx context.Context, m *patient.Patient, events ...*messaging.OutboxEvent) error
 	GetPatientById(ctx context.Context, id uuid.UUID) (*patient.Patient, error)
 	GetPatients(ctx context.Context, pagination *pagination.Pagination) ([]*patient.Patient, error)
+	// GetPatientsOfStaff lists the patients with a booking treated by staffId, or an active
+	// booking in the faculty
+	GetPatientsOfStaff(ctx context.Context, pagination *pagination.Pagination, staffId uuid.UUID, facultyId byte) ([]*patient.Patient, error)
 	UpdatePatient(ctx context.Context, id uuid.UUID, m *patient.Patient) error
 	DeletePatientById(ctx context.Context, id uuid.UUID) error
 	GetPatientByPhoneNumber(ctx context.Context, phoneNumber string) (*patient.Patient, error)
@@ -30,9 +36,12 @@ type patientRepo struct {
 }
 
 func NewPatientRepo(db *bun.DB) PatientRepository {
-	repo := &patientRepo{db: db}
-	_ = repo.migrate()
-	return repo
+	return &patientRepo{db: db}
+}
+
+// Migrate creates the patient tables
+func Migrate(db *bun.DB) error {
+	return (&patientRepo{db: db}).migrate()
 }
 
 func (r *patientRepo) BuildPatientModelForCreate(d *dtopatient.CreatePatientRequest, t time.Time, hashedPassword []byte, nextOfKinInfo []patient.NextOfKinInfo, drugAllergies, diseaseTreatmentHistory []string) (*patient.Patient, error) {
@@ -132,7 +141,7 @@ func (r *patientRepo) BuildPatientModelForUpdate(ud *dtopatient.UpdatePatientReq
 	return &p, nil
 }
 
-func (r *patientRepo) CreatePatient(ctx context.Context, m *patient.Patient) error {
+func (r *patientRepo) CreatePatient(ctx context.Context, m *patient.Patient, events ...*messaging.OutboxEvent) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		logrus.Errorf("Failed to create transaction: %v", err)
@@ -165,6 +174,10 @@ func (r *patientRepo) CreatePatient(ctx context.Context, m *patient.Patient) err
 		}
 	}
 
+	if err := messagingrepository.InsertOutboxEvents(ctx, tx, events...); err != nil {
+		return err
+	}
+
 	// Commit transaction
 	err = tx.Commit()
 	if err != nil {
@@ -223,6 +236,41 @@ func (r *patientRepo) GetPatients(ctx context.Context, pagination *pagination.Pa
 	return p, nil
 }
 
+func (r *patientRepo) GetPatientsOfStaff(ctx context.Context, pagination *pagination.Pagination, staffId uuid.UUID, facultyId byte) ([]*patient.Patient, error) {
+	var p []*patient.Patient
+	related := r.db.NewSelect().
+		Model((*patient.BookingQueue)(nil)).
+		Column("patient_id").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			q = q.Where("doctor_id = ?", staffId)
+			if facultyId != 0 {
+				q = q.WhereOr("faculty_id = ? AND booking_status != ?", facultyId, patient.BookingStatusCompleted)
+			}
+			return q
+		})
+
+	total, err := r.db.NewSelect().Model(&p).Where("patient.patient_id IN (?)", related).Count(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	pagination.Total = int64(total)
+
+	err = r.db.NewSelect().
+		Model(&p).
+		Where("patient.patient_id IN (?)", related).
+		Limit(pagination.GetLimit()).
+		Offset(pagination.GetOffSet()).
+		Relation("MedicalHistory").
+		Relation("GeneralExamination").
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return p, nil
+}
+
 func (r *patientRepo) UpdatePatient(ctx context.Context, patientId uuid.UUID, m *patient.Patient) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -0,0 +1,73 @@
+package paymentrepository
+
+import (
+	"backend/internal/domain/messaging"
+	"backend/internal/domain/patient"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+type PaymentRepository interface {
+	// ConfirmPayment stores the payment together with its outbox events.
+	// It returns false when the session was already confirmed.
+	ConfirmPayment(ctx context.Context, p *patient.Payment, events ...*messaging.OutboxEvent) (bool, error)
+}
+
+type paymentRepository struct {
+	db *bun.DB
+}
+
+func NewPaymentRepository(db *bun.DB) PaymentRepository {
+	return &paymentRepository{db: db}
+}
+
+// Migrate creates the payment table
+func Migrate(db *bun.DB) error {
+	return (&paymentRepository{db: db}).migrate()
+}
+
+func (r *paymentRepository) ConfirmPayment(ctx context.Context, p *patient.Payment, events ...*messaging.OutboxEvent) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create transaction: %v", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NewInsert().Model(p).On("CONFLICT (session_id) DO NOTHING").Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		logrus.Infof("Payment session %s already confirmed", p.SessionId)
+		return false, nil
+	}
+
+	if err := messagingrepository.InsertOutboxEvents(ctx, tx, events...); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.Errorf("Failed to commit transaction: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *paymentRepository) migrate() error {
+	_, err := r.db.NewCreateTable().Model(&patient.Payment{}).IfNotExists().Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Failed to migrate payment table: %v", err)
+		return err
+	}
+	return nil
+}
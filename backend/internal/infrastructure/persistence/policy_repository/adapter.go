@@ -0,0 +1,156 @@
+package policyrepository
+
+import (
+	"backend/internal/domain/authz"
+	"context"
+	"fmt"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+// addedRules were added to policy.csv after databases had been seeded from it, the
+// seed only fills an empty table so the migration adds them to the existing policies
+var addedRules = [][]string{
+	{"p", "doctor", "/api/doctor/*", "POST"},
+	{"p", "nurse", "/api/nurse/*", "POST"},
+}
+
+// policyAdapter stores the Casbin policy in the casbin_rule table, so every instance
+// enforces the same rules
+type policyAdapter struct {
+	db *bun.DB
+}
+
+func NewPolicyAdapter(db *bun.DB) persist.Adapter {
+	return &policyAdapter{db: db}
+}
+
+// Migrate creates the casbin rule table
+func Migrate(db *bun.DB) error {
+	return (&policyAdapter{db: db}).migrate()
+}
+
+func (a *policyAdapter) LoadPolicy(m model.Model) error {
+	var rules []*authz.CasbinRule
+	err := a.db.NewSelect().Model(&rules).Order("id ASC").Scan(context.Background())
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+
+	for _, r := range rules {
+		if err := persist.LoadPolicyArray(append([]string{r.Ptype}, r.Rule()...), m); err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// SavePolicy replaces the stored policy with the one of the model
+func (a *policyAdapter) SavePolicy(m model.Model) error {
+	var rules []*authz.CasbinRule
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			for _, rule := range ast.Policy {
+				rules = append(rules, authz.NewCasbinRule(ptype, rule))
+			}
+		}
+	}
+
+	ctx := context.Background()
+	return a.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().Model((*authz.CasbinRule)(nil)).Where("TRUE").Exec(ctx)
+		if err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return err
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+		_, err = tx.NewInsert().Model(&rules).Exec(ctx)
+		if err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (a *policyAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	_, err := a.db.NewInsert().
+		Model(authz.NewCasbinRule(ptype, rule)).
+		On("CONFLICT DO NOTHING").
+		Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (a *policyAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	r := authz.NewCasbinRule(ptype, rule)
+	_, err := a.db.NewDelete().
+		Model((*authz.CasbinRule)(nil)).
+		Where("ptype = ?", r.Ptype).
+		Where("v0 = ? AND v1 = ? AND v2 = ? AND v3 = ? AND v4 = ? AND v5 = ?", r.V0, r.V1, r.V2, r.V3, r.V4, r.V5).
+		Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+// RemoveFilteredPolicy removes the rules whose values from fieldIndex on match
+// fieldValues, an empty value matches anything
+func (a *policyAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	q := a.db.NewDelete().Model((*authz.CasbinRule)(nil)).Where("ptype = ?", ptype)
+	for i, v := range fieldValues {
+		idx := fieldIndex + i
+		if v == "" || idx > 5 {
+			continue
+		}
+		q = q.Where("? = ?", bun.Ident(fmt.Sprintf("v%d", idx)), v)
+	}
+	_, err := q.Exec(context.Background())
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (a *policyAdapter) migrate() error {
+	ctx := context.Background()
+	_, err := a.db.NewCreateTable().Model((*authz.CasbinRule)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to migrate casbin_rule table: %v", err)
+		return err
+	}
+
+	// a rule is stored once, AddPolicy relies on it to stay idempotent
+	_, err = a.db.ExecContext(ctx,
+		`CREATE UNIQUE INDEX IF NOT EXISTS casbin_rule_unique ON casbin_rule (ptype, v0, v1, v2, v3, v4, v5)`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate casbin_rule table: %v", err)
+		return err
+	}
+
+	// an empty table is left to the seed, a rule in it would stop the seed from running
+	for _, rule := range addedRules {
+		r := authz.NewCasbinRule(rule[0], rule[1:])
+		_, err = a.db.ExecContext(ctx, `INSERT INTO casbin_rule (ptype, v0, v1, v2, v3, v4, v5)
+			SELECT ?, ?, ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM casbin_rule)
+			ON CONFLICT DO NOTHING`, r.Ptype, r.V0, r.V1, r.V2, r.V3, r.V4, r.V5)
+		if err != nil {
+			logrus.Errorf("Failed to migrate casbin_rule table: %v", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package serviceaccountrepository
+
+import (
+	"backend/internal/domain/account"
+	"backend/pkg/common/pagination"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+var (
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrServiceAccountExists   = errors.New("service account name is already taken")
+	ErrKeyNotFound            = errors.New("api key not found")
+)
+
+type ServiceAccountRepository interface {
+	CreateAccount(ctx context.Context, sa *account.ServiceAccount) error
+	GetAccount(ctx context.Context, id uuid.UUID) (*account.ServiceAccount, error)
+	ListAccounts(ctx context.Context, pagination *pagination.Pagination) ([]*account.ServiceAccount, error)
+	// UpdateAccount saves the description, role and disabled state of the account
+	UpdateAccount(ctx context.Context, sa *account.ServiceAccount) error
+	// DeleteAccount removes the account along with its keys
+	DeleteAccount(ctx context.Context, id uuid.UUID) error
+
+	CreateKey(ctx context.Context, key *account.APIKey) error
+	ListKeys(ctx context.Context, serviceAccountId uuid.UUID) ([]*account.APIKey, error)
+	GetKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) (*account.APIKey, error)
+	// GetKeyByPrefix loads the key with its service account, for authentication
+	GetKeyByPrefix(ctx context.Context, prefix string) (*account.APIKey, error)
+	// RotateKey stores next and moves the expiry of the key it replaces to oldExpiresAt,
+	// unless the key expires sooner
+	RotateKey(ctx context.Context, next *account.APIKey, oldExpiresAt time.Time) error
+	RevokeKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) error
+	// RecordUse counts a request made with the key
+	RecordUse(ctx context.Context, keyId uuid.UUID, ip string) error
+}
+
+type serviceAccountRepository struct {
+	db *bun.DB
+}
+
+func NewServiceAccountRepository(db *bun.DB) ServiceAccountRepository {
+	return &serviceAccountRepository{db: db}
+}
+
+// Migrate creates the service account and API key tables
+func Migrate(db *bun.DB) error {
+	return (&serviceAccountRepository{db: db}).migrate()
+}
+
+func (r *serviceAccountRepository) CreateAccount(ctx context.Context, sa *account.ServiceAccount) error {
+	now := time.Now()
+	sa.CreatedAt, sa.UpdatedAt = now, now
+	_, err := r.db.NewInsert().Model(sa).Exec(ctx)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrServiceAccountExists
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *serviceAccountRepository) GetAccount(ctx context.Context, id uuid.UUID) (*account.ServiceAccount, error) {
+	sa := &account.ServiceAccount{}
+	err := r.db.NewSelect().Model(sa).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrServiceAccountNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return sa, nil
+}
+
+func (r *serviceAccountRepository) ListAccounts(ctx context.Context, pagination *pagination.Pagination) ([]*account.ServiceAccount, error) {
+	var accounts []*account.ServiceAccount
+	total, err := r.db.NewSelect().
+		Model(&accounts).
+		Order("name ASC").
+		Limit(pagination.GetLimit()).
+		Offset(pagination.GetOffSet()).
+		ScanAndCount(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	pagination.Total = int64(total)
+	return accounts, nil
+}
+
+func (r *serviceAccountRepository) UpdateAccount(ctx context.Context, sa *account.ServiceAccount) error {
+	sa.UpdatedAt = time.Now()
+	res, err := r.db.NewUpdate().
+		Model(sa).
+		Column("description", "role", "disabled", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (r *serviceAccountRepository) DeleteAccount(ctx context.Context, id uuid.UUID) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().Model((*account.APIKey)(nil)).Where("service_account_id = ?", id).Exec(ctx); err != nil {
+			return err
+		}
+		res, err := tx.NewDelete().Model((*account.ServiceAccount)(nil)).Where("id = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrServiceAccountNotFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrServiceAccountNotFound) {
+		logrus.Errorf("Repository layer: %v", err)
+	}
+	return err
+}
+
+func (r *serviceAccountRepository) CreateKey(ctx context.Context, key *account.APIKey) error {
+	key.CreatedAt = time.Now()
+	_, err := r.db.NewInsert().Model(key).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *serviceAccountRepository) ListKeys(ctx context.Context, serviceAccountId uuid.UUID) ([]*account.APIKey, error) {
+	var keys []*account.APIKey
+	err := r.db.NewSelect().
+		Model(&keys).
+		Where("service_account_id = ?", serviceAccountId).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *serviceAccountRepository) GetKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) (*account.APIKey, error) {
+	key := &account.APIKey{}
+	err := r.db.NewSelect().
+		Model(key).
+		Where("id = ?", keyId).
+		Where("service_account_id = ?", serviceAccountId).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrKeyNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *serviceAccountRepository) GetKeyByPrefix(ctx context.Context, prefix string) (*account.APIKey, error) {
+	key := &account.APIKey{}
+	err := r.db.NewSelect().
+		Model(key).
+		Relation("ServiceAccount").
+		Where("api_key.prefix = ?", prefix).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrKeyNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *serviceAccountRepository) RotateKey(ctx context.Context, next *account.APIKey, oldExpiresAt time.Time) error {
+	next.CreatedAt = time.Now()
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// only a key still in use can be rotated, and only once
+		res, err := tx.NewUpdate().
+			Model((*account.APIKey)(nil)).
+			Set("expires_at = LEAST(expires_at, ?)", oldExpiresAt).
+			Where("id = ?", *next.RotatedFrom).
+			Where("service_account_id = ?", next.ServiceAccountId).
+			Where("revoked_at IS NULL").
+			Where("expires_at > current_timestamp").
+			Where("NOT EXISTS (SELECT 1 FROM api_key AS n WHERE n.rotated_from = ?)", *next.RotatedFrom).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrKeyNotFound
+		}
+		_, err = tx.NewInsert().Model(next).Exec(ctx)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		logrus.Errorf("Repository layer: %v", err)
+	}
+	return err
+}
+
+func (r *serviceAccountRepository) RevokeKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) error {
+	res, err := r.db.NewUpdate().
+		Model((*account.APIKey)(nil)).
+		Set("revoked_at = current_timestamp").
+		Where("id = ?", keyId).
+		Where("service_account_id = ?", serviceAccountId).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (r *serviceAccountRepository) RecordUse(ctx context.Context, keyId uuid.UUID, ip string) error {
+	_, err := r.db.NewUpdate().
+		Model((*account.APIKey)(nil)).
+		Set("usage_count = usage_count + 1").
+		Set("last_used_at = current_timestamp").
+		Set("last_used_ip = ?", ip).
+		Where("id = ?", keyId).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+// isUniqueViolation reports the unique_violation error of Postgres
+func isUniqueViolation(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == "23505"
+}
+
+func (r *serviceAccountRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model((*account.ServiceAccount)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate service_account table: ", err)
+		return err
+	}
+	_, err = r.db.NewCreateTable().Model((*account.APIKey)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate api_key table: ", err)
+		return err
+	}
+	_, err = r.db.NewCreateIndex().
+		Model((*account.APIKey)(nil)).
+		Index("api_key_service_account_idx").
+		Column("service_account_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate api_key table: ", err)
+		return err
+	}
+	return nil
+}
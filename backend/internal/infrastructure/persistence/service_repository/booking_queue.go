@@ -1,21 +1,54 @@
 package persistence
 
 import (
+	"backend/internal/domain/messaging"
 	"backend/internal/domain/patient"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
+	"backend/internal/infrastructure/redis"
 	"backend/pkg/common/pagination"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 )
 
+// ErrStaleFence means the booking was already written under a newer lock, the caller's
+// lock expired while it was working
+var ErrStaleFence = errors.New("booking was changed under a newer lock")
+
+// BookingLockName is the lock serializing the mutations of a booking. Writes made under
+// it record its fencing token in lock_fence and refuse older tokens
+func BookingLockName(queueId int) string {
+	return fmt.Sprintf("booking:%d", queueId)
+}
+
+// BookingEventsFunc builds the outbox events of a booking change, it runs inside the
+// transaction of that change
+type BookingEventsFunc func(bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error)
+
 type BookingQueuueRepository interface {
-	Create(ctx context.Context, bq *patient.BookingQueue) error
+	// Create returns false when a booking for the same payment session already exists
+	Create(ctx context.Context, bq *patient.BookingQueue, events BookingEventsFunc) (bool, error)
 	GetAllBookingQueues(ctx context.Context, pagination *pagination.Pagination) ([]*patient.BookingQueue, error)
+	// GetActiveBookingQueues returns every booking that is not completed, oldest first
+	GetActiveBookingQueues(ctx context.Context) ([]*patient.BookingQueue, error)
 	GetHistoryQueuesByPatientId(ctx context.Context, pagination *pagination.Pagination, patientId uuid.UUID) ([]*patient.BookingQueue, error)
 	GetDetailsBookingByQueueId(ctx context.Context, queueId int) (*patient.BookingQueue, error)
-	UpdateBookingStatus(ctx context.Context, queueId int, status string) error
+	GetBookingQueueById(ctx context.Context, queueId int) (*patient.BookingQueue, error)
+	// IsPatientOfDoctor tells whether the patient has a booking, active or past, assigned
+	// to the doctor
+	IsPatientOfDoctor(ctx context.Context, patientId, doctorId uuid.UUID) (bool, error)
+	// HasOpenBookingInFaculty tells whether the patient waits or is treated in the faculty
+	HasOpenBookingInFaculty(ctx context.Context, patientId uuid.UUID, facultyId byte) (bool, error)
+	// AssignDoctor records the doctor treating the booking, it is fenced like UpdateBookingStatus
+	AssignDoctor(ctx context.Context, queueId int, doctorId uuid.UUID, events BookingEventsFunc) error
+	// UpdateBookingStatus hands the booking as it was before the update to events. Under
+	// the booking lock it fails with ErrStaleFence when the lock has been taken over
+	UpdateBookingStatus(ctx context.Context, queueId int, status string, events BookingEventsFunc) error
 	DeleteBookingById(ctx context.Context, queueId int) error
 }
 
@@ -27,13 +60,77 @@ func NewBookingQueueRepository(db *bun.DB) BookingQueuueRepository {
 	return &bookingQueueRepository{db: db}
 }
 
-func (r *bookingQueueRepository) Create(ctx context.Context, bq *patient.BookingQueue) error {
-	_, err := r.db.NewInsert().Model(bq).Exec(ctx)
+// MigrateBookingQueue adds the booking queue columns, run it after the patient tables
+func MigrateBookingQueue(db *bun.DB) error {
+	return (&bookingQueueRepository{db: db}).migrate()
+}
+
+func (r *bookingQueueRepository) Create(ctx context.Context, bq *patient.BookingQueue, events BookingEventsFunc) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create transaction: %v", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// a redelivered booking message must not create a second booking
+	if bq.PaymentSessionId != "" {
+		exists, err := tx.NewSelect().
+			Model((*patient.BookingQueue)(nil)).
+			Where("payment_session_id = ?", bq.PaymentSessionId).
+			Exists(ctx)
+		if err != nil {
+			logrus.Errorf("Repository layer: %v", err)
+			return false, err
+		}
+		if exists {
+			logrus.Infof("Booking for payment session %s already exists", bq.PaymentSessionId)
+			return false, nil
+		}
+	}
+
+	if bq.FacultyId == 0 && bq.ServiceId != "" {
+		// the faculty of the service's subcategory, it decides which doctors see the booking
+		err = tx.NewSelect().
+			TableExpr("services AS s").
+			Join("JOIN service_subcategories AS sc ON sc.service_subcategory_id = s.service_subcategory_id").
+			ColumnExpr("COALESCE(sc.faculty_id, 0)").
+			Where("s.service_id = ?", bq.ServiceId).
+			Limit(1).
+			Scan(ctx, &bq.FacultyId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("Repository layer: %v", err)
+			return false, err
+		}
+	}
+
+	_, err = tx.NewInsert().Model(bq).Exec(ctx)
 	if err != nil {
 		logrus.Errorf("Repository layer: %v", err)
+		return false, err
+	}
+
+	if err := r.insertEvents(ctx, tx, bq, events); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.Errorf("Failed to commit transaction: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *bookingQueueRepository) insertEvents(ctx context.Context, tx bun.Tx, bq *patient.BookingQueue, events BookingEventsFunc) error {
+	if events == nil {
+		return nil
+	}
+	evs, err := events(bq)
+	if err != nil {
+		logrus.Errorf("Failed to build booking events: %v", err)
 		return err
 	}
-	return nil
+	return messagingrepository.InsertOutboxEvents(ctx, tx, evs...)
 }
 func (r *bookingQueueRepository) GetAllBookingQueues(ctx context.Context, pagination *pagination.Pagination) ([]*patient.BookingQueue, error) {
 	var bq []*patient.BookingQueue
@@ -55,6 +152,20 @@ func (r *bookingQueueRepository) GetAllBookingQueues(ctx context.Context, pagina
 	return bq, nil
 }
 
+func (r *bookingQueueRepository) GetActiveBookingQueues(ctx context.Context) ([]*patient.BookingQueue, error) {
+	var bq []*patient.BookingQueue
+	err := r.db.NewSelect().
+		Model(&bq).
+		Where("booking_status != ?", patient.BookingStatusCompleted).
+		Order("queue_id ASC").
+		Scan(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return bq, nil
+}
+
 func (r *bookingQueueRepository) GetHistoryQueuesByPatientId(ctx context.Context, pagination *pagination.Pagination, patientId uuid.UUID) ([]*patient.BookingQueue, error) {
 	var bq []*patient.BookingQueue
 	offset := pagination.GetOffSet()
@@ -86,10 +197,101 @@ func (r *bookingQueueRepository) GetDetailsBookingByQueueId(ctx context.Context,
 	return bq, nil
 }
 
-func (r *bookingQueueRepository) UpdateBookingStatus(ctx context.Context, queueId int, status string) error {
-	_, err := r.db.NewUpdate().Model((*patient.BookingQueue)(nil)).Set("booking_status = ?", status).Where("queue_id = ?", queueId).Exec(ctx)
+func (r *bookingQueueRepository) GetBookingQueueById(ctx context.Context, queueId int) (*patient.BookingQueue, error) {
+	bq := &patient.BookingQueue{}
+	err := r.db.NewSelect().Model(bq).Where("queue_id = ?", queueId).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("booking not found")
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return bq, nil
+}
+
+func (r *bookingQueueRepository) IsPatientOfDoctor(ctx context.Context, patientId, doctorId uuid.UUID) (bool, error) {
+	exists, err := r.db.NewSelect().
+		Model((*patient.BookingQueue)(nil)).
+		Where("patient_id = ?", patientId).
+		Where("doctor_id = ?", doctorId).
+		Exists(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *bookingQueueRepository) HasOpenBookingInFaculty(ctx context.Context, patientId uuid.UUID, facultyId byte) (bool, error) {
+	if facultyId == 0 {
+		return false, nil
+	}
+	exists, err := r.db.NewSelect().
+		Model((*patient.BookingQueue)(nil)).
+		Where("patient_id = ?", patientId).
+		Where("faculty_id = ?", facultyId).
+		Where("booking_status != ?", patient.BookingStatusCompleted).
+		Exists(ctx)
 	if err != nil {
 		logrus.Errorf("Repository layer: %v", err)
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *bookingQueueRepository) AssignDoctor(ctx context.Context, queueId int, doctorId uuid.UUID, events BookingEventsFunc) error {
+	return r.update(ctx, queueId, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("doctor_id = ?", doctorId)
+	}, events)
+}
+
+func (r *bookingQueueRepository) UpdateBookingStatus(ctx context.Context, queueId int, status string, events BookingEventsFunc) error {
+	return r.update(ctx, queueId, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("booking_status = ?", status)
+	}, events)
+}
+
+// update applies set to the locked row of the booking and stores the events of the
+// change in the same transaction. Under the booking lock it records the fencing token
+// and refuses to write over a newer one
+func (r *bookingQueueRepository) update(ctx context.Context, queueId int, set func(q *bun.UpdateQuery) *bun.UpdateQuery, events BookingEventsFunc) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	bq := &patient.BookingQueue{}
+	err = tx.NewSelect().Model(bq).Where("queue_id = ?", queueId).For("UPDATE").Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("booking not found")
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+
+	update := set(tx.NewUpdate().Model((*patient.BookingQueue)(nil)).Where("queue_id = ?", queueId))
+	if fence, ok := redis.FenceFromContext(ctx, BookingLockName(queueId)); ok {
+		if bq.LockFence > fence {
+			return fmt.Errorf("queue %d: %w", queueId, ErrStaleFence)
+		}
+		update = update.Set("lock_fence = ?", fence)
+	}
+	_, err = update.Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+
+	if err := r.insertEvents(ctx, tx, bq, events); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.Errorf("Failed to commit transaction: %v", err)
 		return err
 	}
 	return nil
@@ -104,6 +306,39 @@ func (r *bookingQueueRepository) DeleteBookingById(ctx context.Context, queueId
 	return nil
 }
 
+// migrate adds the columns introduced after booking_queue was first created,
+// the table itself is created by the patient repository
+func (r *bookingQueueRepository) migrate() error {
+	_, err := r.db.ExecContext(context.Background(),
+		`ALTER TABLE IF EXISTS booking_queue ADD COLUMN IF NOT EXISTS payment_session_id VARCHAR UNIQUE`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate booking_queue table: %v", err)
+		return err
+	}
+
+	_, err = r.db.ExecContext(context.Background(),
+		`ALTER TABLE IF EXISTS booking_queue ADD COLUMN IF NOT EXISTS faculty_id SMALLINT`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate booking_queue table: %v", err)
+		return err
+	}
+
+	_, err = r.db.ExecContext(context.Background(),
+		`ALTER TABLE IF EXISTS booking_queue ADD COLUMN IF NOT EXISTS lock_fence BIGINT`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate booking_queue table: %v", err)
+		return err
+	}
+
+	_, err = r.db.ExecContext(context.Background(),
+		`ALTER TABLE IF EXISTS booking_queue ADD COLUMN IF NOT EXISTS doctor_id UUID`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate booking_queue table: %v", err)
+		return err
+	}
+	return nil
+}
+
 // func (r *bookingQueueRepository) migrate() error {
 // 	ctx := context.Background()
 // 	_, err := r.db.NewCreateTable().
@@ -0,0 +1,95 @@
+package persistence
+
+import (
+	"backend/internal/domain/examination/service"
+	"backend/internal/infrastructure/cache"
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// The cached repositories put the catalog cache in front of the catalog repositories.
+// Writes go to Postgres first and then invalidate the whole catalog
+
+type cachedServiceCategoryRepository struct {
+	ServiceCategoryRepository
+	cache *cache.CatalogCache
+}
+
+func NewCachedServiceCategoryRepository(repo ServiceCategoryRepository, c *cache.CatalogCache) ServiceCategoryRepository {
+	return &cachedServiceCategoryRepository{ServiceCategoryRepository: repo, cache: c}
+}
+
+func (r *cachedServiceCategoryRepository) GetAllCategories(ctx context.Context) ([]*service.ServiceCategories, error) {
+	return cache.ReadThrough(ctx, r.cache, "categories", r.ServiceCategoryRepository.GetAllCategories)
+}
+
+func (r *cachedServiceCategoryRepository) GetCategoryById(ctx context.Context, categoryId uint16) (*service.ServiceCategories, error) {
+	return cache.ReadThrough(ctx, r.cache, fmt.Sprintf("category:%d", categoryId), func(ctx context.Context) (*service.ServiceCategories, error) {
+		return r.ServiceCategoryRepository.GetCategoryById(ctx, categoryId)
+	})
+}
+
+type cachedServiceSubCategoryRepository struct {
+	ServiceSubCategory
+	cache *cache.CatalogCache
+}
+
+func NewCachedServiceSubCategoryRepository(repo ServiceSubCategory, c *cache.CatalogCache) ServiceSubCategory {
+	return &cachedServiceSubCategoryRepository{ServiceSubCategory: repo, cache: c}
+}
+
+func (r *cachedServiceSubCategoryRepository) GetSubCategoryById(ctx context.Context, subCategoryId uint16) (*service.ServiceSubCategories, error) {
+	return cache.ReadThrough(ctx, r.cache, fmt.Sprintf("subcategory:%d", subCategoryId), func(ctx context.Context) (*service.ServiceSubCategories, error) {
+		return r.ServiceSubCategory.GetSubCategoryById(ctx, subCategoryId)
+	})
+}
+
+func (r *cachedServiceSubCategoryRepository) GetAllSubCategoriesByCategoryId(ctx context.Context, categoryId uint16) ([]*service.ServiceSubCategories, error) {
+	return cache.ReadThrough(ctx, r.cache, fmt.Sprintf("category:%d:subcategories", categoryId), func(ctx context.Context) ([]*service.ServiceSubCategories, error) {
+		return r.ServiceSubCategory.GetAllSubCategoriesByCategoryId(ctx, categoryId)
+	})
+}
+
+type cachedServiceRepository struct {
+	ServiceRepository
+	cache *cache.CatalogCache
+}
+
+func NewCachedServiceRepository(repo ServiceRepository, c *cache.CatalogCache) ServiceRepository {
+	return &cachedServiceRepository{ServiceRepository: repo, cache: c}
+}
+
+func (r *cachedServiceRepository) GetServiceListBySubcategoryId(ctx context.Context, subcategoryId uint16) ([]*service.Services, error) {
+	return cache.ReadThrough(ctx, r.cache, fmt.Sprintf("subcategory:%d:services", subcategoryId), func(ctx context.Context) ([]*service.Services, error) {
+		return r.ServiceRepository.GetServiceListBySubcategoryId(ctx, subcategoryId)
+	})
+}
+
+func (r *cachedServiceRepository) GetServiceByServiceId(ctx context.Context, serviceId uuid.UUID) (*service.Services, error) {
+	return cache.ReadThrough(ctx, r.cache, "service:"+serviceId.String(), func(ctx context.Context) (*service.Services, error) {
+		return r.ServiceRepository.GetServiceByServiceId(ctx, serviceId)
+	})
+}
+
+func (r *cachedServiceRepository) CreateService(ctx context.Context, serviceModel *service.Services) error {
+	if err := r.ServiceRepository.CreateService(ctx, serviceModel); err != nil {
+		return err
+	}
+	return r.cache.Invalidate(ctx)
+}
+
+func (r *cachedServiceRepository) UpdateService(ctx context.Context, serviceId uuid.UUID, serviceModel *service.Services) error {
+	if err := r.ServiceRepository.UpdateService(ctx, serviceId, serviceModel); err != nil {
+		return err
+	}
+	return r.cache.Invalidate(ctx)
+}
+
+func (r *cachedServiceRepository) DeleteService(ctx context.Context, serviceId uuid.UUID) error {
+	if err := r.ServiceRepository.DeleteService(ctx, serviceId); err != nil {
+		return err
+	}
+	return r.cache.Invalidate(ctx)
+}
@@ -21,9 +21,12 @@ type serviceCategoryRepository struct {
 }
 
 func NewServiceCategoryRepository(db *bun.DB) ServiceCategoryRepository {
-	repo := &serviceCategoryRepository{db: db}
-	_ = repo.migrate()
-	return repo
+	return &serviceCategoryRepository{db: db}
+}
+
+// MigrateServiceCategory creates the service category table
+func MigrateServiceCategory(db *bun.DB) error {
+	return (&serviceCategoryRepository{db: db}).migrate()
 }
 
 func (r *serviceCategoryRepository) SeedCategory(ctx context.Context) error {
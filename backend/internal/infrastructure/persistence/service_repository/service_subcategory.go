@@ -21,9 +21,12 @@ type serviceSubCategoryRepository struct {
 }
 
 func NewServiceSubCategoryRepository(db *bun.DB) ServiceSubCategory {
-	repo := &serviceSubCategoryRepository{db: db}
-	_ = repo.migrate()
-	return repo
+	return &serviceSubCategoryRepository{db: db}
+}
+
+// MigrateServiceSubCategory creates the service subcategory table
+func MigrateServiceSubCategory(db *bun.DB) error {
+	return (&serviceSubCategoryRepository{db: db}).migrate()
 }
 
 func (r *serviceSubCategoryRepository) SeedSubcategory(ctx context.Context) error {
@@ -31,51 +34,61 @@ func (r *serviceSubCategoryRepository) SeedSubcategory(ctx context.Context) erro
 		{
 			ServiceSubCategoryId: 0,
 			ServiceCategoryId:    1,
+			FacultyId:            1,
 		},
 		{
 			ServiceSubCategoryId: 1,
 			Name:                 "Nội khoa",
 			ServiceCategoryId:    2,
+			FacultyId:            1,
 		},
 		{
 			ServiceSubCategoryId: 2,
 			Name:                 "Ngoại khoa",
 			ServiceCategoryId:    2,
+			FacultyId:            2,
 		},
 		{
 			ServiceSubCategoryId: 3,
 			Name:                 "Sản - Phụ khoa",
 			ServiceCategoryId:    2,
+			FacultyId:            3,
 		},
 		{
 			ServiceSubCategoryId: 4,
 			Name:                 "Nhi khoa",
 			ServiceCategoryId:    2,
+			FacultyId:            4,
 		},
 		{
 			ServiceSubCategoryId: 5,
 			Name:                 "Tai - Mũi - Họng",
 			ServiceCategoryId:    2,
+			FacultyId:            5,
 		},
 		{
 			ServiceSubCategoryId: 6,
 			Name:                 "Da liễu",
 			ServiceCategoryId:    2,
+			FacultyId:            6,
 		},
 		{
 			ServiceSubCategoryId: 7,
 			Name:                 "Xét nghiệm",
 			ServiceCategoryId:    3,
+			FacultyId:            7,
 		},
 		{
 			ServiceSubCategoryId: 8,
 			Name:                 "Chẩn đoán hình ảnh",
 			ServiceCategoryId:    3,
+			FacultyId:            7,
 		},
 		{
 			ServiceSubCategoryId: 9,
 			Name:                 "Tiêm chủng",
 			ServiceCategoryId:    4,
+			FacultyId:            8,
 		},
 	}
 
@@ -127,5 +140,24 @@ func (r *serviceSubCategoryRepository) migrate() error {
 	if err != nil {
 		return err
 	}
+
+	_, err = r.db.ExecContext(ctx,
+		`ALTER TABLE IF EXISTS service_subcategories ADD COLUMN IF NOT EXISTS faculty_id SMALLINT`)
+	if err != nil {
+		logrus.Errorf("Failed to migrate service_subcategories table: %v", err)
+		return err
+	}
+
+	// subcategories seeded before faculty_id existed
+	_, err = r.db.ExecContext(ctx, `UPDATE service_subcategories SET faculty_id = CASE
+		WHEN service_subcategory_id BETWEEN 1 AND 6 THEN service_subcategory_id
+		WHEN service_subcategory_id IN (7, 8) THEN 7
+		WHEN service_subcategory_id = 9 THEN 8
+		ELSE 1 END
+		WHERE faculty_id IS NULL AND service_subcategory_id BETWEEN 0 AND 9`)
+	if err != nil {
+		logrus.Errorf("Failed to backfill service_subcategories faculty: %v", err)
+		return err
+	}
 	return nil
 }
@@ -28,9 +28,12 @@ type serviceRepository struct {
 }
 
 func NewServiceRepository(db *bun.DB) ServiceRepository {
-	repo := &serviceRepository{db: db}
-	_ = repo.migrate()
-	return repo
+	return &serviceRepository{db: db}
+}
+
+// MigrateService creates the services table
+func MigrateService(db *bun.DB) error {
+	return (&serviceRepository{db: db}).migrate()
 }
 
 func (r *serviceRepository) BuildServiceModelForCreate(d *dtoservice.CreateServiceRequest) *service.Services {
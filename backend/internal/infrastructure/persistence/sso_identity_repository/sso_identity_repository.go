@@ -0,0 +1,105 @@
+package ssoidentityrepository
+
+import (
+	"backend/internal/domain/account"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity is not linked")
+	// ErrIdentityConflict refuses a second identity for an account already linked
+	ErrIdentityConflict = errors.New("account is already linked to another identity")
+)
+
+type SSOIdentityRepository interface {
+	Get(ctx context.Context, issuer, subject string) (*account.SSOIdentity, error)
+	// Link stores a new identity, an account has one identity at most
+	Link(ctx context.Context, identity *account.SSOIdentity) error
+	RecordLogin(ctx context.Context, issuer, subject string) error
+}
+
+type ssoIdentityRepository struct {
+	db *bun.DB
+}
+
+func NewSSOIdentityRepository(db *bun.DB) SSOIdentityRepository {
+	return &ssoIdentityRepository{db: db}
+}
+
+// Migrate creates the SSO identity table
+func Migrate(db *bun.DB) error {
+	return (&ssoIdentityRepository{db: db}).migrate()
+}
+
+func (r *ssoIdentityRepository) Get(ctx context.Context, issuer, subject string) (*account.SSOIdentity, error) {
+	identity := &account.SSOIdentity{}
+	err := r.db.NewSelect().
+		Model(identity).
+		Where("issuer = ?", issuer).
+		Where("subject = ?", subject).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdentityNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *ssoIdentityRepository) Link(ctx context.Context, identity *account.SSOIdentity) error {
+	identity.CreatedAt = time.Now()
+	_, err := r.db.NewInsert().Model(identity).Exec(ctx)
+	if err != nil {
+		var pgErr pgdriver.Error
+		if errors.As(err, &pgErr) && pgErr.Field('C') == "23505" {
+			return ErrIdentityConflict
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *ssoIdentityRepository) RecordLogin(ctx context.Context, issuer, subject string) error {
+	_, err := r.db.NewUpdate().
+		Model((*account.SSOIdentity)(nil)).
+		Set("last_login_at = current_timestamp").
+		Where("issuer = ?", issuer).
+		Where("subject = ?", subject).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *ssoIdentityRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model((*account.SSOIdentity)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate sso_identity table: ", err)
+		return err
+	}
+	_, err = r.db.NewCreateIndex().
+		Model((*account.SSOIdentity)(nil)).
+		Index("sso_identity_account_key").
+		Unique().
+		Column("role", "user_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate sso_identity table: ", err)
+		return err
+	}
+	return nil
+}
@@ -2,15 +2,41 @@ package staffrepository
 
 import (
 	staff "backend/internal/domain/staff/admin"
+	"backend/pkg/common/pagination"
 	"context"
-	"os"
+	"database/sql"
+	"errors"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+var (
+	ErrAdminNotFound = errors.New("admin not found")
+	ErrAdminExists   = errors.New("username is already taken")
+	// ErrLastSuperAdmin refuses a change that would leave no active super admin to
+	// manage the admins
+	ErrLastSuperAdmin = errors.New("at least one active super admin is required")
+)
+
+// superAdminLock serializes the changes that could remove the last super admin
+const superAdminLock = 0x61646d6e
+
 type AdminRepository interface {
 	GetByUsername(ctx context.Context, username string) (*staff.Admin, error)
+	GetById(ctx context.Context, adminId uuid.UUID) (*staff.Admin, error)
+	List(ctx context.Context, pagination *pagination.Pagination) ([]*staff.Admin, error)
+	Count(ctx context.Context) (int, error)
+	CountActiveSuperAdmins(ctx context.Context) (int, error)
+	Create(ctx context.Context, admin *staff.Admin) error
+	// Update saves the username, role and disabled state of the admin
+	Update(ctx context.Context, admin *staff.Admin) error
+	UpdatePassword(ctx context.Context, adminId uuid.UUID, hashed []byte) error
+	RecordLogin(ctx context.Context, adminId uuid.UUID, ip string) error
+	Delete(ctx context.Context, adminId uuid.UUID) error
 }
 
 type adminRepository struct {
@@ -18,38 +44,209 @@ type adminRepository struct {
 }
 
 func NewAdminRepository(db *bun.DB) AdminRepository {
-	repo := &adminRepository{db: db}
-	_ = repo.migrate()
-	return repo
+	return &adminRepository{db: db}
+}
+
+// MigrateAdmin creates the admin table
+func MigrateAdmin(db *bun.DB) error {
+	return (&adminRepository{db: db}).migrate()
 }
 
 func (r *adminRepository) GetByUsername(ctx context.Context, username string) (*staff.Admin, error) {
 	admin := &staff.Admin{}
 	err := r.db.NewSelect().Model(admin).Where("username = ?", username).Scan(ctx)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAdminNotFound
+		}
 		logrus.Errorf("Repository layer: %+v", err)
-		return nil ,err
+		return nil, err
 	}
 	return admin, nil
 }
 
-func (r *adminRepository) migrate() error {
-	_, err := r.db.NewCreateTable().Model(&staff.Admin{}).Exec(context.Background())
+func (r *adminRepository) GetById(ctx context.Context, adminId uuid.UUID) (*staff.Admin, error) {
+	admin := &staff.Admin{}
+	err := r.db.NewSelect().Model(admin).Where("admin_id = ?", adminId).Scan(ctx)
 	if err != nil {
-		logrus.Error("Failed to migrate admin table: ", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAdminNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return admin, nil
+}
+
+func (r *adminRepository) List(ctx context.Context, pagination *pagination.Pagination) ([]*staff.Admin, error) {
+	var admins []*staff.Admin
+	total, err := r.db.NewSelect().
+		Model(&admins).
+		Order("created_at ASC").
+		Limit(pagination.GetLimit()).
+		Offset(pagination.GetOffSet()).
+		ScanAndCount(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	pagination.Total = int64(total)
+	return admins, nil
+}
+
+func (r *adminRepository) Count(ctx context.Context) (int, error) {
+	n, err := r.db.NewSelect().Model((*staff.Admin)(nil)).Count(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *adminRepository) CountActiveSuperAdmins(ctx context.Context) (int, error) {
+	n, err := countActiveSuperAdmins(ctx, r.db)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+func countActiveSuperAdmins(ctx context.Context, db bun.IDB) (int, error) {
+	return db.NewSelect().
+		Model((*staff.Admin)(nil)).
+		Where("role = ?", staff.RoleSuperAdmin).
+		Where("NOT disabled").
+		Count(ctx)
+}
+
+func (r *adminRepository) Create(ctx context.Context, admin *staff.Admin) error {
+	now := time.Now()
+	admin.CreatedAt, admin.UpdatedAt = now, now
+	_, err := r.db.NewInsert().Model(admin).Exec(ctx)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAdminExists
+		}
+		logrus.Errorf("Repository layer: %v", err)
 		return err
 	}
+	return nil
+}
+
+func (r *adminRepository) Update(ctx context.Context, admin *staff.Admin) error {
+	admin.UpdatedAt = time.Now()
+	return r.guarded(ctx, func(tx bun.Tx) (sql.Result, error) {
+		return tx.NewUpdate().
+			Model(admin).
+			Column("username", "role", "disabled", "disabled_at", "updated_at").
+			WherePK().
+			Exec(ctx)
+	})
+}
 
-	password := os.Getenv("ADMIN_PASSWORD")
-	logrus.Info(password)
-	admin := &staff.Admin{
-		Username: "admin",
-		Password: password,
+func (r *adminRepository) Delete(ctx context.Context, adminId uuid.UUID) error {
+	return r.guarded(ctx, func(tx bun.Tx) (sql.Result, error) {
+		return tx.NewDelete().Model((*staff.Admin)(nil)).Where("admin_id = ?", adminId).Exec(ctx)
+	})
+}
+
+// guarded runs change in a transaction that is rolled back when it leaves no active
+// super admin
+func (r *adminRepository) guarded(ctx context.Context, change func(tx bun.Tx) (sql.Result, error)) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", superAdminLock); err != nil {
+			return err
+		}
+		res, err := change(tx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrAdminNotFound
+		}
+		left, err := countActiveSuperAdmins(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if left == 0 {
+			return ErrLastSuperAdmin
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrAdminNotFound), errors.Is(err, ErrLastSuperAdmin):
+		return err
+	case isUniqueViolation(err):
+		return ErrAdminExists
 	}
-	_, err = r.db.NewInsert().Model(admin).Exec(context.Background())
+	logrus.Errorf("Repository layer: %v", err)
+	return err
+}
+
+func (r *adminRepository) UpdatePassword(ctx context.Context, adminId uuid.UUID, hashed []byte) error {
+	res, err := r.db.NewUpdate().
+		Model((*staff.Admin)(nil)).
+		Set("password = ?", string(hashed)).
+		Set("updated_at = current_timestamp").
+		Where("admin_id = ?", adminId).
+		Exec(ctx)
 	if err != nil {
-		logrus.Errorf("Repository layer: %+v: ",err)
+		logrus.Errorf("Repository layer: %v", err)
 		return err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAdminNotFound
+	}
+	return nil
+}
+
+func (r *adminRepository) RecordLogin(ctx context.Context, adminId uuid.UUID, ip string) error {
+	_, err := r.db.NewUpdate().
+		Model((*staff.Admin)(nil)).
+		Set("last_login_at = current_timestamp").
+		Set("last_login_ip = ?", ip).
+		Where("admin_id = ?", adminId).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+// isUniqueViolation reports the unique_violation error of Postgres
+func isUniqueViolation(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == "23505"
+}
+
+// migrate creates the admin table and adds the columns introduced after it was first
+// created. The first admin is created by AdminUsecase.Bootstrap
+func (r *adminRepository) migrate() error {
+	ctx := context.Background()
+	_, err := r.db.NewCreateTable().Model((*staff.Admin)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		logrus.Error("Failed to migrate admin table: ", err)
+		return err
+	}
+
+	for _, stmt := range []string{
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS role VARCHAR NOT NULL DEFAULT 'admin'`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS last_login_ip VARCHAR`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp`,
+		`ALTER TABLE IF EXISTS admin ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS admin_username_key ON admin (username)`,
+	} {
+		if _, err := r.db.ExecContext(ctx, stmt); err != nil {
+			logrus.Errorf("Failed to migrate admin table: %v", err)
+			return err
+		}
+	}
 	return nil
 }
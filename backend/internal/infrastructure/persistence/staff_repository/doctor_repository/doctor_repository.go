@@ -30,9 +30,12 @@ type doctorRepo struct {
 }
 
 func NewDoctorRepo(db *bun.DB) DoctorRepository {
-	repo := &doctorRepo{db: db}
-	_ = repo.migrate()
-	return repo
+	return &doctorRepo{db: db}
+}
+
+// Migrate creates the doctor table
+func Migrate(db *bun.DB) error {
+	return (&doctorRepo{db: db}).migrate()
 }
 
 func (r *doctorRepo) migrate() error {
@@ -2,15 +2,23 @@ package doctorrepository
 
 import (
 	"backend/internal/domain/dto/dtodoctor"
+	"backend/internal/domain/messaging"
 	"backend/internal/domain/patient"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
 	"context"
+	"database/sql"
+	"errors"
 
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 )
 
+// ReceiptEventsFunc builds the outbox events of a new receipt from the receipt and
+// the booking it belongs to, it runs inside the transaction of the insert
+type ReceiptEventsFunc func(dr *patient.DrugReceipt, bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error)
+
 type DrugReceiptRepository interface {
-	CreateDrugReceipt(ctx context.Context, dr *patient.DrugReceipt) error
+	CreateDrugReceipt(ctx context.Context, dr *patient.DrugReceipt, events ReceiptEventsFunc) error
 	BuildModelForRequest(dto *dtodoctor.CreateDrugReceiptRequest) *patient.DrugReceipt
 }
 
@@ -33,12 +41,45 @@ func (r *drugReceiptRepository) BuildModelForRequest(dto *dtodoctor.CreateDrugRe
 	return req
 }
 
-func (r *drugReceiptRepository) CreateDrugReceipt(ctx context.Context, dr *patient.DrugReceipt) error {
-	_, err := r.db.NewInsert().Model(dr).Exec(ctx)
+func (r *drugReceiptRepository) CreateDrugReceipt(ctx context.Context, dr *patient.DrugReceipt, events ReceiptEventsFunc) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	bq := &patient.BookingQueue{}
+	err = tx.NewSelect().Model(bq).Where("queue_id = ?", dr.QueueId).Scan(ctx)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("booking not found")
+		}
 		logrus.Errorf("Repository layer %+v", err)
 		return err
 	}
+
+	_, err = tx.NewInsert().Model(dr).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer %+v", err)
+		return err
+	}
+
+	if events != nil {
+		evs, err := events(dr, bq)
+		if err != nil {
+			logrus.Errorf("Failed to build receipt events: %v", err)
+			return err
+		}
+		if err := messagingrepository.InsertOutboxEvents(ctx, tx, evs...); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.Errorf("Failed to commit transaction: %v", err)
+		return err
+	}
 	return nil
 }
 
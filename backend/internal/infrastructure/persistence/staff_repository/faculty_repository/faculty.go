@@ -17,9 +17,12 @@ type facultyRepository struct {
 }
 
 func NewFacultyRepository(db *bun.DB) FacultyRepository {
-	repo := &facultyRepository{db: db}
-	_ = repo.migrate()
-	return repo
+	return &facultyRepository{db: db}
+}
+
+// MigrateFaculty creates the faculty table
+func MigrateFaculty(db *bun.DB) error {
+	return (&facultyRepository{db: db}).migrate()
 }
 
 func (r *facultyRepository) SeedFaculty(ctx context.Context) error {
@@ -30,9 +30,12 @@ type nurseRepo struct {
 }
 
 func NewNurseRepo(db *bun.DB) NurseRepository {
-	repo := &nurseRepo{db: db}
-	_ = repo.migrate()
-	return repo
+	return &nurseRepo{db: db}
+}
+
+// Migrate creates the nurse table
+func Migrate(db *bun.DB) error {
+	return (&nurseRepo{db: db}).migrate()
 }
 
 func (r *nurseRepo) migrate() error {
@@ -0,0 +1,107 @@
+package twofactorrepository
+
+import (
+	"backend/internal/domain/account"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+var ErrTwoFactorNotFound = errors.New("two-factor authentication is not set up")
+
+type TwoFactorRepository interface {
+	Get(ctx context.Context, userId uuid.UUID) (*account.TwoFactor, error)
+	// Save creates or replaces the second factor of the user
+	Save(ctx context.Context, tf *account.TwoFactor) error
+	// UseRecoveryCode removes a spent recovery code, false when another request spent it
+	// first
+	UseRecoveryCode(ctx context.Context, userId uuid.UUID, hash string) (bool, error)
+	Delete(ctx context.Context, userId uuid.UUID) error
+}
+
+type twoFactorRepository struct {
+	db *bun.DB
+}
+
+func NewTwoFactorRepository(db *bun.DB) TwoFactorRepository {
+	return &twoFactorRepository{db: db}
+}
+
+// Migrate creates the two-factor table
+func Migrate(db *bun.DB) error {
+	return (&twoFactorRepository{db: db}).migrate()
+}
+
+func (r *twoFactorRepository) Get(ctx context.Context, userId uuid.UUID) (*account.TwoFactor, error) {
+	tf := &account.TwoFactor{}
+	err := r.db.NewSelect().Model(tf).Where("user_id = ?", userId).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTwoFactorNotFound
+		}
+		logrus.Errorf("Repository layer: %v", err)
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (r *twoFactorRepository) Save(ctx context.Context, tf *account.TwoFactor) error {
+	tf.UpdatedAt = time.Now()
+	_, err := r.db.NewInsert().
+		Model(tf).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("role = EXCLUDED.role").
+		Set("secret = EXCLUDED.secret").
+		Set("enabled = EXCLUDED.enabled").
+		Set("recovery_codes = EXCLUDED.recovery_codes").
+		Set("enabled_at = EXCLUDED.enabled_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userId uuid.UUID, hash string) (bool, error) {
+	res, err := r.db.NewUpdate().
+		Model((*account.TwoFactor)(nil)).
+		Set("recovery_codes = recovery_codes - ?", hash).
+		Set("updated_at = current_timestamp").
+		Where("user_id = ?", userId).
+		Where("recovery_codes @> jsonb_build_array(?::text)", hash).
+		Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *twoFactorRepository) Delete(ctx context.Context, userId uuid.UUID) error {
+	_, err := r.db.NewDelete().Model((*account.TwoFactor)(nil)).Where("user_id = ?", userId).Exec(ctx)
+	if err != nil {
+		logrus.Errorf("Repository layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) migrate() error {
+	_, err := r.db.NewCreateTable().Model((*account.TwoFactor)(nil)).IfNotExists().Exec(context.Background())
+	if err != nil {
+		logrus.Error("Failed to migrate two_factor table: ", err)
+		return err
+	}
+	return nil
+}
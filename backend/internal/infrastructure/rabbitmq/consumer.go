@@ -0,0 +1,292 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// declareRetryTopology declares the retry queues of queueName and the shared
+// dead-letter exchange/queue. Each retry queue holds messages for its TTL and then
+// dead-letters them back into queueName through the default exchange.
+func declareRetryTopology(conn RabbitMQConnection, policy RetryPolicy, ch *amqp.Channel, queueName string) error {
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		args := amqp.Table{
+			"x-message-ttl":             policy.Delay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		}
+		if _, err := conn.QueueDeclareWithArgs(ch, RetryQueueName(queueName, attempt), args); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.ExchangeDeclare(ch, DeadLetterExchange, amqp.ExchangeFanout, true); err != nil {
+		return err
+	}
+	if _, err := conn.QueueDeclare(ch, DeadLetterQueue); err != nil {
+		return err
+	}
+	return conn.QueueBind(ch, DeadLetterQueue, DeadLetterExchange, "")
+}
+
+// HeaderAggregateId is set by the outbox relay, consumers use it to keep the events
+// of one aggregate in order
+const HeaderAggregateId = "x-aggregate-id"
+
+const defaultWorkers = 1
+
+// ConsumerConfig holds the connection-wide defaults of ConsumeOptions
+type ConsumerConfig struct {
+	Workers  int
+	Prefetch int
+}
+
+func (o ConsumeOptions) withDefaults(cfg ConsumerConfig) ConsumeOptions {
+	if o.Workers <= 0 {
+		o.Workers = cfg.Workers
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaultWorkers
+	}
+	if o.Prefetch <= 0 {
+		o.Prefetch = cfg.Prefetch
+	}
+	if o.Prefetch < o.Workers {
+		o.Prefetch = o.Workers
+	}
+	return o
+}
+
+// PartitionByHeader partitions deliveries on the value of a string header
+func PartitionByHeader(name string) func(amqp.Delivery) string {
+	return func(d amqp.Delivery) string {
+		v, _ := d.Headers[name].(string)
+		return v
+	}
+}
+
+var errDraining = errors.New("connection is draining, no new consumers accepted")
+
+// consumerGroup tracks the consumers of a connection so they can be drained on shutdown
+type consumerGroup struct {
+	mu        sync.Mutex
+	seq       int
+	consumers []*consumer
+	draining  bool
+}
+
+type consumer struct {
+	queueName string
+	ch        *amqp.Channel
+	// cancel asks the broker to stop sending deliveries, unacked ones stay with the channel
+	cancel   func()
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (g *consumerGroup) nextTag(queueName string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.seq++
+	return fmt.Sprintf("%s-%d", queueName, g.seq)
+}
+
+// run hands every delivery of msgs to handler on opts.Workers goroutines until ctx
+// is cancelled, the group is drained or msgs is closed. Successes are acked and
+// failures routed with handleFailure.
+func (g *consumerGroup) run(ctx context.Context, conn RabbitMQConnection, ch *amqp.Channel, queueName string, msgs <-chan amqp.Delivery, handler DeliveryHandler, opts ConsumeOptions, cancel func()) error {
+	c := &consumer{
+		queueName: queueName,
+		ch:        ch,
+		cancel:    cancel,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	g.mu.Lock()
+	if g.draining {
+		g.mu.Unlock()
+		cancel()
+		return errDraining
+	}
+	g.consumers = append(g.consumers, c)
+	g.mu.Unlock()
+
+	// one shared input unless deliveries are partitioned, then one input per worker
+	inputs := make([]chan amqp.Delivery, 1)
+	if opts.PartitionKey != nil {
+		inputs = make([]chan amqp.Delivery, opts.Workers)
+	}
+	for i := range inputs {
+		inputs[i] = make(chan amqp.Delivery)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		in := inputs[i%len(inputs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range in {
+				processDelivery(ctx, conn, ch, queueName, msg, handler, opts)
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			for _, in := range inputs {
+				close(in)
+			}
+			wg.Wait()
+			close(c.done)
+		}()
+
+		next := 0
+		for {
+			select {
+			case <-ctx.Done():
+				logrus.Infof("Context cancelled, stopping consumer for queue %s", queueName)
+				return
+			case <-c.stop:
+				logrus.Infof("Draining consumer for queue %s", queueName)
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					logrus.Warnf("Message channel closed for queue %s", queueName)
+					return
+				}
+
+				in := inputs[0]
+				if opts.PartitionKey != nil {
+					key := opts.PartitionKey(msg)
+					if key == "" {
+						in = inputs[next%len(inputs)]
+						next++
+					} else {
+						h := fnv.New32a()
+						_, _ = h.Write([]byte(key))
+						in = inputs[int(h.Sum32()%uint32(len(inputs)))]
+					}
+				}
+
+				// a delivery not handed to a worker stays unacked and is
+				// requeued by the broker when the channel closes
+				select {
+				case in <- msg:
+				case <-ctx.Done():
+					return
+				case <-c.stop:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// drain stops every consumer, waits for in-flight handlers until ctx is done and
+// then closes the channels, which requeues whatever was prefetched but not handled
+func (g *consumerGroup) drain(ctx context.Context, conn RabbitMQConnection) error {
+	g.mu.Lock()
+	g.draining = true
+	consumers := make([]*consumer, len(g.consumers))
+	copy(consumers, g.consumers)
+	g.consumers = nil
+	g.mu.Unlock()
+
+	for _, c := range consumers {
+		c.cancel()
+		c.stopOnce.Do(func() { close(c.stop) })
+	}
+
+	busy := 0
+	for _, c := range consumers {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			select {
+			case <-c.done:
+			default:
+				busy++
+				logrus.Warnf("Consumer for queue %s still busy at drain deadline", c.queueName)
+			}
+		}
+	}
+
+	closed := map[*amqp.Channel]bool{}
+	for _, c := range consumers {
+		if c.ch == nil || closed[c.ch] {
+			continue
+		}
+		closed[c.ch] = true
+		if err := conn.CloseChannel(c.ch); err != nil {
+			logrus.Warnf("Failed to close channel of queue %s: %v", c.queueName, err)
+		}
+	}
+
+	if busy > 0 {
+		return fmt.Errorf("drain deadline exceeded with %d consumer(s) still busy", busy)
+	}
+	logrus.Infof("Drained %d consumer(s)", len(consumers))
+	return nil
+}
+
+func processDelivery(ctx context.Context, conn RabbitMQConnection, ch *amqp.Channel, queueName string, msg amqp.Delivery, handler DeliveryHandler, opts ConsumeOptions) {
+	logrus.Infof("Received message from queue %s", queueName)
+
+	if err := handler(msg); err != nil {
+		logrus.Errorf("Failed to process message from queue %s: %v", queueName, err)
+		handleFailure(ctx, conn, ch, queueName, msg, err, opts)
+		return
+	}
+
+	if err := msg.Ack(false); err != nil {
+		logrus.Errorf("Failed to ack message from queue %s: %v", queueName, err)
+		return
+	}
+	logrus.Infof("Successfully processed message from queue %s", queueName)
+}
+
+// handleFailure either parks the delivery in a retry queue / the dead-letter exchange
+// or, without a retry policy, requeues it after a short pause
+func handleFailure(ctx context.Context, conn RabbitMQConnection, ch *amqp.Channel, queueName string, msg amqp.Delivery, cause error, opts ConsumeOptions) {
+	if opts.Retry == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(requeueDelay):
+		}
+		if err := msg.Nack(false, true); err != nil {
+			logrus.Errorf("Failed to requeue message from queue %s: %v", queueName, err)
+		}
+		return
+	}
+
+	exchange, routingKey, retryMsg := opts.Retry.failureRoute(queueName, msg, cause)
+	// the original is acked only once the broker confirmed its copy
+	if err := conn.PublishConfirmed(ctx, ch, exchange, routingKey, retryMsg); err != nil {
+		// keep the message in the broker rather than losing it
+		if nackErr := msg.Nack(false, true); nackErr != nil {
+			logrus.Errorf("Failed to requeue message from queue %s: %v", queueName, nackErr)
+		}
+		return
+	}
+
+	if exchange == DeadLetterExchange {
+		logrus.Warnf("Message from queue %s dead-lettered after %d attempt(s): %v", queueName, AttemptOf(retryMsg.Headers), cause)
+	} else {
+		logrus.Infof("Message from queue %s scheduled for retry via %s", queueName, routingKey)
+	}
+
+	if err := msg.Ack(false); err != nil {
+		logrus.Errorf("Failed to ack message from queue %s: %v", queueName, err)
+	}
+}
@@ -0,0 +1,70 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EnvelopeVersion is bumped on breaking changes of the envelope or of a payload,
+// consumers reject versions they do not know
+const EnvelopeVersion = 1
+
+// Envelope wraps every event published on the events exchange
+type Envelope struct {
+	Version       int             `json:"version"`
+	EventId       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	CorrelationId string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps payload, the correlation id ties together the events of one
+// business flow (e.g. a payment session and the booking it creates)
+func NewEnvelope(eventType, correlationId string, payload interface{}) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+
+	eventId := uuid.NewString()
+	if correlationId == "" {
+		correlationId = eventId
+	}
+
+	return &Envelope{
+		Version:       EnvelopeVersion,
+		EventId:       eventId,
+		Type:          eventType,
+		OccurredAt:    time.Now().UTC(),
+		CorrelationId: correlationId,
+		Payload:       body,
+	}, nil
+}
+
+// DecodeEnvelope parses a message body, malformed or unknown versions are permanent errors
+func DecodeEnvelope(body []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, Permanent(fmt.Errorf("failed to unmarshal envelope: %w", err))
+	}
+	if env.Type == "" {
+		return nil, Permanent(errors.New("envelope has no type"))
+	}
+	if env.Version < 1 || env.Version > EnvelopeVersion {
+		return nil, Permanent(fmt.Errorf("unsupported envelope version %d", env.Version))
+	}
+	return &env, nil
+}
+
+// Decode unmarshals the payload into v, typically one of the event payload structs
+func (e *Envelope) Decode(v interface{}) error {
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal %s payload: %w", e.Type, err))
+	}
+	return nil
+}
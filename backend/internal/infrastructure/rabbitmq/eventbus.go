@@ -0,0 +1,122 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// EventHandler processes one decoded event. Returning an error retries the delivery
+// through the subscriber's retry queues, Permanent errors dead-letter it right away.
+type EventHandler func(ctx context.Context, env *Envelope) error
+
+// EventBus publishes domain events on the events exchange and lets independent
+// subscribers (notifications, analytics, queue cache...) consume them by routing key
+type EventBus interface {
+	Publish(ctx context.Context, env *Envelope) error
+	// Subscribe declares a durable queue for subscriber bound to routingKeys
+	// (topic patterns such as "booking.*" or "#") and consumes it until ctx is cancelled
+	Subscribe(ctx context.Context, subscriber string, routingKeys []string, handler EventHandler) error
+}
+
+type eventBus struct {
+	conn RabbitMQConnection
+
+	mu sync.Mutex
+	ch *amqp.Channel
+}
+
+func NewEventBus(conn RabbitMQConnection) EventBus {
+	return &eventBus{conn: conn}
+}
+
+// SubscriberQueueName is the queue owned by one subscriber of the events exchange
+func SubscriberQueueName(subscriber string) string {
+	return fmt.Sprintf("%s.%s", EventsExchange, subscriber)
+}
+
+// openChannel opens a channel on the shared connection and declares the events exchange
+func (b *eventBus) openChannel() (*amqp.Channel, error) {
+	conn, err := b.conn.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := b.conn.GetChannel(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.conn.ExchangeDeclare(ch, EventsExchange, amqp.ExchangeTopic, true); err != nil {
+		_ = b.conn.CloseChannel(ch)
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (b *eventBus) Publish(ctx context.Context, env *Envelope) error {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s envelope: %w", env.Type, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ch == nil || b.ch.IsClosed() {
+		ch, err := b.openChannel()
+		if err != nil {
+			return err
+		}
+		b.ch = ch
+	}
+
+	return b.conn.Publish(ctx, b.ch, EventsExchange, env.Type, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     env.EventId,
+		CorrelationId: env.CorrelationId,
+		Type:          env.Type,
+		Timestamp:     env.OccurredAt,
+		Body:          body,
+	})
+}
+
+func (b *eventBus) Subscribe(ctx context.Context, subscriber string, routingKeys []string, handler EventHandler) error {
+	ch, err := b.openChannel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel for subscriber %s: %w", subscriber, err)
+	}
+
+	queueName := SubscriberQueueName(subscriber)
+	if _, err := b.conn.QueueDeclare(ch, queueName); err != nil {
+		return err
+	}
+	for _, routingKey := range routingKeys {
+		if err := b.conn.QueueBind(ch, queueName, EventsExchange, routingKey); err != nil {
+			return err
+		}
+	}
+	if err := b.conn.DeclareRetryTopology(ch, queueName); err != nil {
+		return err
+	}
+
+	// events of one aggregate (e.g. a booking) are handled in publication order
+	opts := ConsumeOptions{PartitionKey: PartitionByHeader(HeaderAggregateId)}
+	err = b.conn.ConsumeDeliveries(ctx, ch, queueName, func(d amqp.Delivery) error {
+		env, err := DecodeEnvelope(d.Body)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, env)
+	}, opts)
+	if err != nil {
+		logrus.Errorf("Failed to subscribe %s to %v: %v", subscriber, routingKeys, err)
+		return err
+	}
+
+	logrus.Infof("Subscriber %s listening on %v", subscriber, routingKeys)
+	return nil
+}
@@ -0,0 +1,219 @@
+package rabbitmq
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/messaging"
+	"backend/internal/domain/patient"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventsExchange is the topic exchange carrying domain events, the routing key is the event type
+const EventsExchange = "clinic.events"
+
+// domain event types
+const (
+	BookingCreatedEvent        = "booking.created"
+	BookingStatusChangedEvent  = "booking.status_changed"
+	BookingDoctorAssignedEvent = "booking.doctor_assigned"
+	ReceiptCreatedEvent        = "receipt.created"
+	PatientRegisteredEvent     = "patient.registered"
+)
+
+// aggregate types stored on outbox rows
+const (
+	AggregatePayment = "payment"
+	AggregateBooking = "booking"
+	AggregateReceipt = "receipt"
+	AggregatePatient = "patient"
+)
+
+type BookingCreated struct {
+	QueueId            int       `json:"queue_id"`
+	PatientId          uuid.UUID `json:"patient_id"`
+	PatientName        string    `json:"patient_name"`
+	PatientEmail       string    `json:"patient_email"`
+	PatientPhoneNumber string    `json:"patient_phone_number"`
+	ServiceId          string    `json:"service_id"`
+	ServiceCode        string    `json:"service_code"`
+	ServiceName        string    `json:"service_name"`
+	Cost               float64   `json:"cost"`
+	FacultyId          byte      `json:"faculty_id,omitempty"`
+	PaymentStatus      string    `json:"payment_status"`
+	BookingStatus      string    `json:"booking_status"`
+	PaymentSessionId   string    `json:"payment_session_id,omitempty"`
+	AppointmentDate    time.Time `json:"appointment_date"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func NewBookingCreated(bq *patient.BookingQueue) *BookingCreated {
+	return &BookingCreated{
+		QueueId:            bq.QueueId,
+		PatientId:          bq.PatientId,
+		PatientName:        bq.PatientName,
+		PatientEmail:       bq.PatientEmail,
+		PatientPhoneNumber: bq.PatientPhoneNumber,
+		ServiceId:          bq.ServiceId,
+		ServiceCode:        bq.ServiceCode,
+		ServiceName:        bq.ServiceName,
+		Cost:               bq.ServiceCost,
+		FacultyId:          bq.FacultyId,
+		PaymentStatus:      string(bq.PaymentStatus),
+		BookingStatus:      string(bq.BookingStatus),
+		PaymentSessionId:   bq.PaymentSessionId,
+		AppointmentDate:    bq.AppointmentDate,
+		CreatedAt:          bq.CreatedAt,
+	}
+}
+
+// Booking rebuilds the booking row carried by the event
+func (e *BookingCreated) Booking() *patient.BookingQueue {
+	return &patient.BookingQueue{
+		QueueId:            e.QueueId,
+		PatientId:          e.PatientId,
+		PatientName:        e.PatientName,
+		PatientEmail:       e.PatientEmail,
+		PatientPhoneNumber: e.PatientPhoneNumber,
+		ServiceId:          e.ServiceId,
+		ServiceCode:        e.ServiceCode,
+		ServiceName:        e.ServiceName,
+		ServiceCost:        e.Cost,
+		FacultyId:          e.FacultyId,
+		PaymentStatus:      patient.PaymentStatus(e.PaymentStatus),
+		BookingStatus:      patient.BookingStatus(e.BookingStatus),
+		PaymentSessionId:   e.PaymentSessionId,
+		AppointmentDate:    e.AppointmentDate,
+		CreatedAt:          e.CreatedAt,
+	}
+}
+
+type BookingStatusChanged struct {
+	QueueId   int       `json:"queue_id"`
+	PatientId uuid.UUID `json:"patient_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type BookingDoctorAssigned struct {
+	QueueId    int       `json:"queue_id"`
+	PatientId  uuid.UUID `json:"patient_id"`
+	DoctorId   uuid.UUID `json:"doctor_id"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+type ReceiptCreated struct {
+	DrugReceiptId uuid.UUID `json:"drug_receipt_id"`
+	QueueId       int       `json:"queue_id"`
+	PatientId     uuid.UUID `json:"patient_id"`
+	PatientEmail  string    `json:"patient_email,omitempty"`
+	DrugName      string    `json:"drug_name"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type PatientRegistered struct {
+	PatientId    uuid.UUID `json:"patient_id"`
+	FullName     string    `json:"full_name"`
+	Email        string    `json:"email,omitempty"`
+	PhoneNumber  string    `json:"phone_number"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// NewEventOutbox stores an envelope for the relay, it is published on the events
+// exchange with the event type as routing key
+func NewEventOutbox(aggregateType, aggregateId string, env *Envelope) (*messaging.OutboxEvent, error) {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s envelope: %w", env.Type, err)
+	}
+	return &messaging.OutboxEvent{
+		AggregateType: aggregateType,
+		AggregateId:   aggregateId,
+		EventType:     env.Type,
+		Exchange:      EventsExchange,
+		RoutingKey:    env.Type,
+		Payload:       string(body),
+		Status:        messaging.OutboxStatusPending,
+		CreatedAt:     env.OccurredAt,
+	}, nil
+}
+
+// NewServiceRegisterEvent asks the booking consumer to create the booking of a paid session,
+// it is a command on the booking exchange rather than a domain event
+func NewServiceRegisterEvent(data *dtoqueue.BookingQueuePublish) (*messaging.OutboxEvent, error) {
+	return messaging.NewOutboxEvent(AggregatePayment, data.PaymentSessionId, ServiceRegisterEvent, ExchangeName, ServiceRegisterEvent, data)
+}
+
+// NewBookingCreatedEvent carries the stored booking, including its queue id
+func NewBookingCreatedEvent(bq *patient.BookingQueue) (*messaging.OutboxEvent, error) {
+	env, err := NewEnvelope(BookingCreatedEvent, bq.PaymentSessionId, NewBookingCreated(bq))
+	if err != nil {
+		return nil, err
+	}
+	return NewEventOutbox(AggregateBooking, strconv.Itoa(bq.QueueId), env)
+}
+
+// NewBookingStatusChangedEvent is built from the booking as it was before the change
+func NewBookingStatusChangedEvent(bq *patient.BookingQueue, status string) (*messaging.OutboxEvent, error) {
+	payload := &BookingStatusChanged{
+		QueueId:   bq.QueueId,
+		PatientId: bq.PatientId,
+		From:      string(bq.BookingStatus),
+		To:        status,
+		ChangedAt: time.Now(),
+	}
+	env, err := NewEnvelope(BookingStatusChangedEvent, bq.PaymentSessionId, payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventOutbox(AggregateBooking, strconv.Itoa(bq.QueueId), env)
+}
+
+func NewBookingDoctorAssignedEvent(bq *patient.BookingQueue, doctorId uuid.UUID) (*messaging.OutboxEvent, error) {
+	payload := &BookingDoctorAssigned{
+		QueueId:    bq.QueueId,
+		PatientId:  bq.PatientId,
+		DoctorId:   doctorId,
+		AssignedAt: time.Now(),
+	}
+	env, err := NewEnvelope(BookingDoctorAssignedEvent, bq.PaymentSessionId, payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventOutbox(AggregateBooking, strconv.Itoa(bq.QueueId), env)
+}
+
+func NewReceiptCreatedEvent(dr *patient.DrugReceipt, bq *patient.BookingQueue) (*messaging.OutboxEvent, error) {
+	payload := &ReceiptCreated{
+		DrugReceiptId: dr.DrugReceiptId,
+		QueueId:       bq.QueueId,
+		PatientId:     bq.PatientId,
+		PatientEmail:  bq.PatientEmail,
+		DrugName:      dr.DrugName,
+		CreatedAt:     time.Now(),
+	}
+	env, err := NewEnvelope(ReceiptCreatedEvent, bq.PaymentSessionId, payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventOutbox(AggregateReceipt, dr.DrugReceiptId.String(), env)
+}
+
+func NewPatientRegisteredEvent(p *patient.Patient) (*messaging.OutboxEvent, error) {
+	payload := &PatientRegistered{
+		PatientId:    p.PatientId,
+		FullName:     p.FullName,
+		Email:        p.Email,
+		PhoneNumber:  p.PhoneNumber,
+		RegisteredAt: time.Now(),
+	}
+	env, err := NewEnvelope(PatientRegisteredEvent, "", payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventOutbox(AggregatePatient, p.PatientId.String(), env)
+}
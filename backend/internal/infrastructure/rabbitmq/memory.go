@@ -0,0 +1,552 @@
+package rabbitmq
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishedMessage is a message accepted by the in-memory broker, kept for assertions
+type PublishedMessage struct {
+	Exchange   string
+	RoutingKey string
+	Msg        amqp.Publishing
+}
+
+// MemoryBroker is an in-process RabbitMQConnection for tests and local development.
+// It supports direct, fanout and topic exchanges, the default exchange, manual acks,
+// redelivery on nack or channel close, and the x-message-ttl / x-dead-letter-*
+// queue arguments used by the retry queues. Messages are lost on restart.
+//
+// The *amqp.Connection and *amqp.Channel it hands out are opaque handles: they are
+// never connected and must only be passed back to the broker.
+type MemoryBroker struct {
+	Retry     RetryPolicy
+	Consumers ConsumerConfig
+
+	group consumerGroup
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	conn      *amqp.Connection
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	consumers map[*amqp.Channel][]*memConsumer
+	unacked   map[uint64]*memUnacked
+	published []PublishedMessage
+	nextTag   uint64
+}
+
+type memExchange struct {
+	kind     string
+	bindings []memBinding
+}
+
+type memBinding struct {
+	queue string
+	key   string
+}
+
+type memQueue struct {
+	name     string
+	args     amqp.Table
+	messages []*memMessage
+}
+
+type memMessage struct {
+	exchange    string
+	routingKey  string
+	msg         amqp.Publishing
+	redelivered bool
+}
+
+type memConsumer struct {
+	queue      string
+	tag        string
+	prefetch   int
+	inflight   int
+	deliveries chan amqp.Delivery
+	// stopped consumers get no new deliveries, cancelled ones also lost their unacked messages
+	stopped   bool
+	cancelled bool
+}
+
+type memUnacked struct {
+	queue    *memQueue
+	consumer *memConsumer
+	message  *memMessage
+}
+
+func NewMemoryBroker(retry RetryPolicy) *MemoryBroker {
+	b := &MemoryBroker{
+		Retry:     retry.normalize(),
+		conn:      &amqp.Connection{},
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+		consumers: map[*amqp.Channel][]*memConsumer{},
+		unacked:   map[uint64]*memUnacked{},
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *MemoryBroker) Connect() (*amqp.Connection, error) {
+	return b.conn, nil
+}
+
+func (b *MemoryBroker) GetConnection() (*amqp.Connection, error) {
+	return b.conn, nil
+}
+
+func (b *MemoryBroker) GetChannel(conn *amqp.Connection) (*amqp.Channel, error) {
+	return &amqp.Channel{}, nil
+}
+
+// CloseChannel cancels the consumers of ch and requeues their unacked messages
+func (b *MemoryBroker) CloseChannel(ch *amqp.Channel) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range b.consumers[ch] {
+		b.cancelConsumerLocked(c)
+	}
+	delete(b.consumers, ch)
+	return nil
+}
+
+func (b *MemoryBroker) QueueDeclare(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
+	return b.QueueDeclareWithArgs(ch, queueName, nil)
+}
+
+func (b *MemoryBroker) QueueDeclareWithArgs(ch *amqp.Channel, queueName string, args amqp.Table) (amqp.Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		q = &memQueue{name: queueName, args: args}
+		b.queues[queueName] = q
+	}
+	return amqp.Queue{Name: queueName, Messages: len(q.messages), Consumers: b.consumerCountLocked(queueName)}, nil
+}
+
+func (b *MemoryBroker) QueueBind(ch *amqp.Channel, queueName, exchangeName, routingKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return fmt.Errorf("failed to bind queue %s: exchange %s not found", queueName, exchangeName)
+	}
+	if _, ok := b.queues[queueName]; !ok {
+		return fmt.Errorf("failed to bind queue %s: queue not found", queueName)
+	}
+	for _, bd := range ex.bindings {
+		if bd.queue == queueName && bd.key == routingKey {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{queue: queueName, key: routingKey})
+	return nil
+}
+
+func (b *MemoryBroker) ExchangeDeclare(ch *amqp.Channel, exchangeName, exchangeType string, durable bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch exchangeType {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic:
+	default:
+		return fmt.Errorf("failed to declare exchange %s: unsupported type %s", exchangeName, exchangeType)
+	}
+
+	if ex, ok := b.exchanges[exchangeName]; ok {
+		if ex.kind != exchangeType {
+			return fmt.Errorf("failed to declare exchange %s: already declared as %s", exchangeName, ex.kind)
+		}
+		return nil
+	}
+	b.exchanges[exchangeName] = &memExchange{kind: exchangeType}
+	return nil
+}
+
+func (b *MemoryBroker) DeclareRetryTopology(ch *amqp.Channel, queueName string) error {
+	return declareRetryTopology(b, b.Retry, ch, queueName)
+}
+
+func (b *MemoryBroker) PublishWithContext(ctx context.Context, ch *amqp.Channel, data *dtoqueue.BookingQueuePublish, queueName string) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return b.Publish(ctx, ch, "", queueName, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// Publish routes msg like RabbitMQ would, unroutable messages are dropped
+func (b *MemoryBroker) Publish(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if exchangeName != "" {
+		if _, ok := b.exchanges[exchangeName]; !ok {
+			return fmt.Errorf("failed to publish message to exchange %q: exchange not found", exchangeName)
+		}
+	}
+
+	b.published = append(b.published, PublishedMessage{Exchange: exchangeName, RoutingKey: routingKey, Msg: msg})
+	b.routeLocked(exchangeName, routingKey, msg)
+	return nil
+}
+
+// ConfirmChannel does nothing, every publish is confirmed in memory
+func (b *MemoryBroker) ConfirmChannel(ch *amqp.Channel) error {
+	return nil
+}
+
+// PublishConfirmed is Publish, the in-memory broker accepts a message once routed
+func (b *MemoryBroker) PublishConfirmed(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	return b.Publish(ctx, ch, exchangeName, routingKey, msg)
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context, ch *amqp.Channel, queueName string, msgHandler MessageHandler) error {
+	return b.ConsumeDeliveries(ctx, ch, queueName, func(d amqp.Delivery) error {
+		return msgHandler(d.Body)
+	}, ConsumeOptions{})
+}
+
+func (b *MemoryBroker) ConsumeDeliveries(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error {
+	retry := b.Retry
+	opts.Retry = &retry
+	return b.ConsumeWithOptions(ctx, ch, queueName, handler, opts)
+}
+
+func (b *MemoryBroker) ConsumeWithOptions(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error {
+	opts = opts.withDefaults(b.Consumers)
+
+	b.mu.Lock()
+	if _, ok := b.queues[queueName]; !ok {
+		b.mu.Unlock()
+		return fmt.Errorf("failed to register consumer for queue %s: queue not found", queueName)
+	}
+	c := &memConsumer{
+		queue:      queueName,
+		tag:        b.group.nextTag(queueName),
+		prefetch:   opts.Prefetch,
+		deliveries: make(chan amqp.Delivery),
+	}
+	b.consumers[ch] = append(b.consumers[ch], c)
+	b.mu.Unlock()
+
+	logrus.Infof("Started consuming messages from in-memory queue %s with %d worker(s), prefetch %d", queueName, opts.Workers, opts.Prefetch)
+
+	go b.pump(ctx, c)
+	return b.group.run(ctx, b, ch, queueName, c.deliveries, handler, opts, func() {
+		b.mu.Lock()
+		c.stopped = true
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+}
+
+func (b *MemoryBroker) Drain(ctx context.Context) error {
+	return b.group.drain(ctx, b)
+}
+
+// Published returns a copy of every message accepted so far
+func (b *MemoryBroker) Published() []PublishedMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := make([]PublishedMessage, len(b.published))
+	copy(res, b.published)
+	return res
+}
+
+// QueueDepth is the number of ready (not yet delivered) messages in queueName
+func (b *MemoryBroker) QueueDepth(queueName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+// pump delivers messages of c's queue one at a time until ctx is done or c is cancelled
+func (b *MemoryBroker) pump(ctx context.Context, c *memConsumer) {
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cancelConsumerLocked(c)
+		b.mu.Unlock()
+	})
+	defer stop()
+
+	for {
+		b.mu.Lock()
+		q := b.queues[c.queue]
+		for !c.cancelled && !c.stopped && (len(q.messages) == 0 || c.inflight >= c.prefetch) {
+			b.cond.Wait()
+		}
+		if c.cancelled || c.stopped {
+			b.mu.Unlock()
+			close(c.deliveries)
+			return
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		b.nextTag++
+		tag := b.nextTag
+		b.unacked[tag] = &memUnacked{queue: q, consumer: c, message: m}
+		c.inflight++
+		d := b.deliveryLocked(c, tag, m)
+		b.mu.Unlock()
+
+		select {
+		case c.deliveries <- d:
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.requeueLocked(tag)
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *MemoryBroker) deliveryLocked(c *memConsumer, tag uint64, m *memMessage) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger:    &memAcknowledger{b: b},
+		Headers:         m.msg.Headers,
+		ContentType:     m.msg.ContentType,
+		ContentEncoding: m.msg.ContentEncoding,
+		DeliveryMode:    m.msg.DeliveryMode,
+		Priority:        m.msg.Priority,
+		CorrelationId:   m.msg.CorrelationId,
+		ReplyTo:         m.msg.ReplyTo,
+		Expiration:      m.msg.Expiration,
+		MessageId:       m.msg.MessageId,
+		Timestamp:       m.msg.Timestamp,
+		Type:            m.msg.Type,
+		UserId:          m.msg.UserId,
+		AppId:           m.msg.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.routingKey,
+		Body:            m.msg.Body,
+	}
+}
+
+func (b *MemoryBroker) cancelConsumerLocked(c *memConsumer) {
+	if c.cancelled {
+		return
+	}
+	c.cancelled = true
+	for tag, u := range b.unacked {
+		if u.consumer == c {
+			b.requeueLocked(tag)
+		}
+	}
+	b.cond.Broadcast()
+}
+
+func (b *MemoryBroker) consumerCountLocked(queueName string) int {
+	n := 0
+	for _, cs := range b.consumers {
+		for _, c := range cs {
+			if c.queue == queueName && !c.cancelled {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// routeLocked copies msg into every queue bound to the exchange with a matching key
+func (b *MemoryBroker) routeLocked(exchangeName, routingKey string, msg amqp.Publishing) {
+	if exchangeName == "" {
+		if q, ok := b.queues[routingKey]; ok {
+			b.enqueueLocked(q, exchangeName, routingKey, msg)
+		}
+		return
+	}
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return
+	}
+
+	routed := map[string]bool{}
+	for _, bd := range ex.bindings {
+		if routed[bd.queue] || !bindingMatches(ex.kind, bd.key, routingKey) {
+			continue
+		}
+		if q, ok := b.queues[bd.queue]; ok {
+			routed[bd.queue] = true
+			b.enqueueLocked(q, exchangeName, routingKey, msg)
+		}
+	}
+}
+
+func (b *MemoryBroker) enqueueLocked(q *memQueue, exchangeName, routingKey string, msg amqp.Publishing) {
+	m := &memMessage{exchange: exchangeName, routingKey: routingKey, msg: msg}
+	q.messages = append(q.messages, m)
+	b.cond.Broadcast()
+
+	if ttl, ok := tableInt(q.args, "x-message-ttl"); ok {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expireLocked(q, m)
+		})
+	}
+}
+
+// expireLocked dead-letters m if it is still waiting in q
+func (b *MemoryBroker) expireLocked(q *memQueue, m *memMessage) {
+	for i, queued := range q.messages {
+		if queued == m {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			b.deadLetterLocked(q, m)
+			return
+		}
+	}
+}
+
+func (b *MemoryBroker) deadLetterLocked(q *memQueue, m *memMessage) {
+	exchangeName, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	routingKey := m.routingKey
+	if key, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		routingKey = key
+	}
+	b.routeLocked(exchangeName, routingKey, m.msg)
+}
+
+func (b *MemoryBroker) requeueLocked(tag uint64) {
+	u, ok := b.unacked[tag]
+	if !ok {
+		return
+	}
+	b.forgetLocked(tag)
+	u.message.redelivered = true
+	u.queue.messages = append([]*memMessage{u.message}, u.queue.messages...)
+	b.cond.Broadcast()
+}
+
+// forgetLocked settles an unacked delivery, freeing a prefetch slot of its consumer
+func (b *MemoryBroker) forgetLocked(tag uint64) {
+	if u, ok := b.unacked[tag]; ok {
+		delete(b.unacked, tag)
+		u.consumer.inflight--
+		b.cond.Broadcast()
+	}
+}
+
+func (b *MemoryBroker) settle(tag uint64, multiple, requeue, ack bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for t := range b.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	for _, t := range tags {
+		u, ok := b.unacked[t]
+		if !ok {
+			return fmt.Errorf("unknown delivery tag %d", t)
+		}
+		switch {
+		case ack:
+			b.forgetLocked(t)
+		case requeue:
+			b.requeueLocked(t)
+		default:
+			b.forgetLocked(t)
+			b.deadLetterLocked(u.queue, u.message)
+		}
+	}
+	return nil
+}
+
+type memAcknowledger struct {
+	b *MemoryBroker
+}
+
+func (a *memAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.b.settle(tag, multiple, false, true)
+}
+
+func (a *memAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.b.settle(tag, multiple, requeue, false)
+}
+
+func (a *memAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.b.settle(tag, false, requeue, false)
+}
+
+func bindingMatches(kind, bindingKey, routingKey string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatches implements AMQP topic patterns: "*" matches one word, "#" zero or more
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+func tableInt(t amqp.Table, key string) (int64, bool) {
+	switch v := t[key].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
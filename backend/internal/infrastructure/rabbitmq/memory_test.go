@@ -0,0 +1,255 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBroker(t *testing.T) (*MemoryBroker, *amqp.Channel) {
+	b := NewMemoryBroker(RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond})
+	conn, err := b.Connect()
+	require.NoError(t, err)
+	ch, err := b.GetChannel(conn)
+	require.NoError(t, err)
+	return b, ch
+}
+
+func TestMemoryBroker_TopicRouting(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	require.NoError(t, b.ExchangeDeclare(ch, EventsExchange, amqp.ExchangeTopic, true))
+	for _, q := range []string{"bookings", "everything", "receipts"} {
+		_, err := b.QueueDeclare(ch, q)
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.QueueBind(ch, "bookings", EventsExchange, "booking.*"))
+	require.NoError(t, b.QueueBind(ch, "everything", EventsExchange, "#"))
+	require.NoError(t, b.QueueBind(ch, "receipts", EventsExchange, "receipt.created"))
+
+	ctx := context.Background()
+	for _, key := range []string{BookingCreatedEvent, BookingStatusChangedEvent, ReceiptCreatedEvent, "booking.created.v2"} {
+		require.NoError(t, b.Publish(ctx, ch, EventsExchange, key, amqp.Publishing{Body: []byte(key)}))
+	}
+
+	assert.Equal(t, 2, b.QueueDepth("bookings"))
+	assert.Equal(t, 4, b.QueueDepth("everything"))
+	assert.Equal(t, 1, b.QueueDepth("receipts"))
+	assert.Len(t, b.Published(), 4)
+
+	err := b.Publish(ctx, ch, "missing", "key", amqp.Publishing{})
+	assert.Error(t, err)
+}
+
+func TestMemoryBroker_RetryThenDeadLetter(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	_, err := b.QueueDeclare(ch, "work")
+	require.NoError(t, err)
+	require.NoError(t, b.DeclareRetryTopology(ch, "work"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	err = b.ConsumeDeliveries(ctx, ch, "work", func(d amqp.Delivery) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("database down")
+	}, ConsumeOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, ch, "", "work", amqp.Publishing{Body: []byte("payload")}))
+
+	require.Eventually(t, func() bool {
+		return b.QueueDepth(DeadLetterQueue) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+
+	dead := b.Published()[len(b.Published())-1]
+	assert.Equal(t, DeadLetterExchange, dead.Exchange)
+	assert.Equal(t, 3, AttemptOf(dead.Msg.Headers))
+	assert.Equal(t, "work", dead.Msg.Headers[HeaderSourceQueue])
+	assert.Equal(t, "database down", dead.Msg.Headers[HeaderLastError])
+}
+
+func TestMemoryBroker_PermanentErrorSkipsRetries(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	_, err := b.QueueDeclare(ch, "work")
+	require.NoError(t, err)
+	require.NoError(t, b.DeclareRetryTopology(ch, "work"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	err = b.ConsumeDeliveries(ctx, ch, "work", func(d amqp.Delivery) error {
+		atomic.AddInt32(&calls, 1)
+		return Permanent(errors.New("bad json"))
+	}, ConsumeOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, ch, "", "work", amqp.Publishing{Body: []byte("{")}))
+
+	require.Eventually(t, func() bool {
+		return b.QueueDepth(DeadLetterQueue) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestMemoryBroker_NackRequeuesAndCloseRedelivers(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	_, err := b.QueueDeclare(ch, "work")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var redelivered []bool
+	err = b.ConsumeWithOptions(ctx, ch, "work", func(d amqp.Delivery) error {
+		mu.Lock()
+		defer mu.Unlock()
+		redelivered = append(redelivered, d.Redelivered)
+		if len(redelivered) == 1 {
+			return errors.New("try again")
+		}
+		return nil
+	}, ConsumeOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, ch, "", "work", amqp.Publishing{Body: []byte("payload")}))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(redelivered) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []bool{false, true}, redelivered)
+	mu.Unlock()
+
+	// a message delivered on a closed channel goes back to the queue
+	ch2, err := b.GetChannel(nil)
+	require.NoError(t, err)
+	_, err = b.QueueDeclare(ch2, "slow")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	err = b.ConsumeWithOptions(ctx, ch2, "slow", func(d amqp.Delivery) error {
+		close(started)
+		<-release
+		return nil
+	}, ConsumeOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, ch2, "", "slow", amqp.Publishing{Body: []byte("payload")}))
+	<-started
+	require.NoError(t, b.CloseChannel(ch2))
+	assert.Equal(t, 1, b.QueueDepth("slow"))
+	close(release)
+}
+
+func TestMemoryBroker_PartitionedWorkersKeepOrder(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	_, err := b.QueueDeclare(ch, "work")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	var busy, maxBusy int32
+	err = b.ConsumeWithOptions(ctx, ch, "work", func(d amqp.Delivery) error {
+		n := atomic.AddInt32(&busy, 1)
+		defer atomic.AddInt32(&busy, -1)
+		for {
+			m := atomic.LoadInt32(&maxBusy)
+			if n <= m || atomic.CompareAndSwapInt32(&maxBusy, m, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := d.Headers["patient"].(string)
+		seen[key] = append(seen[key], string(d.Body))
+		return nil
+	}, ConsumeOptions{Workers: 4, PartitionKey: PartitionByHeader("patient")})
+	require.NoError(t, err)
+
+	patients := []string{"a", "b", "c", "d", "e", "f"}
+	for i := 0; i < 5; i++ {
+		for _, p := range patients {
+			msg := amqp.Publishing{Headers: amqp.Table{"patient": p}, Body: []byte{byte('0' + i)}}
+			require.NoError(t, b.Publish(ctx, ch, "", "work", msg))
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, v := range seen {
+			total += len(v)
+		}
+		return total == 30
+	}, 2*time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range patients {
+		assert.Equal(t, []string{"0", "1", "2", "3", "4"}, seen[p], "patient %s", p)
+	}
+	assert.Greater(t, atomic.LoadInt32(&maxBusy), int32(1))
+}
+
+func TestMemoryBroker_DrainWaitsForInFlight(t *testing.T) {
+	b, ch := newTestBroker(t)
+
+	_, err := b.QueueDeclare(ch, "work")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{}, 1)
+	var finished int32
+	err = b.ConsumeWithOptions(ctx, ch, "work", func(d amqp.Delivery) error {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&finished, 1)
+		return nil
+	}, ConsumeOptions{})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Publish(ctx, ch, "", "work", amqp.Publishing{Body: []byte("payload")}))
+	}
+	<-started
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second)
+	defer drainCancel()
+	require.NoError(t, b.Drain(drainCtx))
+
+	// the in-flight message completed, the others went back to the queue
+	assert.EqualValues(t, 1, atomic.LoadInt32(&finished))
+	assert.Equal(t, 2, b.QueueDepth("work"))
+
+	err = b.ConsumeWithOptions(ctx, ch, "work", func(d amqp.Delivery) error { return nil }, ConsumeOptions{})
+	assert.Error(t, err)
+}
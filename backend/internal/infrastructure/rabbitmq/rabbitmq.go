@@ -4,7 +4,10 @@ import (
 	dtoqueue "backend/internal/domain/dto/queue"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
@@ -16,22 +19,73 @@ const (
 	ExchangeName         = "booking_exchange"
 )
 
+// ErrPublishNotConfirmed is a publish the broker nacked, or did not confirm before the
+// channel closed, the message may not have been routed
+var ErrPublishNotConfirmed = errors.New("publish was not confirmed by the broker")
+
 type MessageHandler func([]byte) error
 
+// DeliveryHandler receives the whole delivery, including headers
+type DeliveryHandler func(amqp.Delivery) error
+
+// ConsumeOptions tunes how a consumer processes and acknowledges deliveries
+type ConsumeOptions struct {
+	// Retry routes failed deliveries through the retry queues and finally the
+	// dead-letter exchange. When nil, failed deliveries are requeued as-is.
+	Retry *RetryPolicy
+	// Workers is the number of concurrent handlers, the connection default when zero
+	Workers int
+	// Prefetch caps unacked deliveries on the channel, at least Workers
+	Prefetch int
+	// PartitionKey keeps deliveries with the same key on the same worker so they are
+	// handled in order. Deliveries with an empty key go to any worker.
+	PartitionKey func(amqp.Delivery) string
+}
+
+// requeueDelay throttles redelivery for consumers without a retry policy
+const requeueDelay = time.Second
+
+// reconnectDelay is the first pause between dials after the broker closed the
+// connection, it doubles up to maxReconnectDelay
+const (
+	reconnectDelay    = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var errConnectionClosed = errors.New("connection is drained, no new channels accepted")
+
 type RabbitMQConnection interface {
+	// Connect opens the shared connection unless it is open already, every channel is
+	// opened on it
 	Connect() (*amqp.Connection, error)
+	// GetConnection returns the shared connection, it is dialed again once the broker
+	// closed it
 	GetConnection() (*amqp.Connection, error)
 
 	GetChannel(conn *amqp.Connection) (*amqp.Channel, error)
 	CloseChannel(ch *amqp.Channel) error
 
 	QueueDeclare(ch *amqp.Channel, queueName string) (amqp.Queue, error)
+	QueueDeclareWithArgs(ch *amqp.Channel, queueName string, args amqp.Table) (amqp.Queue, error)
 	QueueBind(ch *amqp.Channel, queueName, exchangeName, routingKey string) error
 
 	ExchangeDeclare(ch *amqp.Channel, exchangeName, exchangeType string, durable bool) error
+	DeclareRetryTopology(ch *amqp.Channel, queueName string) error
 
 	PublishWithContext(ctx context.Context, ch *amqp.Channel, data *dtoqueue.BookingQueuePublish, queueName string) error
+	Publish(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error
+	// ConfirmChannel puts ch in confirm mode, needed by PublishConfirmed
+	ConfirmChannel(ch *amqp.Channel) error
+	// PublishConfirmed publishes msg and waits until the broker acked it
+	PublishConfirmed(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error
 	Consume(ctx context.Context, ch *amqp.Channel, queueName string, msgHandler MessageHandler) error
+	// ConsumeDeliveries is ConsumeWithOptions with the connection's retry policy
+	ConsumeDeliveries(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error
+	ConsumeWithOptions(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error
+
+	// Drain stops all consumers, waits for in-flight handlers until ctx is done
+	// and closes the channels and connections
+	Drain(ctx context.Context) error
 }
 
 type RabbitConfig struct {
@@ -39,18 +93,49 @@ type RabbitConfig struct {
 	Password string `json:"password,omitempty"`
 	Host     string `json:"host,omitempty"`
 	Port     int    `json:"port,omitempty"`
+
+	MaxAttempts    int           `json:"max_attempts,omitempty"`
+	RetryBaseDelay time.Duration `json:"retry_base_delay,omitempty"`
+
+	Workers  int `json:"workers,omitempty"`
+	Prefetch int `json:"prefetch,omitempty"`
 }
 
 type RabbitMQClient struct {
 	RabbitConfig
 	Connection *amqp.Connection
+	Retry      RetryPolicy
+	Consumers  ConsumerConfig
+
+	group  consumerGroup
+	connMu sync.Mutex
+	// drained stops the reconnects once Drain closed the connection
+	drained bool
 }
 
 func NewRabbitMQ(rbcfg RabbitConfig) *RabbitMQClient {
-	return &RabbitMQClient{RabbitConfig: rbcfg}
+	retry := RetryPolicy{
+		MaxAttempts: rbcfg.MaxAttempts,
+		BaseDelay:   rbcfg.RetryBaseDelay,
+	}
+	return &RabbitMQClient{
+		RabbitConfig: rbcfg,
+		Retry:        retry.normalize(),
+		Consumers:    ConsumerConfig{Workers: rbcfg.Workers, Prefetch: rbcfg.Prefetch},
+	}
 }
 
 func (rbmq *RabbitMQClient) Connect() (*amqp.Connection, error) {
+	rbmq.connMu.Lock()
+	defer rbmq.connMu.Unlock()
+
+	if rbmq.drained {
+		return nil, errConnectionClosed
+	}
+	if rbmq.Connection != nil && !rbmq.Connection.IsClosed() {
+		return rbmq.Connection, nil
+	}
+
 	address := fmt.Sprintf("amqp://%s:%s@%s:%d/", rbmq.User, rbmq.Password, rbmq.Host, rbmq.Port)
 
 	conn, err := amqp.Dial(address)
@@ -62,18 +147,32 @@ func (rbmq *RabbitMQClient) Connect() (*amqp.Connection, error) {
 	logrus.Infof("Successfully connected to RabbitMQ at %s:%d", rbmq.Host, rbmq.Port)
 
 	rbmq.Connection = conn
+	go rbmq.reconnectOnClose(conn)
 	return conn, nil
 }
 
 func (rbmq *RabbitMQClient) GetConnection() (*amqp.Connection, error) {
-	conn, err := rbmq.Connect()
-	if err != nil {
-		return nil, err
+	return rbmq.Connect()
+}
+
+// reconnectOnClose dials again once the broker closed conn, so publishers find an open
+// connection. Closing it ourselves, on Drain, ends the watch
+func (rbmq *RabbitMQClient) reconnectOnClose(conn *amqp.Connection) {
+	reason, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+	if !ok || reason == nil {
+		return
 	}
-	if rbmq.Connection == nil || rbmq.Connection.IsClosed() {
-		return rbmq.Connect()
+	logrus.Warnf("RabbitMQ connection closed: %v, reconnecting", reason)
+
+	delay := reconnectDelay
+	for {
+		_, err := rbmq.Connect()
+		if err == nil || errors.Is(err, errConnectionClosed) {
+			return
+		}
+		time.Sleep(delay)
+		delay = min(2*delay, maxReconnectDelay)
 	}
-	return conn, err
 }
 
 func (rbmq *RabbitMQClient) GetChannel(conn *amqp.Connection) (*amqp.Channel, error) {
@@ -106,6 +205,27 @@ func (rbmq *RabbitMQClient) QueueDeclare(ch *amqp.Channel, queueName string) (am
 	return q, nil
 }
 
+func (rbmq *RabbitMQClient) QueueDeclareWithArgs(ch *amqp.Channel, queueName string, args amqp.Table) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		args,      // arguments
+	)
+	if err != nil {
+		logrus.Errorf("Failed to declare queue %s: %v", queueName, err)
+		return amqp.Queue{}, fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+	logrus.Infof("Successfully declared queue: %s", queueName)
+	return q, nil
+}
+
+func (rbmq *RabbitMQClient) DeclareRetryTopology(ch *amqp.Channel, queueName string) error {
+	return declareRetryTopology(rbmq, rbmq.Retry, ch, queueName)
+}
+
 func (rbmq *RabbitMQClient) QueueBind(ch *amqp.Channel, queueName, exchangeName, routingKey string) error {
 	err := ch.QueueBind(
 		queueName,    // queue name
@@ -169,46 +289,119 @@ func (rbmq *RabbitMQClient) PublishWithContext(ctx context.Context, ch *amqp.Cha
 	return nil
 }
 
+func (rbmq *RabbitMQClient) Publish(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	err := ch.PublishWithContext(
+		ctx,
+		exchangeName, // exchange
+		routingKey,   // routing key
+		false,        // mandatory
+		false,        // immediate
+		msg,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to publish message to exchange %q with routing key %s: %v", exchangeName, routingKey, err)
+		return fmt.Errorf("failed to publish message to exchange %q: %w", exchangeName, err)
+	}
+	return nil
+}
+
+func (rbmq *RabbitMQClient) ConfirmChannel(ch *amqp.Channel) error {
+	if err := ch.Confirm(false); err != nil {
+		logrus.Errorf("Failed to put channel in confirm mode: %v", err)
+		return fmt.Errorf("failed to put channel in confirm mode: %w", err)
+	}
+	return nil
+}
+
+func (rbmq *RabbitMQClient) PublishConfirmed(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchangeName, // exchange
+		routingKey,   // routing key
+		false,        // mandatory
+		false,        // immediate
+		msg,
+	)
+	if err != nil {
+		logrus.Errorf("Failed to publish message to exchange %q with routing key %s: %v", exchangeName, routingKey, err)
+		return fmt.Errorf("failed to publish message to exchange %q: %w", exchangeName, err)
+	}
+	if confirm == nil {
+		return fmt.Errorf("failed to publish message to exchange %q: channel is not in confirm mode", exchangeName)
+	}
+	// a closed channel nacks the publishes still waiting
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to publish message to exchange %q: %w", exchangeName, err)
+	}
+	if !acked {
+		logrus.Errorf("Broker nacked message to exchange %q with routing key %s", exchangeName, routingKey)
+		return fmt.Errorf("failed to publish message to exchange %q: %w", exchangeName, ErrPublishNotConfirmed)
+	}
+	return nil
+}
+
+// Consume processes message bodies with the client's retry policy
 func (rbmq *RabbitMQClient) Consume(ctx context.Context, ch *amqp.Channel, queueName string, msgHandler MessageHandler) error {
+	return rbmq.ConsumeDeliveries(ctx, ch, queueName, func(d amqp.Delivery) error {
+		return msgHandler(d.Body)
+	}, ConsumeOptions{})
+}
+
+func (rbmq *RabbitMQClient) ConsumeDeliveries(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error {
+	retry := rbmq.Retry
+	opts.Retry = &retry
+	return rbmq.ConsumeWithOptions(ctx, ch, queueName, handler, opts)
+}
+
+func (rbmq *RabbitMQClient) ConsumeWithOptions(ctx context.Context, ch *amqp.Channel, queueName string, handler DeliveryHandler, opts ConsumeOptions) error {
+	opts = opts.withDefaults(rbmq.Consumers)
+
+	if err := ch.Qos(opts.Prefetch, 0, false); err != nil {
+		logrus.Errorf("Failed to set prefetch for queue %s: %v", queueName, err)
+		return fmt.Errorf("failed to set prefetch for queue %s: %w", queueName, err)
+	}
+
+	// failures are republished to the retry queues before the delivery is acked, the
+	// ack waits for the broker to confirm the republish
+	if opts.Retry != nil {
+		if err := rbmq.ConfirmChannel(ch); err != nil {
+			return err
+		}
+	}
+
+	consumerTag := rbmq.group.nextTag(queueName)
 	msgs, err := ch.Consume(
-		queueName, // queue
-		"",        // consumer
-		true,      // auto-ack
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
+		queueName,   // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
 	)
 	if err != nil {
 		logrus.Errorf("Failed to register consumer for queue %s: %v", queueName, err)
 		return fmt.Errorf("failed to register consumer for queue %s: %w", queueName, err)
 	}
 
-	logrus.Infof("Started consuming messages from queue %s", queueName)
-
-	// Start consuming messages in a goroutine
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				logrus.Infof("Context cancelled, stopping consumer for queue %s", queueName)
-				return
-			case msg, ok := <-msgs:
-				if !ok {
-					logrus.Warnf("Message channel closed for queue %s", queueName)
-					return
-				}
-
-				logrus.Infof("Received message from queue %s", queueName)
-
-				if err := msgHandler(msg.Body); err != nil {
-					logrus.Errorf("Failed to process message from queue %s: %v", queueName, err)
-				} else {
-					logrus.Infof("Successfully processed message from queue %s", queueName)
-				}
-			}
+	logrus.Infof("Started consuming messages from queue %s with %d worker(s), prefetch %d", queueName, opts.Workers, opts.Prefetch)
+
+	return rbmq.group.run(ctx, rbmq, ch, queueName, msgs, handler, opts, func() {
+		if err := ch.Cancel(consumerTag, false); err != nil {
+			logrus.Warnf("Failed to cancel consumer %s: %v", consumerTag, err)
 		}
-	}()
+	})
+}
 
-	return nil
+func (rbmq *RabbitMQClient) Drain(ctx context.Context) error {
+	err := rbmq.group.drain(ctx, rbmq)
+
+	rbmq.connMu.Lock()
+	defer rbmq.connMu.Unlock()
+	rbmq.drained = true
+	if rbmq.Connection != nil && !rbmq.Connection.IsClosed() {
+		_ = rbmq.Connection.Close()
+	}
+	return err
 }
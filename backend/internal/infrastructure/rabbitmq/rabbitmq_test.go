@@ -0,0 +1,38 @@
+package rabbitmq
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRabbitMQClient_DrainedClientDoesNotDialAgain(t *testing.T) {
+	// a listener that is no broker, every dial fails the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	dials := make(chan struct{}, 8)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dials <- struct{}{}
+			_ = conn.Close()
+		}
+	}()
+
+	rbmq := NewRabbitMQ(RabbitConfig{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port})
+	_, err = rbmq.GetConnection()
+	assert.Error(t, err)
+	<-dials
+
+	require.NoError(t, rbmq.Drain(context.Background()))
+	_, err = rbmq.GetConnection()
+	assert.ErrorIs(t, err, errConnectionClosed)
+	assert.Empty(t, dials)
+}
@@ -0,0 +1,134 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headers carried by every retried or dead-lettered message
+const (
+	HeaderAttempt      = "x-attempt"
+	HeaderLastError    = "x-last-error"
+	HeaderSourceQueue  = "x-source-queue"
+	HeaderDeadLetterAt = "x-dead-lettered-at"
+)
+
+const (
+	DeadLetterExchange = "clinic.dlx"
+	DeadLetterQueue    = "clinic.dead_letter"
+)
+
+const (
+	defaultMaxAttempts = 4
+	defaultBaseDelay   = 5 * time.Second
+)
+
+// RetryPolicy decides how often a failed delivery is retried and how long it waits
+// between attempts. Delays grow exponentially: BaseDelay, 2*BaseDelay, 4*BaseDelay...
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		BaseDelay:   defaultBaseDelay,
+	}
+}
+
+func (p RetryPolicy) normalize() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultBaseDelay
+	}
+	return p
+}
+
+// Delay returns how long a message waits after its n-th failed attempt (1-based)
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	p = p.normalize()
+	if attempt < 1 {
+		attempt = 1
+	}
+	return p.BaseDelay * time.Duration(1<<(attempt-1))
+}
+
+// RetryQueueName is the parking queue used after the n-th failed attempt
+func RetryQueueName(queueName string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, attempt)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying (e.g. malformed payload),
+// the message goes straight to the dead-letter queue
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// AttemptOf reads how many times a message has already failed
+func AttemptOf(headers amqp.Table) int {
+	switch v := headers[HeaderAttempt].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case uint8:
+		return int(v)
+	}
+	return 0
+}
+
+// failureRoute decides where a failed delivery from queueName is republished:
+// either the next retry queue (through the default exchange) or the dead-letter exchange
+func (p RetryPolicy) failureRoute(queueName string, d amqp.Delivery, cause error) (exchange, routingKey string, msg amqp.Publishing) {
+	p = p.normalize()
+	attempt := AttemptOf(d.Headers) + 1
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderAttempt] = int32(attempt)
+	headers[HeaderLastError] = cause.Error()
+	headers[HeaderSourceQueue] = queueName
+
+	msg = amqp.Publishing{
+		ContentType:   d.ContentType,
+		CorrelationId: d.CorrelationId,
+		MessageId:     d.MessageId,
+		Type:          d.Type,
+		DeliveryMode:  amqp.Persistent,
+		Headers:       headers,
+		Body:          d.Body,
+	}
+
+	if attempt >= p.MaxAttempts || IsPermanent(cause) {
+		headers[HeaderDeadLetterAt] = time.Now().UTC().Format(time.RFC3339)
+		return DeadLetterExchange, queueName, msg
+	}
+	return "", RetryQueueName(queueName, attempt), msg
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// KeyBy is what a rule counts requests by
+type KeyBy string
+
+const (
+	KeyByIP    KeyBy = "ip"
+	KeyByPhone KeyBy = "phone"
+	KeyByUser  KeyBy = "user"
+)
+
+// Rule allows Limit requests per Window for every distinct Key
+type Rule struct {
+	Key    KeyBy         `mapstructure:"key"`
+	Limit  int64         `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+}
+
+// Policy is the RateLimit-Policy form of the rule, e.g. 5;w=900
+func (r Rule) Policy() string {
+	return fmt.Sprintf("%d;w=%d", r.Limit, int64(r.Window.Seconds()))
+}
+
+// DefaultRules apply to a group that has no rate_limit.groups entry in the config
+var DefaultRules = map[string][]Rule{
+	"login": {
+		{Key: KeyByIP, Limit: 20, Window: time.Minute},
+		{Key: KeyByPhone, Limit: 5, Window: 15 * time.Minute},
+	},
+	"register": {
+		{Key: KeyByIP, Limit: 5, Window: time.Hour},
+	},
+	"checkout": {
+		{Key: KeyByUser, Limit: 10, Window: 10 * time.Minute},
+		{Key: KeyByIP, Limit: 30, Window: 10 * time.Minute},
+	},
+	"recovery": {
+		{Key: KeyByIP, Limit: 10, Window: 15 * time.Minute},
+	},
+}
+
+// Result is the outcome of a request against one rule
+type Result struct {
+	Rule      Rule
+	Allowed   bool
+	Remaining int64
+	// Reset is when the full quota is back, RetryAfter when the next request would be allowed
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Limiter is a sliding window counter over Redis. Each window has one counter and a
+// request is weighed against the current counter plus the share of the previous one that
+// still overlaps the sliding window, so there is no burst at window boundaries and no
+// per-request state to keep
+type Limiter struct {
+	redis redis.RedisConnection
+	now   func() time.Time
+}
+
+func NewLimiter(rc redis.RedisConnection) *Limiter {
+	return &Limiter{
+		redis: rc,
+		now:   time.Now,
+	}
+}
+
+// Allow counts a request of subject against rule, rejected requests count too so
+// hammering a limited key keeps it limited
+func (l *Limiter) Allow(ctx context.Context, group string, rule Rule, subject string) (Result, error) {
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return Result{}, fmt.Errorf("invalid rate limit rule %+v for %s", rule, group)
+	}
+
+	now := l.now()
+	window := now.UnixNano() / int64(rule.Window)
+	elapsed := float64(now.UnixNano()-window*int64(rule.Window)) / float64(rule.Window)
+	prefix := fmt.Sprintf("ratelimit:%s:%s:%s:", group, rule.Key, subject)
+
+	current, err := l.redis.Incr(ctx, prefix+strconv.FormatInt(window, 10))
+	if err != nil {
+		return Result{}, err
+	}
+	if current == 1 {
+		// the counter is read as the previous window during the next one
+		if err := l.redis.Expire(ctx, prefix+strconv.FormatInt(window, 10), 2*rule.Window); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var previous int64
+	v, err := l.redis.Get(ctx, prefix+strconv.FormatInt(window-1, 10))
+	switch {
+	case err == nil:
+		previous, _ = strconv.ParseInt(v, 10, 64)
+	case !errors.Is(err, redis.ErrNotFound):
+		return Result{}, err
+	}
+
+	estimated := float64(previous)*(1-elapsed) + float64(current)
+	res := Result{
+		Rule:      rule,
+		Allowed:   estimated <= float64(rule.Limit),
+		Remaining: max(0, rule.Limit-int64(math.Ceil(estimated))),
+		// both counters have left the sliding window by then
+		Reset: time.Duration((2 - elapsed) * float64(rule.Window)),
+	}
+	if !res.Allowed {
+		res.RetryAfter = retryAfter(previous, current, rule, elapsed)
+	}
+	return res, nil
+}
+
+// retryAfter is how long until one more request fits, assuming no other requests arrive
+func retryAfter(previous, current int64, rule Rule, elapsed float64) time.Duration {
+	room := float64(rule.Limit - 1)
+	if float64(current) <= room && previous > 0 {
+		// the previous window fades out enough within the current one
+		at := 1 - (room-float64(current))/float64(previous)
+		return time.Duration((at - elapsed) * float64(rule.Window))
+	}
+	// wait for the next window and for the current counter to fade out there
+	fade := 1.0
+	if room > 0 {
+		fade = 1 - room/float64(current)
+	}
+	return time.Duration((1 - elapsed + fade) * float64(rule.Window))
+}
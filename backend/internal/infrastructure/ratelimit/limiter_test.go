@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_SlidingWindow(t *testing.T) {
+	l := NewLimiter(redis.NewMemoryStore())
+	now := time.Unix(0, 0).Add(1000 * time.Minute)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	rule := Rule{Key: KeyByPhone, Limit: 3, Window: time.Minute}
+
+	for i := int64(1); i <= 3; i++ {
+		res, err := l.Allow(ctx, "login", rule, "0901234567")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3-i, res.Remaining)
+	}
+
+	res, err := l.Allow(ctx, "login", rule, "0901234567")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Positive(t, res.RetryAfter)
+
+	// other subjects have their own quota
+	res, err = l.Allow(ctx, "login", rule, "0907654321")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// half way into the next window half of the 4 earlier requests still count
+	now = now.Add(90 * time.Second)
+	res, err = l.Allow(ctx, "login", rule, "0901234567")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.EqualValues(t, 0, res.Remaining)
+
+	res, err = l.Allow(ctx, "login", rule, "0901234567")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	now = now.Add(2 * time.Minute)
+	res, err = l.Allow(ctx, "login", rule, "0901234567")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.EqualValues(t, 2, res.Remaining)
+	assert.Equal(t, "3;w=60", res.Rule.Policy())
+}
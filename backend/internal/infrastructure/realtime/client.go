@@ -0,0 +1,119 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Client is one websocket or SSE connection. Only the connection's writer writes to
+// it, everything else goes through the send buffer
+type Client struct {
+	hub   *Hub
+	scope Scope
+	send  chan outbound
+
+	// nil for SSE clients
+	conn *websocket.Conn
+}
+
+func newClient(h *Hub, scope Scope) *Client {
+	return &Client{
+		hub:   h,
+		scope: scope,
+		send:  make(chan outbound, sendBufferSize),
+	}
+}
+
+// ServeWS upgrades the request and blocks until the client goes away. The current queue
+// is sent right after the upgrade, the client does not have to ask for it. A client
+// reconnecting with ?since=<seq> only gets the events it missed
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, scope Scope) error {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+
+	c := newClient(h, scope)
+	c.conn = conn
+	h.register(c)
+	go c.writePump()
+
+	if since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err == nil && since > 0 {
+		h.resume(r.Context(), c, since)
+	} else {
+		h.sendSnapshot(r.Context(), c)
+	}
+	c.readPump(r.Context())
+	return nil
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// unregistered by the hub
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				logrus.Errorf("write error: %s", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump keeps the read deadline alive with pongs and answers client requests,
+// it returns once the connection is gone
+func (c *Client) readPump(ctx context.Context) {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logrus.Errorf("read error: %s", err)
+			}
+			return
+		}
+
+		var req clientRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			continue
+		}
+		switch req.Type {
+		case MessageGetQueues:
+			c.hub.sendSnapshot(ctx, c)
+		case MessageResume:
+			c.hub.resume(ctx, c, req.Since)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package realtime
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 4096
+	sendBufferSize = 256
+)
+
+// message types understood by the dashboards, change events use their own type
+const (
+	MessageQueueList = "queue_list"
+	MessageError     = "error"
+
+	// sent by clients
+	MessageGetQueues = "get_queues"
+	MessageResume    = "resume"
+)
+
+type Message struct {
+	Type    string      `json:"type"`
+	Seq     int64       `json:"seq,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// clientRequest is what a dashboard sends, Since is the last seq it applied
+type clientRequest struct {
+	Type  string `json:"type"`
+	Since int64  `json:"since"`
+}
+
+// Feed is the source of the live queue, see queuefeedusecase
+type Feed interface {
+	Snapshot(ctx context.Context) (*dtoqueue.QueueSnapshot, error)
+	Since(ctx context.Context, seq int64) ([]*dtoqueue.QueueEvent, bool, error)
+}
+
+// Scope limits what a client receives, the zero value sees the whole queue
+type Scope struct {
+	// only the bookings of this patient
+	PatientId string
+	// only the bookings of this faculty
+	FacultyId byte
+	// a waiting-room board of FacultyId, of a single room of it when Room is set. It gets
+	// the rendered board with masked names instead of the queue
+	Display bool
+	Room    string
+}
+
+// affects reports whether ev changes what a display board shows
+func (s Scope) affects(ev *dtoqueue.QueueEvent) bool {
+	return s.Display && (ev.FacultyId == s.FacultyId || ev.Type == dtoqueue.QueueReordered)
+}
+
+// displayView is the board after ev, preceded by a chime when ev calls a ticket to the board
+func (s Scope) displayView(ev *dtoqueue.QueueEvent, snap *dtoqueue.QueueSnapshot) []outbound {
+	if snap == nil || !s.affects(ev) {
+		return nil
+	}
+
+	var out []outbound
+	if ev.Type == dtoqueue.QueueCalled && ev.Queue != nil && (s.Room == "" || s.Room == ev.Room) {
+		chime, err := json.Marshal(dtoqueue.DisplayChimeEvent{
+			Seq:    ev.Seq,
+			Type:   dtoqueue.DisplayChime,
+			Ticket: dtoqueue.NewDisplayTicket(ev.Queue),
+		})
+		if err == nil {
+			out = append(out, outbound{seq: ev.Seq, kind: dtoqueue.DisplayChime, data: chime})
+		}
+	}
+	if msg := boardMessage(snap, s, ev.Seq); msg.data != nil {
+		out = append(out, msg)
+	}
+	return out
+}
+
+// view returns what a client of this scope receives for ev. Staff get the event as
+// published, with the positions of other faculties left out for a faculty scope. A
+// patient gets the events of their own bookings without anyone else's positions, and a
+// position update, or a "you're next" once at the front, when their booking moves up
+func (s Scope) view(ev *dtoqueue.QueueEvent, data []byte) []outbound {
+	if s.PatientId != "" {
+		return s.patientView(ev)
+	}
+	if s.FacultyId == 0 {
+		return []outbound{{seq: ev.Seq, kind: ev.Type, data: data}}
+	}
+	if ev.FacultyId != s.FacultyId && ev.Type != dtoqueue.QueueReordered {
+		return nil
+	}
+
+	filtered := *ev
+	filtered.Positions = nil
+	for _, p := range ev.Positions {
+		if p.FacultyId == s.FacultyId {
+			filtered.Positions = append(filtered.Positions, p)
+		}
+	}
+	if len(filtered.Positions) == len(ev.Positions) {
+		return []outbound{{seq: ev.Seq, kind: ev.Type, data: data}}
+	}
+	return encodeEvents(&filtered)
+}
+
+func (s Scope) patientView(ev *dtoqueue.QueueEvent) []outbound {
+	var mine []*dtoqueue.QueuePosition
+	for _, p := range ev.Positions {
+		if p.PatientId == s.PatientId {
+			mine = append(mine, p)
+		}
+	}
+
+	var events []*dtoqueue.QueueEvent
+	if ev.PatientId == s.PatientId {
+		own := *ev
+		own.Positions = nil
+		own.Order = nil
+		for _, p := range mine {
+			if p.QueueId == ev.QueueId {
+				own.Position = p.Position
+			}
+		}
+		events = append(events, &own)
+	}
+	for _, p := range mine {
+		kind := dtoqueue.QueuePositionChanged
+		if p.Position == 1 {
+			kind = dtoqueue.QueueNext
+		}
+		events = append(events, &dtoqueue.QueueEvent{
+			Seq:        ev.Seq,
+			Type:       kind,
+			QueueId:    p.QueueId,
+			PatientId:  p.PatientId,
+			FacultyId:  p.FacultyId,
+			Position:   p.Position,
+			OccurredAt: ev.OccurredAt,
+		})
+	}
+	return encodeEvents(events...)
+}
+
+func encodeEvents(events ...*dtoqueue.QueueEvent) []outbound {
+	out := make([]outbound, 0, len(events))
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logrus.Errorf("Failed to encode queue event: %v", err)
+			continue
+		}
+		out = append(out, outbound{seq: ev.Seq, kind: ev.Type, data: data})
+	}
+	return out
+}
+
+func (s Scope) allowsQueue(q *dtoqueue.BookingQueueResponse) bool {
+	if s.PatientId != "" {
+		return q.PatientId.String() == s.PatientId
+	}
+	if s.FacultyId != 0 {
+		return q.FacultyId == s.FacultyId
+	}
+	return true
+}
+
+// outbound is a message queued for one client, seq is the last event it reflects
+type outbound struct {
+	seq  int64
+	kind string
+	data []byte
+}
+
+// Hub fans the live queue out to every websocket and SSE client of this process. It holds
+// a single Redis subscription, so every backend instance sees the same updates no matter
+// which one handled the change
+type Hub struct {
+	redis    redis.RedisConnection
+	channel  string
+	feed     Feed
+	upgrader websocket.Upgrader
+
+	// last event broadcast, only touched by Run
+	lastSeq int64
+
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+// NewHub serves the sockets to pages of allowedOrigins, the CORS allow-list of the API.
+// The sockets sign in with the session cookie, any other site could otherwise open one
+// on behalf of a signed in user
+func NewHub(rc redis.RedisConnection, channel string, feed Feed, allowedOrigins []string) *Hub {
+	return &Hub{
+		redis:   rc,
+		channel: channel,
+		feed:    feed,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin(allowedOrigins),
+		},
+		clients: make(map[*Client]struct{}),
+	}
+}
+
+// checkOrigin accepts requests without an Origin, which browsers always send, pages of
+// the server itself and pages of allowedOrigins
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if slices.Contains(allowedOrigins, origin) {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		logrus.Warnf("Refused realtime socket from origin %s", origin)
+		return false
+	}
+}
+
+// Run listens on the Redis channel until ctx is cancelled, then disconnects every client
+func (h *Hub) Run(ctx context.Context) error {
+	sub := h.redis.SubChannel(ctx, h.channel)
+	defer sub.Close()
+
+	if err := sub.Receive(ctx); err != nil {
+		logrus.Errorf("Failed to subscribe to %s: %v", h.channel, err)
+		return err
+	}
+	logrus.Infof("Realtime hub subscribed to %s", h.channel)
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			h.Close()
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				h.Close()
+				return nil
+			}
+			h.handleEvent(ctx, msg.Payload)
+		}
+	}
+}
+
+// handleEvent broadcasts events in sequence order. Pub/sub gives no ordering between
+// publishers, so a gap is filled from the event log and a lost one falls back to a snapshot
+func (h *Hub) handleEvent(ctx context.Context, payload string) {
+	var ev dtoqueue.QueueEvent
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil || ev.Seq == 0 {
+		logrus.Warnf("Realtime hub: unexpected payload on %s", h.channel)
+		return
+	}
+
+	switch {
+	case ev.Seq <= h.lastSeq:
+		// already sent while filling a gap
+		return
+	case h.lastSeq == 0 || ev.Seq == h.lastSeq+1:
+		h.broadcastEvent(ctx, &ev, []byte(payload))
+		h.lastSeq = ev.Seq
+		return
+	}
+
+	missed, ok, err := h.feed.Since(ctx, h.lastSeq)
+	if err != nil || !ok {
+		h.broadcastSnapshot(ctx, ev.Seq)
+		return
+	}
+	for _, m := range missed {
+		data, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		h.broadcastEvent(ctx, m, data)
+		h.lastSeq = m.Seq
+	}
+}
+
+func (h *Hub) broadcastEvent(ctx context.Context, ev *dtoqueue.QueueEvent, data []byte) {
+	// boards are rendered from the whole queue, read it once and outside the lock
+	var snap *dtoqueue.QueueSnapshot
+	if h.displaysAffected(ev) {
+		var err error
+		if snap, err = h.feed.Snapshot(ctx); err != nil {
+			logrus.Errorf("Failed to build queue snapshot: %v", err)
+		}
+	}
+
+	// clients with the same scope share one view
+	views := map[Scope][]outbound{}
+	h.deliver(func(c *Client) []outbound {
+		msgs, ok := views[c.scope]
+		if !ok {
+			if c.scope.Display {
+				msgs = c.scope.displayView(ev, snap)
+			} else {
+				msgs = c.scope.view(ev, data)
+			}
+			views[c.scope] = msgs
+		}
+		return msgs
+	})
+}
+
+func (h *Hub) displaysAffected(ev *dtoqueue.QueueEvent) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if c.scope.affects(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) broadcastSnapshot(ctx context.Context, seq int64) {
+	snap, err := h.feed.Snapshot(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to build queue snapshot: %v", err)
+		return
+	}
+
+	// clients with the same scope share one encoded snapshot
+	encoded := map[Scope]outbound{}
+	h.deliver(func(c *Client) []outbound {
+		msg, ok := encoded[c.scope]
+		if !ok {
+			msg = snapshotMessage(snap, c.scope)
+			encoded[c.scope] = msg
+		}
+		if msg.data == nil {
+			return nil
+		}
+		return []outbound{msg}
+	})
+	h.lastSeq = max(seq, snap.Seq)
+}
+
+// deliver queues the messages pick returns for every client. A client whose buffer is
+// full is too slow to keep up and gets disconnected, it resyncs when it reconnects
+func (h *Hub) deliver(pick func(c *Client) []outbound) {
+	var slow []*Client
+
+	h.mu.RLock()
+	for c := range h.clients {
+	queue:
+		for _, msg := range pick(c) {
+			select {
+			case c.send <- msg:
+			default:
+				slow = append(slow, c)
+				break queue
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		logrus.Warn("Realtime hub: evicting slow client")
+		h.unregister(c)
+	}
+}
+
+func (h *Hub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+func (h *Hub) register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+// unregister is safe to call more than once, closing send stops the client's writer
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Close disconnects every client, streams end so the HTTP server can shut down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// sendTo queues msg for a single client, same eviction rule as deliver
+func (h *Hub) sendTo(c *Client, msg outbound) {
+	h.mu.RLock()
+	_, ok := h.clients[c]
+	delivered := false
+	if ok {
+		select {
+		case c.send <- msg:
+			delivered = true
+		default:
+		}
+	}
+	h.mu.RUnlock()
+
+	if ok && !delivered {
+		h.unregister(c)
+	}
+}
+
+func (h *Hub) sendSnapshot(ctx context.Context, c *Client) {
+	snap, err := h.feed.Snapshot(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to build queue snapshot: %v", err)
+		data, _ := json.Marshal(Message{Type: MessageError, Message: "failed to load queue"})
+		h.sendTo(c, outbound{kind: MessageError, data: data})
+		return
+	}
+	if msg := snapshotMessage(snap, c.scope); msg.data != nil {
+		h.sendTo(c, msg)
+	}
+}
+
+// resume sends the events after since, or a snapshot when they are no longer retained.
+// A board only needs its current state
+func (h *Hub) resume(ctx context.Context, c *Client, since int64) {
+	if c.scope.Display {
+		h.sendSnapshot(ctx, c)
+		return
+	}
+
+	events, ok, err := h.feed.Since(ctx, since)
+	if err != nil || !ok {
+		h.sendSnapshot(ctx, c)
+		return
+	}
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		for _, msg := range c.scope.view(ev, data) {
+			h.sendTo(c, msg)
+		}
+	}
+}
+
+func snapshotMessage(snap *dtoqueue.QueueSnapshot, scope Scope) outbound {
+	if scope.Display {
+		return boardMessage(snap, scope, snap.Seq)
+	}
+
+	queues := make([]*dtoqueue.BookingQueueResponse, 0, len(snap.Queues))
+	for _, q := range snap.Queues {
+		if scope.allowsQueue(q) {
+			queues = append(queues, q)
+		}
+	}
+	data, err := json.Marshal(Message{Type: MessageQueueList, Seq: snap.Seq, Data: queues})
+	if err != nil {
+		logrus.Errorf("Failed to encode queue snapshot: %v", err)
+		return outbound{}
+	}
+	return outbound{seq: snap.Seq, kind: MessageQueueList, data: data}
+}
+
+func boardMessage(snap *dtoqueue.QueueSnapshot, scope Scope, seq int64) outbound {
+	board := dtoqueue.NewDisplayBoard(snap, scope.FacultyId, scope.Room)
+	board.Seq = seq
+	data, err := json.Marshal(Message{Type: dtoqueue.DisplayBoardUpdated, Seq: seq, Data: board})
+	if err != nil {
+		logrus.Errorf("Failed to encode display board: %v", err)
+		return outbound{}
+	}
+	return outbound{seq: seq, kind: dtoqueue.DisplayBoardUpdated, data: data}
+}
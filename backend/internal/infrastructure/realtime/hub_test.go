@@ -0,0 +1,397 @@
+package realtime
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/infrastructure/redis"
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFeed struct {
+	seq    int64
+	queues []*dtoqueue.BookingQueueResponse
+	// retained events, oldest first
+	log []*dtoqueue.QueueEvent
+}
+
+func (f *fakeFeed) Snapshot(ctx context.Context) (*dtoqueue.QueueSnapshot, error) {
+	return &dtoqueue.QueueSnapshot{Seq: f.seq, Queues: f.queues}, nil
+}
+
+func (f *fakeFeed) Since(ctx context.Context, seq int64) ([]*dtoqueue.QueueEvent, bool, error) {
+	if seq == f.seq {
+		return nil, true, nil
+	}
+	if len(f.log) == 0 || f.log[0].Seq > seq+1 {
+		return nil, false, nil
+	}
+	var out []*dtoqueue.QueueEvent
+	for _, ev := range f.log {
+		if ev.Seq > seq {
+			out = append(out, ev)
+		}
+	}
+	return out, true, nil
+}
+
+func (f *fakeFeed) add(queueId int) *dtoqueue.QueueEvent {
+	f.seq++
+	ev := &dtoqueue.QueueEvent{Seq: f.seq, Type: dtoqueue.QueueAdded, QueueId: queueId}
+	f.log = append(f.log, ev)
+	return ev
+}
+
+func newTestHub(feed *fakeFeed) (*Hub, *httptest.Server) {
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", feed, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = hub.ServeWS(w, r, Scope{})
+	}))
+	return hub, srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	var msg map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestHub_SnapshotOnConnectAndBroadcast(t *testing.T) {
+	feed := &fakeFeed{seq: 7, queues: []*dtoqueue.BookingQueueResponse{{QueueId: 1}, {QueueId: 2}}}
+	hub, srv := newTestHub(feed)
+	defer srv.Close()
+
+	conn := dial(t, srv, "")
+	defer conn.Close()
+
+	// no request needed for the first snapshot
+	msg := readMessage(t, conn)
+	assert.Equal(t, MessageQueueList, msg["type"])
+	assert.EqualValues(t, 7, msg["seq"])
+	assert.Len(t, msg["data"], 2)
+
+	removed := &dtoqueue.QueueEvent{Seq: 8, Type: dtoqueue.QueueRemoved, QueueId: 1}
+	hub.broadcastEvent(context.Background(), removed, []byte(mustJSON(removed)))
+	msg = readMessage(t, conn)
+	assert.Equal(t, dtoqueue.QueueRemoved, msg["type"])
+
+	require.NoError(t, conn.WriteJSON(clientRequest{Type: MessageGetQueues}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, MessageQueueList, msg["type"])
+
+	conn.Close()
+	require.Eventually(t, func() bool { return hub.ClientCount() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestHub_FillsGapsInOrder(t *testing.T) {
+	feed := &fakeFeed{}
+	hub, srv := newTestHub(feed)
+	defer srv.Close()
+
+	conn := dial(t, srv, "")
+	defer conn.Close()
+	readMessage(t, conn)
+
+	first := feed.add(10)
+	second := feed.add(11)
+	third := feed.add(12)
+
+	hub.handleEvent(context.Background(), mustJSON(first))
+	// the third event overtook the second one on the channel
+	hub.handleEvent(context.Background(), mustJSON(third))
+	hub.handleEvent(context.Background(), mustJSON(second))
+
+	for _, want := range []int64{1, 2, 3} {
+		msg := readMessage(t, conn)
+		assert.EqualValues(t, want, msg["seq"])
+	}
+	assert.EqualValues(t, 3, hub.lastSeq)
+
+	// the log lost events 4 and 5, clients get a snapshot instead
+	feed.add(13)
+	feed.add(14)
+	sixth := feed.add(15)
+	feed.log = feed.log[len(feed.log)-1:]
+	hub.handleEvent(context.Background(), mustJSON(sixth))
+
+	msg := readMessage(t, conn)
+	assert.Equal(t, MessageQueueList, msg["type"])
+	assert.EqualValues(t, 6, hub.lastSeq)
+}
+
+func TestHub_ResumeSendsMissedEventsOnly(t *testing.T) {
+	feed := &fakeFeed{}
+	for i := 1; i <= 4; i++ {
+		feed.add(i)
+	}
+	_, srv := newTestHub(feed)
+	defer srv.Close()
+
+	conn := dial(t, srv, "?since=2")
+	defer conn.Close()
+	for _, want := range []int64{3, 4} {
+		msg := readMessage(t, conn)
+		assert.Equal(t, dtoqueue.QueueAdded, msg["type"])
+		assert.EqualValues(t, want, msg["seq"])
+	}
+
+	// too far behind
+	feed.log = feed.log[2:]
+	require.NoError(t, conn.WriteJSON(clientRequest{Type: MessageResume, Since: 1}))
+	msg := readMessage(t, conn)
+	assert.Equal(t, MessageQueueList, msg["type"])
+}
+
+func TestHub_EvictsSlowClient(t *testing.T) {
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", &fakeFeed{}, nil)
+	slow := &Client{hub: hub, send: make(chan outbound, 2)}
+	hub.register(slow)
+
+	ev := &dtoqueue.QueueEvent{Seq: 1, Type: dtoqueue.QueueReordered}
+	for i := 0; i < 3; i++ {
+		hub.broadcastEvent(context.Background(), ev, []byte(mustJSON(ev)))
+	}
+
+	assert.Equal(t, 0, hub.ClientCount())
+	// buffered messages are still drained, then the channel reports closed
+	<-slow.send
+	<-slow.send
+	_, ok := <-slow.send
+	assert.False(t, ok)
+
+	// a late unregister from the client's reader is a no-op
+	hub.unregister(slow)
+}
+
+func TestHub_SSEScopedToPatientWithResume(t *testing.T) {
+	patientId := uuid.New()
+	other := uuid.New()
+	feed := &fakeFeed{queues: []*dtoqueue.BookingQueueResponse{
+		{QueueId: 1, PatientId: patientId},
+		{QueueId: 2, PatientId: other},
+	}}
+	feed.seq = 4
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", feed, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = hub.ServeSSE(w, r, Scope{PatientId: patientId.String()})
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := bufio.NewReader(resp.Body)
+
+	id, kind, data := readSSE(t, events)
+	assert.Equal(t, "4", id)
+	assert.Equal(t, MessageQueueList, kind)
+	assert.Contains(t, data, patientId.String())
+	assert.NotContains(t, data, other.String())
+
+	for _, ev := range []*dtoqueue.QueueEvent{
+		{Seq: 5, Type: dtoqueue.QueueStatusChanged, QueueId: 2, PatientId: other.String()},
+		{Seq: 6, Type: dtoqueue.QueueStatusChanged, QueueId: 1, PatientId: patientId.String()},
+	} {
+		feed.seq = ev.Seq
+		feed.log = append(feed.log, ev)
+		hub.handleEvent(context.Background(), mustJSON(ev))
+	}
+
+	// the other patient's change never reaches this stream
+	id, kind, _ = readSSE(t, events)
+	assert.Equal(t, "6", id)
+	assert.Equal(t, dtoqueue.QueueStatusChanged, kind)
+
+	// a reconnecting EventSource only gets what it missed
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "4")
+	resumed, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resumed.Body.Close()
+
+	id, kind, _ = readSSE(t, bufio.NewReader(resumed.Body))
+	assert.Equal(t, "6", id)
+	assert.Equal(t, dtoqueue.QueueStatusChanged, kind)
+}
+
+// readSSE returns the next event, skipping retry and comment lines
+func readSSE(t *testing.T, r *bufio.Reader) (id, kind, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && kind != "":
+			return id, kind, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			kind = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestHub_PatientViewHidesOtherPatients(t *testing.T) {
+	patientId := uuid.New().String()
+	other := uuid.New().String()
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", &fakeFeed{}, nil)
+	me := newClient(hub, Scope{PatientId: patientId})
+	doctor := newClient(hub, Scope{FacultyId: 2})
+	hub.register(me)
+	hub.register(doctor)
+
+	// the patient ahead is called, everyone behind moves up
+	called := &dtoqueue.QueueEvent{
+		Seq: 1, Type: dtoqueue.QueueCalled, QueueId: 1, PatientId: other, FacultyId: 2, Room: "204",
+		Positions: []*dtoqueue.QueuePosition{
+			{QueueId: 2, PatientId: patientId, FacultyId: 2, Position: 1},
+			{QueueId: 3, PatientId: other, FacultyId: 2, Position: 2},
+			{QueueId: 9, PatientId: other, FacultyId: 5, Position: 1},
+		},
+	}
+	hub.broadcastEvent(context.Background(), called, []byte(mustJSON(called)))
+
+	msg := <-me.send
+	assert.Equal(t, dtoqueue.QueueNext, msg.kind)
+	assert.EqualValues(t, 1, msg.seq)
+	assert.NotContains(t, string(msg.data), other)
+	assert.Empty(t, me.send)
+
+	msg = <-doctor.send
+	assert.Equal(t, dtoqueue.QueueCalled, msg.kind)
+	var seen dtoqueue.QueueEvent
+	require.NoError(t, json.Unmarshal(msg.data, &seen))
+	assert.Len(t, seen.Positions, 2)
+
+	// then it's their turn
+	mine := &dtoqueue.QueueEvent{
+		Seq: 2, Type: dtoqueue.QueueCalled, QueueId: 2, PatientId: patientId, FacultyId: 2, Room: "204",
+		Positions: []*dtoqueue.QueuePosition{{QueueId: 3, PatientId: other, FacultyId: 2, Position: 1}},
+	}
+	hub.broadcastEvent(context.Background(), mine, []byte(mustJSON(mine)))
+
+	msg = <-me.send
+	assert.Equal(t, dtoqueue.QueueCalled, msg.kind)
+	var own dtoqueue.QueueEvent
+	require.NoError(t, json.Unmarshal(msg.data, &own))
+	assert.Equal(t, "204", own.Room)
+	assert.Empty(t, own.Positions)
+	assert.Empty(t, me.send)
+}
+
+func TestHub_DisplayBoardMasksNamesAndChimes(t *testing.T) {
+	calledAt := time.Now()
+	feed := &fakeFeed{seq: 3, queues: []*dtoqueue.BookingQueueResponse{
+		{QueueId: 12, FacultyId: 3, PatientName: "Nguyen Van A", Room: "101", CalledAt: &calledAt},
+		{QueueId: 14, FacultyId: 3, PatientName: "Tran Thi Bich", Position: 1},
+		{QueueId: 15, FacultyId: 4, PatientName: "Le Van C", Position: 1},
+	}}
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", feed, nil)
+	board := newClient(hub, Scope{FacultyId: 3, Display: true})
+	otherBoard := newClient(hub, Scope{FacultyId: 4, Display: true})
+	hub.register(board)
+	hub.register(otherBoard)
+
+	hub.sendSnapshot(context.Background(), board)
+	msg := <-board.send
+	assert.Equal(t, dtoqueue.DisplayBoardUpdated, msg.kind)
+	var frame struct {
+		Data dtoqueue.DisplayBoard `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(msg.data, &frame))
+	require.Len(t, frame.Data.Serving, 1)
+	assert.Equal(t, "0012", frame.Data.Serving[0].Ticket)
+	assert.Equal(t, "Ng*** V** A", frame.Data.Serving[0].PatientName)
+	require.Len(t, frame.Data.Next, 1)
+	assert.Equal(t, "Tr** T** Bi**", frame.Data.Next[0].PatientName)
+	assert.Equal(t, []string{"101"}, frame.Data.Rooms)
+	assert.NotContains(t, string(msg.data), "Nguyen")
+
+	called := &dtoqueue.QueueEvent{
+		Seq: 4, Type: dtoqueue.QueueCalled, QueueId: 14, FacultyId: 3, Room: "102",
+		Queue: &dtoqueue.BookingQueueResponse{QueueId: 14, FacultyId: 3, PatientName: "Tran Thi Bich", Room: "102"},
+	}
+	hub.broadcastEvent(context.Background(), called, []byte(mustJSON(called)))
+
+	msg = <-board.send
+	assert.Equal(t, dtoqueue.DisplayChime, msg.kind)
+	assert.Contains(t, string(msg.data), `"ticket":"0014"`)
+	assert.NotContains(t, string(msg.data), "Tran")
+	msg = <-board.send
+	assert.Equal(t, dtoqueue.DisplayBoardUpdated, msg.kind)
+	assert.EqualValues(t, 4, msg.seq)
+
+	// another faculty's board is left alone
+	assert.Empty(t, otherBoard.send)
+}
+
+func TestHub_RunRelaysPublishedEvents(t *testing.T) {
+	store := redis.NewMemoryStore()
+	hub := NewHub(store, "queue:update", &fakeFeed{}, nil)
+	c := newClient(hub, Scope{})
+	hub.register(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- hub.Run(ctx) }()
+
+	ev := &dtoqueue.QueueEvent{Seq: 1, Type: dtoqueue.QueueAdded, QueueId: 3}
+	// the subscription may not be registered yet
+	require.Eventually(t, func() bool {
+		require.NoError(t, store.Publish(context.Background(), "queue:update", mustJSON(ev)))
+		return len(c.send) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	msg := <-c.send
+	assert.Equal(t, dtoqueue.QueueAdded, msg.kind)
+
+	cancel()
+	require.NoError(t, <-done)
+	// shutting down disconnects the clients
+	assert.Equal(t, 0, hub.ClientCount())
+}
+
+func TestHub_RefusesSocketsFromOtherSites(t *testing.T) {
+	hub := NewHub(redis.NewMemoryStore(), "queue:update", &fakeFeed{}, []string{"https://clinic.test"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = hub.ServeWS(w, r, Scope{})
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// the cookie would sign the socket in, the origin decides
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.test"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	for _, origin := range []string{"https://clinic.test", srv.URL} {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		require.NoError(t, err, origin)
+		conn.Close()
+	}
+}
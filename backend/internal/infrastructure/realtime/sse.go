@@ -0,0 +1,95 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseRetry tells EventSource how long to wait before reconnecting
+const sseRetry = 3 * time.Second
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// ServeSSE streams the same messages as ServeWS as Server-Sent Events, for clients behind
+// proxies that break websockets. Every message carries the sequence it reflects as its id,
+// so a reconnecting EventSource resumes through Last-Event-ID. It blocks until the client
+// goes away
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request, scope Scope) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// nginx buffers responses by default
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+
+	ctx := r.Context()
+	c := newClient(h, scope)
+	h.register(c)
+	defer h.unregister(c)
+
+	if since := lastEventId(r); since > 0 {
+		h.resume(ctx, c, since)
+	} else {
+		h.sendSnapshot(ctx, c)
+	}
+
+	keepalive := time.NewTicker(pingPeriod)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-c.send:
+			if !ok {
+				// evicted or shutting down, the browser reconnects with its last id
+				return nil
+			}
+			if err := writeEvent(w, msg); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			// a comment line keeps idle proxies from closing the stream
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// lastEventId reads the resume point, EventSource sends the header on reconnect and the
+// query parameter lets a page resume across reloads
+func lastEventId(r *http.Request) int64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("since")
+	}
+	since, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || since < 0 {
+		return 0
+	}
+	return since
+}
+
+func writeEvent(w http.ResponseWriter, msg outbound) error {
+	if msg.seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", msg.seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.kind, msg.data)
+	return err
+}
@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("lock is held by someone else")
+	ErrLockLost        = errors.New("lock expired before it was released")
+)
+
+// lockRetryInterval is the base wait between attempts on a held lock, jittered so
+// waiters don't retry in lockstep
+const lockRetryInterval = 50 * time.Millisecond
+
+// Locker hands out named locks shared by every instance using the same Redis. A lock
+// expires after its TTL, so a crashed holder cannot block the others for longer, and each
+// acquisition comes with a fencing token: tokens of a name only grow, so a store that
+// remembers the last token it accepted can refuse writes from a holder whose lock
+// already expired and was taken over
+type Locker struct {
+	redis RedisConnection
+}
+
+func NewLocker(rc RedisConnection) *Locker {
+	return &Locker{redis: rc}
+}
+
+// Lock is a held lock
+type Lock struct {
+	locker    *Locker
+	name      string
+	owner     string
+	Token     int64
+	ExpiresAt time.Time
+}
+
+func lockKey(name string) string {
+	return "lock:" + name
+}
+
+func fenceKey(name string) string {
+	return "lock:" + name + ":fence"
+}
+
+// TryAcquire takes the lock or fails with ErrLockNotAcquired straight away
+func (l *Locker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, err
+	}
+	return l.tryAcquire(ctx, name, owner, ttl)
+}
+
+// Acquire waits for the lock until ctx is done
+func (l *Locker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		lock, err := l.tryAcquire(ctx, name, owner, ttl)
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return lock, err
+		}
+
+		wait := lockRetryInterval/2 + mrand.N(lockRetryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock %s: %w", name, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (l *Locker) tryAcquire(ctx context.Context, name, owner string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl %s for lock %s", ttl, name)
+	}
+	acquired, err := l.redis.SetNX(ctx, lockKey(name), owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, fmt.Errorf("lock %s: %w", name, ErrLockNotAcquired)
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	// the token is drawn once the lock is ours, drawing it before would let a waiter
+	// that lost the race to a later token win the lock afterwards with an older one
+	token, err := l.redis.Incr(ctx, fenceKey(name))
+	if err != nil {
+		_, _ = l.redis.DelIfEqual(context.WithoutCancel(ctx), lockKey(name), owner)
+		return nil, err
+	}
+	return &Lock{locker: l, name: name, owner: owner, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// Held reports whether the lock is still ours
+func (lk *Lock) Held(ctx context.Context) (bool, error) {
+	v, err := lk.locker.redis.Get(ctx, lockKey(lk.name))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v == lk.owner, nil
+}
+
+// Release frees the lock, ErrLockLost means it had expired and may have been taken over
+func (lk *Lock) Release(ctx context.Context) error {
+	released, err := lk.locker.redis.DelIfEqual(ctx, lockKey(lk.name), lk.owner)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("lock %s: %w", lk.name, ErrLockLost)
+	}
+	return nil
+}
+
+// WithLock runs fn holding the lock. fn's context carries the fencing token and is
+// cancelled when the lock expires, work that outlives the lock is abandoned instead of
+// overlapping with the next holder
+func (l *Locker) WithLock(ctx context.Context, name string, ttl time.Duration, fn func(ctx context.Context) error) error {
+	lock, err := l.Acquire(ctx, name, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// released even when ctx was cancelled
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			logrus.Warnf("Failed to release lock %s: %v", name, err)
+		}
+	}()
+
+	lockCtx, cancel := context.WithDeadline(ContextWithFence(ctx, lock), lock.ExpiresAt)
+	defer cancel()
+	return fn(lockCtx)
+}
+
+func newOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type fencesKey struct{}
+
+// ContextWithFence records the lock's fencing token in ctx for the stores written under it
+func ContextWithFence(ctx context.Context, lock *Lock) context.Context {
+	fences := map[string]int64{lock.name: lock.Token}
+	if parent, ok := ctx.Value(fencesKey{}).(map[string]int64); ok {
+		for name, token := range parent {
+			if _, ok := fences[name]; !ok {
+				fences[name] = token
+			}
+		}
+	}
+	return context.WithValue(ctx, fencesKey{}, fences)
+}
+
+// FenceFromContext returns the fencing token of the named lock when ctx runs under it
+func FenceFromContext(ctx context.Context, name string) (int64, bool) {
+	fences, ok := ctx.Value(fencesKey{}).(map[string]int64)
+	if !ok {
+		return 0, false
+	}
+	token, ok := fences[name]
+	return token, ok
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocker_FencingAndExpiry(t *testing.T) {
+	l := NewLocker(NewMemoryStore())
+	ctx := context.Background()
+
+	first, err := l.TryAcquire(ctx, "booking:7", 30*time.Millisecond)
+	require.NoError(t, err)
+	_, err = l.TryAcquire(ctx, "booking:7", time.Second)
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+
+	// a waiter gets the lock once the holder's expires, with a newer token
+	second, err := l.Acquire(ctx, "booking:7", time.Second)
+	require.NoError(t, err)
+	assert.Greater(t, second.Token, first.Token)
+
+	held, err := first.Held(ctx)
+	require.NoError(t, err)
+	assert.False(t, held)
+	assert.ErrorIs(t, first.Release(ctx), ErrLockLost)
+	held, err = second.Held(ctx)
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	// the waiter gives up with its context
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(waitCtx, "booking:7", time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, second.Release(ctx))
+
+	err = l.WithLock(ctx, "booking:7", time.Second, func(ctx context.Context) error {
+		token, ok := FenceFromContext(ctx, "booking:7")
+		assert.True(t, ok)
+		assert.Greater(t, token, second.Token)
+		_, ok = FenceFromContext(ctx, "booking:8")
+		assert.False(t, ok)
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// released on return
+	lock, err := l.TryAcquire(ctx, "booking:7", time.Second)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
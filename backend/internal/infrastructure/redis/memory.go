@@ -0,0 +1,515 @@
+package redis
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// subscriptionBuffer matches go-redis, a subscriber further behind loses messages
+const subscriptionBuffer = 100
+
+var _ RedisConnection = (*MemoryStore)(nil)
+
+// MemoryStore is an in-process RedisConnection for a single instance and for tests. It
+// keeps strings, hashes and sorted sets with key expiry, and fans published messages out
+// to the subscriptions of this process only. Everything is lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+	subs    map[string]map[*memSubscription]struct{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		strings: map[string]string{},
+		hashes:  map[string]map[string]string{},
+		zsets:   map[string]map[string]float64{},
+		expires: map[string]time.Time{},
+		subs:    map[string]map[*memSubscription]struct{}{},
+	}
+}
+
+// expireLocked drops key if its TTL passed, expiry is checked lazily on access
+func (m *MemoryStore) expireLocked(key string) {
+	if at, ok := m.expires[key]; ok && !time.Now().Before(at) {
+		m.deleteLocked(key)
+	}
+}
+
+func (m *MemoryStore) deleteLocked(key string) bool {
+	_, s := m.strings[key]
+	_, h := m.hashes[key]
+	_, z := m.zsets[key]
+	delete(m.strings, key)
+	delete(m.hashes, key)
+	delete(m.zsets, key)
+	delete(m.expires, key)
+	return s || h || z
+}
+
+func (m *MemoryStore) existsLocked(key string) bool {
+	m.expireLocked(key)
+	_, s := m.strings[key]
+	_, h := m.hashes[key]
+	_, z := m.zsets[key]
+	return s || h || z
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	v, err := toString(value)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteLocked(key)
+	m.strings[key] = v
+	if exp > 0 {
+		m.expires[key] = time.Now().Add(exp)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error) {
+	v, err := toString(value)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.existsLocked(key) {
+		return false, nil
+	}
+	m.strings[key] = v
+	if exp > 0 {
+		m.expires[key] = time.Now().Add(exp)
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(key)
+	v, ok := m.strings[key]
+	if !ok {
+		return "", fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) Del(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		m.deleteLocked(key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) DelIfEqual(ctx context.Context, key string, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(key)
+	if v, ok := m.strings[key]; !ok || v != value {
+		return false, nil
+	}
+	m.deleteLocked(key)
+	return true, nil
+}
+
+func (m *MemoryStore) Exists(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if m.existsLocked(key) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(key)
+
+	var n int64
+	if v, ok := m.strings[key]; ok {
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("failed to increment %s: value is not an integer", key)
+		}
+	}
+	n++
+	m.strings[key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (m *MemoryStore) Expire(ctx context.Context, key string, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.existsLocked(key) {
+		return nil
+	}
+	if exp <= 0 {
+		m.deleteLocked(key)
+		return nil
+	}
+	m.expires[key] = time.Now().Add(exp)
+	return nil
+}
+
+func (m *MemoryStore) hashLocked(key string, create bool) map[string]string {
+	m.expireLocked(key)
+	h, ok := m.hashes[key]
+	if !ok && create {
+		h = map[string]string{}
+		m.hashes[key] = h
+	}
+	return h
+}
+
+func (m *MemoryStore) HSet(ctx context.Context, key string, field string, value interface{}) (int64, error) {
+	v, err := toString(value)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hashLocked(key, true)
+	_, existed := h[field]
+	h[field] = v
+	if existed {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *MemoryStore) HGet(ctx context.Context, key string, field string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.hashLocked(key, false)[field]
+	if !ok {
+		return "", fmt.Errorf("field %s in hash %s: %w", field, key, ErrNotFound)
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hashLocked(key, false)
+	res := make(map[string]string, len(h))
+	for f, v := range h {
+		res[f] = v
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) HIncrBy(ctx context.Context, key string, field string, incr int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hashLocked(key, true)
+
+	var n int64
+	if v, ok := h[field]; ok {
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("failed to increment hash field: value is not an integer")
+		}
+	}
+	n += incr
+	h[field] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (m *MemoryStore) HDel(ctx context.Context, key string, fields ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hashLocked(key, false)
+	for _, f := range fields {
+		delete(h, f)
+	}
+	if h != nil && len(h) == 0 {
+		m.deleteLocked(key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ZAdd(ctx context.Context, key string, members ...redis.Z) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(key)
+
+	z, ok := m.zsets[key]
+	if !ok {
+		z = map[string]float64{}
+		m.zsets[key] = z
+	}
+	for _, member := range members {
+		v, err := toString(member.Member)
+		if err != nil {
+			return err
+		}
+		z[v] = member.Score
+	}
+	return nil
+}
+
+func (m *MemoryStore) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(key)
+
+	z := m.zsets[key]
+	for _, member := range members {
+		v, err := toString(member)
+		if err != nil {
+			return err
+		}
+		delete(z, v)
+	}
+	if z != nil && len(z) == 0 {
+		m.deleteLocked(key)
+	}
+	return nil
+}
+
+// sortedLocked returns the set ordered like Redis, by score then member
+func (m *MemoryStore) sortedLocked(key string) []redis.Z {
+	m.expireLocked(key)
+	z := m.zsets[key]
+	res := make([]redis.Z, 0, len(z))
+	for member, score := range z {
+		res = append(res, redis.Z{Score: score, Member: member})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score < res[j].Score
+		}
+		return res[i].Member.(string) < res[j].Member.(string)
+	})
+	return res
+}
+
+// rankRange resolves Redis start/stop ranks, negative ones count from the end
+func rankRange(n int, start, stop int64) (int, int, bool) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	start = max(start, 0)
+	stop = min(stop, int64(n)-1)
+	if start > stop {
+		return 0, 0, false
+	}
+	return int(start), int(stop) + 1, true
+}
+
+func (m *MemoryStore) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sorted := m.sortedLocked(key)
+	from, to, ok := rankRange(len(sorted), start, stop)
+	if !ok {
+		return []redis.Z{}, nil
+	}
+	return sorted[from:to], nil
+}
+
+func (m *MemoryStore) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	members, err := m.ZRangeWithScores(ctx, key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(members))
+	for i, z := range members {
+		res[i] = z.Member.(string)
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	above, err := parseScoreBound(min, true)
+	if err != nil {
+		return nil, err
+	}
+	below, err := parseScoreBound(max, false)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []string{}
+	for _, z := range m.sortedLocked(key) {
+		if above(z.Score) && below(z.Score) {
+			res = append(res, z.Member.(string))
+		}
+	}
+	return res, nil
+}
+
+// parseScoreBound reads a Redis score bound such as "5", "(5", "-inf" or "+inf"
+func parseScoreBound(bound string, lower bool) (func(float64) bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	v := strings.TrimPrefix(bound, "(")
+	if v == "+inf" {
+		v = "inf"
+	}
+	limit, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sorted set: invalid score bound %q", bound)
+	}
+
+	switch {
+	case lower && exclusive:
+		return func(s float64) bool { return s > limit }, nil
+	case lower:
+		return func(s float64) bool { return s >= limit }, nil
+	case exclusive:
+		return func(s float64) bool { return s < limit }, nil
+	default:
+		return func(s float64) bool { return s <= limit }, nil
+	}
+}
+
+func (m *MemoryStore) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sorted := m.sortedLocked(key)
+	from, to, ok := rankRange(len(sorted), start, stop)
+	if !ok {
+		return nil
+	}
+	z := m.zsets[key]
+	for _, member := range sorted[from:to] {
+		delete(z, member.Member.(string))
+	}
+	if len(z) == 0 {
+		m.deleteLocked(key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Publish(ctx context.Context, channel string, message interface{}) error {
+	payload, err := toString(message)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subs[channel] {
+		select {
+		case sub.ch <- &redis.Message{Channel: channel, Payload: payload}:
+		default:
+			logrus.Warnf("In-memory pub/sub: subscriber of %s is full, dropping message", channel)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SubChannel(ctx context.Context, channel string) Subscription {
+	sub := &memSubscription{
+		store:   m,
+		channel: channel,
+		ch:      make(chan *redis.Message, subscriptionBuffer),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subs[channel] == nil {
+		m.subs[channel] = map[*memSubscription]struct{}{}
+	}
+	m.subs[channel][sub] = struct{}{}
+	return sub
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+type memSubscription struct {
+	store   *MemoryStore
+	channel string
+	ch      chan *redis.Message
+	closed  bool
+}
+
+// Receive returns at once, the subscription is active as soon as SubChannel returns
+func (s *memSubscription) Receive(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *memSubscription) Channel() <-chan *redis.Message {
+	return s.ch
+}
+
+func (s *memSubscription) Close() error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	delete(s.store.subs[s.channel], s)
+	close(s.ch)
+	return nil
+}
+
+// toString stores values the way go-redis writes them to the wire
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+	}
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_KeysExpire(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	require.NoError(t, m.Set(ctx, "token", "abc", 20*time.Millisecond))
+	first, err := m.SetNX(ctx, "token", "other", 0)
+	require.NoError(t, err)
+	assert.False(t, first)
+
+	v, err := m.Get(ctx, "token")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", v)
+
+	n, err := m.Incr(ctx, "seq")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	n, err = m.Incr(ctx, "seq")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = m.Get(ctx, "token")
+	assert.ErrorIs(t, err, ErrNotFound)
+	count, err := m.Exists(ctx, "token", "seq")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestMemoryStore_Hash(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	added, err := m.HSet(ctx, "queue", "1", []byte(`{"queue_id":1}`))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, added)
+	added, err = m.HSet(ctx, "queue", "1", []byte(`{"queue_id":1,"booking_status":"waiting"}`))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, added)
+	_, err = m.HIncrBy(ctx, "counts", "booking.created", 2)
+	require.NoError(t, err)
+
+	v, err := m.HGet(ctx, "queue", "1")
+	require.NoError(t, err)
+	assert.Contains(t, v, "waiting")
+	counts, err := m.HGetAll(ctx, "counts")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"booking.created": "2"}, counts)
+
+	require.NoError(t, m.HDel(ctx, "queue", "1"))
+	_, err = m.HGet(ctx, "queue", "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	all, err := m.HGetAll(ctx, "queue")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestMemoryStore_SortedSet(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	require.NoError(t, m.ZAdd(ctx, "log",
+		redis.Z{Score: 3, Member: "c"},
+		redis.Z{Score: 1, Member: "a"},
+		redis.Z{Score: 2, Member: "b"},
+		redis.Z{Score: 4, Member: []byte("d")},
+	))
+
+	all, err := m.ZRange(ctx, "log", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, all)
+
+	last, err := m.ZRangeWithScores(ctx, "log", -1, -1)
+	require.NoError(t, err)
+	require.Len(t, last, 1)
+	assert.Equal(t, redis.Z{Score: 4, Member: "d"}, last[0])
+
+	after, err := m.ZRangeByScore(ctx, "log", "(2", "+inf")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, after)
+
+	// keep the newest two, like the event log retention
+	require.NoError(t, m.ZRemRangeByRank(ctx, "log", 0, -3))
+	require.NoError(t, m.ZRem(ctx, "log", "d"))
+	all, err = m.ZRange(ctx, "log", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, all)
+}
+
+func TestMemoryStore_PubSub(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	sub := m.SubChannel(ctx, "queue:update")
+	require.NoError(t, sub.Receive(ctx))
+	other := m.SubChannel(ctx, "elsewhere")
+
+	require.NoError(t, m.Publish(ctx, "queue:update", []byte(`{"seq":1}`)))
+	select {
+	case msg := <-sub.Channel():
+		assert.Equal(t, `{"seq":1}`, msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+	assert.Empty(t, other.Channel())
+
+	require.NoError(t, sub.Close())
+	_, ok := <-sub.Channel()
+	assert.False(t, ok)
+	// publishing after the last subscriber left is fine
+	require.NoError(t, m.Publish(ctx, "queue:update", "dropped"))
+}
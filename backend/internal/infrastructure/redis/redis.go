@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,22 +12,48 @@ import (
 
 type RedisConnection interface {
 	Set(ctx context.Context, key string, value interface{}, exp time.Duration) error
+	SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error)
 	Get(ctx context.Context, key string) (string, error)
 	Del(ctx context.Context, keys ...string) error
+	// DelIfEqual deletes key only while it still holds value, atomically
+	DelIfEqual(ctx context.Context, key string, value string) (bool, error)
 	Exists(ctx context.Context, keys ...string) (int64, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	Expire(ctx context.Context, key string, exp time.Duration) error
 
-	HSet(ctx context.Context, key string, queueId int, value interface{}) (int64, error)
+	HSet(ctx context.Context, key string, field string, value interface{}) (int64, error)
+	HGet(ctx context.Context, key string, field string) (string, error)
 	HGetAll(ctx context.Context, key string) (map[string]string, error)
-	HDel(ctx context.Context, key string, queueId int) error
+	HIncrBy(ctx context.Context, key string, field string, incr int64) (int64, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+
+	ZAdd(ctx context.Context, key string, members ...redis.Z) error
+	ZRem(ctx context.Context, key string, members ...interface{}) error
+	ZRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error)
+	ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error)
+	ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error
 
 	Publish(ctx context.Context, channel string, message interface{}) error
-	SubChannel(ctx context.Context, channel string) *redis.PubSub
+	SubChannel(ctx context.Context, channel string) Subscription
 
-	GetClient(ctx context.Context) *redis.Client
 	Ping(ctx context.Context) error
 	HealthCheck(ctx context.Context) error
 }
 
+// Subscription is a channel subscription, SubChannel returns it before the server
+// confirmed it
+type Subscription interface {
+	// Receive waits until the subscription is active
+	Receive(ctx context.Context) error
+	// Channel delivers the published messages until Close
+	Channel() <-chan *redis.Message
+	Close() error
+}
+
+// ErrNotFound is returned by Get and HGet when the key or field is missing
+var ErrNotFound = errors.New("not found")
+
 type RedisConfig struct {
 	Address      string
 	Password     string
@@ -38,6 +65,8 @@ type RedisConfig struct {
 	WriteTimeout time.Duration
 }
 
+var _ RedisConnection = (*RedisClient)(nil)
+
 type RedisClient struct {
 	Client *redis.Client
 	config RedisConfig
@@ -73,10 +102,18 @@ func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, ex
 	return r.Client.Set(ctx, key, value, exp).Err()
 }
 
+// SetNX sets key only if it does not exist yet and reports whether it did
+func (r *RedisClient) SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error) {
+	return r.Client.SetNX(ctx, key, value, exp).Result()
+}
+
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
 	res, err := r.Client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key %s not found", key)
+		return "", fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %s", key, err)
 	}
 	return res, nil
 }
@@ -85,10 +122,41 @@ func (r *RedisClient) Del(ctx context.Context, keys ...string) error {
 	return r.Client.Del(ctx, keys...).Err()
 }
 
+// delIfEqualScript is compare-and-delete, GET and DEL as separate calls could delete a
+// value someone else set in between
+var delIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *RedisClient) DelIfEqual(ctx context.Context, key string, value string) (bool, error) {
+	n, err := delIfEqualScript.Run(ctx, r.Client, []string{key}, value).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete %s: %s", key, err)
+	}
+	return n == 1, nil
+}
+
 func (r *RedisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
 	return r.Client.Exists(ctx, keys...).Result()
 }
 
+func (r *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	res, err := r.Client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment %s: %s", key, err)
+	}
+	return res, nil
+}
+
+func (r *RedisClient) Expire(ctx context.Context, key string, exp time.Duration) error {
+	if err := r.Client.Expire(ctx, key, exp).Err(); err != nil {
+		return fmt.Errorf("failed to set expiry on %s: %s", key, err)
+	}
+	return nil
+}
+
 func (r *RedisClient) Ping(ctx context.Context) error {
 	return r.Client.Ping(ctx).Err()
 }
@@ -104,14 +172,25 @@ func (r *RedisClient) GetClient(ctx context.Context) *redis.Client {
 	return r.Client
 }
 
-func (r *RedisClient) HSet(ctx context.Context, key string, queueId int, value interface{}) (int64, error) {
-	res, err := r.Client.HSet(ctx, key, queueId, value).Result()
+func (r *RedisClient) HSet(ctx context.Context, key string, field string, value interface{}) (int64, error) {
+	res, err := r.Client.HSet(ctx, key, field, value).Result()
 	if err != nil {
 		return 0, fmt.Errorf("failed to set on hash: %s", err)
 	}
 	return res, nil
 }
 
+func (r *RedisClient) HGet(ctx context.Context, key string, field string) (string, error) {
+	res, err := r.Client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("field %s in hash %s: %w", field, key, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get from hash: %s", err)
+	}
+	return res, nil
+}
+
 func (r *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
 	res, err := r.Client.HGetAll(ctx, key).Result()
 	if err != nil {
@@ -120,8 +199,16 @@ func (r *RedisClient) HGetAll(ctx context.Context, key string) (map[string]strin
 	return res, nil
 }
 
-func (r *RedisClient) HDel(ctx context.Context, key string, queueId string) error {
-	err := r.Client.HDel(ctx, key, queueId).Err()
+func (r *RedisClient) HIncrBy(ctx context.Context, key string, field string, incr int64) (int64, error) {
+	res, err := r.Client.HIncrBy(ctx, key, field, incr).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment hash field: %s", err)
+	}
+	return res, nil
+}
+
+func (r *RedisClient) HDel(ctx context.Context, key string, fields ...string) error {
+	err := r.Client.HDel(ctx, key, fields...).Err()
 	if err != nil {
 		return fmt.Errorf("not found key for delete")
 	}
@@ -136,6 +223,69 @@ func (r *RedisClient) Publish(ctx context.Context, channel string, message inter
 	return nil
 }
 
-func (r *RedisClient) SubChannel(ctx context.Context, channel string) *redis.PubSub {
-	return r.Client.Subscribe(ctx, channel)
+func (r *RedisClient) SubChannel(ctx context.Context, channel string) Subscription {
+	return &pubSub{ps: r.Client.Subscribe(ctx, channel)}
+}
+
+type pubSub struct {
+	ps *redis.PubSub
+}
+
+func (p *pubSub) Receive(ctx context.Context) error {
+	_, err := p.ps.Receive(ctx)
+	return err
+}
+
+func (p *pubSub) Channel() <-chan *redis.Message {
+	return p.ps.Channel()
+}
+
+func (p *pubSub) Close() error {
+	return p.ps.Close()
+}
+
+func (r *RedisClient) ZAdd(ctx context.Context, key string, members ...redis.Z) error {
+	if err := r.Client.ZAdd(ctx, key, members...).Err(); err != nil {
+		return fmt.Errorf("failed to add to sorted set: %s", err)
+	}
+	return nil
+}
+
+func (r *RedisClient) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	if err := r.Client.ZRem(ctx, key, members...).Err(); err != nil {
+		return fmt.Errorf("failed to remove from sorted set: %s", err)
+	}
+	return nil
+}
+
+func (r *RedisClient) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	res, err := r.Client.ZRange(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sorted set: %s", err)
+	}
+	return res, nil
+}
+
+func (r *RedisClient) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error) {
+	res, err := r.Client.ZRangeWithScores(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sorted set: %s", err)
+	}
+	return res, nil
+}
+
+// ZRangeByScore takes redis score bounds, e.g. "(10" for exclusive and "+inf"
+func (r *RedisClient) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	res, err := r.Client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: min, Max: max}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sorted set: %s", err)
+	}
+	return res, nil
+}
+
+func (r *RedisClient) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	if err := r.Client.ZRemRangeByRank(ctx, key, start, stop).Err(); err != nil {
+		return fmt.Errorf("failed to trim sorted set: %s", err)
+	}
+	return nil
 }
@@ -0,0 +1,82 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Channel string
+
+const (
+	ChannelSMS   Channel = "sms"
+	ChannelEmail Channel = "email"
+)
+
+// Message is a short text for one recipient, a phone number for SMS and an address for
+// email
+type Message struct {
+	Channel Channel   `json:"channel"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject,omitempty"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Sender delivers messages, a provider plugs in by implementing it
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type logSender struct{}
+
+// NewLogSender writes the messages to the log, for development only since the log
+// then holds the one-time codes
+func NewLogSender() Sender {
+	return logSender{}
+}
+
+func (logSender) Send(ctx context.Context, msg *Message) error {
+	logrus.Infof("Send %s to %s: %s %s", msg.Channel, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSender appends the messages to path as JSON lines, a local outbox to read the
+// codes from during development
+func NewFileSender(path string) Sender {
+	return &fileSender{path: path}
+}
+
+func (s *fileSender) Send(ctx context.Context, msg *Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create outbox dir: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
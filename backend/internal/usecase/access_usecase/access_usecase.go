@@ -0,0 +1,201 @@
+package accessusecase
+
+import (
+	"backend/internal/domain/dto"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	doctorrepository "backend/internal/infrastructure/persistence/staff_repository/doctor_repository"
+	nurserepository "backend/internal/infrastructure/persistence/staff_repository/nurse_repository"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrAccessDenied = errors.New("access denied")
+	// ErrNoFaculty means a doctor or nurse has no faculty yet, they see nothing until an
+	// admin assigns one
+	ErrNoFaculty = errors.New("staff member is not assigned to a faculty")
+)
+
+type Action string
+
+const (
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+)
+
+const (
+	roleAdmin   = "admin"
+	rolePatient = "patient"
+	roleDoctor  = "doctor"
+	roleNurse   = "nurse"
+)
+
+// AccessUsecase decides whether an actor may touch a record, on top of the route
+// permissions Casbin grants to the role. Every denial is handed to the auditor
+type AccessUsecase interface {
+	// Faculty returns the faculty a doctor or nurse is scoped to
+	Faculty(ctx context.Context, actor *dto.Actor) (byte, error)
+	// FacultyScope fails unless the actor works in facultyId
+	FacultyScope(ctx context.Context, actor *dto.Actor, facultyId byte) error
+	// Patient lets patients touch their own record, doctors read the patients of the
+	// bookings assigned to them and nurses the patients waiting in their faculty
+	Patient(ctx context.Context, actor *dto.Actor, patientId string, action Action) error
+	// Booking lets patients touch their own bookings, doctors the bookings assigned to
+	// them or still unassigned in their faculty, and nurses the bookings of their faculty
+	Booking(ctx context.Context, actor *dto.Actor, queueId int, action Action) error
+	// Staff lets doctors and nurses touch their own record only
+	Staff(ctx context.Context, actor *dto.Actor, staffId string, action Action) error
+}
+
+type accessUsecase struct {
+	bqRepo     persistence.BookingQueuueRepository
+	doctorRepo doctorrepository.DoctorRepository
+	nurseRepo  nurserepository.NurseRepository
+	auditor    Auditor
+}
+
+func NewAccessUsecase(bqRepo persistence.BookingQueuueRepository, doctorRepo doctorrepository.DoctorRepository, nurseRepo nurserepository.NurseRepository, auditor Auditor) AccessUsecase {
+	return &accessUsecase{
+		bqRepo:     bqRepo,
+		doctorRepo: doctorRepo,
+		nurseRepo:  nurseRepo,
+		auditor:    auditor,
+	}
+}
+
+func (s *accessUsecase) Faculty(ctx context.Context, actor *dto.Actor) (byte, error) {
+	var facultyId byte
+	switch actor.Role {
+	case roleDoctor:
+		d, err := s.doctorRepo.GetDoctorById(ctx, actor.Id)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return 0, err
+		}
+		facultyId = d.Faculty.FacultyId
+	case roleNurse:
+		n, err := s.nurseRepo.GetNurseById(ctx, actor.Id)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return 0, err
+		}
+		facultyId = n.Faculty.FacultyId
+	default:
+		return 0, nil
+	}
+
+	if facultyId == 0 {
+		return 0, s.deny(ctx, actor, "faculty", ActionRead, ErrNoFaculty)
+	}
+	return facultyId, nil
+}
+
+func (s *accessUsecase) FacultyScope(ctx context.Context, actor *dto.Actor, facultyId byte) error {
+	if actor.Role == roleAdmin {
+		return nil
+	}
+	if actor.FacultyId == 0 || actor.FacultyId != facultyId {
+		return s.deny(ctx, actor, fmt.Sprintf("faculty:%d", facultyId), ActionRead, fmt.Errorf("%w: outside the actor's faculty", ErrAccessDenied))
+	}
+	return nil
+}
+
+func (s *accessUsecase) Patient(ctx context.Context, actor *dto.Actor, patientId string, action Action) error {
+	resource := "patient:" + patientId
+	switch actor.Role {
+	case roleAdmin:
+		return nil
+	case rolePatient:
+		if actor.Id == patientId {
+			return nil
+		}
+		return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: not the patient's own record", ErrAccessDenied))
+	case roleDoctor, roleNurse:
+		if action != ActionRead {
+			return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: staff cannot change patient records", ErrAccessDenied))
+		}
+		pid, err := uuid.Parse(patientId)
+		if err != nil {
+			return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: invalid patient id", ErrAccessDenied))
+		}
+		var ok bool
+		if actor.Role == roleDoctor {
+			sid, err := uuid.Parse(actor.Id)
+			if err != nil {
+				return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: invalid staff id", ErrAccessDenied))
+			}
+			ok, err = s.bqRepo.IsPatientOfDoctor(ctx, pid, sid)
+		} else {
+			// nurses are not assigned to bookings, they run the queue of their faculty
+			ok, err = s.bqRepo.HasOpenBookingInFaculty(ctx, pid, actor.FacultyId)
+		}
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return err
+		}
+		if !ok {
+			return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: no booking with the patient", ErrAccessDenied))
+		}
+		return nil
+	}
+	return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: unknown role %q", ErrAccessDenied, actor.Role))
+}
+
+func (s *accessUsecase) Booking(ctx context.Context, actor *dto.Actor, queueId int, action Action) error {
+	resource := fmt.Sprintf("booking:%d", queueId)
+	if actor.Role == roleAdmin {
+		return nil
+	}
+
+	bq, err := s.bqRepo.GetBookingQueueById(ctx, queueId)
+	if err != nil {
+		// an unknown booking is denied like someone else's, so ids cannot be probed
+		return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: %v", ErrAccessDenied, err))
+	}
+
+	switch actor.Role {
+	case rolePatient:
+		if bq.PatientId.String() == actor.Id {
+			return nil
+		}
+		return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: not the patient's own booking", ErrAccessDenied))
+	case roleDoctor:
+		// a doctor takes unassigned bookings from the queue of the faculty, then keeps
+		// them when moving to another one
+		if treatedBy(actor, bq) || (bq.DoctorId == nil && inFaculty(actor, bq)) {
+			return nil
+		}
+		return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: booking of another doctor", ErrAccessDenied))
+	case roleNurse:
+		if inFaculty(actor, bq) {
+			return nil
+		}
+		return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: booking of another faculty", ErrAccessDenied))
+	}
+	return s.deny(ctx, actor, resource, action, fmt.Errorf("%w: unknown role %q", ErrAccessDenied, actor.Role))
+}
+
+func (s *accessUsecase) Staff(ctx context.Context, actor *dto.Actor, staffId string, action Action) error {
+	if actor.Role == roleAdmin || (actor.Role != rolePatient && actor.Id == staffId) {
+		return nil
+	}
+	return s.deny(ctx, actor, actor.Role+":"+staffId, action, fmt.Errorf("%w: not the staff member's own record", ErrAccessDenied))
+}
+
+func (s *accessUsecase) deny(ctx context.Context, actor *dto.Actor, resource string, action Action, reason error) error {
+	s.auditor.AccessDenied(ctx, &Denial{Actor: actor, Resource: resource, Action: action, Reason: reason.Error()})
+	return reason
+}
+
+func inFaculty(actor *dto.Actor, bq *patient.BookingQueue) bool {
+	return actor.FacultyId != 0 && bq.FacultyId == actor.FacultyId
+}
+
+func treatedBy(actor *dto.Actor, bq *patient.BookingQueue) bool {
+	return actor.Role == roleDoctor && bq.DoctorId != nil && bq.DoctorId.String() == actor.Id
+}
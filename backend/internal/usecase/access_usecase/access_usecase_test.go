@@ -0,0 +1,118 @@
+package accessusecase
+
+import (
+	"backend/internal/domain/dto"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bookings struct {
+	persistence.BookingQueuueRepository
+	rows []*patient.BookingQueue
+}
+
+func (r *bookings) GetBookingQueueById(ctx context.Context, queueId int) (*patient.BookingQueue, error) {
+	for _, bq := range r.rows {
+		if bq.QueueId == queueId {
+			return bq, nil
+		}
+	}
+	return nil, errors.New("booking not found")
+}
+
+func (r *bookings) IsPatientOfDoctor(ctx context.Context, patientId, doctorId uuid.UUID) (bool, error) {
+	for _, bq := range r.rows {
+		if bq.PatientId == patientId && bq.DoctorId != nil && *bq.DoctorId == doctorId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *bookings) HasOpenBookingInFaculty(ctx context.Context, patientId uuid.UUID, facultyId byte) (bool, error) {
+	for _, bq := range r.rows {
+		if bq.PatientId == patientId && facultyId != 0 && bq.FacultyId == facultyId && bq.BookingStatus != patient.BookingStatusCompleted {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type recordedDenials struct {
+	denials []*Denial
+}
+
+func (a *recordedDenials) AccessDenied(ctx context.Context, d *Denial) {
+	a.denials = append(a.denials, d)
+}
+
+func TestAccess_OwnershipAndFacultyScope(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	doctorId, colleagueId := uuid.New(), uuid.New()
+	repo := &bookings{rows: []*patient.BookingQueue{
+		// alice was treated by the doctor in cardiology, long ago
+		{QueueId: 1, PatientId: alice, FacultyId: 1, DoctorId: &doctorId, BookingStatus: patient.BookingStatusCompleted},
+		// bob waits in dermatology
+		{QueueId: 2, PatientId: bob, FacultyId: 2, BookingStatus: patient.BookingStatusWaiting},
+		// carol is treated by a colleague in dermatology
+		{QueueId: 3, PatientId: carol, FacultyId: 2, DoctorId: &colleagueId, BookingStatus: patient.BookingStatusWaiting},
+	}}
+	audit := &recordedDenials{}
+	s := NewAccessUsecase(repo, nil, nil, audit)
+
+	asAlice := &dto.Actor{Id: alice.String(), Role: "patient"}
+	assert.NoError(t, s.Patient(ctx, asAlice, alice.String(), ActionWrite))
+	assert.ErrorIs(t, s.Patient(ctx, asAlice, bob.String(), ActionWrite), ErrAccessDenied)
+	assert.NoError(t, s.Booking(ctx, asAlice, 1, ActionRead))
+	assert.ErrorIs(t, s.Booking(ctx, asAlice, 2, ActionRead), ErrAccessDenied)
+	// unknown bookings look like someone else's
+	assert.ErrorIs(t, s.Booking(ctx, asAlice, 99, ActionRead), ErrAccessDenied)
+
+	// the doctor moved to dermatology, alice stays reachable through her past booking
+	doctor := &dto.Actor{Id: doctorId.String(), Role: "doctor", FacultyId: 2}
+	assert.NoError(t, s.Patient(ctx, doctor, alice.String(), ActionRead))
+	assert.NoError(t, s.Booking(ctx, doctor, 1, ActionRead))
+	// the faculty does not open its patients to every doctor, only the unassigned
+	// bookings of its queue can be taken
+	assert.ErrorIs(t, s.Patient(ctx, doctor, bob.String(), ActionRead), ErrAccessDenied)
+	assert.NoError(t, s.Booking(ctx, doctor, 2, ActionWrite))
+	assert.ErrorIs(t, s.Patient(ctx, doctor, carol.String(), ActionRead), ErrAccessDenied)
+	assert.ErrorIs(t, s.Booking(ctx, doctor, 3, ActionWrite), ErrAccessDenied)
+	assert.ErrorIs(t, s.Patient(ctx, doctor, alice.String(), ActionWrite), ErrAccessDenied)
+
+	other := &dto.Actor{Id: uuid.NewString(), Role: "doctor", FacultyId: 3}
+	assert.ErrorIs(t, s.Patient(ctx, other, alice.String(), ActionRead), ErrAccessDenied)
+
+	// nurses run the queue of their faculty, they read the patients waiting in it
+	dermatology := &dto.Actor{Id: uuid.NewString(), Role: "nurse", FacultyId: 2}
+	assert.NoError(t, s.Patient(ctx, dermatology, bob.String(), ActionRead))
+	assert.NoError(t, s.Patient(ctx, dermatology, carol.String(), ActionRead))
+	assert.NoError(t, s.Booking(ctx, dermatology, 3, ActionWrite))
+	assert.ErrorIs(t, s.Patient(ctx, dermatology, bob.String(), ActionWrite), ErrAccessDenied)
+
+	nurse := &dto.Actor{Id: uuid.NewString(), Role: "nurse", FacultyId: 1}
+	// alice's booking in cardiology is over
+	assert.ErrorIs(t, s.Patient(ctx, nurse, alice.String(), ActionRead), ErrAccessDenied)
+	assert.ErrorIs(t, s.Booking(ctx, nurse, 2, ActionWrite), ErrAccessDenied)
+	assert.NoError(t, s.FacultyScope(ctx, nurse, 1))
+	assert.ErrorIs(t, s.FacultyScope(ctx, nurse, 2), ErrAccessDenied)
+	assert.NoError(t, s.Staff(ctx, nurse, nurse.Id, ActionWrite))
+	assert.ErrorIs(t, s.Staff(ctx, nurse, doctorId.String(), ActionWrite), ErrAccessDenied)
+
+	admin := &dto.Actor{Id: "admin", Role: "admin"}
+	assert.NoError(t, s.Patient(ctx, admin, bob.String(), ActionWrite))
+
+	// every denial reached the audit trail with who asked for what
+	require.Len(t, audit.denials, 13)
+	assert.Equal(t, asAlice, audit.denials[0].Actor)
+	assert.Equal(t, "patient:"+bob.String(), audit.denials[0].Resource)
+	assert.Equal(t, ActionWrite, audit.denials[0].Action)
+}
@@ -0,0 +1,41 @@
+package accessusecase
+
+import (
+	"backend/internal/domain/dto"
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Denial is a refused access, for the audit trail
+type Denial struct {
+	Actor    *dto.Actor
+	Resource string
+	Action   Action
+	Reason   string
+}
+
+// Auditor records the denied accesses
+type Auditor interface {
+	AccessDenied(ctx context.Context, d *Denial)
+}
+
+type logAuditor struct{}
+
+// NewLogAuditor writes the denials to the log as structured entries tagged audit
+func NewLogAuditor() Auditor {
+	return logAuditor{}
+}
+
+func (logAuditor) AccessDenied(ctx context.Context, d *Denial) {
+	logrus.WithFields(logrus.Fields{
+		"audit":      "access_denied",
+		"actor":      d.Actor.Id,
+		"role":       d.Actor.Role,
+		"faculty":    d.Actor.FacultyId,
+		"ip":         d.Actor.IP,
+		"user_agent": d.Actor.UserAgent,
+		"resource":   d.Resource,
+		"action":     d.Action,
+	}).Warn(d.Reason)
+}
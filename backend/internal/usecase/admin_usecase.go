@@ -2,44 +2,320 @@ package usecase
 
 import (
 	"backend/internal/domain/dto"
+	staff "backend/internal/domain/staff/admin"
 	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
-	"backend/pkg/common/utils"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	"backend/pkg/common/pagination"
+	"backend/pkg/common/validator"
 	"context"
+	"errors"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrAdminDisabled = errors.New("account is disabled")
+	ErrWrongPassword = errors.New("current password is incorrect")
+	ErrWeakPassword  = errors.New("password needs 8 characters with 2 uppercase, 3 lowercase, 2 digits and one of !@#$&*")
+	// ErrOwnAccount refuses an admin disabling, deleting or demoting themselves, another
+	// super admin has to do it
+	ErrOwnAccount = errors.New("you cannot disable, delete or demote your own account")
+)
+
 type AdminUsecase interface {
 	AdminLogin(ctx context.Context, loginRequest *dto.LoginAdminRequest) (*dto.LoginResponse, error)
+	// Bootstrap creates the first super admin on an empty admin table, and promotes the
+	// admin named username when none of the admins is an active super admin. A disabled
+	// admin stays disabled, re-enabling one is left to a super admin
+	Bootstrap(ctx context.Context, username, password string) error
+	IsSuperAdmin(ctx context.Context, adminId string) (bool, error)
+
+	GetAdmins(ctx context.Context, pagination *pagination.Pagination) ([]*staff.Admin, error)
+	GetAdmin(ctx context.Context, adminId string) (*staff.Admin, error)
+	CreateAdmin(ctx context.Context, req *dto.CreateAdminRequest) (*staff.Admin, error)
+	UpdateAdmin(ctx context.Context, actorId, adminId string, req *dto.UpdateAdminRequest) (*staff.Admin, error)
+	// SetDisabled disables or enables an admin, disabling signs them out everywhere
+	SetDisabled(ctx context.Context, actorId, adminId string, disabled bool) error
+	DeleteAdmin(ctx context.Context, actorId, adminId string) error
+
+	// ChangePassword changes the password of the caller, every session of theirs is
+	// signed out
+	ChangePassword(ctx context.Context, adminId string, req *dto.ChangePasswordRequest) error
+	SetPassword(ctx context.Context, adminId string, req *dto.AdminPasswordRequest) error
 }
 
 type adminUsecase struct {
 	adminRepository staffrepository.AdminRepository
+	twoFactor       twofactorusecase.TwoFactorUsecase
+	guard           loginguardusecase.LoginGuardUsecase
+	sessions        sessionusecase.SessionUsecase
 }
 
-func NewAdminUsecase(adminRepository staffrepository.AdminRepository) AdminUsecase {
-	return &adminUsecase{adminRepository: adminRepository}
+func NewAdminUsecase(adminRepository staffrepository.AdminRepository, twoFactor twofactorusecase.TwoFactorUsecase, guard loginguardusecase.LoginGuardUsecase, sessions sessionusecase.SessionUsecase) AdminUsecase {
+	s := &adminUsecase{adminRepository: adminRepository, twoFactor: twoFactor, guard: guard, sessions: sessions}
+	// the last login is the last session, a password without its second factor is none
+	twoFactor.OnSessionStarted("admin", s.recordLogin)
+	return s
+}
+
+func (s *adminUsecase) recordLogin(ctx context.Context, userId string, client *dto.ClientInfo) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return
+	}
+	if err := s.adminRepository.RecordLogin(ctx, id, client.IP); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+	}
 }
 
 func (s *adminUsecase) AdminLogin(ctx context.Context, loginRequest *dto.LoginAdminRequest) (*dto.LoginResponse, error) {
+	attempt := &dto.LoginAttempt{Role: "admin", Identifier: loginRequest.Username, Client: loginRequest.Client}
+	if err := s.guard.Check(ctx, attempt); err != nil {
+		return nil, err
+	}
+
 	admin, err := s.adminRepository.GetByUsername(ctx, loginRequest.Username)
 	if err != nil {
 		logrus.Errorf("Admin usecase layer %+v:", err)
-		return nil, err
+		s.guard.Failed(ctx, attempt, "", loginguardusecase.ReasonUnknownAccount)
+		return nil, errors.New("invalid credentials")
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(loginRequest.Password))
 	if err != nil {
 		logrus.Errorf("Password is not matching: %+v", err)
+		s.guard.Failed(ctx, attempt, admin.AdminId.String(), loginguardusecase.ReasonWrongPassword)
+		return nil, errors.New("invalid credentials")
+	}
+	if admin.Disabled {
+		s.guard.Failed(ctx, attempt, admin.AdminId.String(), loginguardusecase.ReasonDisabled)
+		return nil, ErrAdminDisabled
+	}
+	resp, err := s.twoFactor.Login(ctx, attempt, admin.AdminId.String(), admin)
+	if err != nil {
+		logrus.Errorf("Admin usecase layer %+v:", err)
 		return nil, err
 	}
+	return resp, nil
+}
 
-	token := utils.GenerateToken(admin.AdminId.String(), "admin")
+func (s *adminUsecase) Bootstrap(ctx context.Context, username, password string) error {
+	count, err := s.adminRepository.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		if password == "" {
+			logrus.Warn("No admin account exists, set admin.bootstrap.password to create the first one")
+			return nil
+		}
+		if _, err := s.CreateAdmin(ctx, &dto.CreateAdminRequest{Username: username, Password: password, Role: staff.RoleSuperAdmin}); err != nil {
+			return err
+		}
+		logrus.Infof("Created the first admin %q", username)
+		return nil
+	}
 
-	resp := &dto.LoginResponse{
-		Token:   token,
-		User:    admin,
-		Message: "Login Successfully",
+	supers, err := s.adminRepository.CountActiveSuperAdmins(ctx)
+	if err != nil || supers > 0 {
+		return err
+	}
+	// admins created before the roles existed, the bootstrap one is promoted
+	admin, err := s.adminRepository.GetByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, staffrepository.ErrAdminNotFound) {
+			logrus.Warnf("No active super admin and no admin %q to promote", username)
+			return nil
+		}
+		return err
+	}
+	if admin.Disabled {
+		logrus.Errorf("No active super admin, admin %q is disabled and is not promoted, enable a super admin in the database", username)
+		return nil
+	}
+	admin.Role = staff.RoleSuperAdmin
+	if err := s.adminRepository.Update(ctx, admin); err != nil {
+		return err
+	}
+	// the password used to be stored as given, it cannot be checked against a hash
+	if _, err := bcrypt.Cost([]byte(admin.Password)); err != nil && password != "" {
+		if err := s.setPassword(ctx, admin.AdminId, password); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Promoted admin %q to super admin", username)
+	return nil
+}
+
+func (s *adminUsecase) IsSuperAdmin(ctx context.Context, adminId string) (bool, error) {
+	admin, err := s.GetAdmin(ctx, adminId)
+	if err != nil {
+		if errors.Is(err, staffrepository.ErrAdminNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return admin.IsSuperAdmin() && !admin.Disabled, nil
+}
+
+func (s *adminUsecase) GetAdmins(ctx context.Context, pagination *pagination.Pagination) ([]*staff.Admin, error) {
+	admins, err := s.adminRepository.List(ctx, pagination)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return admins, nil
+}
+
+func (s *adminUsecase) GetAdmin(ctx context.Context, adminId string) (*staff.Admin, error) {
+	id, err := uuid.Parse(adminId)
+	if err != nil {
+		return nil, staffrepository.ErrAdminNotFound
+	}
+	return s.adminRepository.GetById(ctx, id)
+}
+
+func (s *adminUsecase) CreateAdmin(ctx context.Context, req *dto.CreateAdminRequest) (*staff.Admin, error) {
+	if !validator.IsValidPassword(req.Password) {
+		return nil, ErrWeakPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), 10)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	admin := &staff.Admin{
+		AdminId:  uuid.New(),
+		Username: strings.TrimSpace(req.Username),
+		Password: string(hashed),
+		Role:     staff.RoleAdmin,
+	}
+	if req.Role != "" {
+		admin.Role = req.Role
+	}
+	if err := s.adminRepository.Create(ctx, admin); err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
+
+func (s *adminUsecase) UpdateAdmin(ctx context.Context, actorId, adminId string, req *dto.UpdateAdminRequest) (*staff.Admin, error) {
+	admin, err := s.GetAdmin(ctx, adminId)
+	if err != nil {
+		return nil, err
+	}
+	if req.Username != "" {
+		admin.Username = strings.TrimSpace(req.Username)
+	}
+	if req.Role != "" {
+		if adminId == actorId && req.Role != admin.Role {
+			return nil, ErrOwnAccount
+		}
+		admin.Role = req.Role
+	}
+	if err := s.adminRepository.Update(ctx, admin); err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
+
+func (s *adminUsecase) SetDisabled(ctx context.Context, actorId, adminId string, disabled bool) error {
+	if disabled && adminId == actorId {
+		return ErrOwnAccount
+	}
+	admin, err := s.GetAdmin(ctx, adminId)
+	if err != nil {
+		return err
+	}
+	if admin.Disabled == disabled {
+		return nil
+	}
+
+	admin.Disabled = disabled
+	admin.DisabledAt = nil
+	if disabled {
+		now := time.Now()
+		admin.DisabledAt = &now
+	}
+	if err := s.adminRepository.Update(ctx, admin); err != nil {
+		return err
+	}
+	if disabled {
+		s.signOut(ctx, adminId)
+	}
+	return nil
+}
+
+func (s *adminUsecase) DeleteAdmin(ctx context.Context, actorId, adminId string) error {
+	if adminId == actorId {
+		return ErrOwnAccount
+	}
+	id, err := uuid.Parse(adminId)
+	if err != nil {
+		return staffrepository.ErrAdminNotFound
+	}
+	if err := s.adminRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.signOut(ctx, adminId)
+	if err := s.twoFactor.Reset(ctx, adminId); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+	}
+	return nil
+}
+
+func (s *adminUsecase) ChangePassword(ctx context.Context, adminId string, req *dto.ChangePasswordRequest) error {
+	admin, err := s.GetAdmin(ctx, adminId)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(req.CurrentPassword)) != nil {
+		return ErrWrongPassword
+	}
+	if !validator.IsValidPassword(req.NewPassword) {
+		return ErrWeakPassword
+	}
+	if err := s.setPassword(ctx, admin.AdminId, req.NewPassword); err != nil {
+		return err
+	}
+	s.signOut(ctx, adminId)
+	return nil
+}
+
+func (s *adminUsecase) SetPassword(ctx context.Context, adminId string, req *dto.AdminPasswordRequest) error {
+	if !validator.IsValidPassword(req.NewPassword) {
+		return ErrWeakPassword
+	}
+	id, err := uuid.Parse(adminId)
+	if err != nil {
+		return staffrepository.ErrAdminNotFound
+	}
+	if err := s.setPassword(ctx, id, req.NewPassword); err != nil {
+		return err
+	}
+	s.signOut(ctx, adminId)
+	return nil
+}
+
+func (s *adminUsecase) setPassword(ctx context.Context, adminId uuid.UUID, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return s.adminRepository.UpdatePassword(ctx, adminId, hashed)
+}
+
+// signOut ends every session of the admin, failing to is logged and the change kept
+func (s *adminUsecase) signOut(ctx context.Context, adminId string) {
+	if _, err := s.sessions.RevokeAll(ctx, adminId); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
 	}
-	return resp, nil
 }
@@ -0,0 +1,310 @@
+package usecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	staff "backend/internal/domain/staff/admin"
+	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adminPassword = "AAbcd12!efg"
+
+// memoryAdmins keeps the admins in a map, Update and Delete refuse to leave no active
+// super admin like the repository does
+type memoryAdmins struct {
+	staffrepository.AdminRepository
+	admins map[uuid.UUID]*staff.Admin
+}
+
+func (m *memoryAdmins) GetByUsername(ctx context.Context, username string) (*staff.Admin, error) {
+	for _, a := range m.admins {
+		if a.Username == username {
+			copied := *a
+			return &copied, nil
+		}
+	}
+	return nil, staffrepository.ErrAdminNotFound
+}
+
+func (m *memoryAdmins) GetById(ctx context.Context, adminId uuid.UUID) (*staff.Admin, error) {
+	a, ok := m.admins[adminId]
+	if !ok {
+		return nil, staffrepository.ErrAdminNotFound
+	}
+	copied := *a
+	return &copied, nil
+}
+
+func (m *memoryAdmins) Count(ctx context.Context) (int, error) {
+	return len(m.admins), nil
+}
+
+func (m *memoryAdmins) CountActiveSuperAdmins(ctx context.Context) (int, error) {
+	n := 0
+	for _, a := range m.admins {
+		if a.IsSuperAdmin() && !a.Disabled {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryAdmins) Create(ctx context.Context, admin *staff.Admin) error {
+	if _, err := m.GetByUsername(ctx, admin.Username); err == nil {
+		return staffrepository.ErrAdminExists
+	}
+	copied := *admin
+	m.admins[admin.AdminId] = &copied
+	return nil
+}
+
+func (m *memoryAdmins) Update(ctx context.Context, admin *staff.Admin) error {
+	return m.guarded(func() {
+		stored := m.admins[admin.AdminId]
+		stored.Username, stored.Role, stored.Disabled, stored.DisabledAt = admin.Username, admin.Role, admin.Disabled, admin.DisabledAt
+	})
+}
+
+func (m *memoryAdmins) Delete(ctx context.Context, adminId uuid.UUID) error {
+	return m.guarded(func() { delete(m.admins, adminId) })
+}
+
+func (m *memoryAdmins) guarded(change func()) error {
+	before := make(map[uuid.UUID]staff.Admin, len(m.admins))
+	for id, a := range m.admins {
+		before[id] = *a
+	}
+	change()
+	if n, _ := m.CountActiveSuperAdmins(context.Background()); n == 0 {
+		m.admins = make(map[uuid.UUID]*staff.Admin, len(before))
+		for id, a := range before {
+			m.admins[id] = &a
+		}
+		return staffrepository.ErrLastSuperAdmin
+	}
+	return nil
+}
+
+func (m *memoryAdmins) UpdatePassword(ctx context.Context, adminId uuid.UUID, hashed []byte) error {
+	m.admins[adminId].Password = string(hashed)
+	return nil
+}
+
+func (m *memoryAdmins) RecordLogin(ctx context.Context, adminId uuid.UUID, ip string) error {
+	now := time.Now()
+	m.admins[adminId].LastLoginAt, m.admins[adminId].LastLoginIP = &now, ip
+	return nil
+}
+
+type recordingGuard struct {
+	loginguardusecase.LoginGuardUsecase
+	reasons []string
+}
+
+func (g *recordingGuard) Check(ctx context.Context, attempt *dto.LoginAttempt) error { return nil }
+
+func (g *recordingGuard) Failed(ctx context.Context, attempt *dto.LoginAttempt, userId, reason string) {
+	g.reasons = append(g.reasons, reason)
+}
+
+func (g *recordingGuard) Succeeded(ctx context.Context, attempt *dto.LoginAttempt, userId string) *account.LoginEvent {
+	return nil
+}
+
+// openSessions logs in without a second factor, or only answers with a challenge
+type openSessions struct {
+	twofactorusecase.TwoFactorUsecase
+	sessions  sessionusecase.SessionUsecase
+	challenge bool
+	listener  twofactorusecase.SessionListener
+}
+
+func (o *openSessions) OnSessionStarted(role string, listener twofactorusecase.SessionListener) {
+	o.listener = listener
+}
+
+func (o *openSessions) Login(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}) (*dto.LoginResponse, error) {
+	if o.challenge {
+		return &dto.LoginResponse{TwoFactorRequired: true, ChallengeToken: "challenge"}, nil
+	}
+	tokens, err := o.sessions.Start(ctx, userId, attempt.Role, &attempt.Client)
+	if err != nil {
+		return nil, err
+	}
+	o.listener(ctx, userId, &attempt.Client)
+	return &dto.LoginResponse{Token: tokens.AccessToken}, nil
+}
+
+func (o *openSessions) Reset(ctx context.Context, userId string) error { return nil }
+
+func newAdminUsecase(t *testing.T) (*adminUsecase, *memoryAdmins, sessionusecase.SessionUsecase, *recordingGuard) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	repo := &memoryAdmins{admins: map[uuid.UUID]*staff.Admin{}}
+	sessions := sessionusecase.NewSessionUsecase(redis.NewMemoryStore(), 15*time.Minute, time.Hour)
+	guard := &recordingGuard{}
+	u := NewAdminUsecase(repo, &openSessions{sessions: sessions}, guard, sessions).(*adminUsecase)
+	return u, repo, sessions, guard
+}
+
+func TestAdmin_BootstrapCreatesOnlyTheFirstSuperAdmin(t *testing.T) {
+	ctx := context.Background()
+	u, repo, _, _ := newAdminUsecase(t)
+
+	// without a password nothing is created
+	require.NoError(t, u.Bootstrap(ctx, "root", ""))
+	assert.Empty(t, repo.admins)
+
+	require.NoError(t, u.Bootstrap(ctx, "root", adminPassword))
+	root, err := repo.GetByUsername(ctx, "root")
+	require.NoError(t, err)
+	assert.True(t, root.IsSuperAdmin())
+	assert.NotEqual(t, adminPassword, root.Password)
+
+	// the admin table is no longer empty, another bootstrap changes nothing
+	require.NoError(t, u.Bootstrap(ctx, "other", adminPassword))
+	assert.Len(t, repo.admins, 1)
+
+	// the password hash never leaves the server
+	body, err := json.Marshal(root)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), root.Password)
+	assert.NotContains(t, string(body), "password")
+
+	// the password alone is not a login while the second factor is pending
+	second := u.twoFactor.(*openSessions)
+	second.challenge = true
+	resp, err := u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: adminPassword, Client: dto.ClientInfo{IP: "10.0.0.1"}})
+	require.NoError(t, err)
+	assert.True(t, resp.TwoFactorRequired)
+	root, _ = repo.GetById(ctx, root.AdminId)
+	assert.Nil(t, root.LastLoginAt)
+
+	second.challenge = false
+	resp, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: adminPassword, Client: dto.ClientInfo{IP: "10.0.0.1"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+	root, _ = repo.GetById(ctx, root.AdminId)
+	require.NotNil(t, root.LastLoginAt)
+	assert.Equal(t, "10.0.0.1", root.LastLoginIP)
+}
+
+func TestAdmin_BootstrapPromotesAnAdminFromBeforeTheRoles(t *testing.T) {
+	ctx := context.Background()
+	u, repo, _, _ := newAdminUsecase(t)
+	legacy := &staff.Admin{AdminId: uuid.New(), Username: "admin", Password: "plain", Role: staff.RoleAdmin}
+	require.NoError(t, repo.Create(ctx, legacy))
+
+	require.NoError(t, u.Bootstrap(ctx, "admin", adminPassword))
+	promoted, _ := repo.GetById(ctx, legacy.AdminId)
+	assert.True(t, promoted.IsSuperAdmin())
+
+	// the plain text password is replaced by the configured one
+	_, err := u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "admin", Password: adminPassword})
+	require.NoError(t, err)
+}
+
+func TestAdmin_BootstrapLeavesADisabledAdminDisabled(t *testing.T) {
+	ctx := context.Background()
+	u, repo, _, _ := newAdminUsecase(t)
+	now := time.Now()
+	root := &staff.Admin{AdminId: uuid.New(), Username: "root", Password: "plain", Role: staff.RoleSuperAdmin, Disabled: true, DisabledAt: &now}
+	require.NoError(t, repo.Create(ctx, root))
+
+	// a restart does not bring a deliberately disabled super admin back
+	require.NoError(t, u.Bootstrap(ctx, "root", adminPassword))
+	kept, _ := repo.GetById(ctx, root.AdminId)
+	assert.True(t, kept.Disabled)
+	assert.Equal(t, "plain", kept.Password)
+	_, err := u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: adminPassword})
+	assert.Error(t, err)
+}
+
+func TestAdmin_ManagementKeepsASuperAdmin(t *testing.T) {
+	ctx := context.Background()
+	u, repo, sessions, guard := newAdminUsecase(t)
+	require.NoError(t, u.Bootstrap(ctx, "root", adminPassword))
+	root, _ := repo.GetByUsername(ctx, "root")
+	rootId := root.AdminId.String()
+
+	_, err := u.CreateAdmin(ctx, &dto.CreateAdminRequest{Username: "clerk", Password: "weak"})
+	assert.ErrorIs(t, err, ErrWeakPassword)
+	clerk, err := u.CreateAdmin(ctx, &dto.CreateAdminRequest{Username: "clerk", Password: adminPassword})
+	require.NoError(t, err)
+	assert.Equal(t, staff.RoleAdmin, clerk.Role)
+	clerkId := clerk.AdminId.String()
+	_, err = u.CreateAdmin(ctx, &dto.CreateAdminRequest{Username: "clerk", Password: adminPassword})
+	assert.ErrorIs(t, err, staffrepository.ErrAdminExists)
+
+	ok, err := u.IsSuperAdmin(ctx, clerkId)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// nobody disables, deletes or demotes themselves
+	assert.ErrorIs(t, u.SetDisabled(ctx, rootId, rootId, true), ErrOwnAccount)
+	assert.ErrorIs(t, u.DeleteAdmin(ctx, rootId, rootId), ErrOwnAccount)
+	_, err = u.UpdateAdmin(ctx, rootId, rootId, &dto.UpdateAdminRequest{Role: staff.RoleAdmin})
+	assert.ErrorIs(t, err, ErrOwnAccount)
+
+	// nor anyone else the last super admin
+	_, err = u.UpdateAdmin(ctx, clerkId, rootId, &dto.UpdateAdminRequest{Role: staff.RoleAdmin})
+	assert.ErrorIs(t, err, staffrepository.ErrLastSuperAdmin)
+	assert.ErrorIs(t, u.SetDisabled(ctx, clerkId, rootId, true), staffrepository.ErrLastSuperAdmin)
+	ok, _ = u.IsSuperAdmin(ctx, rootId)
+	assert.True(t, ok)
+
+	// disabling signs the admin out and keeps them out
+	_, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "clerk", Password: adminPassword})
+	require.NoError(t, err)
+	require.NoError(t, u.SetDisabled(ctx, rootId, clerkId, true))
+	open, err := sessions.List(ctx, clerkId)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+	_, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "clerk", Password: adminPassword})
+	assert.ErrorIs(t, err, ErrAdminDisabled)
+	assert.Equal(t, loginguardusecase.ReasonDisabled, guard.reasons[len(guard.reasons)-1])
+
+	require.NoError(t, u.SetDisabled(ctx, rootId, clerkId, false))
+	_, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "clerk", Password: adminPassword})
+	require.NoError(t, err)
+
+	// a second super admin can take over
+	_, err = u.UpdateAdmin(ctx, rootId, clerkId, &dto.UpdateAdminRequest{Role: staff.RoleSuperAdmin})
+	require.NoError(t, err)
+	require.NoError(t, u.DeleteAdmin(ctx, clerkId, rootId))
+	_, err = u.GetAdmin(ctx, rootId)
+	assert.ErrorIs(t, err, staffrepository.ErrAdminNotFound)
+}
+
+func TestAdmin_ChangePasswordNeedsTheCurrentOne(t *testing.T) {
+	ctx := context.Background()
+	u, repo, sessions, _ := newAdminUsecase(t)
+	require.NoError(t, u.Bootstrap(ctx, "root", adminPassword))
+	root, _ := repo.GetByUsername(ctx, "root")
+	rootId := root.AdminId.String()
+	_, err := u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: adminPassword})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, u.ChangePassword(ctx, rootId, &dto.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "ZZyxw98!vut"}), ErrWrongPassword)
+	assert.ErrorIs(t, u.ChangePassword(ctx, rootId, &dto.ChangePasswordRequest{CurrentPassword: adminPassword, NewPassword: "short"}), ErrWeakPassword)
+	require.NoError(t, u.ChangePassword(ctx, rootId, &dto.ChangePasswordRequest{CurrentPassword: adminPassword, NewPassword: "ZZyxw98!vut"}))
+
+	open, err := sessions.List(ctx, rootId)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+	_, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: adminPassword})
+	assert.Error(t, err)
+	_, err = u.AdminLogin(ctx, &dto.LoginAdminRequest{Username: "root", Password: "ZZyxw98!vut"})
+	require.NoError(t, err)
+}
@@ -0,0 +1,92 @@
+package analyticsusecase
+
+import (
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	analyticsSubscriber = "analytics"
+	dateLayout          = "2006-01-02"
+
+	// redeliveries of an event within this window are not counted twice
+	seenEventTTL = 48 * time.Hour
+	// daily counters are kept for a year
+	countersTTL = 365 * 24 * time.Hour
+)
+
+type AnalyticsUsecase interface {
+	StartAnalyticsConsumer(ctx context.Context) error
+	// GetEventCounts returns how many events of each type occurred on date (YYYY-MM-DD)
+	GetEventCounts(ctx context.Context, date string) (map[string]int64, error)
+}
+
+type analyticsUsecase struct {
+	bus   rabbitmq.EventBus
+	redis redis.RedisConnection
+}
+
+func NewAnalyticsUsecase(bus rabbitmq.EventBus, redis redis.RedisConnection) AnalyticsUsecase {
+	return &analyticsUsecase{
+		bus:   bus,
+		redis: redis,
+	}
+}
+
+func countersKey(date string) string {
+	return fmt.Sprintf("analytics:events:%s", date)
+}
+
+func (s *analyticsUsecase) StartAnalyticsConsumer(ctx context.Context) error {
+	// every event type, including the ones added later
+	err := s.bus.Subscribe(ctx, analyticsSubscriber, []string{"#"}, s.countEvent)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return fmt.Errorf("failed to start analytics consumer: %w", err)
+	}
+	return nil
+}
+
+func (s *analyticsUsecase) countEvent(ctx context.Context, env *rabbitmq.Envelope) error {
+	first, err := s.redis.SetNX(ctx, "analytics:seen:"+env.EventId, 1, seenEventTTL)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return nil
+	}
+
+	key := countersKey(env.OccurredAt.UTC().Format(dateLayout))
+	if _, err := s.redis.HIncrBy(ctx, key, env.Type, 1); err != nil {
+		return err
+	}
+	return s.redis.Expire(ctx, key, countersTTL)
+}
+
+func (s *analyticsUsecase) GetEventCounts(ctx context.Context, date string) (map[string]int64, error) {
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+
+	res, err := s.redis.HGetAll(ctx, countersKey(date))
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(res))
+	for eventType, v := range res {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[eventType] = n
+	}
+	return counts, nil
+}
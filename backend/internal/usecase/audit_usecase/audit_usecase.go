@@ -0,0 +1,168 @@
+package auditusecase
+
+import (
+	"backend/internal/domain/audit"
+	"backend/internal/domain/dto"
+	auditrepository "backend/internal/infrastructure/persistence/audit_repository"
+	accessusecase "backend/internal/usecase/access_usecase"
+	"backend/pkg/common/pagination"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownFormat = errors.New("export format must be csv or jsonl")
+
+// errChainBroken stops the walk of Verify at the first mismatch
+var errChainBroken = errors.New("audit chain broken")
+
+// AuditUsecase keeps the audit trail of the accesses to clinical data
+type AuditUsecase interface {
+	Record(ctx context.Context, e *audit.Event) error
+	Search(ctx context.Context, filter *dto.AuditFilter, pagination *pagination.Pagination) ([]*audit.Event, error)
+	// Export writes the matching events oldest first, as csv or jsonl
+	Export(ctx context.Context, filter *dto.AuditFilter, format string, w io.Writer) error
+	// Verify recomputes the hash chain over the whole log
+	Verify(ctx context.Context) (*dto.AuditVerifyResponse, error)
+	// AccessAuditor records the denials of the access checks in the log
+	AccessAuditor() accessusecase.Auditor
+}
+
+type auditUsecase struct {
+	repo auditrepository.AuditRepository
+}
+
+func NewAuditUsecase(repo auditrepository.AuditRepository) AuditUsecase {
+	return &auditUsecase{repo: repo}
+}
+
+func (s *auditUsecase) Record(ctx context.Context, e *audit.Event) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+	if err := s.repo.Append(ctx, e); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *auditUsecase) Search(ctx context.Context, filter *dto.AuditFilter, pagination *pagination.Pagination) ([]*audit.Event, error) {
+	events, err := s.repo.Search(ctx, filter, pagination)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+var csvHeader = []string{"id", "created_at", "actor_id", "role", "action", "resource", "resource_id", "patient_id", "outcome", "reason", "ip", "user_agent", "method", "path", "status", "prev_hash", "hash"}
+
+func (s *auditUsecase) Export(ctx context.Context, filter *dto.AuditFilter, format string, w io.Writer) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		err := s.repo.Each(ctx, filter, func(e *audit.Event) error {
+			patientId := ""
+			if e.PatientId != nil {
+				patientId = e.PatientId.String()
+			}
+			return cw.Write([]string{
+				strconv.FormatInt(e.Id, 10),
+				e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				e.ActorId, e.Role, e.Action, e.Resource, e.ResourceId, patientId,
+				e.Outcome, e.Reason, e.IP, e.UserAgent, e.Method, e.Path,
+				strconv.Itoa(e.Status), e.PrevHash, e.Hash,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		return s.repo.Each(ctx, filter, func(e *audit.Event) error {
+			return enc.Encode(e)
+		})
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func (s *auditUsecase) Verify(ctx context.Context) (*dto.AuditVerifyResponse, error) {
+	resp := &dto.AuditVerifyResponse{Valid: true}
+	prev := ""
+	err := s.repo.Each(ctx, nil, func(e *audit.Event) error {
+		switch {
+		case e.PrevHash != prev:
+			resp.Reason = "previous hash does not match, an event was removed or reordered"
+		case e.ComputeHash() != e.Hash:
+			resp.Reason = "hash does not match the content, the event was changed"
+		default:
+			resp.Checked++
+			prev = e.Hash
+			return nil
+		}
+		resp.Valid = false
+		id := e.Id
+		resp.BrokenAt = &id
+		return errChainBroken
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if !resp.Valid {
+		logrus.Errorf("Audit log tampered at event %d: %s", *resp.BrokenAt, resp.Reason)
+	}
+	return resp, nil
+}
+
+func (s *auditUsecase) AccessAuditor() accessusecase.Auditor {
+	return &accessAuditor{audit: s, log: accessusecase.NewLogAuditor()}
+}
+
+// accessAuditor keeps logging the denials and adds them to the audit log
+type accessAuditor struct {
+	audit AuditUsecase
+	log   accessusecase.Auditor
+}
+
+func (a *accessAuditor) AccessDenied(ctx context.Context, d *accessusecase.Denial) {
+	a.log.AccessDenied(ctx, d)
+
+	// resources are named type:id by the access checks
+	resource, resourceId, _ := strings.Cut(d.Resource, ":")
+	e := &audit.Event{
+		ActorId:    d.Actor.Id,
+		Role:       d.Actor.Role,
+		Action:     string(d.Action),
+		Resource:   resource,
+		ResourceId: resourceId,
+		Outcome:    audit.OutcomeDenied,
+		Reason:     d.Reason,
+		IP:         d.Actor.IP,
+		UserAgent:  d.Actor.UserAgent,
+	}
+	if resource == "patient" {
+		if id, err := uuid.Parse(resourceId); err == nil {
+			e.PatientId = &id
+		}
+	}
+	if err := a.audit.Record(ctx, e); err != nil {
+		logrus.Errorf("Failed to audit denial of %s on %s: %v", d.Actor.Id, d.Resource, err)
+	}
+}
@@ -0,0 +1,94 @@
+package auditusecase
+
+import (
+	"backend/internal/domain/audit"
+	"backend/internal/domain/dto"
+	auditrepository "backend/internal/infrastructure/persistence/audit_repository"
+	accessusecase "backend/internal/usecase/access_usecase"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLog chains the events like the database repository does
+type memoryLog struct {
+	auditrepository.AuditRepository
+	events []*audit.Event
+}
+
+func (m *memoryLog) Append(ctx context.Context, e *audit.Event) error {
+	if n := len(m.events); n > 0 {
+		e.PrevHash = m.events[n-1].Hash
+	}
+	e.Id = int64(len(m.events) + 1)
+	e.Hash = e.ComputeHash()
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memoryLog) Each(ctx context.Context, filter *dto.AuditFilter, fn func(*audit.Event) error) error {
+	for _, e := range m.events {
+		if filter != nil && filter.Outcome != "" && e.Outcome != filter.Outcome {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAudit_ChainDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	log := &memoryLog{}
+	s := NewAuditUsecase(log)
+	patientId := uuid.New()
+
+	for _, actor := range []string{"doctor-1", "doctor-2", "nurse-1"} {
+		require.NoError(t, s.Record(ctx, &audit.Event{ActorId: actor, Role: "doctor", Action: "read", Resource: "patient", ResourceId: patientId.String(), PatientId: &patientId, Outcome: audit.OutcomeSuccess, IP: "10.0.0.1"}))
+	}
+	s.AccessAuditor().AccessDenied(ctx, &accessusecase.Denial{
+		Actor:    &dto.Actor{Id: "doctor-3", Role: "doctor", IP: "10.0.0.9"},
+		Resource: "patient:" + patientId.String(),
+		Action:   accessusecase.ActionRead,
+		Reason:   "access denied: no booking with the patient",
+	})
+	require.Len(t, log.events, 4)
+	denied := log.events[3]
+	assert.Equal(t, audit.OutcomeDenied, denied.Outcome)
+	assert.Equal(t, &patientId, denied.PatientId)
+	assert.Equal(t, log.events[2].Hash, denied.PrevHash)
+
+	result, err := s.Verify(ctx)
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, 4, result.Checked)
+
+	var out bytes.Buffer
+	require.NoError(t, s.Export(ctx, &dto.AuditFilter{Outcome: audit.OutcomeDenied}, "csv", &out))
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "doctor-3", rows[1][2])
+	assert.ErrorIs(t, s.Export(ctx, nil, "xml", &out), ErrUnknownFormat)
+
+	// rewriting who looked at the record breaks its hash
+	log.events[1].ActorId = "admin"
+	result, err = s.Verify(ctx)
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, int64(2), *result.BrokenAt)
+
+	// dropping an event breaks the link of the next one
+	log.events[1].ActorId = "doctor-2"
+	log.events = append(log.events[:1], log.events[2:]...)
+	result, err = s.Verify(ctx)
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, int64(3), *result.BrokenAt)
+}
@@ -0,0 +1,109 @@
+package displayusecase
+
+import (
+	"backend/internal/domain/display"
+	dtoqueue "backend/internal/domain/dto/queue"
+	displayrepository "backend/internal/infrastructure/persistence/display_repository"
+	queuefeedusecase "backend/internal/usecase/queue_feed_usecase"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrInvalidDisplayToken = errors.New("invalid display token")
+
+type DisplayUsecase interface {
+	// CreateBoard registers a board, its token is only returned here
+	CreateBoard(ctx context.Context, req *dtoqueue.CreateDisplayBoardRequest) (*dtoqueue.DisplayBoardResponse, error)
+	ListBoards(ctx context.Context) ([]*display.DisplayBoard, error)
+	DeleteBoard(ctx context.Context, id int) error
+	// Resolve returns the board a token was issued for
+	Resolve(ctx context.Context, token string) (*display.DisplayBoard, error)
+	// Render returns what the board currently shows
+	Render(ctx context.Context, board *display.DisplayBoard) (*dtoqueue.DisplayBoard, error)
+}
+
+type displayUsecase struct {
+	repo displayrepository.DisplayBoardRepository
+	feed queuefeedusecase.QueueFeedUsecase
+}
+
+func NewDisplayUsecase(repo displayrepository.DisplayBoardRepository, feed queuefeedusecase.QueueFeedUsecase) DisplayUsecase {
+	return &displayUsecase{
+		repo: repo,
+		feed: feed,
+	}
+}
+
+func (s *displayUsecase) CreateBoard(ctx context.Context, req *dtoqueue.CreateDisplayBoardRequest) (*dtoqueue.DisplayBoardResponse, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	board := &display.DisplayBoard{
+		Name:      req.Name,
+		FacultyId: req.FacultyId,
+		Room:      req.Room,
+		TokenHash: hashToken(token),
+	}
+	if err := s.repo.Create(ctx, board); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return &dtoqueue.DisplayBoardResponse{DisplayBoard: board, Token: token}, nil
+}
+
+func (s *displayUsecase) ListBoards(ctx context.Context) ([]*display.DisplayBoard, error) {
+	boards, err := s.repo.List(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return boards, nil
+}
+
+func (s *displayUsecase) DeleteBoard(ctx context.Context, id int) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *displayUsecase) Resolve(ctx context.Context, token string) (*display.DisplayBoard, error) {
+	if token == "" {
+		return nil, ErrInvalidDisplayToken
+	}
+	board, err := s.repo.GetByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, displayrepository.ErrBoardNotFound) {
+			return nil, ErrInvalidDisplayToken
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return board, nil
+}
+
+func (s *displayUsecase) Render(ctx context.Context, board *display.DisplayBoard) (*dtoqueue.DisplayBoard, error) {
+	snap, err := s.feed.Snapshot(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return dtoqueue.NewDisplayBoard(snap, board.FacultyId, board.Room), nil
+}
+
+// hashToken is what gets stored, the token is a random 256-bit value so a plain
+// digest is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
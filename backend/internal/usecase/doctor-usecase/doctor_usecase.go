@@ -3,7 +3,12 @@ package doctorusecase
 import (
 	"backend/internal/domain/dto"
 	"backend/internal/domain/dto/dtodoctor"
+	"backend/internal/domain/messaging"
+	"backend/internal/domain/patient"
 	doctorrepository "backend/internal/infrastructure/persistence/staff_repository/doctor_repository"
+	"backend/internal/infrastructure/rabbitmq"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
 	"backend/pkg/common/pagination"
 	"backend/pkg/common/utils"
 	"backend/pkg/common/validator"
@@ -31,12 +36,16 @@ type DoctorUsecase interface {
 type doctorUsecase struct {
 	repo                  doctorrepository.DoctorRepository
 	drugReceiptRepository doctorrepository.DrugReceiptRepository
+	twoFactor             twofactorusecase.TwoFactorUsecase
+	guard                 loginguardusecase.LoginGuardUsecase
 }
 
-func NewDoctorUsecase(repo doctorrepository.DoctorRepository, drugReceiptRepository doctorrepository.DrugReceiptRepository) DoctorUsecase {
+func NewDoctorUsecase(repo doctorrepository.DoctorRepository, drugReceiptRepository doctorrepository.DrugReceiptRepository, twoFactor twofactorusecase.TwoFactorUsecase, guard loginguardusecase.LoginGuardUsecase) DoctorUsecase {
 	return &doctorUsecase{
 		repo:                  repo,
-		drugReceiptRepository: drugReceiptRepository}
+		drugReceiptRepository: drugReceiptRepository,
+		twoFactor:             twoFactor,
+		guard:                 guard}
 }
 
 func (s *doctorUsecase) CreateDoctor(ctx context.Context, req *dtodoctor.CreateDoctorRequest) error {
@@ -94,32 +103,27 @@ func (s *doctorUsecase) Login(ctx context.Context, req *dto.LoginRequest) (*dto.
 		return nil, errors.New("email and password are required")
 	}
 
+	attempt := &dto.LoginAttempt{Role: "doctor", Identifier: req.PhoneNumber, Client: req.Client}
+	if err := s.guard.Check(ctx, attempt); err != nil {
+		return nil, err
+	}
+
 	d, err := s.repo.GetDoctorByPhoneNumber(ctx, req.PhoneNumber)
 	if err != nil {
 		logrus.Errorf("Doctor not found with email %s: %v", req.PhoneNumber, err)
+		s.guard.Failed(ctx, attempt, "", loginguardusecase.ReasonUnknownAccount)
 		return nil, errors.New("invalid credentials")
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(d.Password), []byte(req.Password))
 	if err != nil {
 		logrus.Errorf("Invalid password for email: %s", req.PhoneNumber)
+		s.guard.Failed(ctx, attempt, d.ID.String(), loginguardusecase.ReasonWrongPassword)
 		return nil, errors.New("invalid credentials")
 	}
 
-	token := utils.GenerateToken(d.ID.String(), "doctor")
-
-	// err = s.redis.Set(context.Background(), "doctorId", token, 20*time.Minute)
-	// if err != nil {
-	// 	logrus.Warnf("failed to cache patient information: %s", err)
-	// }
-
-	resp := &dto.LoginResponse{
-		Token:   token,
-		User:    d,
-		Message: "Login successfully",
-	}
-
-	return resp, nil
+	// the session is opened here or, with a second factor, once the code is verified
+	return s.twoFactor.Login(ctx, attempt, d.ID.String(), d)
 }
 
 func (s *doctorUsecase) UpdateDoctorById(ctx context.Context, doctorId string, ud *dtodoctor.UpdateDoctorRequest) error {
@@ -180,7 +184,14 @@ func (s *doctorUsecase) CreateDrugReceipt(ctx context.Context, dr *dtodoctor.Cre
 	dr.DrugReceiptId = uuid.New()
 	req := s.drugReceiptRepository.BuildModelForRequest(dr)
 
-	if err := s.drugReceiptRepository.CreateDrugReceipt(ctx, req); err != nil {
+	err := s.drugReceiptRepository.CreateDrugReceipt(ctx, req, func(dr *patient.DrugReceipt, bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error) {
+		ev, err := rabbitmq.NewReceiptCreatedEvent(dr, bq)
+		if err != nil {
+			return nil, err
+		}
+		return []*messaging.OutboxEvent{ev}, nil
+	})
+	if err != nil {
 		logrus.Errorf("Usecase layer %+v", err)
 		return err
 	}
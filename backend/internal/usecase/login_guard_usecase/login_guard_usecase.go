@@ -0,0 +1,298 @@
+package loginguardusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLocked   = errors.New("too many failed logins, try again later")
+	ErrSlowDown = errors.New("too many failed logins, wait before trying again")
+)
+
+// reasons recorded with the failed logins
+const (
+	ReasonUnknownAccount = "unknown_account"
+	ReasonWrongPassword  = "wrong_password"
+	ReasonWrongCode      = "wrong_2fa_code"
+	ReasonDisabled       = "account_disabled"
+	// ReasonSSODenied is a single sign-on refused after the provider vouched for the user
+	ReasonSSODenied = "sso_denied"
+)
+
+const historyLimit = 50
+
+// ThrottleError refuses a login before the password is checked, RetryAfter tells when
+// the next attempt is allowed
+type ThrottleError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string { return e.Err.Error() }
+
+func (e *ThrottleError) Unwrap() error { return e.Err }
+
+type Config struct {
+	// Window is how long failures are remembered
+	Window time.Duration
+	// DelayAfter failures of an account make it wait 1s, then twice as long after each
+	// failure up to MaxDelay
+	DelayAfter int
+	MaxDelay   time.Duration
+	// LockAfter failures of an account lock it for LockDuration
+	LockAfter    int
+	LockDuration time.Duration
+	// IPLockAfter failures from one IP, on any account, lock the IP for LockDuration. The
+	// IP is the peer, X-Forwarded-For only counts from the server.trusted_proxies
+	IPLockAfter int
+}
+
+// LoginGuardUsecase throttles password guessing and keeps the login history. The login
+// usecases call Check before looking at the password, then Failed or Succeeded
+type LoginGuardUsecase interface {
+	// Check refuses the attempt with a ThrottleError while the account or the IP is
+	// locked or has to wait
+	Check(ctx context.Context, attempt *dto.LoginAttempt) error
+	// Failed counts a failed login, userId is empty when no account matched
+	Failed(ctx context.Context, attempt *dto.LoginAttempt, userId, reason string)
+	// Succeeded clears the failures of the account and records the login, flagged when
+	// the device or the network was never seen for the user
+	Succeeded(ctx context.Context, attempt *dto.LoginAttempt, userId string) *account.LoginEvent
+	History(ctx context.Context, userId string) ([]*dto.LoginEventResponse, error)
+	// Unlock lifts the lockout and the failures of an account, of an IP, or both
+	Unlock(ctx context.Context, req *dto.UnlockLoginRequest) error
+}
+
+type loginGuardUsecase struct {
+	history loginhistoryrepository.LoginHistoryRepository
+	redis   redis.RedisConnection
+	cfg     Config
+}
+
+func NewLoginGuardUsecase(history loginhistoryrepository.LoginHistoryRepository, rc redis.RedisConnection, cfg Config) LoginGuardUsecase {
+	return &loginGuardUsecase{history: history, redis: rc, cfg: cfg}
+}
+
+// accountKey names the account by what was typed in, unknown identifiers are throttled
+// like real ones so the answers do not tell them apart
+func accountKey(prefix, role, identifier string) string {
+	return fmt.Sprintf("login:%s:%s:%s", prefix, role, strings.ToLower(strings.TrimSpace(identifier)))
+}
+
+func ipKey(prefix, ip string) string {
+	return fmt.Sprintf("login:%s:ip:%s", prefix, ip)
+}
+
+func (s *loginGuardUsecase) Check(ctx context.Context, attempt *dto.LoginAttempt) error {
+	if wait := s.until(ctx, ipKey("lock", attempt.Client.IP)); wait > 0 {
+		logrus.Warnf("Login from locked IP %s refused", attempt.Client.IP)
+		return &ThrottleError{Err: ErrLocked, RetryAfter: wait}
+	}
+	if wait := s.until(ctx, accountKey("lock", attempt.Role, attempt.Identifier)); wait > 0 {
+		logrus.Warnf("Login to locked %s account %s refused", attempt.Role, attempt.Identifier)
+		return &ThrottleError{Err: ErrLocked, RetryAfter: wait}
+	}
+	if wait := s.until(ctx, accountKey("wait", attempt.Role, attempt.Identifier)); wait > 0 {
+		return &ThrottleError{Err: ErrSlowDown, RetryAfter: wait}
+	}
+	return nil
+}
+
+// until reads a deadline stored as unix milliseconds, zero once it passed or when the
+// key is missing. A failing Redis lets the login through, the password is still checked
+func (s *loginGuardUsecase) until(ctx context.Context, key string) time.Duration {
+	raw, err := s.redis.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, redis.ErrNotFound) {
+			logrus.Errorf("Usecase layer: %v", err)
+		}
+		return 0
+	}
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Until(time.UnixMilli(ms))
+}
+
+func (s *loginGuardUsecase) block(ctx context.Context, key string, d time.Duration) {
+	deadline := time.Now().Add(d).UnixMilli()
+	if err := s.redis.Set(ctx, key, deadline, d); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+	}
+}
+
+func (s *loginGuardUsecase) count(ctx context.Context, key string) int64 {
+	n, err := s.redis.Incr(ctx, key)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return 0
+	}
+	if n == 1 {
+		_ = s.redis.Expire(ctx, key, s.cfg.Window)
+	}
+	return n
+}
+
+func (s *loginGuardUsecase) Failed(ctx context.Context, attempt *dto.LoginAttempt, userId, reason string) {
+	failures := s.count(ctx, accountKey("fail", attempt.Role, attempt.Identifier))
+	switch {
+	case failures >= int64(s.cfg.LockAfter):
+		s.block(ctx, accountKey("lock", attempt.Role, attempt.Identifier), s.cfg.LockDuration)
+		_ = s.redis.Del(ctx, accountKey("fail", attempt.Role, attempt.Identifier), accountKey("wait", attempt.Role, attempt.Identifier))
+		logrus.Warnf("Locked %s account %s for %s after %d failed logins", attempt.Role, attempt.Identifier, s.cfg.LockDuration, failures)
+	case failures >= int64(s.cfg.DelayAfter):
+		delay := time.Second << min(failures-int64(s.cfg.DelayAfter), 16)
+		s.block(ctx, accountKey("wait", attempt.Role, attempt.Identifier), min(delay, s.cfg.MaxDelay))
+	}
+
+	if ip := attempt.Client.IP; ip != "" {
+		if s.count(ctx, ipKey("fail", ip)) >= int64(s.cfg.IPLockAfter) {
+			s.block(ctx, ipKey("lock", ip), s.cfg.LockDuration)
+			_ = s.redis.Del(ctx, ipKey("fail", ip))
+			logrus.Warnf("Locked IP %s for %s after too many failed logins", ip, s.cfg.LockDuration)
+		}
+	}
+
+	e := s.event(attempt, userId)
+	e.Reason = reason
+	if err := s.history.Record(ctx, e); err != nil {
+		logrus.Errorf("Failed to record login: %v", err)
+	}
+}
+
+func (s *loginGuardUsecase) Succeeded(ctx context.Context, attempt *dto.LoginAttempt, userId string) *account.LoginEvent {
+	if err := s.redis.Del(ctx, accountKey("fail", attempt.Role, attempt.Identifier), accountKey("wait", attempt.Role, attempt.Identifier)); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+	}
+
+	e := s.event(attempt, userId)
+	e.Success = true
+	if e.UserId != nil {
+		known, err := s.history.Known(ctx, *e.UserId, e.DeviceId, e.Network)
+		if err != nil {
+			logrus.Errorf("Failed to read login history: %v", err)
+		} else if known.Seen {
+			e.NewDevice = !known.Device
+			e.NewLocation = !known.Network
+		}
+	}
+	if e.NewDevice || e.NewLocation {
+		logrus.WithFields(logrus.Fields{
+			"user_id":      userId,
+			"role":         attempt.Role,
+			"ip":           e.IP,
+			"user_agent":   e.UserAgent,
+			"new_device":   e.NewDevice,
+			"new_location": e.NewLocation,
+		}).Warn("Login from a new device or location")
+	}
+
+	if err := s.history.Record(ctx, e); err != nil {
+		logrus.Errorf("Failed to record login: %v", err)
+	}
+	return e
+}
+
+func (s *loginGuardUsecase) event(attempt *dto.LoginAttempt, userId string) *account.LoginEvent {
+	e := &account.LoginEvent{
+		Role:       attempt.Role,
+		Identifier: strings.ToLower(strings.TrimSpace(attempt.Identifier)),
+		IP:         attempt.Client.IP,
+		Network:    network(attempt.Client.IP),
+		UserAgent:  attempt.Client.UserAgent,
+		DeviceId:   attempt.Client.DeviceId,
+		CreatedAt:  time.Now(),
+	}
+	// clients without a device id are told apart by their user agent
+	if e.DeviceId == "" && e.UserAgent != "" {
+		e.DeviceId = "ua:" + e.UserAgent
+		if len(e.DeviceId) > 64 {
+			e.DeviceId = e.DeviceId[:64]
+		}
+	}
+	if id, err := uuid.Parse(userId); err == nil {
+		e.UserId = &id
+	}
+	return e
+}
+
+// network is the /24 of an IPv4 or the /48 of an IPv6 address, logins from the same
+// network count as the same location
+func network(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	bits := 48
+	if addr.Unmap().Is4() {
+		addr, bits = addr.Unmap(), 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+func (s *loginGuardUsecase) History(ctx context.Context, userId string) ([]*dto.LoginEventResponse, error) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.history.ListByUser(ctx, id, historyLimit)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	resp := make([]*dto.LoginEventResponse, 0, len(events))
+	for _, e := range events {
+		resp = append(resp, &dto.LoginEventResponse{
+			Success:     e.Success,
+			Reason:      e.Reason,
+			IP:          e.IP,
+			UserAgent:   e.UserAgent,
+			DeviceId:    e.DeviceId,
+			NewDevice:   e.NewDevice,
+			NewLocation: e.NewLocation,
+			CreatedAt:   e.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+
+func (s *loginGuardUsecase) Unlock(ctx context.Context, req *dto.UnlockLoginRequest) error {
+	var keys []string
+	if req.Role != "" {
+		keys = append(keys,
+			accountKey("lock", req.Role, req.Identifier),
+			accountKey("wait", req.Role, req.Identifier),
+			accountKey("fail", req.Role, req.Identifier))
+	}
+	if req.IP != "" {
+		keys = append(keys, ipKey("lock", req.IP), ipKey("fail", req.IP))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := s.redis.Del(ctx, keys...); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	logrus.Infof("Login unlocked for %s %s %s", req.Role, req.Identifier, req.IP)
+	return nil
+}
@@ -0,0 +1,109 @@
+package loginguardusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryHistory struct {
+	loginhistoryrepository.LoginHistoryRepository
+	events []*account.LoginEvent
+}
+
+func (m *memoryHistory) Record(ctx context.Context, e *account.LoginEvent) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memoryHistory) Known(ctx context.Context, userId uuid.UUID, deviceId, network string) (*loginhistoryrepository.KnownLogin, error) {
+	known := &loginhistoryrepository.KnownLogin{}
+	for _, e := range m.events {
+		if e.Success && e.UserId != nil && *e.UserId == userId {
+			known.Seen = true
+			known.Device = known.Device || e.DeviceId == deviceId
+			known.Network = known.Network || e.Network == network
+		}
+	}
+	return known, nil
+}
+
+func TestLoginGuard_DelaysLocksAndUnlocks(t *testing.T) {
+	ctx := context.Background()
+	history := &memoryHistory{}
+	g := NewLoginGuardUsecase(history, redis.NewMemoryStore(), Config{
+		Window:       15 * time.Minute,
+		DelayAfter:   2,
+		MaxDelay:     time.Minute,
+		LockAfter:    4,
+		LockDuration: 15 * time.Minute,
+		IPLockAfter:  100,
+	})
+	attempt := &dto.LoginAttempt{Role: "nurse", Identifier: "0901234567", Client: dto.ClientInfo{IP: "10.0.0.1"}}
+
+	g.Failed(ctx, attempt, "", ReasonWrongPassword)
+	require.NoError(t, g.Check(ctx, attempt))
+
+	// from the second failure on the account has to wait
+	g.Failed(ctx, attempt, "", ReasonWrongPassword)
+	var throttled *ThrottleError
+	require.ErrorAs(t, g.Check(ctx, attempt), &throttled)
+	assert.ErrorIs(t, throttled, ErrSlowDown)
+	assert.InDelta(t, time.Second, throttled.RetryAfter, float64(100*time.Millisecond))
+
+	// other accounts are not affected
+	require.NoError(t, g.Check(ctx, &dto.LoginAttempt{Role: "nurse", Identifier: "0907654321", Client: attempt.Client}))
+
+	g.Failed(ctx, attempt, "", ReasonWrongPassword)
+	require.ErrorAs(t, g.Check(ctx, attempt), &throttled)
+	assert.InDelta(t, 2*time.Second, throttled.RetryAfter, float64(100*time.Millisecond))
+
+	g.Failed(ctx, attempt, "", ReasonWrongPassword)
+	require.ErrorAs(t, g.Check(ctx, attempt), &throttled)
+	assert.ErrorIs(t, throttled, ErrLocked)
+	assert.Greater(t, throttled.RetryAfter, 14*time.Minute)
+	assert.Len(t, history.events, 4)
+
+	require.NoError(t, g.Unlock(ctx, &dto.UnlockLoginRequest{Role: "nurse", Identifier: "0901234567"}))
+	assert.NoError(t, g.Check(ctx, attempt))
+}
+
+func TestLoginGuard_IPLockAndNewDeviceFlags(t *testing.T) {
+	ctx := context.Background()
+	history := &memoryHistory{}
+	g := NewLoginGuardUsecase(history, redis.NewMemoryStore(), Config{
+		Window: 15 * time.Minute, DelayAfter: 10, MaxDelay: time.Minute, LockAfter: 10, LockDuration: time.Minute, IPLockAfter: 3,
+	})
+
+	// one IP spraying many accounts gets locked
+	for _, phone := range []string{"0900000001", "0900000002", "0900000003"} {
+		g.Failed(ctx, &dto.LoginAttempt{Role: "patient", Identifier: phone, Client: dto.ClientInfo{IP: "203.0.113.9"}}, "", ReasonUnknownAccount)
+	}
+	var throttled *ThrottleError
+	require.ErrorAs(t, g.Check(ctx, &dto.LoginAttempt{Role: "patient", Identifier: "0900000004", Client: dto.ClientInfo{IP: "203.0.113.9"}}), &throttled)
+	assert.ErrorIs(t, throttled, ErrLocked)
+
+	userId := uuid.NewString()
+	home := &dto.LoginAttempt{Role: "doctor", Identifier: "0911111111", Client: dto.ClientInfo{IP: "198.51.100.7", DeviceId: "laptop"}}
+
+	first := g.Succeeded(ctx, home, userId)
+	assert.False(t, first.NewDevice || first.NewLocation, "the first login sets the baseline")
+
+	// same network, another address
+	home.Client.IP = "198.51.100.42"
+	again := g.Succeeded(ctx, home, userId)
+	assert.False(t, again.NewDevice || again.NewLocation)
+
+	away := g.Succeeded(ctx, &dto.LoginAttempt{Role: "doctor", Identifier: "0911111111", Client: dto.ClientInfo{IP: "192.0.2.1", DeviceId: "phone"}}, userId)
+	assert.True(t, away.NewDevice)
+	assert.True(t, away.NewLocation)
+	assert.Equal(t, "192.0.2.0/24", away.Network)
+}
@@ -0,0 +1,112 @@
+package messagequeue
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/messaging"
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/pkg/common/pagination"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// StartDeadLetterConsumer archives every dead-lettered message into the database
+// so that admins can inspect, requeue or discard it
+func (rbmq *rabbitMQUsecase) StartDeadLetterConsumer(ctx context.Context) error {
+	ch, err := rbmq.setupInfrastructure()
+	if err != nil {
+		return fmt.Errorf("failed to setup infrastructure for dead-letter consumer: %w", err)
+	}
+
+	// no retry policy here: if archiving fails the message stays in the dead-letter queue
+	err = rbmq.uc.ConsumeWithOptions(ctx, ch, rabbitmq.DeadLetterQueue, rbmq.archiveDeadLetter, rabbitmq.ConsumeOptions{})
+	if err != nil {
+		logrus.Errorf("Failed to start dead-letter consumer: %v", err)
+		return fmt.Errorf("failed to start dead-letter consumer: %w", err)
+	}
+	return nil
+}
+
+func (rbmq *rabbitMQUsecase) archiveDeadLetter(d amqp091.Delivery) error {
+	sourceQueue, _ := d.Headers[rabbitmq.HeaderSourceQueue].(string)
+	lastError, _ := d.Headers[rabbitmq.HeaderLastError].(string)
+
+	dl := &messaging.DeadLetter{
+		SourceQueue: sourceQueue,
+		MessageId:   d.MessageId,
+		MessageType: d.Type,
+		Body:        string(d.Body),
+		Attempts:    rabbitmq.AttemptOf(d.Headers),
+		LastError:   lastError,
+		Status:      messaging.DeadLetterStatusDead,
+	}
+
+	if err := rbmq.dlrepo.Create(context.Background(), dl); err != nil {
+		logrus.Errorf("Failed to archive dead letter from queue %s: %v", sourceQueue, err)
+		return err
+	}
+
+	logrus.Warnf("Archived dead letter %d from queue %s", dl.DeadLetterId, sourceQueue)
+	return nil
+}
+
+func (rbmq *rabbitMQUsecase) GetDeadLetters(ctx context.Context, pagination *pagination.Pagination, status string) ([]*dtoqueue.DeadLetterResponse, error) {
+	dl, err := rbmq.dlrepo.List(ctx, pagination, status)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return dtoqueue.ConvertToDeadLetterList(dl), nil
+}
+
+// RequeueDeadLetter publishes the archived message back to its source queue with a fresh attempt count
+func (rbmq *rabbitMQUsecase) RequeueDeadLetter(ctx context.Context, id int) error {
+	dl, err := rbmq.dlrepo.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if dl.Status != messaging.DeadLetterStatusDead {
+		return errors.New("dead letter has already been resolved")
+	}
+	if dl.SourceQueue == "" {
+		return errors.New("dead letter has no source queue")
+	}
+
+	ch, err := rbmq.setupInfrastructure()
+	if err != nil {
+		return fmt.Errorf("failed to setup infrastructure: %w", err)
+	}
+	defer rbmq.uc.CloseChannel(ch)
+	// the dead letter is marked requeued only once the broker has the message
+	if err := rbmq.uc.ConfirmChannel(ch); err != nil {
+		return err
+	}
+
+	err = rbmq.uc.PublishConfirmed(ctx, ch, "", dl.SourceQueue, amqp091.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    dl.MessageId,
+		Type:         dl.MessageType,
+		Body:         []byte(dl.Body),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to requeue dead letter %d: %v", id, err)
+		return err
+	}
+
+	return rbmq.dlrepo.UpdateStatus(ctx, id, messaging.DeadLetterStatusRequeued)
+}
+
+func (rbmq *rabbitMQUsecase) DiscardDeadLetter(ctx context.Context, id int) error {
+	dl, err := rbmq.dlrepo.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if dl.Status != messaging.DeadLetterStatusDead {
+		return errors.New("dead letter has already been resolved")
+	}
+	return rbmq.dlrepo.UpdateStatus(ctx, id, messaging.DeadLetterStatusDiscarded)
+}
@@ -0,0 +1,103 @@
+package messagequeue
+
+import (
+	"backend/internal/domain/messaging"
+	"backend/internal/infrastructure/rabbitmq"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 100
+)
+
+// StartOutboxRelay publishes pending outbox rows until ctx is cancelled. The relay keeps
+// one channel on the shared connection, in confirm mode, and rows are marked sent only
+// after the broker acked them, so a crash can at worst publish an event twice; consumers
+// are idempotent on the booking / payment session id.
+func (rbmq *rabbitMQUsecase) StartOutboxRelay(ctx context.Context) error {
+	ch, err := rbmq.setupInfrastructure()
+	if err != nil {
+		return fmt.Errorf("failed to setup infrastructure for outbox relay: %w", err)
+	}
+	if err := rbmq.uc.ConfirmChannel(ch); err != nil {
+		_ = rbmq.uc.CloseChannel(ch)
+		return fmt.Errorf("failed to setup infrastructure for outbox relay: %w", err)
+	}
+
+	logrus.Info("Started outbox relay")
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Context cancelled, stopping outbox relay")
+			if ch != nil {
+				_ = rbmq.uc.CloseChannel(ch)
+			}
+			return nil
+		case <-ticker.C:
+		}
+
+		// reopen the channel after a broker failure, the connection redials on its own
+		if ch == nil || ch.IsClosed() {
+			ch, err = rbmq.relayChannel()
+			if err != nil {
+				logrus.Errorf("Outbox relay could not reopen channel: %v", err)
+				ch = nil
+				continue
+			}
+		}
+
+		for {
+			sent, err := rbmq.obrepo.RelayPending(ctx, outboxBatchSize, func(ev *messaging.OutboxEvent) error {
+				return rbmq.uc.PublishConfirmed(ctx, ch, ev.Exchange, ev.RoutingKey, amqp091.Publishing{
+					ContentType:  "application/json",
+					DeliveryMode: amqp091.Persistent,
+					MessageId:    strconv.FormatInt(ev.OutboxId, 10),
+					Type:         ev.EventType,
+					Timestamp:    ev.CreatedAt,
+					Headers:      amqp091.Table{rabbitmq.HeaderAggregateId: ev.AggregateId},
+					Body:         []byte(ev.Payload),
+				})
+			})
+			if err != nil {
+				logrus.Errorf("Outbox relay failed: %v", err)
+				break
+			}
+			if sent > 0 {
+				logrus.Infof("Outbox relay published %d event(s)", sent)
+			}
+			// a full batch means more rows may be waiting
+			if sent < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relayChannel opens a channel in confirm mode on the shared connection, the topology
+// was declared when the relay started
+func (rbmq *rabbitMQUsecase) relayChannel() (*amqp091.Channel, error) {
+	conn, err := rbmq.uc.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := rbmq.uc.GetChannel(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := rbmq.uc.ConfirmChannel(ch); err != nil {
+		_ = rbmq.uc.CloseChannel(ch)
+		return nil, err
+	}
+	return ch, nil
+}
@@ -2,11 +2,12 @@ package messagequeue
 
 import (
 	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/messaging"
 	"backend/internal/domain/patient"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
 	persistence "backend/internal/infrastructure/persistence/service_repository"
 	"backend/internal/infrastructure/rabbitmq"
-	"backend/internal/infrastructure/redis"
-	"backend/pkg/constants"
+	"backend/pkg/common/pagination"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -19,24 +20,39 @@ type RabbitMQUsecase interface {
 	PublishBooking(ctx context.Context, data *dtoqueue.BookingQueuePublish) error
 	StartBookingConsumer(ctx context.Context) error
 	SetupInfrastructure(ctx context.Context) error
+
+	// outbox
+	StartOutboxRelay(ctx context.Context) error
+
+	// dead letters
+	StartDeadLetterConsumer(ctx context.Context) error
+	GetDeadLetters(ctx context.Context, pagination *pagination.Pagination, status string) ([]*dtoqueue.DeadLetterResponse, error)
+	RequeueDeadLetter(ctx context.Context, id int) error
+	DiscardDeadLetter(ctx context.Context, id int) error
 }
 
 type rabbitMQUsecase struct {
 	uc     rabbitmq.RabbitMQConnection
+	bus    rabbitmq.EventBus
 	bqrepo persistence.BookingQueuueRepository
-	redis  redis.RedisClient
+	dlrepo messagingrepository.DeadLetterRepository
+	obrepo messagingrepository.OutboxRepository
 }
 
-func NewRabbitMQUsecase(rabbitConn rabbitmq.RabbitMQConnection, bqrepo persistence.BookingQueuueRepository, redis redis.RedisClient) RabbitMQUsecase {
+func NewRabbitMQUsecase(rabbitConn rabbitmq.RabbitMQConnection, bus rabbitmq.EventBus, bqrepo persistence.BookingQueuueRepository, dlrepo messagingrepository.DeadLetterRepository, obrepo messagingrepository.OutboxRepository) RabbitMQUsecase {
 	return &rabbitMQUsecase{
 		uc:     rabbitConn,
+		bus:    bus,
 		bqrepo: bqrepo,
-		redis:  redis,
+		dlrepo: dlrepo,
+		obrepo: obrepo,
 	}
 }
 
+// setupInfrastructure opens a channel on the shared connection and declares the
+// topology, the caller closes the channel
 func (rbmq *rabbitMQUsecase) setupInfrastructure() (*amqp091.Channel, error) {
-	conn, err := rbmq.uc.Connect()
+	conn, err := rbmq.uc.GetConnection()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
@@ -45,34 +61,49 @@ func (rbmq *rabbitMQUsecase) setupInfrastructure() (*amqp091.Channel, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := rbmq.declareTopology(ch); err != nil {
+		_ = rbmq.uc.CloseChannel(ch)
+		return nil, err
+	}
+	return ch, nil
+}
 
+func (rbmq *rabbitMQUsecase) declareTopology(ch *amqp091.Channel) error {
 	// register an exchange
-	err = rbmq.uc.ExchangeDeclare(ch, rabbitmq.ExchangeName, "direct", true)
+	err := rbmq.uc.ExchangeDeclare(ch, rabbitmq.ExchangeName, "direct", true)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// register a queue
 	_, err = rbmq.uc.QueueDeclare(ch, rabbitmq.QueueName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// bind queue to exchange
 	err = rbmq.uc.QueueBind(ch, rabbitmq.QueueName, rabbitmq.ExchangeName, rabbitmq.ServiceRegisterEvent)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return ch, nil
+
+	// retry queues and dead-letter exchange for failed bookings
+	err = rbmq.uc.DeclareRetryTopology(ch, rabbitmq.QueueName)
+	if err != nil {
+		return err
+	}
+
+	// outbox rows of domain events are relayed to the events exchange
+	return rbmq.uc.ExchangeDeclare(ch, rabbitmq.EventsExchange, amqp091.ExchangeTopic, true)
 }
 
 func (rbmq *rabbitMQUsecase) SetupInfrastructure(ctx context.Context) error {
-	_, err := rbmq.setupInfrastructure()
+	ch, err := rbmq.setupInfrastructure()
 	if err != nil {
 		logrus.Errorf("Failed to setup RabbitMQ infrastructure: %v", err)
 		return fmt.Errorf("failed to setup RabbitMQ infrastructure: %w", err)
 	}
-	return nil
+	return rbmq.uc.CloseChannel(ch)
 }
 
 func (rbmq *rabbitMQUsecase) PublishBooking(ctx context.Context, data *dtoqueue.BookingQueuePublish) error {
@@ -97,8 +128,11 @@ func (rbmq *rabbitMQUsecase) StartBookingConsumer(ctx context.Context) error {
 		return fmt.Errorf("failed to setup infrastructure for consumer: %w", err)
 	}
 
-	// Start consuming with message handler
-	err = rbmq.uc.Consume(ctx, ch, rabbitmq.QueueName, rbmq.processBookingMessage)
+	// bookings of one patient are created in the order they were paid
+	opts := rabbitmq.ConsumeOptions{PartitionKey: bookingPartitionKey}
+	err = rbmq.uc.ConsumeDeliveries(ctx, ch, rabbitmq.QueueName, func(d amqp091.Delivery) error {
+		return rbmq.processBookingMessage(d.Body)
+	}, opts)
 	if err != nil {
 		logrus.Errorf("Failed to start booking consumer: %v", err)
 		return fmt.Errorf("failed to start booking consumer: %w", err)
@@ -107,6 +141,16 @@ func (rbmq *rabbitMQUsecase) StartBookingConsumer(ctx context.Context) error {
 	return nil
 }
 
+// bookingPartitionKey is the patient of a booking message, malformed bodies get no key
+// and are dead-lettered by processBookingMessage anyway
+func bookingPartitionKey(d amqp091.Delivery) string {
+	var data struct {
+		PatientId string `json:"patient_id"`
+	}
+	_ = json.Unmarshal(d.Body, &data)
+	return data.PatientId
+}
+
 // Business logic for processing booking messages
 func (rbmq *rabbitMQUsecase) processBookingMessage(messageBody []byte) error {
 
@@ -114,7 +158,8 @@ func (rbmq *rabbitMQUsecase) processBookingMessage(messageBody []byte) error {
 	var bookingData dtoqueue.BookingQueuePublish
 	if err := json.Unmarshal(messageBody, &bookingData); err != nil {
 		logrus.Errorf("Failed to unmarshal booking message: %v", err)
-		return fmt.Errorf("failed to unmarshal booking message: %w", err)
+		// a malformed payload will never succeed, skip the retries
+		return rabbitmq.Permanent(fmt.Errorf("failed to unmarshal booking message: %w", err))
 	}
 
 	// Business logic processing
@@ -142,32 +187,25 @@ func (rbmq *rabbitMQUsecase) processBookingBusinessLogic(data *dtoqueue.BookingQ
 		ServiceCost:        data.Cost,
 		PaymentStatus:      patient.PaymentStatus(data.PaymentStatus),
 		BookingStatus:      patient.BookingStatus(data.BookingStatus),
+		PaymentSessionId:   data.PaymentSessionId,
 		AppointmentDate:    data.AppointmentDate,
 		CreatedAt:          data.CreatedAt,
 	}
 
-	err := rbmq.bqrepo.Create(ctx, bq)
+	// the cache is updated from booking.created once the outbox relays it
+	created, err := rbmq.bqrepo.Create(ctx, bq, func(bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error) {
+		ev, err := rabbitmq.NewBookingCreatedEvent(bq)
+		if err != nil {
+			return nil, err
+		}
+		return []*messaging.OutboxEvent{ev}, nil
+	})
 	if err != nil {
 		logrus.Error("Failed to create booking_queue in database")
 		return err
 	}
-
-	bqMarshaled, err := json.Marshal(bq)
-	if err != nil {
-		logrus.Error("Failed when marshalling")
-		return err
-	}
-
-	_, err = rbmq.redis.HSet(ctx, "queue", bq.QueueId, bqMarshaled)
-	if err != nil {
-		logrus.Error("failed to set data on cache has")
-		return err
-	}
-
-	err = rbmq.redis.Publish(ctx, constants.CHANNEL_REDIS, "updated")
-	if err != nil {
-		logrus.Errorf("Publish to %s channel failed", constants.CHANNEL_REDIS)
-		return err
+	if !created {
+		logrus.Infof("Skipped duplicate booking for payment session %s", data.PaymentSessionId)
 	}
 
 	return nil
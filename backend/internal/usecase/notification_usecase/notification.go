@@ -0,0 +1,133 @@
+package notificationusecase
+
+import (
+	"backend/internal/infrastructure/rabbitmq"
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const notificationSubscriber = "notifications"
+
+// Notification is a message addressed to one patient
+type Notification struct {
+	PatientId uuid.UUID
+	Email     string
+	Subject   string
+	Message   string
+}
+
+// Notifier delivers notifications, e.g. by email or SMS
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// logNotifier only logs, it is used until a real provider is configured
+type logNotifier struct{}
+
+func NewLogNotifier() Notifier {
+	return &logNotifier{}
+}
+
+func (n *logNotifier) Notify(ctx context.Context, notification *Notification) error {
+	logrus.Infof("Notify patient %s <%s>: %s - %s", notification.PatientId, notification.Email, notification.Subject, notification.Message)
+	return nil
+}
+
+type NotificationUsecase interface {
+	StartNotificationConsumer(ctx context.Context) error
+}
+
+type notificationUsecase struct {
+	bus      rabbitmq.EventBus
+	notifier Notifier
+}
+
+func NewNotificationUsecase(bus rabbitmq.EventBus, notifier Notifier) NotificationUsecase {
+	return &notificationUsecase{
+		bus:      bus,
+		notifier: notifier,
+	}
+}
+
+func (s *notificationUsecase) StartNotificationConsumer(ctx context.Context) error {
+	routingKeys := []string{
+		rabbitmq.BookingCreatedEvent,
+		rabbitmq.BookingStatusChangedEvent,
+		rabbitmq.ReceiptCreatedEvent,
+		rabbitmq.PatientRegisteredEvent,
+	}
+	err := s.bus.Subscribe(ctx, notificationSubscriber, routingKeys, s.handleEvent)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return fmt.Errorf("failed to start notification consumer: %w", err)
+	}
+	return nil
+}
+
+func (s *notificationUsecase) handleEvent(ctx context.Context, env *rabbitmq.Envelope) error {
+	n, err := buildNotification(env)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return nil
+	}
+	return s.notifier.Notify(ctx, n)
+}
+
+func buildNotification(env *rabbitmq.Envelope) (*Notification, error) {
+	switch env.Type {
+	case rabbitmq.BookingCreatedEvent:
+		var ev rabbitmq.BookingCreated
+		if err := env.Decode(&ev); err != nil {
+			return nil, err
+		}
+		return &Notification{
+			PatientId: ev.PatientId,
+			Email:     ev.PatientEmail,
+			Subject:   "Booking confirmed",
+			Message:   fmt.Sprintf("Your booking #%d for %s on %s is confirmed", ev.QueueId, ev.ServiceName, ev.AppointmentDate.Format("02/01/2006")),
+		}, nil
+
+	case rabbitmq.BookingStatusChangedEvent:
+		var ev rabbitmq.BookingStatusChanged
+		if err := env.Decode(&ev); err != nil {
+			return nil, err
+		}
+		return &Notification{
+			PatientId: ev.PatientId,
+			Subject:   "Booking updated",
+			Message:   fmt.Sprintf("Your booking #%d is now %s", ev.QueueId, ev.To),
+		}, nil
+
+	case rabbitmq.ReceiptCreatedEvent:
+		var ev rabbitmq.ReceiptCreated
+		if err := env.Decode(&ev); err != nil {
+			return nil, err
+		}
+		return &Notification{
+			PatientId: ev.PatientId,
+			Email:     ev.PatientEmail,
+			Subject:   "Prescription ready",
+			Message:   fmt.Sprintf("Your prescription for booking #%d is ready", ev.QueueId),
+		}, nil
+
+	case rabbitmq.PatientRegisteredEvent:
+		var ev rabbitmq.PatientRegistered
+		if err := env.Decode(&ev); err != nil {
+			return nil, err
+		}
+		return &Notification{
+			PatientId: ev.PatientId,
+			Email:     ev.Email,
+			Subject:   "Welcome",
+			Message:   fmt.Sprintf("Welcome %s, your account has been created", ev.FullName),
+		}, nil
+	}
+
+	// event types added later are ignored rather than dead-lettered
+	return nil, nil
+}
@@ -4,6 +4,8 @@ import (
 	"backend/internal/domain/dto"
 	dtonurse "backend/internal/domain/dto/dto_nurse"
 	nurserepository "backend/internal/infrastructure/persistence/staff_repository/nurse_repository"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
 	"backend/pkg/common/pagination"
 	"backend/pkg/common/utils"
 	"backend/pkg/common/validator"
@@ -25,11 +27,13 @@ type NurseUsecase interface {
 }
 
 type nurseUsecase struct {
-	repo nurserepository.NurseRepository
+	repo      nurserepository.NurseRepository
+	twoFactor twofactorusecase.TwoFactorUsecase
+	guard     loginguardusecase.LoginGuardUsecase
 }
 
-func NewNurseService(repo nurserepository.NurseRepository) NurseUsecase {
-	return &nurseUsecase{repo: repo}
+func NewNurseService(repo nurserepository.NurseRepository, twoFactor twofactorusecase.TwoFactorUsecase, guard loginguardusecase.LoginGuardUsecase) NurseUsecase {
+	return &nurseUsecase{repo: repo, twoFactor: twoFactor, guard: guard}
 }
 
 func (s *nurseUsecase) CreateNurse(ctx context.Context, d *dtonurse.CreateNurseRequest) error {
@@ -85,27 +89,27 @@ func (s *nurseUsecase) Login(ctx context.Context, req *dto.LoginRequest) (*dto.L
 		return nil, errors.New("email and password are required")
 	}
 
+	attempt := &dto.LoginAttempt{Role: "nurse", Identifier: req.PhoneNumber, Client: req.Client}
+	if err := s.guard.Check(ctx, attempt); err != nil {
+		return nil, err
+	}
+
 	n, err := s.repo.GetNurseByPhoneNumber(ctx, req.PhoneNumber)
 	if err != nil {
 		logrus.Errorf("Nurse not found with email %s: %v", req.PhoneNumber, err)
+		s.guard.Failed(ctx, attempt, "", loginguardusecase.ReasonUnknownAccount)
 		return nil, errors.New("invalid credentials")
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(n.Password), []byte(req.Password))
 	if err != nil {
 		logrus.Errorf("Invalid password for email: %s", req.PhoneNumber)
+		s.guard.Failed(ctx, attempt, n.ID.String(), loginguardusecase.ReasonWrongPassword)
 		return nil, errors.New("invalid credentials")
 	}
 
-	token := utils.GenerateToken(n.ID.String(), "nurse")
-
-	resp := &dto.LoginResponse{
-		Token:   token,
-		User:    n, // Include nurse data
-		Message: "Login successfully",
-	}
-
-	return resp, nil
+	// the session is opened here or, with a second factor, once the code is verified
+	return s.twoFactor.Login(ctx, attempt, n.ID.String(), n)
 }
 
 func (s *nurseUsecase) UpdateNurseById(ctx context.Context, nurseId string, ud *dtonurse.UpdateNurseRequest) error {
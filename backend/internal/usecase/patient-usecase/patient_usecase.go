@@ -4,7 +4,9 @@ import (
 	"backend/internal/domain/dto"
 	dtopatient "backend/internal/domain/dto/dto_patient"
 	patientrepository "backend/internal/infrastructure/persistence/patient_repository"
-	"backend/internal/infrastructure/redis"
+	"backend/internal/infrastructure/rabbitmq"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
 	"backend/pkg/common/pagination"
 	"backend/pkg/common/utils"
 	cloudinaryutils "backend/pkg/common/utils/cloudinary_utils"
@@ -13,7 +15,6 @@ import (
 	"context"
 	"errors"
 	"mime/multipart"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -24,6 +25,8 @@ type PatientUsecase interface {
 	CreatePatient(ctx context.Context, d *dtopatient.CreatePatientRequest, file *multipart.FileHeader) error
 	GetPatientById(ctx context.Context, id string) (*dtopatient.PatientResponse, error)
 	GetPatients(ctx context.Context, pagination *pagination.Pagination) ([]*dtopatient.PatientResponse, error)
+	// GetPatientsOfStaff lists the patients a doctor or nurse is allowed to see
+	GetPatientsOfStaff(ctx context.Context, pagination *pagination.Pagination, staffId string, facultyId byte) ([]*dtopatient.PatientResponse, error)
 	UpdatePatient(ctx context.Context, patientId string, d *dtopatient.UpdatePatientRequest, file *multipart.FileHeader) error
 	DeletePatientById(ctx context.Context, id string) error
 	LoginPatient(ctx context.Context, req *dto.LoginRequest) (*dto.LoginResponse, error)
@@ -32,14 +35,16 @@ type PatientUsecase interface {
 type patientUsecase struct {
 	repo           patientrepository.PatientRepository
 	avatarUploader cloudinaryutils.AvatarUploader
-	redis          redis.RedisClient
+	sessions       sessionusecase.SessionUsecase
+	guard          loginguardusecase.LoginGuardUsecase
 }
 
-func NewPatientService(repo patientrepository.PatientRepository, redisConn redis.RedisClient, avatarUploader cloudinaryutils.AvatarUploader) PatientUsecase {
+func NewPatientService(repo patientrepository.PatientRepository, sessions sessionusecase.SessionUsecase, avatarUploader cloudinaryutils.AvatarUploader, guard loginguardusecase.LoginGuardUsecase) PatientUsecase {
 	return &patientUsecase{
 		repo:           repo,
-		redis:          redisConn,
+		sessions:       sessions,
 		avatarUploader: avatarUploader,
+		guard:          guard,
 	}
 }
 
@@ -88,8 +93,15 @@ func (s *patientUsecase) CreatePatient(ctx context.Context, d *dtopatient.Create
 		logrus.Errorf("Failed to build patient model: %v", err)
 		return err
 	}
+
+	ev, err := rabbitmq.NewPatientRegisteredEvent(p)
+	if err != nil {
+		logrus.Errorf("Failed to build patient.registered event: %v", err)
+		return err
+	}
+
 	// main logic
-	return s.repo.CreatePatient(ctx, p)
+	return s.repo.CreatePatient(ctx, p, ev)
 }
 
 func (s *patientUsecase) LoginPatient(ctx context.Context, req *dto.LoginRequest) (*dto.LoginResponse, error) {
@@ -99,10 +111,17 @@ func (s *patientUsecase) LoginPatient(ctx context.Context, req *dto.LoginRequest
 		return nil, errors.New("email and password are required")
 	}
 
+	// refuse locked accounts before touching the password
+	attempt := &dto.LoginAttempt{Role: "patient", Identifier: req.PhoneNumber, Client: req.Client}
+	if err := s.guard.Check(ctx, attempt); err != nil {
+		return nil, err
+	}
+
 	// get patient by email
 	p, err := s.repo.GetPatientByPhoneNumber(ctx, req.PhoneNumber)
 	if err != nil {
 		logrus.Errorf("Patient not found with email %s: %v", req.PhoneNumber, err)
+		s.guard.Failed(ctx, attempt, "", loginguardusecase.ReasonUnknownAccount)
 		return nil, errors.New("invalid credentials")
 	}
 
@@ -110,28 +129,20 @@ func (s *patientUsecase) LoginPatient(ctx context.Context, req *dto.LoginRequest
 	err = bcrypt.CompareHashAndPassword([]byte(p.Password), []byte(req.Password))
 	if err != nil {
 		logrus.Errorf("Invalid password for email %s", req.PhoneNumber)
+		s.guard.Failed(ctx, attempt, p.PatientId.String(), loginguardusecase.ReasonWrongPassword)
 		return nil, errors.New("invalid credentials")
 	}
+	s.guard.Succeeded(ctx, attempt, p.PatientId.String())
 
-	// generate new token and sign it to key
-	token := utils.GenerateToken(p.PatientId.String(), "patient")
-
-	// Cache token in Redis if redis connection is available
-	// if s.redis != nil {
-	err = s.redis.Set(context.Background(), "patientId", token, 20*time.Minute)
+	// open a session for this device, it issues the access and refresh tokens
+	tokens, err := s.sessions.Start(ctx, p.PatientId.String(), "patient", &req.Client)
 	if err != nil {
-		logrus.Warnf("failed to cache patient information: %s", err)
+		logrus.Errorf("Failed to start session: %v", err)
+		return nil, err
 	}
-	// } else {
-	// 	logrus.Warn("Redis connection not available, skipping token caching")
-	// }
 
 	// return response
-	resp := &dto.LoginResponse{
-		Token:   token,
-		User:    p, // Include patient data
-		Message: "Login successfully",
-	}
+	resp := dto.NewLoginResponse(tokens, p, "Login successfully") // Include patient data
 
 	logrus.Infof("User %s logged in successfully", req.PhoneNumber)
 	return resp, nil
@@ -169,6 +180,20 @@ func (s *patientUsecase) GetPatients(ctx context.Context, pagination *pagination
 	return responses, nil
 }
 
+func (s *patientUsecase) GetPatientsOfStaff(ctx context.Context, pagination *pagination.Pagination, staffId string, facultyId byte) ([]*dtopatient.PatientResponse, error) {
+	id, err := uuid.Parse(staffId)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	patients, err := s.repo.GetPatientsOfStaff(ctx, pagination, id, facultyId)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return dtopatient.ConvertToPatientList(patients), nil
+}
+
 func (s *patientUsecase) UpdatePatient(ctx context.Context, patientId string, ud *dtopatient.UpdatePatientRequest, file *multipart.FileHeader) error {
 	var hashedPassword []byte
 	var err error
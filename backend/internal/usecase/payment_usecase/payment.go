@@ -4,7 +4,11 @@ import (
 	dtopatient "backend/internal/domain/dto/dto_patient"
 	"backend/internal/domain/dto/dtoservice"
 	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/patient"
+	paymentrepository "backend/internal/infrastructure/persistence/payment_repository"
+	"backend/internal/infrastructure/rabbitmq"
 	"backend/pkg/common/utils"
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
@@ -20,12 +24,15 @@ import (
 type PaymentMethods interface {
 	CreateCheckoutSession(patient *dtopatient.PatientResponse, service *dtoservice.ServiceResponse, appointment dtoservice.AppointmentRequest) (*stripe.CheckoutSession, error)
 	WebhookCheckAndSolving(event stripe.Event) (*dtoqueue.BookingQueuePublish, error)
+	ConfirmPayment(ctx context.Context, data *dtoqueue.BookingQueuePublish) error
 }
 
-type paymentMethods struct{}
+type paymentMethods struct {
+	paymentRepo paymentrepository.PaymentRepository
+}
 
-func NewPaymentMethods() PaymentMethods {
-	return &paymentMethods{}
+func NewPaymentMethods(paymentRepo paymentrepository.PaymentRepository) PaymentMethods {
+	return &paymentMethods{paymentRepo: paymentRepo}
 }
 
 func (p *paymentMethods) CreateCheckoutSession(patient *dtopatient.PatientResponse, service *dtoservice.ServiceResponse, appointment dtoservice.AppointmentRequest) (*stripe.CheckoutSession, error) {
@@ -138,9 +145,44 @@ func (p *paymentMethods) WebhookCheckAndSolving(event stripe.Event) (*dtoqueue.B
 		Cost:               serviceCost,
 		PaymentStatus:      "paid",
 		BookingStatus:      "waiting",
+		PaymentSessionId:   session.ID,
 		CreatedAt:          time.Now(),
 		AppointmentDate:    appointmentDate,
 	}
-	
+
 	return bookingQueuePublish, nil
 }
+
+// ConfirmPayment stores the payment and the booking request in one transaction,
+// the outbox relay then hands the booking to the booking consumer.
+// A session that was already confirmed (webhook retry) is ignored.
+func (p *paymentMethods) ConfirmPayment(ctx context.Context, data *dtoqueue.BookingQueuePublish) error {
+	if data.PaymentSessionId == "" {
+		return errors.New("missing payment session id")
+	}
+
+	ev, err := rabbitmq.NewServiceRegisterEvent(data)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	payment := &patient.Payment{
+		SessionId: data.PaymentSessionId,
+		PatientId: data.PatientId,
+		ServiceId: data.ServiceId,
+		Amount:    data.Cost,
+		Status:    patient.PaymentStatus(data.PaymentStatus),
+		PaidAt:    time.Now(),
+	}
+
+	confirmed, err := p.paymentRepo.ConfirmPayment(ctx, payment, ev)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !confirmed {
+		logrus.Infof("Payment session %s was already confirmed", data.PaymentSessionId)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package policyusecase
+
+import (
+	"backend/internal/domain/dto"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrProtectedPolicy guards the admin rules, removing them would lock every admin
+	// out of the policy API and adding admin roles would hand it out past the admin accounts
+	ErrProtectedPolicy = errors.New("policy is protected")
+	ErrPolicyNotFound  = errors.New("policy not found")
+	ErrPolicyExists    = errors.New("policy already exists")
+)
+
+const (
+	adminRole    = "admin"
+	adminObject  = "/api/admin/*"
+	objectPrefix = "/api/"
+)
+
+var policyActions = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+}
+
+// PolicyUsecase manages the Casbin policy, every change is saved by the adapter and
+// the other instances reload through the watcher
+type PolicyUsecase interface {
+	List(ctx context.Context) (*dto.PolicyResponse, error)
+	AddPolicy(ctx context.Context, req *dto.PolicyRequest) error
+	RemovePolicy(ctx context.Context, req *dto.PolicyRequest) error
+	AddRole(ctx context.Context, req *dto.RoleRequest) error
+	RemoveRole(ctx context.Context, req *dto.RoleRequest) error
+	// Reload reads the policy from the database again, on this instance only
+	Reload(ctx context.Context) error
+}
+
+type policyUsecase struct {
+	enforcer *casbin.SyncedEnforcer
+}
+
+func NewPolicyUsecase(e *casbin.SyncedEnforcer) PolicyUsecase {
+	return &policyUsecase{enforcer: e}
+}
+
+func (u *policyUsecase) List(ctx context.Context) (*dto.PolicyResponse, error) {
+	policies, err := u.enforcer.GetPolicy()
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	grouping, err := u.enforcer.GetGroupingPolicy()
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	resp := &dto.PolicyResponse{
+		Policies: make([]*dto.PolicyRequest, 0, len(policies)),
+		Roles:    make([]*dto.RoleRequest, 0, len(grouping)),
+	}
+	for _, p := range policies {
+		if len(p) < 3 {
+			continue
+		}
+		resp.Policies = append(resp.Policies, &dto.PolicyRequest{Subject: p[0], Object: p[1], Action: p[2]})
+	}
+	for _, g := range grouping {
+		if len(g) < 2 {
+			continue
+		}
+		resp.Roles = append(resp.Roles, &dto.RoleRequest{User: g[0], Role: g[1]})
+	}
+	return resp, nil
+}
+
+func (u *policyUsecase) AddPolicy(ctx context.Context, req *dto.PolicyRequest) error {
+	if err := normalizePolicy(req); err != nil {
+		return err
+	}
+	added, err := u.enforcer.AddPolicy(req.Subject, req.Object, req.Action)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !added {
+		return ErrPolicyExists
+	}
+	logrus.Infof("Policy added: %s %s %s", req.Subject, req.Object, req.Action)
+	return nil
+}
+
+func (u *policyUsecase) RemovePolicy(ctx context.Context, req *dto.PolicyRequest) error {
+	if err := normalizePolicy(req); err != nil {
+		return err
+	}
+	if req.Subject == adminRole && req.Object == adminObject {
+		return ErrProtectedPolicy
+	}
+	removed, err := u.enforcer.RemovePolicy(req.Subject, req.Object, req.Action)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !removed {
+		return ErrPolicyNotFound
+	}
+	logrus.Infof("Policy removed: %s %s %s", req.Subject, req.Object, req.Action)
+	return nil
+}
+
+func (u *policyUsecase) AddRole(ctx context.Context, req *dto.RoleRequest) error {
+	if err := normalizeRole(req); err != nil {
+		return err
+	}
+	if err := u.checkAdminRole(req); err != nil {
+		return err
+	}
+	added, err := u.enforcer.AddGroupingPolicy(req.User, req.Role)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !added {
+		return ErrPolicyExists
+	}
+	logrus.Infof("Role added: %s inherits %s", req.User, req.Role)
+	return nil
+}
+
+func (u *policyUsecase) RemoveRole(ctx context.Context, req *dto.RoleRequest) error {
+	if err := normalizeRole(req); err != nil {
+		return err
+	}
+	if err := u.checkAdminRole(req); err != nil {
+		return err
+	}
+	removed, err := u.enforcer.RemoveGroupingPolicy(req.User, req.Role)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !removed {
+		return ErrPolicyNotFound
+	}
+	logrus.Infof("Role removed: %s no longer inherits %s", req.User, req.Role)
+	return nil
+}
+
+// checkAdminRole refuses the role rules involving admin, on either side and directly or
+// through an inherited role. Admin is given through the admin accounts only
+func (u *policyUsecase) checkAdminRole(req *dto.RoleRequest) error {
+	for _, name := range []string{req.User, req.Role} {
+		if name == adminRole {
+			return ErrProtectedPolicy
+		}
+		roles, err := u.enforcer.GetImplicitRolesForUser(name)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return err
+		}
+		if slices.Contains(roles, adminRole) {
+			return ErrProtectedPolicy
+		}
+	}
+	return nil
+}
+
+func (u *policyUsecase) Reload(ctx context.Context) error {
+	if err := u.enforcer.LoadPolicy(); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func normalizePolicy(req *dto.PolicyRequest) error {
+	req.Subject = strings.TrimSpace(req.Subject)
+	req.Object = strings.TrimSpace(req.Object)
+	req.Action = strings.ToUpper(strings.TrimSpace(req.Action))
+	if req.Subject == "" || strings.Contains(req.Subject, ",") {
+		return fmt.Errorf("%w: subject is required and cannot contain commas", ErrInvalidPolicy)
+	}
+	if !strings.HasPrefix(req.Object, objectPrefix) || strings.Contains(req.Object, ",") {
+		return fmt.Errorf("%w: object must be a path under %s", ErrInvalidPolicy, objectPrefix)
+	}
+	if !policyActions[req.Action] {
+		return fmt.Errorf("%w: unsupported action %q", ErrInvalidPolicy, req.Action)
+	}
+	return nil
+}
+
+func normalizeRole(req *dto.RoleRequest) error {
+	req.User = strings.TrimSpace(req.User)
+	req.Role = strings.TrimSpace(req.Role)
+	if req.User == "" || req.Role == "" || strings.Contains(req.User+req.Role, ",") {
+		return fmt.Errorf("%w: user and role are required and cannot contain commas", ErrInvalidPolicy)
+	}
+	if req.User == req.Role {
+		return fmt.Errorf("%w: a role cannot inherit itself", ErrInvalidPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package policyusecase
+
+import (
+	"backend/internal/domain/dto"
+	casbinusage "backend/pkg/casbin"
+	"context"
+	"testing"
+
+	stringadapter "github.com/casbin/casbin/v2/persist/string-adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddRole_AdminIsProtected(t *testing.T) {
+	e, err := casbinusage.NewEnforcer(stringadapter.NewAdapter(`
+p, admin, /api/admin/*, GET
+p, nurse, /api/nurse/*, GET
+g, auditor, admin`))
+	require.NoError(t, err)
+	e.EnableAutoSave(false)
+	u := NewPolicyUsecase(e)
+	ctx := context.Background()
+
+	for _, req := range []dto.RoleRequest{
+		{User: "nurse", Role: "admin"},
+		{User: "nurse", Role: "auditor"},
+		{User: "admin", Role: "nurse"},
+		{User: "auditor", Role: "nurse"},
+	} {
+		assert.ErrorIs(t, u.AddRole(ctx, &req), ErrProtectedPolicy, "%s inherits %s", req.User, req.Role)
+	}
+	assert.ErrorIs(t, u.RemoveRole(ctx, &dto.RoleRequest{User: "auditor", Role: "admin"}), ErrProtectedPolicy)
+
+	require.NoError(t, u.AddRole(ctx, &dto.RoleRequest{User: "triage", Role: "nurse"}))
+	ok, err := e.Enforce("triage", "/api/nurse/queues", "GET")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,161 @@
+package queuefeedusecase
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+const queueCacheSubscriber = "queue_cache"
+
+// StartQueueFeedConsumer keeps the Redis queue hash in line with the bookings stored in
+// Postgres, driven by the booking events relayed from the outbox, and publishes the
+// matching change event for the websocket clients
+func (s *queueFeedUsecase) StartQueueFeedConsumer(ctx context.Context) error {
+	routingKeys := []string{rabbitmq.BookingCreatedEvent, rabbitmq.BookingStatusChangedEvent}
+	err := s.bus.Subscribe(ctx, queueCacheSubscriber, routingKeys, s.processBookingEvent)
+	if err != nil {
+		logrus.Errorf("Failed to start booking cache consumer: %v", err)
+		return fmt.Errorf("failed to start booking cache consumer: %w", err)
+	}
+	return nil
+}
+
+// processBookingEvent applies the event under the booking lock, so it does not
+// interleave with a room call or a reconcile repair of the same booking
+func (s *queueFeedUsecase) processBookingEvent(ctx context.Context, env *rabbitmq.Envelope) error {
+	var ref struct {
+		QueueId int `json:"queue_id"`
+	}
+	if err := env.Decode(&ref); err != nil {
+		return err
+	}
+
+	return s.locker.WithLock(ctx, persistence.BookingLockName(ref.QueueId), queueLockTTL, func(ctx context.Context) error {
+		var (
+			ev  *dtoqueue.QueueEvent
+			err error
+		)
+		switch env.Type {
+		case rabbitmq.BookingCreatedEvent:
+			ev, err = s.cacheBookingCreated(ctx, env)
+		case rabbitmq.BookingStatusChangedEvent:
+			ev, err = s.cacheBookingStatusChanged(ctx, env)
+		default:
+			return rabbitmq.Permanent(fmt.Errorf("unexpected event type %q", env.Type))
+		}
+		if err != nil || ev == nil {
+			return err
+		}
+		ev.OccurredAt = env.OccurredAt
+
+		return s.publish(ctx, ev)
+	})
+}
+
+func (s *queueFeedUsecase) cacheBookingCreated(ctx context.Context, env *rabbitmq.Envelope) (*dtoqueue.QueueEvent, error) {
+	var ev rabbitmq.BookingCreated
+	if err := env.Decode(&ev); err != nil {
+		return nil, err
+	}
+	bq := ev.Booking()
+
+	bqMarshaled, err := json.Marshal(bq)
+	if err != nil {
+		logrus.Error("Failed when marshalling")
+		return nil, err
+	}
+
+	// HSet overwrites, replaying the event is harmless
+	_, err = s.redis.HSet(ctx, queueHashKey, strconv.Itoa(ev.QueueId), bqMarshaled)
+	if err != nil {
+		logrus.Error("failed to set data on cache has")
+		return nil, err
+	}
+	if err := s.appendToOrder(ctx, ev.QueueId); err != nil {
+		return nil, err
+	}
+
+	return &dtoqueue.QueueEvent{
+		Type:      dtoqueue.QueueAdded,
+		QueueId:   ev.QueueId,
+		PatientId: ev.PatientId.String(),
+		FacultyId: ev.FacultyId,
+		Queue:     dtoqueue.ConvertToResponse(bq),
+	}, nil
+}
+
+func (s *queueFeedUsecase) cacheBookingStatusChanged(ctx context.Context, env *rabbitmq.Envelope) (*dtoqueue.QueueEvent, error) {
+	var ev rabbitmq.BookingStatusChanged
+	if err := env.Decode(&ev); err != nil {
+		return nil, err
+	}
+
+	field := strconv.Itoa(ev.QueueId)
+	cached, err := s.redis.HGet(ctx, queueHashKey, field)
+	if err != nil && !errors.Is(err, redis.ErrNotFound) {
+		return nil, err
+	}
+
+	var bq patient.BookingQueue
+	if cached != "" {
+		if err := json.Unmarshal([]byte(cached), &bq); err != nil {
+			return nil, rabbitmq.Permanent(fmt.Errorf("failed to unmarshal cached booking %d: %w", ev.QueueId, err))
+		}
+	}
+
+	if ev.To == string(patient.BookingStatusCompleted) {
+		if err := s.redis.HDel(ctx, queueHashKey, field); err != nil {
+			return nil, err
+		}
+		if err := s.redis.ZRem(ctx, queueOrderKey, field); err != nil {
+			return nil, err
+		}
+		if err := s.redis.HDel(ctx, queueRoomsKey, field); err != nil {
+			return nil, err
+		}
+		return &dtoqueue.QueueEvent{
+			Type:      dtoqueue.QueueRemoved,
+			QueueId:   ev.QueueId,
+			PatientId: ev.PatientId.String(),
+			FacultyId: bq.FacultyId,
+		}, nil
+	}
+
+	if cached == "" {
+		// completed or never cached, nothing to update
+		return nil, nil
+	}
+	bq.BookingStatus = patient.BookingStatus(ev.To)
+
+	bqMarshaled, err := json.Marshal(&bq)
+	if err != nil {
+		logrus.Error("Failed when marshalling")
+		return nil, err
+	}
+
+	_, err = s.redis.HSet(ctx, queueHashKey, strconv.Itoa(bq.QueueId), bqMarshaled)
+	if err != nil {
+		logrus.Error("failed to set data on cache has")
+		return nil, err
+	}
+
+	return &dtoqueue.QueueEvent{
+		Type:      dtoqueue.QueueStatusChanged,
+		QueueId:   ev.QueueId,
+		PatientId: ev.PatientId.String(),
+		FacultyId: bq.FacultyId,
+		Queue:     dtoqueue.ConvertToResponse(&bq),
+		From:      ev.From,
+		To:        ev.To,
+	}, nil
+}
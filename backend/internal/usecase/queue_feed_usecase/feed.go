@@ -0,0 +1,572 @@
+package queuefeedusecase
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/messaging"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/redis"
+	"backend/pkg/common/pagination"
+	"backend/pkg/constants"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// active bookings by queue id
+	queueHashKey = "queue"
+	// queue ids scored by their display position
+	queueOrderKey = "queue:order"
+	// last sequence number handed out
+	queueSeqKey = "queue:seq"
+	// recent events scored by sequence number, for clients resuming after a reconnect
+	queueLogKey = "queue:events"
+	// callRecord of each called booking
+	queueRoomsKey = "queue:rooms"
+	// last waiting position told to each booking's patient
+	queuePositionsKey = "queue:positions"
+
+	// how many events a client can be behind and still resume without a snapshot
+	eventLogRetention = 1000
+	// bounds the Postgres read used when the Redis hash is empty
+	snapshotFallbackSize = 100
+
+	// serializes rewrites of the display order
+	queueOrderLock = "queue:order"
+	// serializes numbering the events, taken last and never held while taking another
+	queuePublishLock = "queue:publish"
+	// bounds how long a queue mutation may hold its lock
+	queueLockTTL = 10 * time.Second
+)
+
+// facultyLockName serializes picking the next patient of a faculty. Lock order is
+// faculty before booking
+func facultyLockName(facultyId byte) string {
+	return fmt.Sprintf("faculty:%d", facultyId)
+}
+
+var (
+	ErrUnknownQueue = errors.New("queue is not in the live queue")
+	ErrQueueEmpty   = errors.New("no patient is waiting")
+)
+
+// callRecord is where and when a booking was called
+type callRecord struct {
+	Room     string    `json:"room"`
+	CalledAt time.Time `json:"called_at"`
+}
+
+type QueueFeedUsecase interface {
+	StartQueueFeedConsumer(ctx context.Context) error
+	// Snapshot returns the active queue in display order, from Postgres when the cache is empty
+	Snapshot(ctx context.Context) (*dtoqueue.QueueSnapshot, error)
+	// Since returns the events after seq, ok is false when some of them are no longer
+	// retained and the client needs a snapshot instead
+	Since(ctx context.Context, seq int64) (events []*dtoqueue.QueueEvent, ok bool, err error)
+	// Reorder moves queueIds to the front of the queue in the given order
+	Reorder(ctx context.Context, queueIds []int) error
+	// Call sends the booking's patient to room, it leaves the waiting line of its faculty.
+	// A non-nil doctorId is assigned to the booking before the call is announced
+	Call(ctx context.Context, queueId int, room string, doctorId *uuid.UUID) error
+	// CallNext calls the front of the faculty's waiting line to room, assigning doctorId
+	// like Call
+	CallNext(ctx context.Context, facultyId byte, room string, doctorId *uuid.UUID) (*dtoqueue.BookingQueueResponse, error)
+
+	// StartQueueReconciler rebuilds the cache from Postgres now and then every interval
+	StartQueueReconciler(ctx context.Context, interval time.Duration) error
+	// Drift compares the cache with Postgres without changing anything
+	Drift(ctx context.Context) (*dtoqueue.QueueDrift, error)
+	// Reconcile brings the cache in line with Postgres and reports what it fixed
+	Reconcile(ctx context.Context) (*dtoqueue.QueueDrift, error)
+}
+
+type queueFeedUsecase struct {
+	bus    rabbitmq.EventBus
+	bqrepo persistence.BookingQueuueRepository
+	redis  redis.RedisConnection
+	locker *redis.Locker
+}
+
+func NewQueueFeedUsecase(bus rabbitmq.EventBus, bqrepo persistence.BookingQueuueRepository, rc redis.RedisConnection) QueueFeedUsecase {
+	return &queueFeedUsecase{
+		bus:    bus,
+		bqrepo: bqrepo,
+		redis:  rc,
+		locker: redis.NewLocker(rc),
+	}
+}
+
+// publish numbers ev, keeps it in the log and fans it out to every instance's hub. The
+// waiting positions that changed with it travel along, the hub only shows each patient
+// their own. The positions and the number are taken under one lock, so a client applying
+// the events in sequence never puts older positions over newer ones
+func (s *queueFeedUsecase) publish(ctx context.Context, ev *dtoqueue.QueueEvent) error {
+	return s.locker.WithLock(ctx, queuePublishLock, queueLockTTL, func(ctx context.Context) error {
+		return s.publishLocked(ctx, ev)
+	})
+}
+
+func (s *queueFeedUsecase) publishLocked(ctx context.Context, ev *dtoqueue.QueueEvent) error {
+	positions, err := s.refreshPositions(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	ev.Positions = positions
+
+	seq, err := s.redis.Incr(ctx, queueSeqKey)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	ev.Seq = seq
+	if ev.OccurredAt.IsZero() {
+		ev.OccurredAt = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.ZAdd(ctx, queueLogKey, goredis.Z{Score: float64(seq), Member: data}); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if err := s.redis.ZRemRangeByRank(ctx, queueLogKey, 0, -(eventLogRetention + 1)); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	if err := s.redis.Publish(ctx, constants.CHANNEL_REDIS, data); err != nil {
+		logrus.Errorf("Publish to %s channel failed", constants.CHANNEL_REDIS)
+		return err
+	}
+	return nil
+}
+
+func (s *queueFeedUsecase) currentSeq(ctx context.Context) (int64, error) {
+	v, err := s.redis.Get(ctx, queueSeqKey)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// appendToOrder puts a new booking at the end of the queue, a redelivered event keeps its position
+func (s *queueFeedUsecase) appendToOrder(ctx context.Context, queueId int) error {
+	return s.locker.WithLock(ctx, queueOrderLock, queueLockTTL, func(ctx context.Context) error {
+		return s.appendToOrderLocked(ctx, queueId)
+	})
+}
+
+func (s *queueFeedUsecase) appendToOrderLocked(ctx context.Context, queueId int) error {
+	last, err := s.redis.ZRangeWithScores(ctx, queueOrderKey, -1, -1)
+	if err != nil {
+		return err
+	}
+	score := float64(1)
+	if len(last) > 0 {
+		if last[0].Member == strconv.Itoa(queueId) {
+			return nil
+		}
+		score = last[0].Score + 1
+	}
+	return s.redis.ZAdd(ctx, queueOrderKey, goredis.Z{Score: score, Member: strconv.Itoa(queueId)})
+}
+
+func (s *queueFeedUsecase) Snapshot(ctx context.Context) (*dtoqueue.QueueSnapshot, error) {
+	// read the sequence first, events after it may already show in the data and
+	// replaying them on top of the snapshot is harmless
+	seq, err := s.currentSeq(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	queues, err := s.liveQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dtoqueue.QueueSnapshot{Seq: seq, Queues: queues}, nil
+}
+
+// liveQueue returns the active bookings in display order, with the room of the called
+// ones and the waiting position of the others
+func (s *queueFeedUsecase) liveQueue(ctx context.Context) ([]*dtoqueue.BookingQueueResponse, error) {
+	cached, err := s.redis.HGetAll(ctx, queueHashKey)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	var queues []*dtoqueue.BookingQueueResponse
+	if len(cached) == 0 {
+		queues, err = s.snapshotFromDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		order, err := s.redis.ZRange(ctx, queueOrderKey, 0, -1)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return nil, err
+		}
+
+		queues = make([]*dtoqueue.BookingQueueResponse, 0, len(cached))
+		for _, field := range order {
+			if bq := decodeCached(field, cached[field]); bq != nil {
+				queues = append(queues, bq)
+			}
+			delete(cached, field)
+		}
+
+		// bookings cached before the order was tracked go last, oldest first
+		var rest []*dtoqueue.BookingQueueResponse
+		for field, v := range cached {
+			if bq := decodeCached(field, v); bq != nil {
+				rest = append(rest, bq)
+			}
+		}
+		sort.Slice(rest, func(i, j int) bool { return rest[i].QueueId < rest[j].QueueId })
+		queues = append(queues, rest...)
+	}
+
+	rooms, err := s.redis.HGetAll(ctx, queueRoomsKey)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	// each faculty has its own waiting line
+	waiting := make(map[byte]int)
+	for _, q := range queues {
+		if v, ok := rooms[strconv.Itoa(q.QueueId)]; ok {
+			var call callRecord
+			if err := json.Unmarshal([]byte(v), &call); err != nil {
+				logrus.Errorf("Skipping call of booking %d: %v", q.QueueId, err)
+			} else {
+				q.Room = call.Room
+				q.CalledAt = &call.CalledAt
+				continue
+			}
+		}
+		waiting[q.FacultyId]++
+		q.Position = waiting[q.FacultyId]
+	}
+	return queues, nil
+}
+
+// refreshPositions stores the current waiting positions and returns the ones that
+// changed since they were last published
+func (s *queueFeedUsecase) refreshPositions(ctx context.Context) ([]*dtoqueue.QueuePosition, error) {
+	queues, err := s.liveQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	published, err := s.redis.HGetAll(ctx, queuePositionsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []*dtoqueue.QueuePosition
+	for _, q := range queues {
+		field := strconv.Itoa(q.QueueId)
+		if q.Position == 0 {
+			continue
+		}
+		last, ok := published[field]
+		delete(published, field)
+		if ok && last == strconv.Itoa(q.Position) {
+			continue
+		}
+		if _, err := s.redis.HSet(ctx, queuePositionsKey, field, q.Position); err != nil {
+			return nil, err
+		}
+		changed = append(changed, &dtoqueue.QueuePosition{
+			QueueId:   q.QueueId,
+			PatientId: q.PatientId.String(),
+			FacultyId: q.FacultyId,
+			Position:  q.Position,
+		})
+	}
+	// called or gone
+	for field := range published {
+		if err := s.redis.HDel(ctx, queuePositionsKey, field); err != nil {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
+func decodeCached(field, v string) *dtoqueue.BookingQueueResponse {
+	if v == "" {
+		return nil
+	}
+	var bq patient.BookingQueue
+	if err := json.Unmarshal([]byte(v), &bq); err != nil {
+		logrus.Errorf("Skipping cached booking %s: %v", field, err)
+		return nil
+	}
+	return dtoqueue.ConvertToResponse(&bq)
+}
+
+func (s *queueFeedUsecase) snapshotFromDB(ctx context.Context) ([]*dtoqueue.BookingQueueResponse, error) {
+	page := &pagination.Pagination{Page: 1, PageSize: snapshotFallbackSize}
+	bqs, err := s.bqrepo.GetAllBookingQueues(ctx, page)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	resp := make([]*dtoqueue.BookingQueueResponse, 0, len(bqs))
+	for _, bq := range bqs {
+		if bq.BookingStatus == patient.BookingStatusCompleted {
+			continue
+		}
+		resp = append(resp, dtoqueue.ConvertToResponse(bq))
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].QueueId < resp[j].QueueId })
+	return resp, nil
+}
+
+func (s *queueFeedUsecase) Since(ctx context.Context, seq int64) ([]*dtoqueue.QueueEvent, bool, error) {
+	current, err := s.currentSeq(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, false, err
+	}
+	if seq == current {
+		return nil, true, nil
+	}
+	if seq > current {
+		// the counter was reset, the client's state is from before that
+		return nil, false, nil
+	}
+
+	members, err := s.redis.ZRangeByScore(ctx, queueLogKey, fmt.Sprintf("(%d", seq), "+inf")
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, false, err
+	}
+
+	events := make([]*dtoqueue.QueueEvent, 0, len(members))
+	for _, m := range members {
+		var ev dtoqueue.QueueEvent
+		if err := json.Unmarshal([]byte(m), &ev); err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return nil, false, nil
+		}
+		events = append(events, &ev)
+	}
+	if len(events) == 0 || events[0].Seq != seq+1 {
+		// trimmed from the log
+		return nil, false, nil
+	}
+	return events, true, nil
+}
+
+func (s *queueFeedUsecase) Reorder(ctx context.Context, queueIds []int) error {
+	return s.locker.WithLock(ctx, queueOrderLock, queueLockTTL, func(ctx context.Context) error {
+		return s.reorder(ctx, queueIds)
+	})
+}
+
+func (s *queueFeedUsecase) reorder(ctx context.Context, queueIds []int) error {
+	current, err := s.redis.ZRange(ctx, queueOrderKey, 0, -1)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	known := make(map[string]bool, len(current))
+	for _, field := range current {
+		known[field] = true
+	}
+
+	moved := make(map[string]bool, len(queueIds))
+	order := make([]string, 0, len(current))
+	for _, id := range queueIds {
+		field := strconv.Itoa(id)
+		if !known[field] {
+			return fmt.Errorf("queue %d: %w", id, ErrUnknownQueue)
+		}
+		if moved[field] {
+			continue
+		}
+		moved[field] = true
+		order = append(order, field)
+	}
+	for _, field := range current {
+		if !moved[field] {
+			order = append(order, field)
+		}
+	}
+
+	members := make([]goredis.Z, len(order))
+	ids := make([]int, len(order))
+	for i, field := range order {
+		members[i] = goredis.Z{Score: float64(i + 1), Member: field}
+		ids[i], _ = strconv.Atoi(field)
+	}
+	if err := s.redis.ZAdd(ctx, queueOrderKey, members...); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	return s.publish(ctx, &dtoqueue.QueueEvent{Type: dtoqueue.QueueReordered, Order: ids})
+}
+
+func (s *queueFeedUsecase) Call(ctx context.Context, queueId int, room string, doctorId *uuid.UUID) error {
+	return s.locker.WithLock(ctx, persistence.BookingLockName(queueId), queueLockTTL, func(ctx context.Context) error {
+		bq, err := s.cachedBooking(ctx, queueId)
+		if err != nil {
+			return err
+		}
+		if err := s.assignDoctor(ctx, queueId, doctorId); err != nil {
+			return err
+		}
+		return s.call(ctx, bq, room)
+	})
+}
+
+func (s *queueFeedUsecase) cachedBooking(ctx context.Context, queueId int) (*dtoqueue.BookingQueueResponse, error) {
+	field := strconv.Itoa(queueId)
+	cached, err := s.redis.HGet(ctx, queueHashKey, field)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return nil, fmt.Errorf("queue %d: %w", queueId, ErrUnknownQueue)
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	bq := decodeCached(field, cached)
+	if bq == nil {
+		return nil, fmt.Errorf("queue %d: %w", queueId, ErrUnknownQueue)
+	}
+	return bq, nil
+}
+
+// CallNext holds the faculty lock so two rooms never call the same patient, and the
+// booking lock of the pick so it is not completed or called by id in the meantime
+func (s *queueFeedUsecase) CallNext(ctx context.Context, facultyId byte, room string, doctorId *uuid.UUID) (*dtoqueue.BookingQueueResponse, error) {
+	var called *dtoqueue.BookingQueueResponse
+	err := s.locker.WithLock(ctx, facultyLockName(facultyId), queueLockTTL, func(ctx context.Context) error {
+		for {
+			next, err := s.nextWaiting(ctx, facultyId)
+			if err != nil {
+				return err
+			}
+
+			picked := false
+			err = s.locker.WithLock(ctx, persistence.BookingLockName(next.QueueId), queueLockTTL, func(ctx context.Context) error {
+				// the pick was read before its lock was taken
+				if waiting, err := s.isWaiting(ctx, next.QueueId); err != nil || !waiting {
+					return err
+				}
+				picked = true
+				if err := s.assignDoctor(ctx, next.QueueId, doctorId); err != nil {
+					return err
+				}
+				return s.call(ctx, next, room)
+			})
+			if err != nil {
+				return err
+			}
+			if picked {
+				called = next
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return called, nil
+}
+
+func (s *queueFeedUsecase) nextWaiting(ctx context.Context, facultyId byte) (*dtoqueue.BookingQueueResponse, error) {
+	queues, err := s.liveQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range queues {
+		if q.FacultyId == facultyId && q.Position == 1 {
+			return q, nil
+		}
+	}
+	return nil, ErrQueueEmpty
+}
+
+// isWaiting reports whether the booking is still cached and not called to a room
+func (s *queueFeedUsecase) isWaiting(ctx context.Context, queueId int) (bool, error) {
+	field := strconv.Itoa(queueId)
+	if _, err := s.redis.HGet(ctx, queueHashKey, field); err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err := s.redis.HGet(ctx, queueRoomsKey, field)
+	if errors.Is(err, redis.ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// assignDoctor records the doctor of a booking being called, it runs under the booking
+// lock so the write carries its fencing token
+func (s *queueFeedUsecase) assignDoctor(ctx context.Context, queueId int, doctorId *uuid.UUID) error {
+	if doctorId == nil {
+		return nil
+	}
+	err := s.bqrepo.AssignDoctor(ctx, queueId, *doctorId, func(bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error) {
+		ev, err := rabbitmq.NewBookingDoctorAssignedEvent(bq, *doctorId)
+		if err != nil {
+			return nil, err
+		}
+		return []*messaging.OutboxEvent{ev}, nil
+	})
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *queueFeedUsecase) call(ctx context.Context, bq *dtoqueue.BookingQueueResponse, room string) error {
+	record := callRecord{Room: room, CalledAt: time.Now().UTC()}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.redis.HSet(ctx, queueRoomsKey, strconv.Itoa(bq.QueueId), data); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	bq.Room = record.Room
+	bq.CalledAt = &record.CalledAt
+	bq.Position = 0
+
+	return s.publish(ctx, &dtoqueue.QueueEvent{
+		Type:       dtoqueue.QueueCalled,
+		QueueId:    bq.QueueId,
+		PatientId:  bq.PatientId.String(),
+		FacultyId:  bq.FacultyId,
+		Queue:      bq,
+		Room:       room,
+		OccurredAt: record.CalledAt,
+	})
+}
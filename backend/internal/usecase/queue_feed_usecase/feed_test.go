@@ -0,0 +1,141 @@
+package queuefeedusecase
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowSequence lets other publishers run between reading the positions and numbering
+// the event
+type slowSequence struct {
+	*redis.MemoryStore
+}
+
+func (r slowSequence) Incr(ctx context.Context, key string) (int64, error) {
+	if key == queueSeqKey {
+		time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	}
+	return r.MemoryStore.Incr(ctx, key)
+}
+
+func TestPublish_PositionsFollowTheSequence(t *testing.T) {
+	ctx := context.Background()
+	s := NewQueueFeedUsecase(nil, &activeBookings{}, slowSequence{redis.NewMemoryStore()}).(*queueFeedUsecase)
+
+	// the first booking is cached, an empty cache is read from Postgres
+	require.NoError(t, s.cacheBooking(ctx, booking(1, patient.BookingStatusWaiting, time.Hour)))
+	require.NoError(t, s.appendToOrder(ctx, 1))
+
+	// bookings arrive while rooms call the front of the line
+	var wg sync.WaitGroup
+	for id := 2; id <= 12; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bq := booking(id, patient.BookingStatusWaiting, time.Hour)
+			assert.NoError(t, s.cacheBooking(ctx, bq))
+			assert.NoError(t, s.appendToOrder(ctx, id))
+			assert.NoError(t, s.publish(ctx, &dtoqueue.QueueEvent{Type: dtoqueue.QueueAdded, QueueId: id, FacultyId: 1}))
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.CallNext(ctx, 1, "room", nil)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrQueueEmpty)
+			}
+		}()
+	}
+	wg.Wait()
+
+	events, ok, err := s.Since(ctx, 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	positions := map[int]int{}
+	for i, ev := range events {
+		require.EqualValues(t, i+1, ev.Seq)
+		if ev.Type == dtoqueue.QueueCalled {
+			delete(positions, ev.QueueId)
+		}
+		for _, p := range ev.Positions {
+			positions[p.QueueId] = p.Position
+		}
+	}
+
+	queues, err := s.liveQueue(ctx)
+	require.NoError(t, err)
+	want := map[int]int{}
+	for _, q := range queues {
+		if q.Position > 0 {
+			want[q.QueueId] = q.Position
+		}
+	}
+	assert.Equal(t, want, positions)
+}
+
+// doctorAssignments records the assignments made while calling, and whether the call
+// had already been announced
+type doctorAssignments struct {
+	activeBookings
+	store     *redis.MemoryStore
+	err       error
+	fenced    bool
+	announced bool
+	doctorId  uuid.UUID
+	eventType string
+}
+
+func (r *doctorAssignments) AssignDoctor(ctx context.Context, queueId int, doctorId uuid.UUID, events persistence.BookingEventsFunc) error {
+	_, r.fenced = redis.FenceFromContext(ctx, persistence.BookingLockName(queueId))
+	_, err := r.store.HGet(ctx, queueRoomsKey, strconv.Itoa(queueId))
+	r.announced = err == nil
+	r.doctorId = doctorId
+
+	evs, err := events(booking(queueId, patient.BookingStatusWaiting, time.Hour))
+	if err != nil {
+		return err
+	}
+	r.eventType = evs[0].EventType
+	return r.err
+}
+
+func TestCall_AssignsDoctorUnderTheBookingLock(t *testing.T) {
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	repo := &doctorAssignments{store: store}
+	s := NewQueueFeedUsecase(nil, repo, store).(*queueFeedUsecase)
+	for id := 1; id <= 2; id++ {
+		require.NoError(t, s.cacheBooking(ctx, booking(id, patient.BookingStatusWaiting, time.Hour)))
+		require.NoError(t, s.appendToOrder(ctx, id))
+	}
+
+	doctorId := uuid.New()
+	require.NoError(t, s.Call(ctx, 1, "room 1", &doctorId))
+	assert.True(t, repo.fenced)
+	assert.False(t, repo.announced)
+	assert.Equal(t, doctorId, repo.doctorId)
+	assert.Equal(t, rabbitmq.BookingDoctorAssignedEvent, repo.eventType)
+
+	// a write refused under a newer lock does not send the patient to the room
+	repo.err = persistence.ErrStaleFence
+	err := s.Call(ctx, 2, "room 2", &doctorId)
+	require.ErrorIs(t, err, persistence.ErrStaleFence)
+	waiting, err := s.isWaiting(ctx, 2)
+	require.NoError(t, err)
+	assert.True(t, waiting)
+}
@@ -0,0 +1,295 @@
+package queuefeedusecase
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// held by the instance reconciling, the others skip that round
+	queueReconcileLockKey = "queue:reconcile:lock"
+
+	// bookings younger than this may still be on their way through the outbox, they
+	// are not reported missing yet
+	reconcileGracePeriod = 30 * time.Second
+)
+
+// queueFix repairs one booking's cache entry. cached is the entry the fix was planned
+// against, empty when the booking was not cached
+type queueFix struct {
+	queueId int
+	cached  string
+	apply   func(ctx context.Context) error
+}
+
+// StartQueueReconciler warms the cache up from Postgres, then keeps reconciling it
+// every interval until ctx is cancelled
+func (s *queueFeedUsecase) StartQueueReconciler(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid reconcile interval %s", interval)
+	}
+
+	s.reconcileRound(ctx, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.reconcileRound(ctx, interval)
+		}
+	}
+}
+
+func (s *queueFeedUsecase) reconcileRound(ctx context.Context, interval time.Duration) {
+	// expires on its own if this instance dies mid-round
+	acquired, err := s.redis.SetNX(ctx, queueReconcileLockKey, 1, interval/2)
+	if err != nil {
+		logrus.Errorf("Queue reconciler: %v", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	drift, err := s.Reconcile(ctx)
+	if err != nil {
+		logrus.Errorf("Queue reconciler: %v", err)
+		return
+	}
+	if !drift.InSync() {
+		logrus.Warnf("Queue reconciler: repaired %d missing, %d stale, %d mismatched, %d unordered, %d orphaned",
+			len(drift.Missing), len(drift.Stale), len(drift.Mismatched), len(drift.Unordered), len(drift.Orphaned))
+	}
+}
+
+func (s *queueFeedUsecase) Drift(ctx context.Context) (*dtoqueue.QueueDrift, error) {
+	return s.reconcile(ctx, false)
+}
+
+func (s *queueFeedUsecase) Reconcile(ctx context.Context) (*dtoqueue.QueueDrift, error) {
+	return s.reconcile(ctx, true)
+}
+
+// reconcile compares the cache with Postgres and, when repair is set, brings the cache
+// in line and publishes the matching events so open dashboards follow. The cache is
+// read before the database: a booking only changes in Redis after it changed in
+// Postgres, so the database read is never older than the cache read
+func (s *queueFeedUsecase) reconcile(ctx context.Context, repair bool) (*dtoqueue.QueueDrift, error) {
+	cached, err := s.redis.HGetAll(ctx, queueHashKey)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	order, err := s.redis.ZRange(ctx, queueOrderKey, 0, -1)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	active, err := s.bqrepo.GetActiveBookingQueues(ctx)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	drift := &dtoqueue.QueueDrift{
+		CheckedAt:  time.Now().UTC(),
+		Database:   len(active),
+		Cache:      len(cached),
+		Missing:    []int{},
+		Stale:      []int{},
+		Mismatched: []*dtoqueue.QueueDriftField{},
+		Unordered:  []int{},
+		Orphaned:   []int{},
+	}
+
+	ordered := make(map[string]bool, len(order))
+	for _, field := range order {
+		ordered[field] = true
+	}
+
+	var fixes []queueFix
+	inDB := make(map[string]bool, len(active))
+	for _, bq := range active {
+		field := strconv.Itoa(bq.QueueId)
+		inDB[field] = true
+
+		v, ok := cached[field]
+		if !ok {
+			if time.Since(bq.CreatedAt) < reconcileGracePeriod {
+				continue
+			}
+			drift.Missing = append(drift.Missing, bq.QueueId)
+			fixes = append(fixes, queueFix{bq.QueueId, "", func(ctx context.Context) error { return s.restoreBooking(ctx, bq) }})
+			continue
+		}
+
+		var c patient.BookingQueue
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			drift.Mismatched = append(drift.Mismatched, &dtoqueue.QueueDriftField{QueueId: bq.QueueId, Field: "json", Cache: v})
+			fixes = append(fixes, queueFix{bq.QueueId, v, func(ctx context.Context) error { return s.replaceBooking(ctx, bq, "") }})
+			continue
+		}
+		if diffs := bookingDiffs(&c, bq); len(diffs) > 0 {
+			drift.Mismatched = append(drift.Mismatched, diffs...)
+			from := string(c.BookingStatus)
+			fixes = append(fixes, queueFix{bq.QueueId, v, func(ctx context.Context) error { return s.replaceBooking(ctx, bq, from) }})
+		}
+		if !ordered[field] {
+			drift.Unordered = append(drift.Unordered, bq.QueueId)
+			fixes = append(fixes, queueFix{bq.QueueId, v, func(ctx context.Context) error { return s.appendToOrder(ctx, bq.QueueId) }})
+		}
+	}
+
+	for field, v := range cached {
+		if inDB[field] {
+			continue
+		}
+		id, _ := strconv.Atoi(field)
+		drift.Stale = append(drift.Stale, id)
+		fixes = append(fixes, queueFix{id, v, func(ctx context.Context) error { return s.dropBooking(ctx, field, v) }})
+	}
+	for _, field := range order {
+		if _, ok := cached[field]; ok || inDB[field] {
+			continue
+		}
+		id, _ := strconv.Atoi(field)
+		drift.Orphaned = append(drift.Orphaned, id)
+		fixes = append(fixes, queueFix{id, "", func(ctx context.Context) error { return s.redis.ZRem(ctx, queueOrderKey, field) }})
+	}
+
+	sort.Ints(drift.Stale)
+	sort.Ints(drift.Orphaned)
+
+	if !repair {
+		return drift, nil
+	}
+	for _, fix := range fixes {
+		applied, err := s.applyFix(ctx, fix)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return nil, err
+		}
+		if !applied {
+			drift.Deferred = append(drift.Deferred, fix.queueId)
+		}
+	}
+	drift.Repaired = true
+	return drift, nil
+}
+
+// applyFix runs fix under the booking lock. A booking that is locked or whose entry
+// changed since it was read is being handled by someone else, it is left for the next
+// round instead of being overwritten with data that may now be older
+func (s *queueFeedUsecase) applyFix(ctx context.Context, fix queueFix) (bool, error) {
+	lock, err := s.locker.TryAcquire(ctx, persistence.BookingLockName(fix.queueId), queueLockTTL)
+	if errors.Is(err, redis.ErrLockNotAcquired) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			logrus.Warnf("Queue reconciler: %v", err)
+		}
+	}()
+
+	current, err := s.redis.HGet(ctx, queueHashKey, strconv.Itoa(fix.queueId))
+	if err != nil && !errors.Is(err, redis.ErrNotFound) {
+		return false, err
+	}
+	if current != fix.cached {
+		return false, nil
+	}
+	return true, fix.apply(redis.ContextWithFence(ctx, lock))
+}
+
+// bookingDiffs lists the fields the live queue shows that differ between the cache and Postgres
+func bookingDiffs(cached, db *patient.BookingQueue) []*dtoqueue.QueueDriftField {
+	var diffs []*dtoqueue.QueueDriftField
+	add := func(field, c, d string) {
+		if c != d {
+			diffs = append(diffs, &dtoqueue.QueueDriftField{QueueId: db.QueueId, Field: field, Cache: c, Database: d})
+		}
+	}
+	add("booking_status", string(cached.BookingStatus), string(db.BookingStatus))
+	add("payment_status", string(cached.PaymentStatus), string(db.PaymentStatus))
+	add("faculty_id", strconv.Itoa(int(cached.FacultyId)), strconv.Itoa(int(db.FacultyId)))
+	add("patient_id", cached.PatientId.String(), db.PatientId.String())
+	return diffs
+}
+
+func (s *queueFeedUsecase) cacheBooking(ctx context.Context, bq *patient.BookingQueue) error {
+	data, err := json.Marshal(bq)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.HSet(ctx, queueHashKey, strconv.Itoa(bq.QueueId), data)
+	return err
+}
+
+func (s *queueFeedUsecase) restoreBooking(ctx context.Context, bq *patient.BookingQueue) error {
+	if err := s.cacheBooking(ctx, bq); err != nil {
+		return err
+	}
+	if err := s.appendToOrder(ctx, bq.QueueId); err != nil {
+		return err
+	}
+	return s.publish(ctx, &dtoqueue.QueueEvent{
+		Type:      dtoqueue.QueueAdded,
+		QueueId:   bq.QueueId,
+		PatientId: bq.PatientId.String(),
+		FacultyId: bq.FacultyId,
+		Queue:     dtoqueue.ConvertToResponse(bq),
+	})
+}
+
+func (s *queueFeedUsecase) replaceBooking(ctx context.Context, bq *patient.BookingQueue, from string) error {
+	if err := s.cacheBooking(ctx, bq); err != nil {
+		return err
+	}
+	return s.publish(ctx, &dtoqueue.QueueEvent{
+		Type:      dtoqueue.QueueStatusChanged,
+		QueueId:   bq.QueueId,
+		PatientId: bq.PatientId.String(),
+		FacultyId: bq.FacultyId,
+		Queue:     dtoqueue.ConvertToResponse(bq),
+		From:      from,
+		To:        string(bq.BookingStatus),
+	})
+}
+
+func (s *queueFeedUsecase) dropBooking(ctx context.Context, field, cached string) error {
+	if err := s.redis.HDel(ctx, queueHashKey, field); err != nil {
+		return err
+	}
+	if err := s.redis.ZRem(ctx, queueOrderKey, field); err != nil {
+		return err
+	}
+	if err := s.redis.HDel(ctx, queueRoomsKey, field); err != nil {
+		return err
+	}
+
+	id, _ := strconv.Atoi(field)
+	ev := &dtoqueue.QueueEvent{Type: dtoqueue.QueueRemoved, QueueId: id}
+	var bq patient.BookingQueue
+	if json.Unmarshal([]byte(cached), &bq) == nil {
+		ev.PatientId = bq.PatientId.String()
+		ev.FacultyId = bq.FacultyId
+	}
+	return s.publish(ctx, ev)
+}
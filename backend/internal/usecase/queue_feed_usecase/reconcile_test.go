@@ -0,0 +1,95 @@
+package queuefeedusecase
+
+import (
+	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/patient"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/redis"
+	"backend/pkg/constants"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type activeBookings struct {
+	persistence.BookingQueuueRepository
+	rows []*patient.BookingQueue
+}
+
+func (r *activeBookings) GetActiveBookingQueues(ctx context.Context) ([]*patient.BookingQueue, error) {
+	return r.rows, nil
+}
+
+func booking(id int, status patient.BookingStatus, age time.Duration) *patient.BookingQueue {
+	return &patient.BookingQueue{
+		QueueId:       id,
+		PatientId:     uuid.New(),
+		FacultyId:     1,
+		BookingStatus: status,
+		CreatedAt:     time.Now().Add(-age),
+	}
+}
+
+func TestReconcile_RepairsDriftAndPublishes(t *testing.T) {
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	sub := store.SubChannel(ctx, constants.CHANNEL_REDIS)
+	defer sub.Close()
+
+	changed := booking(2, patient.BookingStatusWaiting, time.Hour)
+	repo := &activeBookings{rows: []*patient.BookingQueue{
+		booking(1, patient.BookingStatusInProgress, time.Hour),
+		changed,
+		// just created, the consumer has not cached it yet
+		booking(3, patient.BookingStatusInProgress, time.Second),
+	}}
+	s := NewQueueFeedUsecase(nil, repo, store).(*queueFeedUsecase)
+
+	// the cache missed booking 1, still has 2 as in progress and kept 9 after it was completed
+	stale := *changed
+	stale.BookingStatus = patient.BookingStatusInProgress
+	require.NoError(t, s.cacheBooking(ctx, &stale))
+	require.NoError(t, s.appendToOrder(ctx, 2))
+	require.NoError(t, s.cacheBooking(ctx, booking(9, patient.BookingStatusInProgress, time.Hour)))
+	require.NoError(t, s.appendToOrder(ctx, 9))
+	require.NoError(t, s.appendToOrder(ctx, 42))
+
+	drift, err := s.Drift(ctx)
+	require.NoError(t, err)
+	assert.False(t, drift.Repaired)
+	assert.Equal(t, []int{1}, drift.Missing)
+	assert.Equal(t, []int{9}, drift.Stale)
+	assert.Equal(t, []int{42}, drift.Orphaned)
+	require.Len(t, drift.Mismatched, 1)
+	assert.Equal(t, "booking_status", drift.Mismatched[0].Field)
+	assert.Empty(t, sub.Channel(), "a drift report changes nothing")
+
+	drift, err = s.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.True(t, drift.Repaired)
+
+	var types []string
+	for range 3 {
+		msg := <-sub.Channel()
+		var ev dtoqueue.QueueEvent
+		require.NoError(t, json.Unmarshal([]byte(msg.Payload), &ev))
+		types = append(types, ev.Type)
+	}
+	assert.ElementsMatch(t, []string{dtoqueue.QueueAdded, dtoqueue.QueueStatusChanged, dtoqueue.QueueRemoved}, types)
+
+	drift, err = s.Drift(ctx)
+	require.NoError(t, err)
+	assert.True(t, drift.InSync())
+
+	snap, err := s.Snapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Queues, 2)
+	assert.Equal(t, 2, snap.Queues[0].QueueId)
+	assert.Equal(t, string(patient.BookingStatusWaiting), snap.Queues[0].BookingStatus)
+	assert.Equal(t, 1, snap.Queues[1].QueueId)
+}
@@ -0,0 +1,290 @@
+package recoveryusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	accountrepository "backend/internal/infrastructure/persistence/account_repository"
+	"backend/internal/infrastructure/redis"
+	"backend/internal/infrastructure/sender"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	"backend/pkg/common/validator"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCode = errors.New("invalid or expired code")
+	// ErrTooManyAttempts drops the code, a new one has to be requested
+	ErrTooManyAttempts   = errors.New("too many wrong codes, request a new one")
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrWeakPassword      = errors.New("password needs 8 characters with 2 uppercase, 3 lowercase, 2 digits and one of !@#$&*")
+)
+
+const (
+	codeDigits = 6
+	// resendCooldown spaces the codes sent to an account
+	resendCooldown = time.Minute
+	resetTokenTTL  = 10 * time.Minute
+)
+
+// RecoveryUsecase resets forgotten passwords with a one-time code sent to the email or
+// phone number of the account. Only HMACs of the codes and hashes of the reset tokens
+// are stored
+type RecoveryUsecase interface {
+	// RequestReset sends a code when the account exists, and answers the same either way
+	RequestReset(ctx context.Context, req *dto.PasswordResetRequest) error
+	// VerifyCode trades a valid code for a single-use reset token
+	VerifyCode(ctx context.Context, req *dto.VerifyResetCodeRequest) (*dto.ResetTokenResponse, error)
+	// ResetPassword sets the new password and signs the account out everywhere
+	ResetPassword(ctx context.Context, req *dto.SetPasswordRequest) error
+}
+
+type recoveryUsecase struct {
+	accounts    accountrepository.AccountRepository
+	sessions    sessionusecase.SessionUsecase
+	redis       redis.RedisConnection
+	sender      sender.Sender
+	codeTTL     time.Duration
+	maxAttempts int
+}
+
+func NewRecoveryUsecase(accounts accountrepository.AccountRepository, sessions sessionusecase.SessionUsecase, rc redis.RedisConnection, s sender.Sender, codeTTL time.Duration, maxAttempts int) RecoveryUsecase {
+	return &recoveryUsecase{
+		accounts:    accounts,
+		sessions:    sessions,
+		redis:       rc,
+		sender:      s,
+		codeTTL:     codeTTL,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func codeKey(role string, id uuid.UUID) string {
+	return fmt.Sprintf("recovery:code:%s:%s", role, id)
+}
+
+func attemptsKey(role string, id uuid.UUID) string {
+	return fmt.Sprintf("recovery:attempts:%s:%s", role, id)
+}
+
+func cooldownKey(role string, id uuid.UUID) string {
+	return fmt.Sprintf("recovery:cooldown:%s:%s", role, id)
+}
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "recovery:token:" + hex.EncodeToString(sum[:])
+}
+
+// codeHash binds the code to the account, with the server key so a leaked hash cannot
+// be brute-forced over the million codes
+func codeHash(role string, id uuid.UUID, code string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET_KEY")))
+	fmt.Fprintf(mac, "%s:%s:%s", role, id, code)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *recoveryUsecase) RequestReset(ctx context.Context, req *dto.PasswordResetRequest) error {
+	acc, err := s.accounts.FindByContact(ctx, req.Role, req.Contact)
+	if err != nil {
+		if errors.Is(err, accountrepository.ErrAccountNotFound) {
+			logrus.Infof("Password reset requested for unknown %s contact", req.Role)
+			return nil
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	// accounts of the identity provider have no password here, they reset it there
+	if acc.ExternalLogin() {
+		logrus.Infof("Password reset requested for single sign-on %s %s", acc.Role, acc.Id)
+		return nil
+	}
+
+	fresh, err := s.redis.SetNX(ctx, cooldownKey(acc.Role, acc.Id), 1, resendCooldown)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !fresh {
+		// the previous code is still on its way
+		return nil
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, codeKey(acc.Role, acc.Id), codeHash(acc.Role, acc.Id, code), s.codeTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	// a new code starts a new count of attempts
+	if err := s.redis.Del(ctx, attemptsKey(acc.Role, acc.Id)); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	body := fmt.Sprintf("Your clinic password reset code is %s. It expires in %d minutes. If you did not ask for it, ignore this message.", code, int(s.codeTTL.Minutes()))
+	if err := s.sender.Send(ctx, recipient(acc, req.Contact, "Password reset code", body)); err != nil {
+		logrus.Errorf("Failed to send reset code: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *recoveryUsecase) VerifyCode(ctx context.Context, req *dto.VerifyResetCodeRequest) (*dto.ResetTokenResponse, error) {
+	acc, err := s.accounts.FindByContact(ctx, req.Role, req.Contact)
+	if err != nil {
+		if errors.Is(err, accountrepository.ErrAccountNotFound) {
+			return nil, ErrInvalidCode
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if acc.ExternalLogin() {
+		return nil, ErrInvalidCode
+	}
+
+	stored, err := s.redis.Get(ctx, codeKey(acc.Role, acc.Id))
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return nil, ErrInvalidCode
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	// counted before comparing, so parallel guesses cannot exceed the limit
+	attempts, err := s.redis.Incr(ctx, attemptsKey(acc.Role, acc.Id))
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if attempts == 1 {
+		_ = s.redis.Expire(ctx, attemptsKey(acc.Role, acc.Id), s.codeTTL)
+	}
+	if attempts > int64(s.maxAttempts) {
+		_ = s.redis.Del(ctx, codeKey(acc.Role, acc.Id))
+		logrus.Warnf("Too many reset code attempts for %s %s", acc.Role, acc.Id)
+		return nil, ErrTooManyAttempts
+	}
+
+	if !hmac.Equal([]byte(stored), []byte(codeHash(acc.Role, acc.Id, req.Code))) {
+		return nil, ErrInvalidCode
+	}
+	// the code is single use, whoever deletes it first gets the token
+	claimed, err := s.redis.DelIfEqual(ctx, codeKey(acc.Role, acc.Id), stored)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrInvalidCode
+	}
+	_ = s.redis.Del(ctx, attemptsKey(acc.Role, acc.Id))
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, tokenKey(token), acc.Role+":"+acc.Id.String(), resetTokenTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return &dto.ResetTokenResponse{ResetToken: token, ExpiresAt: time.Now().Add(resetTokenTTL).UTC()}, nil
+}
+
+func (s *recoveryUsecase) ResetPassword(ctx context.Context, req *dto.SetPasswordRequest) error {
+	if !validator.IsValidPassword(req.NewPassword) {
+		return ErrWeakPassword
+	}
+
+	key := tokenKey(req.ResetToken)
+	owner, err := s.redis.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	claimed, err := s.redis.DelIfEqual(ctx, key, owner)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if !claimed {
+		return ErrInvalidResetToken
+	}
+
+	role, rawId, _ := strings.Cut(owner, ":")
+	id, err := uuid.Parse(rawId)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), 10)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if err := s.accounts.UpdatePassword(ctx, role, id, hashed); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+
+	// whoever knew the old password is signed out
+	revoked, err := s.sessions.RevokeAll(ctx, id.String())
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	logrus.Infof("Password of %s %s reset, %d sessions revoked", role, id, revoked)
+
+	if acc, err := s.accounts.FindById(ctx, role, id); err == nil {
+		notice := "Your clinic password was changed. If this was not you, contact the clinic right away."
+		if err := s.sender.Send(ctx, recipient(acc, "", "Password changed", notice)); err != nil {
+			logrus.Warnf("Failed to send password change notice: %v", err)
+		}
+	}
+	return nil
+}
+
+// recipient answers on the channel the user asked on, email when contact is the
+// account's email and SMS otherwise
+func recipient(acc *account.Account, contact, subject, body string) *sender.Message {
+	if acc.Email != "" && (strings.EqualFold(contact, acc.Email) || acc.PhoneNumber == "") {
+		return &sender.Message{Channel: sender.ChannelEmail, To: acc.Email, Subject: subject, Body: body}
+	}
+	return &sender.Message{Channel: sender.ChannelSMS, To: acc.PhoneNumber, Body: body}
+}
+
+func newCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n.Int64()), nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package recoveryusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	accountrepository "backend/internal/infrastructure/persistence/account_repository"
+	"backend/internal/infrastructure/redis"
+	"backend/internal/infrastructure/sender"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeAccounts struct {
+	accountrepository.AccountRepository
+	acc *account.Account
+}
+
+func (f *fakeAccounts) FindByContact(ctx context.Context, role, contact string) (*account.Account, error) {
+	if role == f.acc.Role && (strings.EqualFold(contact, f.acc.Email) || contact == f.acc.PhoneNumber) {
+		return f.acc, nil
+	}
+	return nil, accountrepository.ErrAccountNotFound
+}
+
+func (f *fakeAccounts) FindById(ctx context.Context, role string, id uuid.UUID) (*account.Account, error) {
+	return f.acc, nil
+}
+
+func (f *fakeAccounts) UpdatePassword(ctx context.Context, role string, id uuid.UUID, hashed []byte) error {
+	f.acc.Password = string(hashed)
+	return nil
+}
+
+type outbox struct {
+	sent []*sender.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg *sender.Message) error {
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+func (o *outbox) lastCode(t *testing.T) string {
+	require.NotEmpty(t, o.sent)
+	code := regexp.MustCompile(`\d{6}`).FindString(o.sent[len(o.sent)-1].Body)
+	require.NotEmpty(t, code)
+	return code
+}
+
+func TestRecovery_ResetWithCodeRevokesSessions(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	sessions := sessionusecase.NewSessionUsecase(store, 15*time.Minute, time.Hour)
+	accounts := &fakeAccounts{acc: &account.Account{Id: uuid.New(), Role: "nurse", Email: "nurse@clinic.test", PhoneNumber: "0901234567"}}
+	box := &outbox{}
+	r := NewRecoveryUsecase(accounts, sessions, store, box, 10*time.Minute, 3)
+
+	session, err := sessions.Start(ctx, accounts.acc.Id.String(), "nurse", &dto.ClientInfo{DeviceId: "ward-pc"})
+	require.NoError(t, err)
+
+	// unknown contacts look the same and send nothing
+	require.NoError(t, r.RequestReset(ctx, &dto.PasswordResetRequest{Role: "nurse", Contact: "nobody@clinic.test"}))
+	assert.Empty(t, box.sent)
+
+	require.NoError(t, r.RequestReset(ctx, &dto.PasswordResetRequest{Role: "nurse", Contact: "0901234567"}))
+	require.Len(t, box.sent, 1)
+	assert.Equal(t, sender.ChannelSMS, box.sent[0].Channel)
+	code := box.lastCode(t)
+
+	// the code is stored hashed
+	stored, err := store.Get(ctx, codeKey("nurse", accounts.acc.Id))
+	require.NoError(t, err)
+	assert.NotContains(t, stored, code)
+
+	// a resend inside the cooldown keeps the first code
+	require.NoError(t, r.RequestReset(ctx, &dto.PasswordResetRequest{Role: "nurse", Contact: "0901234567"}))
+	assert.Len(t, box.sent, 1)
+
+	verify := &dto.VerifyResetCodeRequest{Role: "nurse", Contact: "0901234567", Code: wrong(code)}
+	_, err = r.VerifyCode(ctx, verify)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	verify.Code = code
+	token, err := r.VerifyCode(ctx, verify)
+	require.NoError(t, err)
+	// the code works once
+	_, err = r.VerifyCode(ctx, verify)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	assert.ErrorIs(t, r.ResetPassword(ctx, &dto.SetPasswordRequest{ResetToken: token.ResetToken, NewPassword: "weak"}), ErrWeakPassword)
+	require.NoError(t, r.ResetPassword(ctx, &dto.SetPasswordRequest{ResetToken: token.ResetToken, NewPassword: "NEwpass12!"}))
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(accounts.acc.Password), []byte("NEwpass12!")))
+	assert.ErrorIs(t, sessions.Check(ctx, session.SessionId, accounts.acc.Id.String()), sessionusecase.ErrSessionRevoked)
+	assert.Len(t, box.sent, 2)
+
+	// the token works once
+	assert.ErrorIs(t, r.ResetPassword(ctx, &dto.SetPasswordRequest{ResetToken: token.ResetToken, NewPassword: "NEwpass12!"}), ErrInvalidResetToken)
+}
+
+func TestRecovery_TooManyAttemptsDropsCode(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	accounts := &fakeAccounts{acc: &account.Account{Id: uuid.New(), Role: "patient", Email: "patient@mail.test"}}
+	box := &outbox{}
+	r := NewRecoveryUsecase(accounts, sessionusecase.NewSessionUsecase(store, time.Minute, time.Hour), store, box, 10*time.Minute, 2)
+
+	require.NoError(t, r.RequestReset(ctx, &dto.PasswordResetRequest{Role: "patient", Contact: "Patient@mail.test"}))
+	assert.Equal(t, sender.ChannelEmail, box.sent[0].Channel)
+	code := box.lastCode(t)
+
+	verify := &dto.VerifyResetCodeRequest{Role: "patient", Contact: "patient@mail.test", Code: wrong(code)}
+	for i := 0; i < 2; i++ {
+		_, err := r.VerifyCode(ctx, verify)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	}
+	_, err := r.VerifyCode(ctx, verify)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// even the right code is refused now
+	verify.Code = code
+	_, err = r.VerifyCode(ctx, verify)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func wrong(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
@@ -0,0 +1,347 @@
+package serviceaccountusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	serviceaccountrepository "backend/internal/infrastructure/persistence/service_account_repository"
+	"backend/pkg/common/pagination"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidKey answers every key that cannot be used, whether unknown, revoked,
+	// expired or of a disabled account
+	ErrInvalidKey    = errors.New("invalid or expired api key")
+	ErrInvalidRole   = errors.New("role must be lowercase letters, digits, - or _ and cannot be a user role")
+	ErrInvalidExpiry = errors.New("expiry must be in the future and within the maximum key lifetime")
+)
+
+// keyPrefix starts every key, so leaked keys are easy to search for
+const keyPrefix = "clk_"
+
+// userRoles belong to people, a service account cannot take them over
+var userRoles = map[string]bool{"patient": true, "doctor": true, "nurse": true, "admin": true, "super_admin": true}
+
+var rolePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,63}$`)
+
+type Config struct {
+	// DefaultTTL is the lifetime of a key created without an expiry
+	DefaultTTL time.Duration
+	// MaxTTL caps the lifetime of every key
+	MaxTTL time.Duration
+	// RotationGrace is how long a rotated key keeps working, for the integration to
+	// switch to the new one
+	RotationGrace time.Duration
+}
+
+// ServiceAccountUsecase manages the machine integrations and authenticates their API
+// keys. A key is shown once when created, only its hash is stored
+type ServiceAccountUsecase interface {
+	CreateAccount(ctx context.Context, actorId string, req *dto.CreateServiceAccountRequest) (*dto.ServiceAccountResponse, error)
+	ListAccounts(ctx context.Context, pagination *pagination.Pagination) ([]*dto.ServiceAccountResponse, error)
+	// GetAccount returns the account with its keys
+	GetAccount(ctx context.Context, id string) (*dto.ServiceAccountResponse, error)
+	UpdateAccount(ctx context.Context, id string, req *dto.UpdateServiceAccountRequest) (*dto.ServiceAccountResponse, error)
+	DeleteAccount(ctx context.Context, id string) error
+
+	CreateKey(ctx context.Context, accountId string, req *dto.CreateAPIKeyRequest) (*dto.NewAPIKeyResponse, error)
+	// RotateKey issues a key with the scopes of keyId, which expires after the grace
+	RotateKey(ctx context.Context, accountId, keyId string, req *dto.RotateAPIKeyRequest) (*dto.NewAPIKeyResponse, error)
+	RevokeKey(ctx context.Context, accountId, keyId string) error
+
+	// Authenticate resolves a key sent with a request and counts its use, the key comes
+	// with its service account
+	Authenticate(ctx context.Context, rawKey, ip string) (*account.APIKey, error)
+}
+
+type serviceAccountUsecase struct {
+	repo serviceaccountrepository.ServiceAccountRepository
+	cfg  Config
+}
+
+func NewServiceAccountUsecase(repo serviceaccountrepository.ServiceAccountRepository, cfg Config) ServiceAccountUsecase {
+	return &serviceAccountUsecase{repo: repo, cfg: cfg}
+}
+
+func (s *serviceAccountUsecase) CreateAccount(ctx context.Context, actorId string, req *dto.CreateServiceAccountRequest) (*dto.ServiceAccountResponse, error) {
+	role, err := normalizeRole(req.Role)
+	if err != nil {
+		return nil, err
+	}
+	sa := &account.ServiceAccount{
+		Id:          uuid.New(),
+		Name:        strings.TrimSpace(req.Name),
+		Description: strings.TrimSpace(req.Description),
+		Role:        role,
+		CreatedBy:   actorId,
+	}
+	if err := s.repo.CreateAccount(ctx, sa); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Service account %s created by %s with role %s", sa.Name, actorId, sa.Role)
+	return toAccountResponse(sa, nil), nil
+}
+
+func (s *serviceAccountUsecase) ListAccounts(ctx context.Context, pagination *pagination.Pagination) ([]*dto.ServiceAccountResponse, error) {
+	accounts, err := s.repo.ListAccounts(ctx, pagination)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	resp := make([]*dto.ServiceAccountResponse, len(accounts))
+	for i, sa := range accounts {
+		resp[i] = toAccountResponse(sa, nil)
+	}
+	return resp, nil
+}
+
+func (s *serviceAccountUsecase) GetAccount(ctx context.Context, id string) (*dto.ServiceAccountResponse, error) {
+	sa, err := s.account(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.repo.ListKeys(ctx, sa.Id)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return toAccountResponse(sa, keys), nil
+}
+
+func (s *serviceAccountUsecase) UpdateAccount(ctx context.Context, id string, req *dto.UpdateServiceAccountRequest) (*dto.ServiceAccountResponse, error) {
+	sa, err := s.account(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Role != "" {
+		if sa.Role, err = normalizeRole(req.Role); err != nil {
+			return nil, err
+		}
+	}
+	if req.Description != nil {
+		sa.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Disabled != nil {
+		sa.Disabled = *req.Disabled
+	}
+	if err := s.repo.UpdateAccount(ctx, sa); err != nil {
+		return nil, err
+	}
+	return toAccountResponse(sa, nil), nil
+}
+
+func (s *serviceAccountUsecase) DeleteAccount(ctx context.Context, id string) error {
+	accountId, err := uuid.Parse(id)
+	if err != nil {
+		return serviceaccountrepository.ErrServiceAccountNotFound
+	}
+	if err := s.repo.DeleteAccount(ctx, accountId); err != nil {
+		return err
+	}
+	logrus.Infof("Service account %s deleted", accountId)
+	return nil
+}
+
+func (s *serviceAccountUsecase) CreateKey(ctx context.Context, accountId string, req *dto.CreateAPIKeyRequest) (*dto.NewAPIKeyResponse, error) {
+	sa, err := s.account(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := s.expiry(req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, key, err := newKey(sa.Id, req.Scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateKey(ctx, key); err != nil {
+		return nil, err
+	}
+	logrus.Infof("API key %s issued to service account %s", key.Prefix, sa.Name)
+	return &dto.NewAPIKeyResponse{Key: raw, APIKeyResponse: toKeyResponse(key)}, nil
+}
+
+func (s *serviceAccountUsecase) RotateKey(ctx context.Context, accountId, keyId string, req *dto.RotateAPIKeyRequest) (*dto.NewAPIKeyResponse, error) {
+	sa, err := s.account(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	oldId, err := uuid.Parse(keyId)
+	if err != nil {
+		return nil, serviceaccountrepository.ErrKeyNotFound
+	}
+	old, err := s.repo.GetKey(ctx, sa.Id, oldId)
+	if err != nil {
+		return nil, err
+	}
+	if !old.Active(time.Now()) {
+		return nil, serviceaccountrepository.ErrKeyNotFound
+	}
+	expiresAt, err := s.expiry(req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, key, err := newKey(sa.Id, old.Scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	key.RotatedFrom = &old.Id
+	if err := s.repo.RotateKey(ctx, key, time.Now().Add(s.cfg.RotationGrace)); err != nil {
+		return nil, err
+	}
+	logrus.Infof("API key %s of service account %s rotated to %s", old.Prefix, sa.Name, key.Prefix)
+	return &dto.NewAPIKeyResponse{Key: raw, APIKeyResponse: toKeyResponse(key)}, nil
+}
+
+func (s *serviceAccountUsecase) RevokeKey(ctx context.Context, accountId, keyId string) error {
+	saId, err := uuid.Parse(accountId)
+	if err != nil {
+		return serviceaccountrepository.ErrKeyNotFound
+	}
+	id, err := uuid.Parse(keyId)
+	if err != nil {
+		return serviceaccountrepository.ErrKeyNotFound
+	}
+	if err := s.repo.RevokeKey(ctx, saId, id); err != nil {
+		return err
+	}
+	logrus.Infof("API key %s of service account %s revoked", id, saId)
+	return nil
+}
+
+func (s *serviceAccountUsecase) Authenticate(ctx context.Context, rawKey, ip string) (*account.APIKey, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(rawKey, keyPrefix), "_")
+	if !ok || !strings.HasPrefix(rawKey, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	key, err := s.repo.GetKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, serviceaccountrepository.ErrKeyNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if !key.Active(time.Now()) || key.ServiceAccount == nil || key.ServiceAccount.Disabled {
+		logrus.Warnf("Refused API key %s from %s", key.Prefix, ip)
+		return nil, ErrInvalidKey
+	}
+
+	if err := s.repo.RecordUse(ctx, key.Id, ip); err != nil {
+		logrus.Errorf("Failed to record use of API key %s: %v", key.Prefix, err)
+	}
+	return key, nil
+}
+
+func (s *serviceAccountUsecase) account(ctx context.Context, id string) (*account.ServiceAccount, error) {
+	accountId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, serviceaccountrepository.ErrServiceAccountNotFound
+	}
+	return s.repo.GetAccount(ctx, accountId)
+}
+
+// expiry checks the requested expiry against the maximum lifetime, the default applies
+// when there is none
+func (s *serviceAccountUsecase) expiry(requested *time.Time) (time.Time, error) {
+	now := time.Now()
+	if requested == nil {
+		return now.Add(s.cfg.DefaultTTL), nil
+	}
+	if !requested.After(now) || requested.After(now.Add(s.cfg.MaxTTL)) {
+		return time.Time{}, ErrInvalidExpiry
+	}
+	return *requested, nil
+}
+
+func normalizeRole(role string) (string, error) {
+	role = strings.TrimSpace(role)
+	if !rolePattern.MatchString(role) || userRoles[role] {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// newKey generates a key, clk_<prefix>_<secret>, and the row storing it
+func newKey(serviceAccountId uuid.UUID, scopes []string, expiresAt time.Time) (string, *account.APIKey, error) {
+	p := make([]byte, 6)
+	if _, err := rand.Read(p); err != nil {
+		return "", nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	prefix := hex.EncodeToString(p)
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	key := &account.APIKey{
+		Id:               uuid.New(),
+		ServiceAccountId: serviceAccountId,
+		Prefix:           prefix,
+		Hash:             hashSecret(secret),
+		Scopes:           slices.Compact(scopes),
+		ExpiresAt:        expiresAt,
+	}
+	return fmt.Sprintf("%s%s_%s", keyPrefix, prefix, secret), key, nil
+}
+
+// hashSecret is a plain SHA-256, the secrets are random enough not to need a slow hash
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func toAccountResponse(sa *account.ServiceAccount, keys []*account.APIKey) *dto.ServiceAccountResponse {
+	resp := &dto.ServiceAccountResponse{
+		Id:          sa.Id,
+		Name:        sa.Name,
+		Description: sa.Description,
+		Role:        sa.Role,
+		Disabled:    sa.Disabled,
+		CreatedBy:   sa.CreatedBy,
+		CreatedAt:   sa.CreatedAt,
+		UpdatedAt:   sa.UpdatedAt,
+	}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, toKeyResponse(k))
+	}
+	return resp
+}
+
+func toKeyResponse(k *account.APIKey) *dto.APIKeyResponse {
+	return &dto.APIKeyResponse{
+		Id:          k.Id,
+		Prefix:      keyPrefix + k.Prefix,
+		Scopes:      k.Scopes,
+		Active:      k.Active(time.Now()),
+		ExpiresAt:   k.ExpiresAt,
+		RevokedAt:   k.RevokedAt,
+		RotatedFrom: k.RotatedFrom,
+		LastUsedAt:  k.LastUsedAt,
+		LastUsedIP:  k.LastUsedIP,
+		UsageCount:  k.UsageCount,
+		CreatedAt:   k.CreatedAt,
+	}
+}
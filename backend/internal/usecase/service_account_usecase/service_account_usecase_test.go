@@ -0,0 +1,194 @@
+package serviceaccountusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	serviceaccountrepository "backend/internal/infrastructure/persistence/service_account_repository"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAccounts struct {
+	serviceaccountrepository.ServiceAccountRepository
+	accounts map[uuid.UUID]*account.ServiceAccount
+	keys     map[uuid.UUID]*account.APIKey
+}
+
+func (m *memoryAccounts) CreateAccount(ctx context.Context, sa *account.ServiceAccount) error {
+	for _, other := range m.accounts {
+		if other.Name == sa.Name {
+			return serviceaccountrepository.ErrServiceAccountExists
+		}
+	}
+	m.accounts[sa.Id] = sa
+	return nil
+}
+
+func (m *memoryAccounts) GetAccount(ctx context.Context, id uuid.UUID) (*account.ServiceAccount, error) {
+	sa, ok := m.accounts[id]
+	if !ok {
+		return nil, serviceaccountrepository.ErrServiceAccountNotFound
+	}
+	copied := *sa
+	return &copied, nil
+}
+
+func (m *memoryAccounts) UpdateAccount(ctx context.Context, sa *account.ServiceAccount) error {
+	copied := *sa
+	m.accounts[sa.Id] = &copied
+	return nil
+}
+
+func (m *memoryAccounts) CreateKey(ctx context.Context, key *account.APIKey) error {
+	m.keys[key.Id] = key
+	return nil
+}
+
+func (m *memoryAccounts) GetKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) (*account.APIKey, error) {
+	key, ok := m.keys[keyId]
+	if !ok || key.ServiceAccountId != serviceAccountId {
+		return nil, serviceaccountrepository.ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (m *memoryAccounts) GetKeyByPrefix(ctx context.Context, prefix string) (*account.APIKey, error) {
+	for _, key := range m.keys {
+		if key.Prefix == prefix {
+			copied := *key
+			copied.ServiceAccount, _ = m.GetAccount(ctx, key.ServiceAccountId)
+			return &copied, nil
+		}
+	}
+	return nil, serviceaccountrepository.ErrKeyNotFound
+}
+
+func (m *memoryAccounts) RotateKey(ctx context.Context, next *account.APIKey, oldExpiresAt time.Time) error {
+	old := m.keys[*next.RotatedFrom]
+	if oldExpiresAt.Before(old.ExpiresAt) {
+		old.ExpiresAt = oldExpiresAt
+	}
+	m.keys[next.Id] = next
+	return nil
+}
+
+func (m *memoryAccounts) RevokeKey(ctx context.Context, serviceAccountId, keyId uuid.UUID) error {
+	now := time.Now()
+	m.keys[keyId].RevokedAt = &now
+	return nil
+}
+
+func (m *memoryAccounts) RecordUse(ctx context.Context, keyId uuid.UUID, ip string) error {
+	now := time.Now()
+	m.keys[keyId].UsageCount++
+	m.keys[keyId].LastUsedAt, m.keys[keyId].LastUsedIP = &now, ip
+	return nil
+}
+
+func newUsecase() (ServiceAccountUsecase, *memoryAccounts) {
+	repo := &memoryAccounts{accounts: map[uuid.UUID]*account.ServiceAccount{}, keys: map[uuid.UUID]*account.APIKey{}}
+	return NewServiceAccountUsecase(repo, Config{DefaultTTL: 30 * 24 * time.Hour, MaxTTL: 90 * 24 * time.Hour, RotationGrace: time.Hour}), repo
+}
+
+func TestServiceAccount_KeysAuthenticateWithinScopes(t *testing.T) {
+	ctx := context.Background()
+	u, repo := newUsecase()
+
+	for _, role := range []string{"admin", "nurse", "Lab Bridge", "lab,bridge"} {
+		_, err := u.CreateAccount(ctx, "admin-1", &dto.CreateServiceAccountRequest{Name: "lab-bridge", Role: role})
+		assert.ErrorIs(t, err, ErrInvalidRole, role)
+	}
+	sa, err := u.CreateAccount(ctx, "admin-1", &dto.CreateServiceAccountRequest{Name: "lab-bridge", Role: "lab_bridge"})
+	require.NoError(t, err)
+	_, err = u.CreateAccount(ctx, "admin-1", &dto.CreateServiceAccountRequest{Name: "lab-bridge", Role: "lab_bridge"})
+	assert.ErrorIs(t, err, serviceaccountrepository.ErrServiceAccountExists)
+
+	tooLate := time.Now().Add(365 * 24 * time.Hour)
+	_, err = u.CreateKey(ctx, sa.Id.String(), &dto.CreateAPIKeyRequest{Scopes: []string{account.ScopeRead}, ExpiresAt: &tooLate})
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+
+	created, err := u.CreateKey(ctx, sa.Id.String(), &dto.CreateAPIKeyRequest{Scopes: []string{account.ScopeRead}})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix+"_"))
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), created.ExpiresAt, time.Minute)
+
+	// only the hash of the secret is stored
+	stored := repo.keys[created.Id]
+	assert.NotContains(t, created.Key, stored.Hash)
+	assert.NotEmpty(t, stored.Hash)
+
+	key, err := u.Authenticate(ctx, created.Key, "10.0.0.7")
+	require.NoError(t, err)
+	assert.Equal(t, "lab_bridge", key.ServiceAccount.Role)
+	assert.True(t, key.Allows(http.MethodGet))
+	assert.False(t, key.Allows(http.MethodPost))
+	assert.EqualValues(t, 1, stored.UsageCount)
+	assert.Equal(t, "10.0.0.7", stored.LastUsedIP)
+	require.NotNil(t, stored.LastUsedAt)
+
+	for _, bad := range []string{"", "clk_", created.Key + "x", strings.TrimPrefix(created.Key, "clk_"), "clk_" + strings.Repeat("0", 12) + "_secret"} {
+		_, err := u.Authenticate(ctx, bad, "10.0.0.7")
+		assert.ErrorIs(t, err, ErrInvalidKey, bad)
+	}
+	assert.EqualValues(t, 1, stored.UsageCount)
+
+	// a disabled account cannot use its keys until enabled again
+	disabled := true
+	_, err = u.UpdateAccount(ctx, sa.Id.String(), &dto.UpdateServiceAccountRequest{Disabled: &disabled})
+	require.NoError(t, err)
+	_, err = u.Authenticate(ctx, created.Key, "10.0.0.7")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	disabled = false
+	_, err = u.UpdateAccount(ctx, sa.Id.String(), &dto.UpdateServiceAccountRequest{Disabled: &disabled})
+	require.NoError(t, err)
+	_, err = u.Authenticate(ctx, created.Key, "10.0.0.7")
+	require.NoError(t, err)
+
+	// nor an expired key
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	_, err = u.Authenticate(ctx, created.Key, "10.0.0.7")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestServiceAccount_RotateAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	u, repo := newUsecase()
+	sa, err := u.CreateAccount(ctx, "admin-1", &dto.CreateServiceAccountRequest{Name: "reporting", Role: "reporting"})
+	require.NoError(t, err)
+	old, err := u.CreateKey(ctx, sa.Id.String(), &dto.CreateAPIKeyRequest{Scopes: []string{account.ScopeWrite, account.ScopeRead, account.ScopeRead}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{account.ScopeRead, account.ScopeWrite}, old.Scopes)
+
+	rotated, err := u.RotateKey(ctx, sa.Id.String(), old.Id.String(), &dto.RotateAPIKeyRequest{})
+	require.NoError(t, err)
+	assert.NotEqual(t, old.Key, rotated.Key)
+	assert.Equal(t, old.Scopes, rotated.Scopes)
+	require.NotNil(t, rotated.RotatedFrom)
+	assert.Equal(t, old.Id, *rotated.RotatedFrom)
+
+	// both work during the grace, then only the new one
+	_, err = u.Authenticate(ctx, old.Key, "10.0.0.8")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), repo.keys[old.Id].ExpiresAt, time.Minute)
+	_, err = u.Authenticate(ctx, rotated.Key, "10.0.0.8")
+	require.NoError(t, err)
+
+	require.NoError(t, u.RevokeKey(ctx, sa.Id.String(), rotated.Id.String()))
+	_, err = u.Authenticate(ctx, rotated.Key, "10.0.0.8")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = u.RotateKey(ctx, sa.Id.String(), rotated.Id.String(), &dto.RotateAPIKeyRequest{})
+	assert.ErrorIs(t, err, serviceaccountrepository.ErrKeyNotFound)
+
+	// keys are only reachable through their own account
+	other, err := u.CreateAccount(ctx, "admin-1", &dto.CreateServiceAccountRequest{Name: "other", Role: "reporting"})
+	require.NoError(t, err)
+	_, err = u.RotateKey(ctx, other.Id.String(), old.Id.String(), &dto.RotateAPIKeyRequest{})
+	assert.ErrorIs(t, err, serviceaccountrepository.ErrKeyNotFound)
+}
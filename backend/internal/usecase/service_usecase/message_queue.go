@@ -2,29 +2,41 @@ package serviceusecase
 
 import (
 	dtoqueue "backend/internal/domain/dto/queue"
+	"backend/internal/domain/messaging"
+	"backend/internal/domain/patient"
 	persistence "backend/internal/infrastructure/persistence/service_repository"
+	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/redis"
 	"backend/pkg/common/pagination"
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// bookingLockTTL bounds how long a booking mutation may hold the booking lock
+const bookingLockTTL = 10 * time.Second
+
 type BookingQueueUseCase interface {
 	GetBookingQueues(ctx context.Context, pagination *pagination.Pagination) ([]*dtoqueue.BookingQueueResponse, error)
 	GetHistoryQueuesByPatientId(ctx context.Context, pagination *pagination.Pagination, patientId uuid.UUID) ([]*dtoqueue.BookingQueueResponse, error)
 	GetDetailsBookingByQueueId(ctx context.Context, queueId int) (*dtoqueue.BookingQueueResponse, error)
 	UpdateBookingStatus(ctx context.Context, queueId int, status string) error
 	DeleteBookingById(ctx context.Context, queueId int) error
+	// AssignDoctor records the doctor treating the booking
+	AssignDoctor(ctx context.Context, queueId int, doctorId string) error
 }
 
 type bookingQueueUseCase struct {
 	bqRepo persistence.BookingQueuueRepository
+	locker *redis.Locker
 }
 
-func NewBookingQueueUsecase(bqRepo persistence.BookingQueuueRepository) BookingQueueUseCase {
+func NewBookingQueueUsecase(bqRepo persistence.BookingQueuueRepository, rc redis.RedisConnection) BookingQueueUseCase {
 	return &bookingQueueUseCase{
-		bqRepo: bqRepo}
+		bqRepo: bqRepo,
+		locker: redis.NewLocker(rc)}
 }
 
 func (s *bookingQueueUseCase) GetBookingQueues(ctx context.Context, pagination *pagination.Pagination) ([]*dtoqueue.BookingQueueResponse, error) {
@@ -62,8 +74,19 @@ func (s *bookingQueueUseCase) GetDetailsBookingByQueueId(ctx context.Context, qu
 	return response, nil
 }
 
+// UpdateBookingStatus stores the new status together with a booking.status_changed
+// outbox event, the queue cache is updated once the event is relayed. It holds the
+// booking lock so it does not interleave with a call to a room of the same booking
 func (s *bookingQueueUseCase) UpdateBookingStatus(ctx context.Context, queueId int, status string) error {
-	err := s.bqRepo.UpdateBookingStatus(ctx, queueId, status)
+	err := s.locker.WithLock(ctx, persistence.BookingLockName(queueId), bookingLockTTL, func(ctx context.Context) error {
+		return s.bqRepo.UpdateBookingStatus(ctx, queueId, status, func(bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error) {
+			ev, err := rabbitmq.NewBookingStatusChangedEvent(bq, status)
+			if err != nil {
+				return nil, err
+			}
+			return []*messaging.OutboxEvent{ev}, nil
+		})
+	})
 	if err != nil {
 		logrus.Errorf("Usecase layer: %v", err)
 		return err
@@ -79,3 +102,27 @@ func (s *bookingQueueUseCase) DeleteBookingById(ctx context.Context, queueId int
 	}
 	return nil
 }
+
+// AssignDoctor stores the doctor together with a booking.doctor_assigned outbox event,
+// under the booking lock like UpdateBookingStatus
+func (s *bookingQueueUseCase) AssignDoctor(ctx context.Context, queueId int, doctorId string) error {
+	id, err := uuid.Parse(doctorId)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	err = s.locker.WithLock(ctx, persistence.BookingLockName(queueId), bookingLockTTL, func(ctx context.Context) error {
+		return s.bqRepo.AssignDoctor(ctx, queueId, id, func(bq *patient.BookingQueue) ([]*messaging.OutboxEvent, error) {
+			ev, err := rabbitmq.NewBookingDoctorAssignedEvent(bq, id)
+			if err != nil {
+				return nil, err
+			}
+			return []*messaging.OutboxEvent{ev}, nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package sessionusecase
+
+import (
+	"backend/internal/domain/dto"
+	"backend/internal/infrastructure/redis"
+	"backend/pkg/common/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrSessionRevoked      = errors.New("session expired or revoked")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means a rotated refresh token came back, someone else holds a
+	// copy of it so the whole session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+const (
+	// refreshReuseGrace tolerates two tabs refreshing at the same time, the loser is
+	// told to sign in again instead of getting the session revoked
+	refreshReuseGrace = 10 * time.Second
+	// sessionLockTTL bounds a refresh
+	sessionLockTTL = 5 * time.Second
+)
+
+type SessionUsecase interface {
+	// Start opens a session for the user on the client's device, a previous session of
+	// the same device is revoked
+	Start(ctx context.Context, userId, role string, client *dto.ClientInfo) (*dto.SessionTokens, error)
+	// Refresh rotates the refresh token and issues a new access token
+	Refresh(ctx context.Context, refreshToken string, client *dto.ClientInfo) (*dto.SessionTokens, error)
+	// Check fails with ErrSessionRevoked unless the session is open and belongs to userId
+	Check(ctx context.Context, sessionId, userId string) error
+	Revoke(ctx context.Context, sessionId string) error
+	RevokeByRefreshToken(ctx context.Context, refreshToken string) error
+	// RevokeAll signs the user out everywhere and returns how many sessions were open
+	RevokeAll(ctx context.Context, userId string) (int, error)
+	List(ctx context.Context, userId string) ([]*dto.SessionResponse, error)
+}
+
+// session is stored as JSON under session:<id> with the refresh lifetime as TTL, only
+// hashes of the refresh tokens are kept
+type session struct {
+	UserId       string    `json:"user_id"`
+	Role         string    `json:"role"`
+	DeviceId     string    `json:"device_id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	RefreshHash  string    `json:"refresh_hash"`
+	PreviousHash string    `json:"previous_hash,omitempty"`
+	RotatedAt    time.Time `json:"rotated_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastUsedAt   time.Time `json:"last_used_at"`
+}
+
+type sessionUsecase struct {
+	redis      redis.RedisConnection
+	locker     *redis.Locker
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSessionUsecase(rc redis.RedisConnection, accessTTL, refreshTTL time.Duration) SessionUsecase {
+	return &sessionUsecase{
+		redis:      rc,
+		locker:     redis.NewLocker(rc),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+func sessionKey(sessionId string) string {
+	return "session:" + sessionId
+}
+
+// userSessionsKey maps each open session id of a user to its device
+func userSessionsKey(userId string) string {
+	return "user:sessions:" + userId
+}
+
+func (s *sessionUsecase) Start(ctx context.Context, userId, role string, client *dto.ClientInfo) (*dto.SessionTokens, error) {
+	deviceId := client.DeviceId
+	if deviceId == "" {
+		deviceId = uuid.NewString()
+	}
+
+	open, err := s.redis.HGetAll(ctx, userSessionsKey(userId))
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	for sessionId, device := range open {
+		if device == deviceId {
+			if err := s.Revoke(ctx, sessionId); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	sessionId := uuid.NewString()
+	sess := &session{
+		UserId:     userId,
+		Role:       role,
+		DeviceId:   deviceId,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	tokens, err := s.issue(ctx, sessionId, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.redis.HSet(ctx, userSessionsKey(userId), sessionId, deviceId); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if err := s.redis.Expire(ctx, userSessionsKey(userId), s.refreshTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	logrus.Infof("Session %s opened for %s %s", sessionId, role, userId)
+	return tokens, nil
+}
+
+// issue rotates the refresh token of sess, stores it and signs a new access token
+func (s *sessionUsecase) issue(ctx context.Context, sessionId string, sess *session) (*dto.SessionTokens, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refreshToken := sessionId + "." + base64.RawURLEncoding.EncodeToString(secret)
+
+	if sess.RefreshHash != "" {
+		sess.PreviousHash = sess.RefreshHash
+		sess.RotatedAt = time.Now().UTC()
+	}
+	sess.RefreshHash = hashToken(refreshToken)
+
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, sessionKey(sessionId), data, s.refreshTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	accessToken := utils.GenerateToken(sess.UserId, sess.Role, sessionId, s.accessTTL)
+	if accessToken == "" {
+		return nil, errors.New("failed to sign access token")
+	}
+	now := time.Now().UTC()
+	return &dto.SessionTokens{
+		SessionId:        sessionId,
+		DeviceId:         sess.DeviceId,
+		AccessToken:      accessToken,
+		AccessExpiresAt:  now.Add(s.accessTTL),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: now.Add(s.refreshTTL),
+	}, nil
+}
+
+func (s *sessionUsecase) Refresh(ctx context.Context, refreshToken string, client *dto.ClientInfo) (*dto.SessionTokens, error) {
+	sessionId, ok := sessionIdOf(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var tokens *dto.SessionTokens
+	err := s.locker.WithLock(ctx, sessionKey(sessionId), sessionLockTTL, func(ctx context.Context) error {
+		sess, err := s.load(ctx, sessionId)
+		if errors.Is(err, ErrSessionRevoked) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hashToken(refreshToken) {
+		case sess.RefreshHash:
+		case sess.PreviousHash:
+			if time.Since(sess.RotatedAt) < refreshReuseGrace {
+				return ErrInvalidRefreshToken
+			}
+			logrus.Warnf("Refresh token of session %s reused, revoking it", sessionId)
+			if err := s.revoke(ctx, sessionId, sess.UserId); err != nil {
+				return err
+			}
+			return ErrRefreshTokenReused
+		default:
+			return ErrInvalidRefreshToken
+		}
+
+		sess.LastUsedAt = time.Now().UTC()
+		if client.IP != "" {
+			sess.IP = client.IP
+		}
+		if client.UserAgent != "" {
+			sess.UserAgent = client.UserAgent
+		}
+		tokens, err = s.issue(ctx, sessionId, sess)
+		if err != nil {
+			return err
+		}
+		return s.redis.Expire(ctx, userSessionsKey(sess.UserId), s.refreshTTL)
+	})
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *sessionUsecase) Check(ctx context.Context, sessionId, userId string) error {
+	if sessionId == "" {
+		return ErrSessionRevoked
+	}
+	sess, err := s.load(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if sess.UserId != userId {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (s *sessionUsecase) load(ctx context.Context, sessionId string) (*session, error) {
+	data, err := s.redis.Get(ctx, sessionKey(sessionId))
+	if errors.Is(err, redis.ErrNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess session
+	if err := json.Unmarshal([]byte(data), &sess); err != nil {
+		return nil, fmt.Errorf("session %s: %w", sessionId, err)
+	}
+	return &sess, nil
+}
+
+func (s *sessionUsecase) Revoke(ctx context.Context, sessionId string) error {
+	sess, err := s.load(ctx, sessionId)
+	if errors.Is(err, ErrSessionRevoked) {
+		return nil
+	}
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	return s.revoke(ctx, sessionId, sess.UserId)
+}
+
+func (s *sessionUsecase) revoke(ctx context.Context, sessionId, userId string) error {
+	if err := s.redis.Del(ctx, sessionKey(sessionId)); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	if err := s.redis.HDel(ctx, userSessionsKey(userId), sessionId); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return err
+	}
+	logrus.Infof("Session %s of %s revoked", sessionId, userId)
+	return nil
+}
+
+func (s *sessionUsecase) RevokeByRefreshToken(ctx context.Context, refreshToken string) error {
+	sessionId, ok := sessionIdOf(refreshToken)
+	if !ok {
+		return ErrInvalidRefreshToken
+	}
+	sess, err := s.load(ctx, sessionId)
+	if errors.Is(err, ErrSessionRevoked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// an old token of the session may log it out too, it was issued to the same device
+	h := hashToken(refreshToken)
+	if h != sess.RefreshHash && h != sess.PreviousHash {
+		return ErrInvalidRefreshToken
+	}
+	return s.revoke(ctx, sessionId, sess.UserId)
+}
+
+func (s *sessionUsecase) RevokeAll(ctx context.Context, userId string) (int, error) {
+	open, err := s.redis.HGetAll(ctx, userSessionsKey(userId))
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return 0, err
+	}
+	keys := []string{userSessionsKey(userId)}
+	for sessionId := range open {
+		keys = append(keys, sessionKey(sessionId))
+	}
+	if err := s.redis.Del(ctx, keys...); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return 0, err
+	}
+	logrus.Warnf("All %d sessions of %s revoked", len(open), userId)
+	return len(open), nil
+}
+
+func (s *sessionUsecase) List(ctx context.Context, userId string) ([]*dto.SessionResponse, error) {
+	open, err := s.redis.HGetAll(ctx, userSessionsKey(userId))
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	sessions := make([]*dto.SessionResponse, 0, len(open))
+	for sessionId := range open {
+		sess, err := s.load(ctx, sessionId)
+		if errors.Is(err, ErrSessionRevoked) {
+			// expired on its own
+			_ = s.redis.HDel(ctx, userSessionsKey(userId), sessionId)
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return nil, err
+		}
+		sessions = append(sessions, &dto.SessionResponse{
+			SessionId:  sessionId,
+			UserId:     sess.UserId,
+			Role:       sess.Role,
+			DeviceId:   sess.DeviceId,
+			UserAgent:  sess.UserAgent,
+			IP:         sess.IP,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastUsedAt,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// sessionIdOf splits a refresh token, it is <session id>.<secret>
+func sessionIdOf(refreshToken string) (string, bool) {
+	sessionId, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || secret == "" {
+		return "", false
+	}
+	if _, err := uuid.Parse(sessionId); err != nil {
+		return "", false
+	}
+	return sessionId, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
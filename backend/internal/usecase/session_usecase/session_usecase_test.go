@@ -0,0 +1,68 @@
+package sessionusecase
+
+import (
+	"backend/internal/domain/dto"
+	"backend/internal/infrastructure/redis"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions_RotateRevokeAndDetectReuse(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	s := NewSessionUsecase(store, 15*time.Minute, time.Hour).(*sessionUsecase)
+	phone := &dto.ClientInfo{DeviceId: "phone", UserAgent: "app", IP: "10.0.0.1"}
+
+	first, err := s.Start(ctx, "patient-1", "patient", phone)
+	require.NoError(t, err)
+	require.NoError(t, s.Check(ctx, first.SessionId, "patient-1"))
+	assert.ErrorIs(t, s.Check(ctx, first.SessionId, "patient-2"), ErrSessionRevoked)
+
+	// signing in again on the same device replaces its session
+	second, err := s.Start(ctx, "patient-1", "patient", phone)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.Check(ctx, first.SessionId, "patient-1"), ErrSessionRevoked)
+	laptop, err := s.Start(ctx, "patient-1", "patient", &dto.ClientInfo{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, laptop.DeviceId)
+
+	sessions, err := s.List(ctx, "patient-1")
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	rotated, err := s.Refresh(ctx, second.RefreshToken, phone)
+	require.NoError(t, err)
+	assert.Equal(t, second.SessionId, rotated.SessionId)
+	assert.NotEqual(t, second.RefreshToken, rotated.RefreshToken)
+
+	// a concurrent refresh with the old token is refused without harm
+	_, err = s.Refresh(ctx, second.RefreshToken, phone)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	require.NoError(t, s.Check(ctx, second.SessionId, "patient-1"))
+
+	// later the same old token means it leaked, the session is revoked
+	sess, err := s.load(ctx, second.SessionId)
+	require.NoError(t, err)
+	sess.RotatedAt = time.Now().Add(-time.Minute)
+	data, err := json.Marshal(sess)
+	require.NoError(t, err)
+	require.NoError(t, store.Set(ctx, sessionKey(second.SessionId), data, time.Hour))
+
+	_, err = s.Refresh(ctx, second.RefreshToken, phone)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.ErrorIs(t, s.Check(ctx, second.SessionId, "patient-1"), ErrSessionRevoked)
+	_, err = s.Refresh(ctx, rotated.RefreshToken, phone)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	// force sign-out
+	revoked, err := s.RevokeAll(ctx, "patient-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	assert.ErrorIs(t, s.Check(ctx, laptop.SessionId, "patient-1"), ErrSessionRevoked)
+}
@@ -0,0 +1,361 @@
+package ssousecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	accountrepository "backend/internal/infrastructure/persistence/account_repository"
+	ssoidentityrepository "backend/internal/infrastructure/persistence/sso_identity_repository"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrDisabled     = errors.New("single sign-on is not configured")
+	ErrInvalidState = errors.New("invalid or expired sign-on state, start again")
+	ErrInvalidToken = errors.New("identity provider answer could not be verified")
+	ErrNoRole       = errors.New("identity provider grants no staff role")
+	// ErrEmailNotVerified refuses to link by an email the provider did not verify
+	ErrEmailNotVerified = errors.New("email is not verified by the identity provider")
+	ErrNotLinked        = errors.New("no staff account matches the email")
+)
+
+// staffRoles can sign in through the identity provider, in order of precedence
+var staffRoles = []string{"doctor", "nurse"}
+
+type Config struct {
+	Enabled      bool
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// RedirectURL is the page of the front end the provider sends the browser back to
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim lists the groups of the user, mapped to roles by RoleMapping. Groups
+	// are matched regardless of case
+	GroupsClaim string
+	RoleMapping map[string]string
+	// RoleClaim, when set, names a claim holding the role itself
+	RoleClaim string
+	// StateTTL is how long a started sign-on can be finished
+	StateTTL time.Duration
+}
+
+// SSOUsecase signs doctors and nurses in through an OpenID Connect provider with the
+// authorization code flow and PKCE. A user is linked by verified email to the staff
+// record on first sign-on, by issuer and subject afterwards
+type SSOUsecase interface {
+	// Start hands out the authorization URL and the state to bind to the browser, the
+	// nonce and PKCE verifier stay here
+	Start(ctx context.Context) (*dto.SSOStartResponse, error)
+	// Callback exchanges the code and signs in like a password login, two-factor included
+	Callback(ctx context.Context, req *dto.SSOCallbackRequest) (*dto.LoginResponse, error)
+}
+
+type ssoUsecase struct {
+	accounts   accountrepository.AccountRepository
+	identities ssoidentityrepository.SSOIdentityRepository
+	twoFactor  twofactorusecase.TwoFactorUsecase
+	guard      loginguardusecase.LoginGuardUsecase
+	redis      redis.RedisConnection
+	cfg        Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// pending is what Start keeps for the callback of its state
+type pending struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// claims are the parts of the ID token used, the groups and role claims are read apart
+// since their names are configured
+type claims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func NewSSOUsecase(accounts accountrepository.AccountRepository, identities ssoidentityrepository.SSOIdentityRepository, twoFactor twofactorusecase.TwoFactorUsecase, guard loginguardusecase.LoginGuardUsecase, rc redis.RedisConnection, cfg Config) SSOUsecase {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.StateTTL <= 0 {
+		cfg.StateTTL = 10 * time.Minute
+	}
+	mapping := make(map[string]string, len(cfg.RoleMapping))
+	for group, role := range cfg.RoleMapping {
+		mapping[strings.ToLower(group)] = strings.ToLower(role)
+	}
+	cfg.RoleMapping = mapping
+	return &ssoUsecase{
+		accounts:   accounts,
+		identities: identities,
+		twoFactor:  twoFactor,
+		guard:      guard,
+		redis:      rc,
+		cfg:        cfg,
+	}
+}
+
+func stateKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return "sso:state:" + hex.EncodeToString(sum[:])
+}
+
+// oauth2Config discovers the provider on first use, a provider down at startup does
+// not keep the server from starting
+func (s *ssoUsecase) oauth2Config(ctx context.Context) (*oauth2.Config, *oidc.Provider, error) {
+	if !s.cfg.Enabled {
+		return nil, nil, ErrDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider == nil {
+		// the provider keeps the context to refresh its keys, it must outlive the request
+		provider, err := oidc.NewProvider(context.WithoutCancel(ctx), s.cfg.Issuer)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return nil, nil, err
+		}
+		s.provider = provider
+	}
+	return &oauth2.Config{
+		ClientID:     s.cfg.ClientId,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint:     s.provider.Endpoint(),
+		Scopes:       s.cfg.Scopes,
+	}, s.provider, nil
+}
+
+func (s *ssoUsecase) Start(ctx context.Context) (*dto.SSOStartResponse, error) {
+	conf, _, err := s.oauth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	p := pending{Verifier: oauth2.GenerateVerifier(), Nonce: nonce}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, stateKey(state), string(raw), s.cfg.StateTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	return &dto.SSOStartResponse{
+		AuthorizationURL: conf.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(p.Verifier)),
+		ExpiresAt:        time.Now().Add(s.cfg.StateTTL).UTC(),
+		State:            state,
+	}, nil
+}
+
+// consume claims the state once, a replayed callback finds nothing
+func (s *ssoUsecase) consume(ctx context.Context, state string) (*pending, error) {
+	key := stateKey(state)
+	raw, err := s.redis.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return nil, ErrInvalidState
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	claimed, err := s.redis.DelIfEqual(ctx, key, raw)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrInvalidState
+	}
+	var p pending
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return nil, ErrInvalidState
+	}
+	return &p, nil
+}
+
+func (s *ssoUsecase) Callback(ctx context.Context, req *dto.SSOCallbackRequest) (*dto.LoginResponse, error) {
+	conf, provider, err := s.oauth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.consume(ctx, req.State)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := conf.Exchange(ctx, req.Code, oauth2.VerifierOption(p.Verifier))
+	if err != nil {
+		logrus.Warnf("Single sign-on code exchange failed: %v", err)
+		return nil, ErrInvalidToken
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.cfg.ClientId}).Verify(ctx, rawIDToken)
+	if err != nil {
+		logrus.Warnf("Single sign-on ID token refused: %v", err)
+		return nil, ErrInvalidToken
+	}
+	if idToken.Nonce != p.Nonce {
+		return nil, ErrInvalidToken
+	}
+	var c claims
+	var all map[string]interface{}
+	if err := idToken.Claims(&c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := idToken.Claims(&all); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	attempt := &dto.LoginAttempt{Role: "sso", Identifier: c.Email, Client: req.Client}
+	acc, err := s.resolve(ctx, idToken, &c, s.roles(all))
+	if err != nil {
+		if errors.Is(err, ErrNoRole) || errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrNotLinked) || errors.Is(err, ssoidentityrepository.ErrIdentityConflict) {
+			logrus.Warnf("Single sign-on of %s %s refused: %v", idToken.Issuer, idToken.Subject, err)
+			s.guard.Failed(ctx, attempt, "", loginguardusecase.ReasonSSODenied)
+		}
+		return nil, err
+	}
+	if err := s.identities.RecordLogin(ctx, idToken.Issuer, idToken.Subject); err != nil {
+		return nil, err
+	}
+	attempt.Role = acc.Role
+
+	user := &dto.SSOUser{Id: acc.Id.String(), Role: acc.Role, Email: acc.Email, Name: c.Name}
+	return s.twoFactor.Login(ctx, attempt, acc.Id.String(), user)
+}
+
+// roles maps the role claim and the groups to the staff roles they grant, in order of
+// precedence
+func (s *ssoUsecase) roles(all map[string]interface{}) []string {
+	granted := map[string]bool{}
+	if s.cfg.RoleClaim != "" {
+		if role, ok := all[s.cfg.RoleClaim].(string); ok {
+			granted[strings.ToLower(role)] = true
+		}
+	}
+	// providers send a single group as a string
+	var groups []interface{}
+	switch v := all[s.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		groups = v
+	case string:
+		groups = []interface{}{v}
+	}
+	for _, g := range groups {
+		if group, ok := g.(string); ok {
+			if role, ok := s.cfg.RoleMapping[strings.ToLower(group)]; ok {
+				granted[role] = true
+			}
+		}
+	}
+
+	var roles []string
+	for _, role := range staffRoles {
+		if granted[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// resolve finds the account of the identity, linking it by email on first sign-on
+func (s *ssoUsecase) resolve(ctx context.Context, idToken *oidc.IDToken, c *claims, roles []string) (*account.Account, error) {
+	if len(roles) == 0 {
+		return nil, ErrNoRole
+	}
+
+	identity, err := s.identities.Get(ctx, idToken.Issuer, idToken.Subject)
+	switch {
+	case err == nil:
+		// the provider can take a role back, the link does not outlive it
+		if !slices.Contains(roles, identity.Role) {
+			return nil, ErrNoRole
+		}
+		acc, err := s.accounts.FindById(ctx, identity.Role, identity.UserId)
+		if errors.Is(err, accountrepository.ErrAccountNotFound) {
+			return nil, ErrNotLinked
+		}
+		return acc, err
+	case !errors.Is(err, ssoidentityrepository.ErrIdentityNotFound):
+		return nil, err
+	}
+
+	if !c.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	var acc *account.Account
+	for _, role := range roles {
+		acc, err = s.accounts.FindByEmail(ctx, role, c.Email)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, accountrepository.ErrAccountNotFound) {
+			return nil, err
+		}
+	}
+	if acc == nil {
+		return nil, ErrNotLinked
+	}
+
+	err = s.identities.Link(ctx, &account.SSOIdentity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Role:    acc.Role,
+		UserId:  acc.Id,
+		Email:   c.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// from now on the account signs in through the provider only
+	if err := s.accounts.UpdatePassword(ctx, acc.Role, acc.Id, []byte(account.ExternalPassword)); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	logrus.Infof("Linked %s %s to %s %s by email", acc.Role, acc.Id, idToken.Issuer, idToken.Subject)
+	return acc, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
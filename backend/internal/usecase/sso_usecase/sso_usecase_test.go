@@ -0,0 +1,230 @@
+package ssousecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	"backend/internal/infrastructure/mockidp"
+	accountrepository "backend/internal/infrastructure/persistence/account_repository"
+	ssoidentityrepository "backend/internal/infrastructure/persistence/sso_identity_repository"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	twofactorusecase "backend/internal/usecase/two_factor_usecase"
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "https://clinic.test/sso/callback"
+
+type memoryAccounts struct {
+	accountrepository.AccountRepository
+	accounts []*account.Account
+}
+
+func (m *memoryAccounts) FindByEmail(ctx context.Context, role, email string) (*account.Account, error) {
+	for _, a := range m.accounts {
+		if a.Role == role && strings.EqualFold(a.Email, email) {
+			copied := *a
+			return &copied, nil
+		}
+	}
+	return nil, accountrepository.ErrAccountNotFound
+}
+
+func (m *memoryAccounts) FindById(ctx context.Context, role string, id uuid.UUID) (*account.Account, error) {
+	for _, a := range m.accounts {
+		if a.Role == role && a.Id == id {
+			copied := *a
+			return &copied, nil
+		}
+	}
+	return nil, accountrepository.ErrAccountNotFound
+}
+
+func (m *memoryAccounts) UpdatePassword(ctx context.Context, role string, id uuid.UUID, hashed []byte) error {
+	for _, a := range m.accounts {
+		if a.Role == role && a.Id == id {
+			a.Password = string(hashed)
+			return nil
+		}
+	}
+	return accountrepository.ErrAccountNotFound
+}
+
+// memoryIdentities allows one identity per account like the unique index
+type memoryIdentities struct {
+	identities map[string]*account.SSOIdentity
+}
+
+func (m *memoryIdentities) Get(ctx context.Context, issuer, subject string) (*account.SSOIdentity, error) {
+	identity, ok := m.identities[issuer+" "+subject]
+	if !ok {
+		return nil, ssoidentityrepository.ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (m *memoryIdentities) Link(ctx context.Context, identity *account.SSOIdentity) error {
+	for _, other := range m.identities {
+		if other.Role == identity.Role && other.UserId == identity.UserId {
+			return ssoidentityrepository.ErrIdentityConflict
+		}
+	}
+	m.identities[identity.Issuer+" "+identity.Subject] = identity
+	return nil
+}
+
+func (m *memoryIdentities) RecordLogin(ctx context.Context, issuer, subject string) error {
+	return nil
+}
+
+type recordingGuard struct {
+	loginguardusecase.LoginGuardUsecase
+	failed []string
+}
+
+func (g *recordingGuard) Failed(ctx context.Context, attempt *dto.LoginAttempt, userId, reason string) {
+	g.failed = append(g.failed, reason)
+}
+
+// openSessions logs in without a second factor and remembers whom
+type openSessions struct {
+	twofactorusecase.TwoFactorUsecase
+	opened []string
+}
+
+func (o *openSessions) Login(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}) (*dto.LoginResponse, error) {
+	o.opened = append(o.opened, attempt.Role+":"+userId)
+	return &dto.LoginResponse{Token: "token-of-" + userId, User: user, Message: "Login successfully"}, nil
+}
+
+type fixture struct {
+	idp        *mockidp.Server
+	sso        SSOUsecase
+	accounts   *memoryAccounts
+	identities *memoryIdentities
+	guard      *recordingGuard
+	sessions   *openSessions
+	doctor     *account.Account
+	nurse      *account.Account
+}
+
+func newFixture(t *testing.T) *fixture {
+	idp, err := mockidp.New("clinic", "s3cret")
+	require.NoError(t, err)
+	t.Cleanup(idp.Close)
+
+	f := &fixture{
+		idp:        idp,
+		identities: &memoryIdentities{identities: map[string]*account.SSOIdentity{}},
+		guard:      &recordingGuard{},
+		sessions:   &openSessions{},
+		doctor:     &account.Account{Id: uuid.New(), Role: "doctor", Email: "House@clinic.test", Password: "$2a$10$hash"},
+		nurse:      &account.Account{Id: uuid.New(), Role: "nurse", Email: "joy@clinic.test", Password: "$2a$10$hash"},
+	}
+	f.accounts = &memoryAccounts{accounts: []*account.Account{f.doctor, f.nurse}}
+	f.sso = NewSSOUsecase(f.accounts, f.identities, f.sessions, f.guard, redis.NewMemoryStore(), Config{
+		Enabled:      true,
+		Issuer:       idp.Issuer(),
+		ClientId:     "clinic",
+		ClientSecret: "s3cret",
+		RedirectURL:  redirectURL,
+		RoleMapping:  map[string]string{"Physicians": "doctor", "nursing": "nurse"},
+	})
+	return f
+}
+
+// signIn runs the browser side of the flow and returns the callback it would post
+func (f *fixture) signIn(t *testing.T, user *mockidp.User) *dto.SSOCallbackRequest {
+	f.idp.SignIn(user)
+	start, err := f.sso.Start(context.Background())
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(start.AuthorizationURL)
+	require.NoError(t, err)
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	assert.NotEmpty(t, authURL.Query().Get("nonce"))
+
+	back, err := f.idp.Authorize(start.AuthorizationURL)
+	require.NoError(t, err)
+	return &dto.SSOCallbackRequest{Code: back.Get("code"), State: back.Get("state")}
+}
+
+func TestSSO_LinksByEmailThenBySubject(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	house := &mockidp.User{Subject: "idp-house", Email: "house@clinic.test", EmailVerified: true, Name: "Gregory House", Groups: []string{"physicians"}}
+
+	resp, err := f.sso.Callback(ctx, f.signIn(t, house))
+	require.NoError(t, err)
+	assert.Equal(t, "token-of-"+f.doctor.Id.String(), resp.Token)
+	user := resp.User.(*dto.SSOUser)
+	assert.Equal(t, "doctor", user.Role)
+	assert.Equal(t, "Gregory House", user.Name)
+	assert.Equal(t, []string{"doctor:" + f.doctor.Id.String()}, f.sessions.opened)
+
+	// the password no longer signs in
+	assert.Equal(t, account.ExternalPassword, f.doctor.Password)
+	assert.True(t, f.doctor.ExternalLogin())
+
+	// a replayed callback is refused, the state and the code are single use
+	req := f.signIn(t, house)
+	_, err = f.sso.Callback(ctx, req)
+	require.NoError(t, err)
+	_, err = f.sso.Callback(ctx, req)
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	// once linked the subject signs in even after the email changed
+	house.Email = "gh@elsewhere.test"
+	_, err = f.sso.Callback(ctx, f.signIn(t, house))
+	require.NoError(t, err)
+
+	// a wrong code spends the state but signs nobody in
+	req = f.signIn(t, house)
+	req.Code = "forged"
+	_, err = f.sso.Callback(ctx, req)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestSSO_RefusesWithoutRoleVerifiedEmailOrAccount(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+
+	_, err := f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-clerk", Email: "joy@clinic.test", EmailVerified: true, Groups: []string{"front-desk"}}))
+	assert.ErrorIs(t, err, ErrNoRole)
+
+	_, err = f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-joy", Email: "joy@clinic.test", EmailVerified: false, Groups: []string{"nursing"}}))
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+
+	// the email of a nurse does not make a doctor
+	_, err = f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-joy", Email: "joy@clinic.test", EmailVerified: true, Groups: []string{"physicians"}}))
+	assert.ErrorIs(t, err, ErrNotLinked)
+
+	resp, err := f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-joy", Email: "joy@clinic.test", EmailVerified: true, Groups: []string{"nursing"}}))
+	require.NoError(t, err)
+	assert.Equal(t, "nurse", resp.User.(*dto.SSOUser).Role)
+
+	// another subject with the same email cannot take the account over
+	_, err = f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-impostor", Email: "joy@clinic.test", EmailVerified: true, Groups: []string{"nursing"}}))
+	assert.ErrorIs(t, err, ssoidentityrepository.ErrIdentityConflict)
+
+	// the provider taking the group back ends the sign-ons of the link
+	_, err = f.sso.Callback(ctx, f.signIn(t, &mockidp.User{Subject: "idp-joy", Email: "joy@clinic.test", EmailVerified: true}))
+	assert.ErrorIs(t, err, ErrNoRole)
+
+	assert.Equal(t, []string{
+		loginguardusecase.ReasonSSODenied, loginguardusecase.ReasonSSODenied, loginguardusecase.ReasonSSODenied,
+		loginguardusecase.ReasonSSODenied, loginguardusecase.ReasonSSODenied,
+	}, f.guard.failed)
+}
+
+func TestSSO_Disabled(t *testing.T) {
+	u := NewSSOUsecase(&memoryAccounts{}, &memoryIdentities{}, &openSessions{}, &recordingGuard{}, redis.NewMemoryStore(), Config{})
+	_, err := u.Start(context.Background())
+	assert.ErrorIs(t, err, ErrDisabled)
+}
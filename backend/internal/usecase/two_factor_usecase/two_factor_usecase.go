@@ -0,0 +1,584 @@
+package twofactorusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	twofactorrepository "backend/internal/infrastructure/persistence/two_factor_repository"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotStaff         = errors.New("two-factor authentication is for staff accounts")
+	ErrInvalidCode      = errors.New("invalid authentication code")
+	ErrInvalidChallenge = errors.New("login challenge expired, please sign in again")
+	ErrAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrNotEnabled       = errors.New("two-factor authentication is not enabled")
+	// ErrRequired refuses to turn off a second factor the role policy makes mandatory
+	ErrRequired = errors.New("two-factor authentication is mandatory for this role")
+)
+
+const (
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+	// totpSkew accepts the codes of the neighbouring periods, for clocks that drift
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var staffRoles = map[string]bool{"admin": true, "doctor": true, "nurse": true}
+
+var totpOpts = totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// TwoFactorUsecase adds TOTP codes to staff logins. A password login of an account with a
+// second factor only gets a challenge token, the session is opened once the code is
+// verified. Roles in the required list must enroll before their first session. The
+// login guard hears of the login once the session is opened, and of every wrong code
+type TwoFactorUsecase interface {
+	// Login finishes a password login of attempt, it opens the session or answers with a
+	// challenge
+	Login(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}) (*dto.LoginResponse, error)
+	// OnSessionStarted registers a listener for the logins of role, it is called once the
+	// session is opened. Listeners are registered at startup
+	OnSessionStarted(role string, listener SessionListener)
+	// Challenge verifies the code of a login challenge and opens the session
+	Challenge(ctx context.Context, req *dto.TwoFactorChallengeRequest) (*dto.LoginResponse, error)
+	// EnrollChallenge starts the enrollment of an account that cannot sign in without one
+	EnrollChallenge(ctx context.Context, challengeToken string) (*dto.TwoFactorEnrollment, error)
+
+	Status(ctx context.Context, userId, role string) (*dto.TwoFactorStatus, error)
+	// Enroll creates a new secret, it is only used once Activate saw a code of it
+	Enroll(ctx context.Context, userId, role string) (*dto.TwoFactorEnrollment, error)
+	Activate(ctx context.Context, userId, code string) (*dto.RecoveryCodesResponse, error)
+	Disable(ctx context.Context, userId, role, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes, the old ones stop working
+	RegenerateRecoveryCodes(ctx context.Context, userId, code string) (*dto.RecoveryCodesResponse, error)
+	// Reset removes the second factor of a user who lost their device, for admins
+	Reset(ctx context.Context, userId string) error
+}
+
+// SessionListener hears of a login once every factor passed and its session is opened
+type SessionListener func(ctx context.Context, userId string, client *dto.ClientInfo)
+
+// challenge is stored as JSON under 2fa:challenge:<hash of the token>
+type challenge struct {
+	UserId  string           `json:"user_id"`
+	User    json.RawMessage  `json:"user"`
+	Attempt dto.LoginAttempt `json:"attempt"`
+	Enroll  bool             `json:"enroll"`
+}
+
+type twoFactorUsecase struct {
+	repo      twofactorrepository.TwoFactorRepository
+	sessions  sessionusecase.SessionUsecase
+	guard     loginguardusecase.LoginGuardUsecase
+	redis     redis.RedisConnection
+	issuer    string
+	required  map[string]bool
+	listeners map[string][]SessionListener
+}
+
+func NewTwoFactorUsecase(repo twofactorrepository.TwoFactorRepository, sessions sessionusecase.SessionUsecase, guard loginguardusecase.LoginGuardUsecase, rc redis.RedisConnection, issuer string, requiredRoles []string) TwoFactorUsecase {
+	required := make(map[string]bool, len(requiredRoles))
+	for _, role := range requiredRoles {
+		required[role] = true
+	}
+	return &twoFactorUsecase{
+		repo:      repo,
+		sessions:  sessions,
+		guard:     guard,
+		redis:     rc,
+		issuer:    issuer,
+		required:  required,
+		listeners: map[string][]SessionListener{},
+	}
+}
+
+func challengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "2fa:challenge:" + hex.EncodeToString(sum[:])
+}
+
+// usedCodeKey remembers a verified period so its code cannot be replayed
+func usedCodeKey(userId uuid.UUID, step int64) string {
+	return fmt.Sprintf("2fa:used:%s:%d", userId, step)
+}
+
+func (s *twoFactorUsecase) Login(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}) (*dto.LoginResponse, error) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := s.repo.Get(ctx, id)
+	switch {
+	case err == nil && tf.Enabled:
+		return s.challenge(ctx, attempt, userId, user, false)
+	case err != nil && !errors.Is(err, twofactorrepository.ErrTwoFactorNotFound):
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if s.required[attempt.Role] {
+		return s.challenge(ctx, attempt, userId, user, true)
+	}
+	return s.startSession(ctx, attempt, userId, user)
+}
+
+// startSession opens the session of a login that passed every factor, only then is the
+// login a success for the guard and the device a known one
+func (s *twoFactorUsecase) startSession(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}) (*dto.LoginResponse, error) {
+	tokens, err := s.sessions.Start(ctx, userId, attempt.Role, &attempt.Client)
+	if err != nil {
+		logrus.Errorf("Failed to start session: %v", err)
+		return nil, err
+	}
+	s.guard.Succeeded(ctx, attempt, userId)
+	for _, listener := range s.listeners[attempt.Role] {
+		listener(ctx, userId, &attempt.Client)
+	}
+	return dto.NewLoginResponse(tokens, user, "Login successfully"), nil
+}
+
+func (s *twoFactorUsecase) OnSessionStarted(role string, listener SessionListener) {
+	s.listeners[role] = append(s.listeners[role], listener)
+}
+
+func (s *twoFactorUsecase) challenge(ctx context.Context, attempt *dto.LoginAttempt, userId string, user interface{}, enroll bool) (*dto.LoginResponse, error) {
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(&challenge{UserId: userId, User: userJSON, Attempt: *attempt, Enroll: enroll})
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, challengeKey(token), string(raw), challengeTTL); err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	msg := "Enter the code of your authenticator app"
+	if enroll {
+		msg = "Two-factor authentication is mandatory, set it up to sign in"
+	}
+	return &dto.LoginResponse{
+		Message:                msg,
+		TwoFactorRequired:      true,
+		TwoFactorSetupRequired: enroll,
+		ChallengeToken:         token,
+	}, nil
+}
+
+func (s *twoFactorUsecase) loadChallenge(ctx context.Context, token string) (*challenge, string, error) {
+	raw, err := s.redis.Get(ctx, challengeKey(token))
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			return nil, "", ErrInvalidChallenge
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, "", err
+	}
+	c := &challenge{}
+	if err := json.Unmarshal([]byte(raw), c); err != nil {
+		return nil, "", ErrInvalidChallenge
+	}
+	return c, raw, nil
+}
+
+func (s *twoFactorUsecase) Challenge(ctx context.Context, req *dto.TwoFactorChallengeRequest) (*dto.LoginResponse, error) {
+	key := challengeKey(req.ChallengeToken)
+	c, raw, err := s.loadChallenge(ctx, req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// a stolen password plus a challenge token still only get a few guesses
+	attempts, err := s.redis.Incr(ctx, key+":attempts")
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if attempts == 1 {
+		_ = s.redis.Expire(ctx, key+":attempts", challengeTTL)
+	}
+	if attempts > maxChallengeAttempts {
+		_ = s.redis.Del(ctx, key, key+":attempts")
+		logrus.Warnf("Too many two-factor attempts for %s %s", c.Attempt.Role, c.UserId)
+		return nil, ErrInvalidChallenge
+	}
+	// wrong codes count towards the lockout of the account like wrong passwords
+	if err := s.guard.Check(ctx, &c.Attempt); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(c.UserId)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	tf, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, twofactorrepository.ErrTwoFactorNotFound) {
+			return nil, ErrInvalidCode
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	activate := c.Enroll && !tf.Enabled
+	if err := s.verifyChallenge(ctx, tf, req.Code, activate); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			s.guard.Failed(ctx, &c.Attempt, c.UserId, loginguardusecase.ReasonWrongCode)
+		}
+		return nil, err
+	}
+
+	// the challenge is single use, whoever deletes it first gets the session
+	claimed, err := s.redis.DelIfEqual(ctx, key, raw)
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrInvalidChallenge
+	}
+	_ = s.redis.Del(ctx, key+":attempts")
+
+	var codes []string
+	if activate {
+		if codes, err = s.enable(ctx, tf); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.startSession(ctx, &c.Attempt, c.UserId, c.User)
+	if err != nil {
+		return nil, err
+	}
+	resp.RecoveryCodes = codes
+	return resp, nil
+}
+
+func (s *twoFactorUsecase) verifyChallenge(ctx context.Context, tf *account.TwoFactor, code string, activate bool) error {
+	switch {
+	case activate:
+		// the first code proves the app was set up, recovery codes do not exist yet
+		ok, err := s.checkTOTP(ctx, tf, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidCode
+		}
+		return nil
+	case tf.Enabled:
+		return s.verify(ctx, tf, code)
+	}
+	return ErrInvalidCode
+}
+
+func (s *twoFactorUsecase) EnrollChallenge(ctx context.Context, challengeToken string) (*dto.TwoFactorEnrollment, error) {
+	c, _, err := s.loadChallenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Enroll {
+		return nil, ErrAlreadyEnabled
+	}
+	return s.Enroll(ctx, c.UserId, c.Attempt.Role)
+}
+
+func (s *twoFactorUsecase) Status(ctx context.Context, userId, role string) (*dto.TwoFactorStatus, error) {
+	status := &dto.TwoFactorStatus{Required: s.required[role]}
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, twofactorrepository.ErrTwoFactorNotFound) {
+			return status, nil
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	if tf.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesLeft = len(tf.RecoveryCodes)
+		status.EnabledAt = tf.EnabledAt
+	}
+	return status, nil
+}
+
+func (s *twoFactorUsecase) Enroll(ctx context.Context, userId, role string) (*dto.TwoFactorEnrollment, error) {
+	if !staffRoles[role] {
+		return nil, ErrNotStaff
+	}
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	if tf, err := s.repo.Get(ctx, id); err == nil && tf.Enabled {
+		return nil, ErrAlreadyEnabled
+	} else if err != nil && !errors.Is(err, twofactorrepository.ErrTwoFactorNotFound) {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.issuer,
+		AccountName: fmt.Sprintf("%s %s", role, userId[:8]),
+		Period:      uint(totpOpts.Period),
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	secret, err := seal(key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	// a pending secret replaces the previous pending one
+	if err := s.repo.Save(ctx, &account.TwoFactor{UserId: id, Role: role, Secret: secret, RecoveryCodes: []string{}}); err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &dto.TwoFactorEnrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+func (s *twoFactorUsecase) Activate(ctx context.Context, userId, code string) (*dto.RecoveryCodesResponse, error) {
+	tf, err := s.get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrAlreadyEnabled
+	}
+	ok, err := s.checkTOTP(ctx, tf, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, err := s.enable(ctx, tf)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *twoFactorUsecase) Disable(ctx context.Context, userId, role, code string) error {
+	if s.required[role] {
+		return ErrRequired
+	}
+	tf, err := s.get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return ErrNotEnabled
+	}
+	if err := s.verify(ctx, tf, code); err != nil {
+		return err
+	}
+	logrus.Infof("Two-factor authentication disabled for %s %s", tf.Role, tf.UserId)
+	return s.repo.Delete(ctx, tf.UserId)
+}
+
+func (s *twoFactorUsecase) RegenerateRecoveryCodes(ctx context.Context, userId, code string) (*dto.RecoveryCodesResponse, error) {
+	tf, err := s.get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !tf.Enabled {
+		return nil, ErrNotEnabled
+	}
+	ok, err := s.checkTOTP(ctx, tf, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes(tf.UserId)
+	if err != nil {
+		return nil, err
+	}
+	tf.RecoveryCodes = hashes
+	if err := s.repo.Save(ctx, tf); err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *twoFactorUsecase) Reset(ctx context.Context, userId string) error {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return err
+	}
+	logrus.Warnf("Two-factor authentication reset for %s", id)
+	return s.repo.Delete(ctx, id)
+}
+
+// get loads the second factor of the user, ErrNotEnabled when there is none
+func (s *twoFactorUsecase) get(ctx context.Context, userId string) (*account.TwoFactor, error) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, twofactorrepository.ErrTwoFactorNotFound) {
+			return nil, ErrNotEnabled
+		}
+		logrus.Errorf("Usecase layer: %v", err)
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (s *twoFactorUsecase) enable(ctx context.Context, tf *account.TwoFactor) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes(tf.UserId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tf.Enabled = true
+	tf.EnabledAt = &now
+	tf.RecoveryCodes = hashes
+	if err := s.repo.Save(ctx, tf); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Two-factor authentication enabled for %s %s", tf.Role, tf.UserId)
+	return codes, nil
+}
+
+// verify accepts a code of the authenticator app or an unused recovery code
+func (s *twoFactorUsecase) verify(ctx context.Context, tf *account.TwoFactor, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpOpts.Digits.Length() {
+		ok, err := s.checkTOTP(ctx, tf, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	ok, err := s.repo.UseRecoveryCode(ctx, tf.UserId, recoveryCodeHash(tf.UserId, code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	logrus.Warnf("Recovery code used by %s %s, %d left", tf.Role, tf.UserId, len(tf.RecoveryCodes)-1)
+	return nil
+}
+
+// checkTOTP compares the code with the periods around now, a period that was already
+// used is refused
+func (s *twoFactorUsecase) checkTOTP(ctx context.Context, tf *account.TwoFactor, code string) (bool, error) {
+	secret, err := unseal(tf.Secret)
+	if err != nil {
+		logrus.Errorf("Failed to unseal two-factor secret of %s: %v", tf.UserId, err)
+		return false, err
+	}
+
+	period := time.Duration(totpOpts.Period) * time.Second
+	now := time.Now()
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		at := now.Add(time.Duration(skew) * period)
+		want, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) != 1 {
+			continue
+		}
+
+		fresh, err := s.redis.SetNX(ctx, usedCodeKey(tf.UserId, at.Unix()/int64(totpOpts.Period)), 1, (2*totpSkew+1)*period)
+		if err != nil {
+			logrus.Errorf("Usecase layer: %v", err)
+			return false, err
+		}
+		return fresh, nil
+	}
+	return false, nil
+}
+
+// newRecoveryCodes returns the codes to show the user and the hashes to store
+func newRecoveryCodes(userId uuid.UUID) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = raw[:8] + "-" + raw[8:]
+		hashes[i] = recoveryCodeHash(userId, codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func recoveryCodeHash(userId uuid.UUID, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET_KEY")))
+	fmt.Fprintf(mac, "%s:%s", userId, code)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
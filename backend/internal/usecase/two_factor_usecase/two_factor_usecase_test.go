@@ -0,0 +1,192 @@
+package twofactorusecase
+
+import (
+	"backend/internal/domain/account"
+	"backend/internal/domain/dto"
+	twofactorrepository "backend/internal/infrastructure/persistence/two_factor_repository"
+	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryTwoFactors struct {
+	twofactorrepository.TwoFactorRepository
+	rows map[uuid.UUID]account.TwoFactor
+}
+
+func (m *memoryTwoFactors) Get(ctx context.Context, userId uuid.UUID) (*account.TwoFactor, error) {
+	tf, ok := m.rows[userId]
+	if !ok {
+		return nil, twofactorrepository.ErrTwoFactorNotFound
+	}
+	tf.RecoveryCodes = slices.Clone(tf.RecoveryCodes)
+	return &tf, nil
+}
+
+func (m *memoryTwoFactors) Save(ctx context.Context, tf *account.TwoFactor) error {
+	m.rows[tf.UserId] = *tf
+	return nil
+}
+
+func (m *memoryTwoFactors) UseRecoveryCode(ctx context.Context, userId uuid.UUID, hash string) (bool, error) {
+	tf := m.rows[userId]
+	i := slices.Index(tf.RecoveryCodes, hash)
+	if i < 0 {
+		return false, nil
+	}
+	tf.RecoveryCodes = slices.Delete(slices.Clone(tf.RecoveryCodes), i, i+1)
+	m.rows[userId] = tf
+	return true, nil
+}
+
+func (m *memoryTwoFactors) Delete(ctx context.Context, userId uuid.UUID) error {
+	delete(m.rows, userId)
+	return nil
+}
+
+type recordingGuard struct {
+	loginguardusecase.LoginGuardUsecase
+	failed    []string
+	succeeded []string
+}
+
+func (g *recordingGuard) Check(ctx context.Context, attempt *dto.LoginAttempt) error { return nil }
+
+func (g *recordingGuard) Failed(ctx context.Context, attempt *dto.LoginAttempt, userId, reason string) {
+	g.failed = append(g.failed, reason)
+}
+
+func (g *recordingGuard) Succeeded(ctx context.Context, attempt *dto.LoginAttempt, userId string) *account.LoginEvent {
+	g.succeeded = append(g.succeeded, attempt.Role+":"+userId)
+	return nil
+}
+
+func TestTwoFactor_EnrollChallengeAndRecoveryCodes(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	sessions := sessionusecase.NewSessionUsecase(store, 15*time.Minute, time.Hour)
+	repo := &memoryTwoFactors{rows: map[uuid.UUID]account.TwoFactor{}}
+	guard := &recordingGuard{}
+	s := NewTwoFactorUsecase(repo, sessions, guard, store, "Clinic", []string{"admin"})
+	doctorId := uuid.NewString()
+	attempt := &dto.LoginAttempt{Role: "doctor", Identifier: "0812345678", Client: dto.ClientInfo{DeviceId: "ward-pc"}}
+
+	// without a second factor the password is enough
+	resp, err := s.Login(ctx, attempt, doctorId, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+	assert.False(t, resp.TwoFactorRequired)
+	assert.Equal(t, []string{"doctor:" + doctorId}, guard.succeeded)
+
+	_, err = s.Enroll(ctx, uuid.NewString(), "patient")
+	assert.ErrorIs(t, err, ErrNotStaff)
+
+	enrollment, err := s.Enroll(ctx, doctorId, "doctor")
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/Clinic")
+	assert.Contains(t, enrollment.QRCode, "data:image/png;base64,")
+	assert.NotContains(t, repo.rows[uuid.MustParse(doctorId)].Secret, enrollment.Secret)
+
+	now, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	_, err = s.Activate(ctx, doctorId, "000000")
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	codes, err := s.Activate(ctx, doctorId, now)
+	require.NoError(t, err)
+	assert.Len(t, codes.RecoveryCodes, recoveryCodeCount)
+
+	// the password now only gets a challenge
+	resp, err = s.Login(ctx, attempt, doctorId, map[string]string{"name": "dr"})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Token)
+	require.True(t, resp.TwoFactorRequired)
+	// the password alone is not a successful login
+	assert.Len(t, guard.succeeded, 1)
+
+	// the code used to activate cannot be replayed, a wrong code counts as a failed login
+	_, err = s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: now})
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	assert.Equal(t, []string{loginguardusecase.ReasonWrongCode}, guard.failed)
+	assert.Len(t, guard.succeeded, 1)
+
+	next, err := totp.GenerateCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	signedIn, err := s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: next})
+	require.NoError(t, err)
+	assert.NotEmpty(t, signedIn.Token)
+	assert.JSONEq(t, `{"name":"dr"}`, string(signedIn.User.(json.RawMessage)))
+	assert.Equal(t, []string{"doctor:" + doctorId, "doctor:" + doctorId}, guard.succeeded)
+
+	// the challenge is single use
+	_, err = s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: next})
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+
+	// a recovery code works once
+	for _, want := range []error{nil, ErrInvalidCode} {
+		resp, err = s.Login(ctx, attempt, doctorId, nil)
+		require.NoError(t, err)
+		_, err = s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: codes.RecoveryCodes[0]})
+		if want == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, want)
+		}
+	}
+	status, err := s.Status(ctx, doctorId, "doctor")
+	require.NoError(t, err)
+	assert.Equal(t, recoveryCodeCount-1, status.RecoveryCodesLeft)
+
+	// guessing burns the challenge
+	for i := 0; i < maxChallengeAttempts; i++ {
+		_, err = s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: "123456"})
+	}
+	_, err = s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: "123456"})
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+}
+
+func TestTwoFactor_RequiredRoleEnrollsAtLogin(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret")
+	ctx := context.Background()
+	store := redis.NewMemoryStore()
+	repo := &memoryTwoFactors{rows: map[uuid.UUID]account.TwoFactor{}}
+	guard := &recordingGuard{}
+	s := NewTwoFactorUsecase(repo, sessionusecase.NewSessionUsecase(store, 15*time.Minute, time.Hour), guard, store, "Clinic", []string{"admin"})
+	adminId := uuid.NewString()
+	var started []string
+	s.OnSessionStarted("admin", func(ctx context.Context, userId string, client *dto.ClientInfo) {
+		started = append(started, userId+"@"+client.IP)
+	})
+
+	resp, err := s.Login(ctx, &dto.LoginAttempt{Role: "admin", Identifier: "root", Client: dto.ClientInfo{IP: "10.0.0.1"}}, adminId, nil)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Token)
+	require.True(t, resp.TwoFactorSetupRequired)
+	assert.Empty(t, guard.succeeded)
+	assert.Empty(t, started)
+
+	enrollment, err := s.EnrollChallenge(ctx, resp.ChallengeToken)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+
+	signedIn, err := s.Challenge(ctx, &dto.TwoFactorChallengeRequest{ChallengeToken: resp.ChallengeToken, Code: code})
+	require.NoError(t, err)
+	assert.NotEmpty(t, signedIn.Token)
+	assert.Len(t, signedIn.RecoveryCodes, recoveryCodeCount)
+	assert.Equal(t, []string{"admin:" + adminId}, guard.succeeded)
+	assert.Equal(t, []string{adminId + "@10.0.0.1"}, started)
+
+	// a mandatory second factor stays on
+	assert.ErrorIs(t, s.Disable(ctx, adminId, "admin", signedIn.RecoveryCodes[0]), ErrRequired)
+}
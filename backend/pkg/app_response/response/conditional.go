@@ -0,0 +1,56 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewConditionalResponse writes body as JSON with ETag and Last-Modified validators and
+// answers 304 Not Modified when the client already holds the same representation.
+// A zero modified leaves Last-Modified out
+func NewConditionalResponse(ctx *gin.Context, statusCode int, body interface{}, modified time.Time) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		ctx.JSON(statusCode, body)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", "private, no-cache")
+	if !modified.IsZero() {
+		ctx.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(ctx.Request, etag, modified) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(statusCode, "application/json; charset=utf-8", data)
+}
+
+// notModified follows RFC 9110, If-None-Match wins over If-Modified-Since
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
@@ -1,23 +1,111 @@
 package casbinusage
 
 import (
+	policyrepository "backend/internal/infrastructure/persistence/policy_repository"
+	"backend/internal/infrastructure/redis"
+	"context"
+	_ "embed"
+	"strings"
+
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
 	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+var (
+	//go:embed model.conf
+	modelText string
+
+	// defaultPolicy seeds an empty casbin_rule table
+	//go:embed policy.csv
+	defaultPolicy string
 )
 
-var Enforcer *casbin.Enforcer
+var Enforcer *casbin.SyncedEnforcer
 
-func InitCasbin() (*casbin.Enforcer, error) {
-	var err error
-	Enforcer, err = casbin.NewEnforcer("pkg/casbin/model.conf", "pkg/casbin/policy.csv")
+// InitCasbin loads the policy from the database, seeding it from policy.csv the first
+// time, and reloads it whenever another instance changes it
+func InitCasbin(ctx context.Context, db *bun.DB, rc redis.RedisConnection) (*casbin.SyncedEnforcer, error) {
+	e, err := NewEnforcer(policyrepository.NewPolicyAdapter(db))
 	if err != nil {
 		return nil, err
 	}
 
+	if err := seedPolicy(e); err != nil {
+		return nil, err
+	}
+
+	if err := watchPolicy(ctx, e, rc); err != nil {
+		return nil, err
+	}
+
+	Enforcer = e
 	logrus.Info("Casbin enforcer initialized")
 	return Enforcer, nil
 }
 
+// NewEnforcer builds an enforcer of the clinic model on top of adapter
+func NewEnforcer(adapter persist.Adapter) (*casbin.SyncedEnforcer, error) {
+	m, err := model.NewModelFromString(modelText)
+	if err != nil {
+		return nil, err
+	}
+	return casbin.NewSyncedEnforcer(m, adapter)
+}
+
+// watchPolicy reloads the policy of e whenever another instance changes it, and
+// notifies them of the changes made through e
+func watchPolicy(ctx context.Context, e *casbin.SyncedEnforcer, rc redis.RedisConnection) error {
+	watcher, err := NewRedisWatcher(ctx, rc)
+	if err != nil {
+		return err
+	}
+	if err := e.SetWatcher(watcher); err != nil {
+		return err
+	}
+	// SetWatcher installs a reload of the unsynced enforcer, it would race with Enforce
+	return watcher.SetUpdateCallback(func(string) {
+		if err := e.LoadPolicy(); err != nil {
+			logrus.Errorf("Failed to reload casbin policy: %v", err)
+		}
+	})
+}
+
+func seedPolicy(e *casbin.SyncedEnforcer) error {
+	policies, err := e.GetPolicy()
+	if err != nil {
+		return err
+	}
+	grouping, err := e.GetGroupingPolicy()
+	if err != nil {
+		return err
+	}
+	if len(policies) > 0 || len(grouping) > 0 {
+		return nil
+	}
+
+	// the lines go into the model and are saved in one go
+	m := e.GetModel()
+	for _, line := range strings.Split(defaultPolicy, "\n") {
+		if err := persist.LoadPolicyLine(strings.TrimSpace(line), m); err != nil {
+			return err
+		}
+	}
+	if err := e.BuildRoleLinks(); err != nil {
+		return err
+	}
+	if err := e.SavePolicy(); err != nil {
+		return err
+	}
+
+	policies, _ = e.GetPolicy()
+	grouping, _ = e.GetGroupingPolicy()
+	logrus.Infof("Casbin policy seeded with %d rules and %d roles", len(policies), len(grouping))
+	return nil
+}
+
 func Enforce(sub string, obj string, act string) (bool, error) {
 	if Enforcer == nil {
 		logrus.Fatal("casbin enforcer is not initialized")
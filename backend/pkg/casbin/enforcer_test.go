@@ -0,0 +1,110 @@
+package casbinusage
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sharedAdapter stands in for the casbin_rule table shared by the instances
+type sharedAdapter struct {
+	mu    sync.Mutex
+	rules [][]string
+}
+
+func (a *sharedAdapter) LoadPolicy(m model.Model) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.rules {
+		if err := m.AddPolicy(r[0][:1], r[0], r[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *sharedAdapter) SavePolicy(m model.Model) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = nil
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			for _, rule := range ast.Policy {
+				a.rules = append(a.rules, append([]string{ptype}, rule...))
+			}
+		}
+	}
+	return nil
+}
+
+func (a *sharedAdapter) AddPolicy(sec, ptype string, rule []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = append(a.rules, append([]string{ptype}, rule...))
+	return nil
+}
+
+func (a *sharedAdapter) RemovePolicy(sec, ptype string, rule []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = slices.DeleteFunc(a.rules, func(r []string) bool {
+		return slices.Equal(r, append([]string{ptype}, rule...))
+	})
+	return nil
+}
+
+func (a *sharedAdapter) RemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return nil
+}
+
+func TestEnforcer_SeedInheritAndReloadAcrossInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := redis.NewMemoryStore()
+	adapter := &sharedAdapter{}
+
+	first, err := NewEnforcer(adapter)
+	require.NoError(t, err)
+	require.NoError(t, seedPolicy(first))
+	require.NoError(t, watchPolicy(ctx, first, store))
+
+	second, err := NewEnforcer(adapter)
+	require.NoError(t, err)
+	require.NoError(t, seedPolicy(second))
+	require.NoError(t, watchPolicy(ctx, second, store))
+
+	// the second instance found the table seeded and did not seed it again
+	policies, err := second.GetPolicy()
+	require.NoError(t, err)
+	assert.Len(t, adapter.rules, len(policies))
+
+	allowed := func(sub, obj, act string) bool {
+		ok, err := second.Enforce(sub, obj, act)
+		require.NoError(t, err)
+		return ok
+	}
+	assert.True(t, allowed("patient", "/api/patient/profile", "GET"))
+	assert.False(t, allowed("patient", "/api/doctor/patients", "GET"))
+	assert.False(t, allowed("nurse", "/api/admin/policies", "GET"))
+	assert.True(t, allowed("admin", "/api/admin/policies", "GET"))
+
+	// a role granted on the first instance applies on the second once it reloaded
+	_, err = first.AddGroupingPolicy("doctor", "nurse")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return allowed("doctor", "/api/nurse/queues", "GET")
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = first.RemoveGroupingPolicy("doctor", "nurse")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !allowed("doctor", "/api/nurse/queues", "GET")
+	}, time.Second, 10*time.Millisecond)
+}
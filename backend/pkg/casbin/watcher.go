@@ -0,0 +1,82 @@
+package casbinusage
+
+import (
+	"backend/internal/infrastructure/redis"
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// policyChannel carries the policy changes between instances
+const policyChannel = "casbin:policy"
+
+// RedisWatcher tells the other instances to reload the policy after this one changed it
+type RedisWatcher struct {
+	redis    redis.RedisConnection
+	id       string
+	sub      redis.Subscription
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	callback func(string)
+}
+
+// NewRedisWatcher subscribes to the policy channel until ctx is cancelled or Close
+func NewRedisWatcher(ctx context.Context, rc redis.RedisConnection) (*RedisWatcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	sub := rc.SubChannel(ctx, policyChannel)
+	if err := sub.Receive(ctx); err != nil {
+		cancel()
+		_ = sub.Close()
+		logrus.Errorf("Failed to subscribe to %s: %v", policyChannel, err)
+		return nil, err
+	}
+
+	w := &RedisWatcher{redis: rc, id: uuid.NewString(), sub: sub, cancel: cancel}
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *RedisWatcher) run(ctx context.Context) {
+	ch := w.sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			// this instance already holds its own change
+			if msg.Payload == w.id {
+				continue
+			}
+			w.mu.Lock()
+			callback := w.callback
+			w.mu.Unlock()
+			if callback != nil {
+				logrus.Infof("Casbin policy changed by %s, reloading", msg.Payload)
+				callback(msg.Payload)
+			}
+		}
+	}
+}
+
+// SetUpdateCallback is called by the enforcer, the callback reloads its policy
+func (w *RedisWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update is called by the enforcer after each policy change
+func (w *RedisWatcher) Update() error {
+	return w.redis.Publish(context.Background(), policyChannel, w.id)
+}
+
+func (w *RedisWatcher) Close() {
+	w.cancel()
+	_ = w.sub.Close()
+}
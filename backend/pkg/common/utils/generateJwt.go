@@ -8,7 +8,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func GenerateToken(id, role string) string {
+// GenerateToken signs an access token of the session sessionId, AuthMiddleware refuses
+// it once the session is revoked
+func GenerateToken(id, role, sessionId string, ttl time.Duration) string {
 	// get secret key from environment variable
 	key := os.Getenv("SECRET_KEY")
 	if key == "" {
@@ -17,11 +19,13 @@ func GenerateToken(id, role string) string {
 	}
 
 	// create token with claims
+	now := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":  id, // subject (phone number)
+		"sub":  id, // subject (user id)
 		"role": role,
-		"iat":  time.Now().Unix(),                          // issued at
-		"exp":  time.Now().Add(time.Hour * 24 * 30).Unix(), // expires in 30 days
+		"sid":  sessionId,
+		"iat":  now.Unix(),          // issued at
+		"exp":  now.Add(ttl).Unix(), // short-lived, renewed with the refresh token
 	})
 
 	// sign token with secret key
@@ -1,9 +1,8 @@
 package config
 
 import (
-	"fmt"
-
 	"github.com/caarlos0/env/v11"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -26,15 +25,15 @@ var AppConfig Config
 func InitConfig() error {
 	err := env.Parse(&AppConfig)
 	if err != nil {
-		fmt.Errorf("Failed to parse config: %s", err)
+		logrus.Warnf("Failed to parse config: %s", err)
 	}
 
 	viper.SetConfigFile(AppConfig.Dir)
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Errorf("Not found config file: %v", ok)
+			logrus.Warnf("Not found config file: %v", ok)
 		}
-		fmt.Errorf("Failed to read config file: %s", err)
+		logrus.Warnf("Failed to read config file: %s", err)
 	}
 
 	AppConfig.Main.Port = viper.GetString("main.port")
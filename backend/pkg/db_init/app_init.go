@@ -3,9 +3,15 @@ package dbinit
 import (
 	"backend/internal/infrastructure/db"
 	"backend/internal/infrastructure/rabbitmq"
+	"backend/internal/infrastructure/ratelimit"
 	"backend/internal/infrastructure/redis"
+	"backend/internal/infrastructure/sender"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
+	serviceaccountusecase "backend/internal/usecase/service_account_usecase"
+	ssousecase "backend/internal/usecase/sso_usecase"
 	"backend/pkg/config"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,7 +19,19 @@ import (
 )
 
 var RedisClient redis.RedisConnection
-var RabbitMQClient *rabbitmq.RabbitMQClient
+var RabbitMQClient rabbitmq.RabbitMQConnection
+
+// redis.driver values
+const (
+	RedisDriverRedis  = "redis"
+	RedisDriverMemory = "memory"
+)
+
+// rabbitmq.driver values
+const (
+	RabbitMQDriverAMQP   = "amqp"
+	RabbitMQDriverMemory = "memory"
+)
 
 func InitDatabase() error {
 	if !config.AppConfig.Main.DB { // false
@@ -39,9 +57,23 @@ func InitDatabase() error {
 	return nil
 }
 
-func InitRedis() (*redis.RedisClient, error) {
-	if !config.AppConfig.Main.Redis {
-		logrus.Errorf("Redis is not enable")
+// InitRedis returns the cache selected by redis.driver: "redis" (default) or "memory"
+// for an in-process store, which only suits a single instance. When Redis is disabled
+// the in-process store is used as well.
+func InitRedis() (redis.RedisConnection, error) {
+	driver := viper.GetString("redis.driver")
+	if !config.AppConfig.Main.Redis && driver != RedisDriverMemory {
+		logrus.Warn("Redis is not enabled, falling back to the in-memory store")
+		driver = RedisDriverMemory
+	}
+
+	switch driver {
+	case RedisDriverMemory:
+		RedisClient = NewMemoryRedis()
+		return RedisClient, nil
+	case "", RedisDriverRedis:
+	default:
+		return nil, fmt.Errorf("unknown redis driver %q", driver)
 	}
 
 	redisCfg := redis.RedisConfig{
@@ -60,20 +92,58 @@ func InitRedis() (*redis.RedisClient, error) {
 		return nil, err
 	}
 
-	return redisClient, nil
+	RedisClient = redisClient
+	return RedisClient, nil
 }
 
-func InitRabbitMQ() (*rabbitmq.RabbitMQClient, error) {
-	if !config.AppConfig.Main.RabbitMQ {
-		logrus.Errorf("RabbitMQ is not enabled")
-		return nil, fmt.Errorf("RabbitMQ is disabled in config")
+// NewMemoryRedis is the degraded mode: caches, the live queue and its pub/sub keep
+// working but are not shared between instances and are lost on restart
+func NewMemoryRedis() redis.RedisConnection {
+	logrus.Warn("Using the in-memory Redis store, run a single instance only")
+	return redis.NewMemoryStore()
+}
+
+// InitRabbitMQ returns the broker selected by rabbitmq.driver: "amqp" (default) or
+// "memory" for an in-process broker. When RabbitMQ is disabled the in-process broker
+// is used as well so the app still runs locally.
+func InitRabbitMQ() (rabbitmq.RabbitMQConnection, error) {
+	retry := rabbitmq.RetryPolicy{
+		MaxAttempts: viper.GetInt("rabbitmq.max_attempts"),
+		BaseDelay:   viper.GetDuration("rabbitmq.retry_base_delay"),
+	}
+
+	consumers := rabbitmq.ConsumerConfig{
+		Workers:  viper.GetInt("rabbitmq.workers"),
+		Prefetch: viper.GetInt("rabbitmq.prefetch"),
+	}
+
+	driver := viper.GetString("rabbitmq.driver")
+	if !config.AppConfig.Main.RabbitMQ && driver != RabbitMQDriverMemory {
+		logrus.Warn("RabbitMQ is not enabled, falling back to the in-memory broker")
+		driver = RabbitMQDriverMemory
+	}
+
+	switch driver {
+	case RabbitMQDriverMemory:
+		logrus.Warn("Using the in-memory message broker, messages are lost on restart")
+		broker := rabbitmq.NewMemoryBroker(retry)
+		broker.Consumers = consumers
+		RabbitMQClient = broker
+		return RabbitMQClient, nil
+	case "", RabbitMQDriverAMQP:
+	default:
+		return nil, fmt.Errorf("unknown rabbitmq driver %q", driver)
 	}
 
 	rabbitmqCfg := &rabbitmq.RabbitConfig{
-		User:     viper.GetString("rabbitmq.username"),
-		Password: viper.GetString("rabbitmq.password"),
-		Host:     viper.GetString("rabbitmq.host"),
-		Port:     viper.GetInt("rabbitmq.port"),
+		User:           viper.GetString("rabbitmq.username"),
+		Password:       viper.GetString("rabbitmq.password"),
+		Host:           viper.GetString("rabbitmq.host"),
+		Port:           viper.GetInt("rabbitmq.port"),
+		MaxAttempts:    retry.MaxAttempts,
+		RetryBaseDelay: retry.BaseDelay,
+		Workers:        consumers.Workers,
+		Prefetch:       consumers.Prefetch,
 	}
 
 	logrus.Infof("Connecting to RabbitMQ at %s: %d", rabbitmqCfg.Host, rabbitmqCfg.Port)
@@ -85,4 +155,221 @@ func InitRabbitMQ() (*rabbitmq.RabbitMQClient, error) {
 
 	RabbitMQClient = rabbitmqClient
 	return RabbitMQClient, nil
-}
\ No newline at end of file
+}
+
+// RabbitMQDrainTimeout bounds how long shutdown waits for in-flight messages
+func RabbitMQDrainTimeout() time.Duration {
+	if d := viper.GetDuration("rabbitmq.drain_timeout"); d > 0 {
+		return d
+	}
+	return 20 * time.Second
+}
+
+// QueueReconcileInterval is how often the live queue cache is checked against Postgres
+func QueueReconcileInterval() time.Duration {
+	if d := viper.GetDuration("queue.reconcile_interval"); d > 0 {
+		return d
+	}
+	return time.Minute
+}
+
+// CatalogCacheTTL is how long a cached catalog read lives when nothing invalidates it
+func CatalogCacheTTL() time.Duration {
+	if d := viper.GetDuration("catalog.cache_ttl"); d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// RateLimitEnabled turns the rate-limit middleware off when rate_limit.enabled is false
+func RateLimitEnabled() bool {
+	if !viper.IsSet("rate_limit.enabled") {
+		return true
+	}
+	return viper.GetBool("rate_limit.enabled")
+}
+
+// TrustedProxies are the addresses or CIDRs of the proxies in front of the server, the
+// only ones whose X-Forwarded-For is believed. None by default
+func TrustedProxies() []string {
+	return viper.GetStringSlice("server.trusted_proxies")
+}
+
+// AllowedOrigins are the browser origins allowed to call the API and to open the
+// realtime sockets, the frontend of local development by default
+func AllowedOrigins() []string {
+	if origins := viper.GetStringSlice("server.allowed_origins"); len(origins) > 0 {
+		return origins
+	}
+	return []string{"http://localhost:3000"}
+}
+
+// RateLimitRules reads rate_limit.groups.<group>, a list of {key, limit, window},
+// and falls back to the built-in rules of the group
+func RateLimitRules(group string) []ratelimit.Rule {
+	key := "rate_limit.groups." + group
+	if !viper.IsSet(key) {
+		return ratelimit.DefaultRules[group]
+	}
+
+	var rules []ratelimit.Rule
+	if err := viper.UnmarshalKey(key, &rules); err != nil {
+		logrus.Errorf("Invalid %s config, using defaults: %v", key, err)
+		return ratelimit.DefaultRules[group]
+	}
+	return rules
+}
+
+// AccessTokenTTL is the lifetime of access tokens, clients renew them with their refresh token
+func AccessTokenTTL() time.Duration {
+	if d := viper.GetDuration("auth.access_token_ttl"); d > 0 {
+		return d
+	}
+	return 15 * time.Minute
+}
+
+// RefreshTokenTTL is how long a session stays open without being used
+func RefreshTokenTTL() time.Duration {
+	if d := viper.GetDuration("auth.refresh_token_ttl"); d > 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// RecoveryCodeTTL is how long a password reset code stays valid
+func RecoveryCodeTTL() time.Duration {
+	if d := viper.GetDuration("recovery.code_ttl"); d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// RecoveryMaxAttempts is how many wrong codes are tolerated before a new one is needed
+func RecoveryMaxAttempts() int {
+	if n := viper.GetInt("recovery.max_attempts"); n > 0 {
+		return n
+	}
+	return 5
+}
+
+// RecoverySender delivers the reset codes, recovery.sender is log or file, file writes
+// to recovery.outbox_file
+func RecoverySender() sender.Sender {
+	switch viper.GetString("recovery.sender") {
+	case "file":
+		path := viper.GetString("recovery.outbox_file")
+		if path == "" {
+			path = "tmp/outbox.jsonl"
+		}
+		return sender.NewFileSender(path)
+	case "", "log":
+		return sender.NewLogSender()
+	default:
+		logrus.Errorf("Unknown recovery.sender %q, using log", viper.GetString("recovery.sender"))
+		return sender.NewLogSender()
+	}
+}
+
+// TwoFactorIssuer names the clinic in the authenticator apps
+func TwoFactorIssuer() string {
+	if issuer := viper.GetString("two_factor.issuer"); issuer != "" {
+		return issuer
+	}
+	return "Clinic"
+}
+
+// TwoFactorRequiredRoles must enroll a second factor before they can sign in, none by
+// default
+func TwoFactorRequiredRoles() []string {
+	return viper.GetStringSlice("two_factor.required_roles")
+}
+
+// LoginGuardConfig reads login_guard, the throttling of failed logins, missing values
+// keep their default
+func LoginGuardConfig() loginguardusecase.Config {
+	cfg := loginguardusecase.Config{
+		Window:       15 * time.Minute,
+		DelayAfter:   3,
+		MaxDelay:     30 * time.Second,
+		LockAfter:    10,
+		LockDuration: 15 * time.Minute,
+		IPLockAfter:  50,
+	}
+	if d := viper.GetDuration("login_guard.window"); d > 0 {
+		cfg.Window = d
+	}
+	if n := viper.GetInt("login_guard.delay_after"); n > 0 {
+		cfg.DelayAfter = n
+	}
+	if d := viper.GetDuration("login_guard.max_delay"); d > 0 {
+		cfg.MaxDelay = d
+	}
+	if n := viper.GetInt("login_guard.lock_after"); n > 0 {
+		cfg.LockAfter = n
+	}
+	if d := viper.GetDuration("login_guard.lock_duration"); d > 0 {
+		cfg.LockDuration = d
+	}
+	if n := viper.GetInt("login_guard.ip_lock_after"); n > 0 {
+		cfg.IPLockAfter = n
+	}
+	return cfg
+}
+
+// AdminBootstrap is the super admin created on an empty admin table, the password falls
+// back to ADMIN_PASSWORD. Without a password no admin is created
+func AdminBootstrap() (string, string) {
+	username := viper.GetString("admin.bootstrap.username")
+	if username == "" {
+		username = "admin"
+	}
+	password := viper.GetString("admin.bootstrap.password")
+	if password == "" {
+		password = os.Getenv("ADMIN_PASSWORD")
+	}
+	return username, password
+}
+
+// ServiceAccountConfig reads api_keys, the lifetimes of the service account keys,
+// missing values keep their default
+func ServiceAccountConfig() serviceaccountusecase.Config {
+	cfg := serviceaccountusecase.Config{
+		DefaultTTL:    90 * 24 * time.Hour,
+		MaxTTL:        365 * 24 * time.Hour,
+		RotationGrace: 24 * time.Hour,
+	}
+	if d := viper.GetDuration("api_keys.default_ttl"); d > 0 {
+		cfg.DefaultTTL = d
+	}
+	if d := viper.GetDuration("api_keys.max_ttl"); d > 0 {
+		cfg.MaxTTL = d
+	}
+	if d := viper.GetDuration("api_keys.rotation_grace"); d > 0 {
+		cfg.RotationGrace = d
+	}
+	if cfg.DefaultTTL > cfg.MaxTTL {
+		cfg.DefaultTTL = cfg.MaxTTL
+	}
+	return cfg
+}
+
+// SSOConfig reads oidc, the identity provider of the staff. Single sign-on is off until
+// oidc.issuer and oidc.client_id are set, the secret falls back to OIDC_CLIENT_SECRET
+func SSOConfig() ssousecase.Config {
+	cfg := ssousecase.Config{
+		Issuer:       viper.GetString("oidc.issuer"),
+		ClientId:     viper.GetString("oidc.client_id"),
+		ClientSecret: viper.GetString("oidc.client_secret"),
+		RedirectURL:  viper.GetString("oidc.redirect_url"),
+		Scopes:       viper.GetStringSlice("oidc.scopes"),
+		GroupsClaim:  viper.GetString("oidc.groups_claim"),
+		RoleClaim:    viper.GetString("oidc.role_claim"),
+		RoleMapping:  viper.GetStringMapString("oidc.role_mapping"),
+		StateTTL:     viper.GetDuration("oidc.state_ttl"),
+	}
+	if cfg.ClientSecret == "" {
+		cfg.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	}
+	cfg.Enabled = cfg.Issuer != "" && cfg.ClientId != ""
+	return cfg
+}
@@ -0,0 +1,63 @@
+package dbinit
+
+import (
+	auditrepository "backend/internal/infrastructure/persistence/audit_repository"
+	displayrepository "backend/internal/infrastructure/persistence/display_repository"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
+	messagingrepository "backend/internal/infrastructure/persistence/messaging_repository"
+	patientrepository "backend/internal/infrastructure/persistence/patient_repository"
+	paymentrepository "backend/internal/infrastructure/persistence/payment_repository"
+	policyrepository "backend/internal/infrastructure/persistence/policy_repository"
+	serviceaccountrepository "backend/internal/infrastructure/persistence/service_account_repository"
+	persistence "backend/internal/infrastructure/persistence/service_repository"
+	ssoidentityrepository "backend/internal/infrastructure/persistence/sso_identity_repository"
+	staffrepository "backend/internal/infrastructure/persistence/staff_repository"
+	doctorrepository "backend/internal/infrastructure/persistence/staff_repository/doctor_repository"
+	facultyrepository "backend/internal/infrastructure/persistence/staff_repository/faculty_repository"
+	nurserepository "backend/internal/infrastructure/persistence/staff_repository/nurse_repository"
+	twofactorrepository "backend/internal/infrastructure/persistence/two_factor_repository"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+type migration struct {
+	name string
+	run  func(db *bun.DB) error
+}
+
+// migrations run in order, a table comes after the tables its foreign keys reference
+var migrations = []migration{
+	{"faculty", facultyrepository.MigrateFaculty},
+	{"service_categories", persistence.MigrateServiceCategory},
+	{"service_subcategories", persistence.MigrateServiceSubCategory},
+	{"services", persistence.MigrateService},
+	{"patient", patientrepository.Migrate},
+	{"booking_queue", persistence.MigrateBookingQueue},
+	{"payment", paymentrepository.Migrate},
+	{"doctor", doctorrepository.Migrate},
+	{"nurse", nurserepository.Migrate},
+	{"admin", staffrepository.MigrateAdmin},
+	{"casbin_rule", policyrepository.Migrate},
+	{"outbox", messagingrepository.MigrateOutbox},
+	{"dead_letter", messagingrepository.MigrateDeadLetter},
+	{"login_history", loginhistoryrepository.Migrate},
+	{"two_factor", twofactorrepository.Migrate},
+	{"sso_identity", ssoidentityrepository.Migrate},
+	{"service_account", serviceaccountrepository.Migrate},
+	{"display_board", displayrepository.Migrate},
+	{"audit", auditrepository.Migrate},
+}
+
+// MigrateDataBase creates or updates every table, it stops at the first failure so the
+// server never starts on a half migrated schema
+func MigrateDataBase(db *bun.DB) error {
+	for _, m := range migrations {
+		if err := m.run(db); err != nil {
+			return fmt.Errorf("migrate %s: %w", m.name, err)
+		}
+	}
+	logrus.Info("Database migrated")
+	return nil
+}
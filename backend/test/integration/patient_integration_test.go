@@ -6,14 +6,19 @@ import (
 	dtopatient "backend/internal/domain/dto/dto_patient"
 	"backend/internal/domain/patient"
 	"backend/internal/infrastructure/db"
+	loginhistoryrepository "backend/internal/infrastructure/persistence/login_history_repository"
 	patientrepository "backend/internal/infrastructure/persistence/patient_repository"
+	paymentrepository "backend/internal/infrastructure/persistence/payment_repository"
 	persistence "backend/internal/infrastructure/persistence/service_repository"
 	"backend/internal/infrastructure/redis"
+	loginguardusecase "backend/internal/usecase/login_guard_usecase"
 	patientusecase "backend/internal/usecase/patient-usecase"
 	paymentusecase "backend/internal/usecase/payment_usecase"
 	serviceusecase "backend/internal/usecase/service_usecase"
+	sessionusecase "backend/internal/usecase/session_usecase"
 	errorsresponse "backend/pkg/app_response/errors_response"
 	"backend/pkg/app_response/response"
+	dbinit "backend/pkg/db_init"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -58,6 +63,7 @@ func setUpTestDB(t *testing.T) *bun.DB {
 	if err := db.Ping(); err != nil {
 		t.Fatalf("Faield to ping to database: %v", err)
 	}
+	require.NoError(t, dbinit.MigrateDataBase(db.GetDB()))
 	return db.GetDB()
 }
 
@@ -80,6 +86,10 @@ func setUpRedisTest(t *testing.T) *redis.RedisClient {
 	return client
 }
 
+func newLoginGuard(db *bun.DB, rc redis.RedisConnection) loginguardusecase.LoginGuardUsecase {
+	return loginguardusecase.NewLoginGuardUsecase(loginhistoryrepository.NewLoginHistoryRepository(db), rc, dbinit.LoginGuardConfig())
+}
+
 func TestCreatePatient(t *testing.T) {
 	db := setUpTestDB(t)
 	mockRepo := patientrepository.NewPatientRepo(db)
@@ -88,12 +98,12 @@ func TestCreatePatient(t *testing.T) {
 	mockBookingQueueRepository := persistence.NewBookingQueueRepository(db)
 	mockServiceRepository := persistence.NewServiceRepository(db)
 	mockServiceUsecase := serviceusecase.NewServicesUsecase(mockServiceRepository)
-	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository)
+	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository, mockRedis)
 
-	mockPaymentUsecase := paymentusecase.NewPaymentMethods()
+	mockPaymentUsecase := paymentusecase.NewPaymentMethods(paymentrepository.NewPaymentRepository(db))
 
 	mockAvatarUploader := &MockAvatarUploader{}
-	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, *mockRedis, mockAvatarUploader)
+	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, sessionusecase.NewSessionUsecase(mockRedis, 15*time.Minute, time.Hour), mockAvatarUploader, newLoginGuard(db, mockRedis))
 	mockHandler := patienthandler.NewPatientHandler(mockPatientUsecase, mockServiceUsecase, mockBookingQueueUsecase, mockPaymentUsecase)
 
 	// Define test cases
@@ -278,12 +288,12 @@ func TestGetPatientById(t *testing.T) {
 	mockBookingQueueRepository := persistence.NewBookingQueueRepository(db)
 	mockServiceRepository := persistence.NewServiceRepository(db)
 	mockServiceUsecase := serviceusecase.NewServicesUsecase(mockServiceRepository)
-	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository)
+	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository, mockRedis)
 
-	mockPaymentUsecase := paymentusecase.NewPaymentMethods()
+	mockPaymentUsecase := paymentusecase.NewPaymentMethods(paymentrepository.NewPaymentRepository(db))
 
 	mockUploader := &MockAvatarUploader{}
-	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, *mockRedis, mockUploader)
+	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, sessionusecase.NewSessionUsecase(mockRedis, 15*time.Minute, time.Hour), mockUploader, newLoginGuard(db, mockRedis))
 	mockHandler := patienthandler.NewPatientHandler(mockPatientUsecase, mockServiceUsecase, mockBookingQueueUsecase, mockPaymentUsecase)
 	db.ExecContext(context.Background(), "TRUNCATE TABLE patient CASCADE")
 
@@ -370,12 +380,12 @@ func TestGetPatients(t *testing.T) {
 	mockBookingQueueRepository := persistence.NewBookingQueueRepository(db)
 	mockServiceRepository := persistence.NewServiceRepository(db)
 	mockServiceUsecase := serviceusecase.NewServicesUsecase(mockServiceRepository)
-	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository)
+	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository, mockRedis)
 
-	mockPaymentUsecase := paymentusecase.NewPaymentMethods()
+	mockPaymentUsecase := paymentusecase.NewPaymentMethods(paymentrepository.NewPaymentRepository(db))
 
 	mockAvatarUploader := &MockAvatarUploader{}
-	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, *mockRedis, mockAvatarUploader)
+	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, sessionusecase.NewSessionUsecase(mockRedis, 15*time.Minute, time.Hour), mockAvatarUploader, newLoginGuard(db, mockRedis))
 	mockHandler := patienthandler.NewPatientHandler(mockPatientUsecase, mockServiceUsecase, mockBookingQueueUsecase, mockPaymentUsecase)
 	db.ExecContext(context.Background(), "TRUNCATE TABLE patient CASCADE")
 
@@ -470,12 +480,12 @@ func TestUpdatePatient(t *testing.T) {
 	mockBookingQueueRepository := persistence.NewBookingQueueRepository(db)
 	mockServiceRepository := persistence.NewServiceRepository(db)
 	mockServiceUsecase := serviceusecase.NewServicesUsecase(mockServiceRepository)
-	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository)
+	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository, mockRedis)
 
-	mockPaymentUsecase := paymentusecase.NewPaymentMethods()
+	mockPaymentUsecase := paymentusecase.NewPaymentMethods(paymentrepository.NewPaymentRepository(db))
 
 	mockAvatarUploader := &MockAvatarUploader{}
-	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, *mockRedis, mockAvatarUploader)
+	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, sessionusecase.NewSessionUsecase(mockRedis, 15*time.Minute, time.Hour), mockAvatarUploader, newLoginGuard(db, mockRedis))
 	mockHandler := patienthandler.NewPatientHandler(mockPatientUsecase, mockServiceUsecase, mockBookingQueueUsecase, mockPaymentUsecase)
 	patientId := uuid.New()
 
@@ -716,12 +726,12 @@ func TestDeletePatient(t *testing.T) {
 	mockBookingQueueRepository := persistence.NewBookingQueueRepository(db)
 	mockServiceRepository := persistence.NewServiceRepository(db)
 	mockServiceUsecase := serviceusecase.NewServicesUsecase(mockServiceRepository)
-	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository)
+	mockBookingQueueUsecase := serviceusecase.NewBookingQueueUsecase(mockBookingQueueRepository, mockRedis)
 
-	mockPaymentUsecase := paymentusecase.NewPaymentMethods()
+	mockPaymentUsecase := paymentusecase.NewPaymentMethods(paymentrepository.NewPaymentRepository(db))
 
 	mockAvatarUploader := &MockAvatarUploader{}
-	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, *mockRedis, mockAvatarUploader)
+	mockPatientUsecase := patientusecase.NewPatientService(mockRepo, sessionusecase.NewSessionUsecase(mockRedis, 15*time.Minute, time.Hour), mockAvatarUploader, newLoginGuard(db, mockRedis))
 	mockHandler := patienthandler.NewPatientHandler(mockPatientUsecase, mockServiceUsecase, mockBookingQueueUsecase, mockPaymentUsecase)
 	patientID := uuid.New()
 	invalidPatientId := uuid.MustParse("da2012db-f4fc-420c-8490-eb2de00de6b1")
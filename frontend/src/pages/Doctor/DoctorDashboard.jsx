@@ -1,4 +1,4 @@
-import React, { useState, useEffect } from 'react'
+import React, { useState, useEffect, useRef } from 'react'
 import { motion } from 'framer-motion'
 import Layout from '../../components/layout/Layout'
 import { Users, Calendar, User, FileText, Clock, TrendingUp, CheckSquare, Pill, Phone, Mail, Wifi, WifiOff, RefreshCw, Stethoscope, Heart } from 'lucide-react'
@@ -38,22 +38,39 @@ const DoctorDashboard = () => {
 
     const wsUrl = process.env.NODE_ENV === 'development' ? 'ws://localhost:9000/api/doctor/queues' : `wss://${window.location.host}/api/doctor/queues`
 
+    // last change event applied, older or repeated events are skipped
+    const lastSeqRef = useRef(0)
+
     const handleWebSocketMessage = (data) => {
         if (typeof data === 'object' && data.type) {
+            if (data.type !== 'queue_list' && data.seq) {
+                if (data.seq <= lastSeqRef.current) return
+                lastSeqRef.current = data.seq
+            }
             switch (data.type) {
                 case 'queue_list':
                 case 'queue_updated':
-                    const sortedQueues = (data.data || []).sort((a, b) => a.queue_id - b.queue_id)
-                    setQueues(sortedQueues)
-                    updateStats(sortedQueues)
+                    // the server sends the queue in display order
+                    lastSeqRef.current = data.seq || 0
+                    setQueues(data.data || [])
+                    updateStats(data.data || [])
                     if (data.type === 'queue_updated') toast.info('Danh sách hàng đợi đã được cập nhật')
                     setLoading(false)
                     break
                 case 'queue_added':
-                    setQueues(prev => [...prev, data.queue].sort((a, b) => a.queue_id - b.queue_id))
+                    setQueues(prev => [...prev.filter(q => q.queue_id !== data.queue_id), data.queue])
                     updateStats(prev => [...prev, data.queue])
                     toast.success(`Bệnh nhân mới: ${data.queue.patient_name || 'Không rõ'}`)
                     break
+                case 'queue_status_changed':
+                    setQueues(prev => prev.map(q => q.queue_id === data.queue_id ? data.queue : q))
+                    break
+                case 'queue_reordered':
+                    setQueues(prev => {
+                        const pos = new Map(data.order.map((id, i) => [id, i]))
+                        return [...prev].sort((a, b) => (pos.get(a.queue_id) ?? Infinity) - (pos.get(b.queue_id) ?? Infinity))
+                    })
+                    break
                 case 'queue_removed':
                     setQueues(prev => prev.filter(q => q.queue_id !== data.queue_id))
                     updateStats(prev => prev.filter(q => q.queue_id !== data.queue_id))